@@ -23,12 +23,62 @@ import (
 // SecretClassSpec defines the desired state of SecretClass
 type SecretClassSpec struct {
 	Backend *BackendSpec `json:"backend,omitempty"`
+
+	// AllowedNamespaces restricts the namespaces of the pods which can mount the secret class.
+	// When not set, pods in any namespace can mount it.
+	// +kubebuilder:validation:Optional
+	AllowedNamespaces *AllowedNamespacesSpec `json:"allowedNamespaces,omitempty"`
+
+	// MountOptions are the extra options of the tmpfs mounting the secret volumes, e.g. noatime.
+	// They are merged with the safe defaults noexec, nosuid and nodev, which can not be relaxed,
+	// except noexec with allowExec.
+	// +kubebuilder:validation:Optional
+	MountOptions []string `json:"mountOptions,omitempty"`
+
+	// AllowExec mounts the secret volumes without noexec, so the files can be executed,
+	// e.g. entrypoint wrappers delivered as secrets.
+	// +kubebuilder:validation:Optional
+	AllowExec bool `json:"allowExec,omitempty"`
+
+	// FSType is the filesystem of the secret volumes, tmpfs by default.
+	// ramfs is never swapped to disk, but has no size limit, the secret data is only bounded by the
+	// max secret size of the csi driver, which must be enabled.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=tmpfs;ramfs
+	FSType string `json:"fsType,omitempty"`
+
+	// RestartOnRotation restarts the workload of a pod when the csi driver rotates its secret, by setting the
+	// secrets.zncdata.dev/restartedAt annotation of the pod template of the Deployment, StatefulSet or DaemonSet
+	// owning the pod, like kubectl rollout restart. The pods without such owner are not restarted.
+	// +kubebuilder:validation:Optional
+	RestartOnRotation bool `json:"restartOnRotation,omitempty"`
+
+	// VolumeDefaults are the secrets.zncdata.dev volume annotations applied to the volumes of the secret class
+	// which do not set them, e.g. secrets.zncdata.dev/format or secrets.zncdata.dev/mode.
+	// The annotations of the volume always win, then the defaults of the first class of the volume.
+	// The class annotations and the pod info of the volume context can not be defaulted.
+	// +kubebuilder:validation:Optional
+	VolumeDefaults map[string]string `json:"volumeDefaults,omitempty"`
+}
+
+// AllowedNamespacesSpec allows a namespace when it is in names, or its labels match the selector.
+// When both are empty, no namespace is allowed.
+type AllowedNamespacesSpec struct {
+	// +kubebuilder:validation:Optional
+	Names []string `json:"names,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
 }
 
 type BackendSpec struct {
-	AutoTls   *AutoTlsSpec   `json:"autoTls,omitempty"`
-	K8sSearch *K8sSearchSpec `json:"k8sSearch,omitempty"`
-	Kerberos  *KerberosSpec  `json:"kerberos,omitempty"`
+	AutoTls     *AutoTlsSpec     `json:"autoTls,omitempty"`
+	CertManager *CertManagerSpec `json:"certManager,omitempty"`
+	Custom      *CustomSpec      `json:"custom,omitempty"`
+	File        *FileSpec        `json:"file,omitempty"`
+	K8sSearch   *K8sSearchSpec   `json:"k8sSearch,omitempty"`
+	Kerberos    *KerberosSpec    `json:"kerberos,omitempty"`
+	Vault       *VaultSpec       `json:"vault,omitempty"`
 }
 
 type AutoTlsSpec struct {
@@ -39,46 +89,252 @@ type AutoTlsSpec struct {
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default="360h"
 	MaxCertificateLifeTime string `json:"maxCertificateLifeTime,omitempty"`
+
+	// Max percentage of the certificate lifetime to cut off randomly, so the pods mounting the secrets
+	// with the same lifetime do not expire and restart at the same time.
+	// The jitter is deterministic per pod, seeded by the pod UID.
+	// Overridden by the volume annotation secrets.zncdata.dev/autoTlsCertJitterFactor.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=99
+	CertificateJitterPercent int32 `json:"certificateJitterPercent,omitempty"`
+
+	// NotBeforeSkew backdates the NotBefore of the issued certificates, so the clients whose clock is behind
+	// the clock of the node do not reject them as not yet valid. It does not extend the expiration time.
+	// Use time.ParseDuration to parse the string, default is 5m, capped to 1h.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="5m"
+	NotBeforeSkew string `json:"notBeforeSkew,omitempty"`
+
+	// Algorithm of the private keys of the issued certificates, default is rsa:2048.
+	// The CA keeps its RSA key, which signs the keys of every algorithm.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum="rsa:2048";"rsa:4096";"ecdsa:P256";"ecdsa:P384";"ed25519"
+	KeyAlgorithm string `json:"keyAlgorithm,omitempty"`
+
+	// SpiffeTrustDomain is the trust domain of the SPIFFE IDs added to the certificates of the volumes
+	// setting secrets.zncdata.dev/autoTlsSpiffe, default is cluster.local.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[a-z0-9._-]+$`
+	SpiffeTrustDomain string `json:"spiffeTrustDomain,omitempty"`
+
+	// CommonNameTemplate is the common name of the issued certificates, with the placeholders {pod}, {namespace},
+	// {service} and {node} replaced by the pod name, its namespace, the first service of the scope or the subdomain
+	// of the pod, and the node name, e.g. "{service}.{namespace}". The rendered name must not exceed 64 characters.
+	// Default is the first DNS name of the certificate, or the pod name when it has none.
+	// +kubebuilder:validation:Optional
+	CommonNameTemplate string `json:"commonNameTemplate,omitempty"`
+
+	// ExtendedKeyUsages of the issued certificates, default is both serverAuth and clientAuth,
+	// e.g. only serverAuth for the server-only certificates.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:items:Enum=serverAuth;clientAuth
+	ExtendedKeyUsages []string `json:"extendedKeyUsages,omitempty"`
+
+	// KeyUsages of the issued certificates, default is digitalSignature, and keyEncipherment for the RSA keys.
+	// keyEncipherment requires a RSA key algorithm.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:items:Enum=digitalSignature;keyEncipherment
+	KeyUsages []string `json:"keyUsages,omitempty"`
 }
 
 type CASpec struct {
+	// AutoGenerated creates the CA secret with a self-signed certificate authority when it does not exist
+	// or has no valid certificate authority, and rotates it before it expires.
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default=false
 	AutoGenerated bool `json:"autoGenerated,omitempty"`
 
+	// CommonName of the self-signed certificate authorities generated when autoGenerated is true,
+	// default is "secret-operator self-signed CA".
+	// +kubebuilder:validation:Optional
+	CommonName string `json:"commonName,omitempty"`
+
 	// Use time.ParseDuration to parse the string
 	// Default is 8760h (1 year)
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default="8760h"
 	CACertificateLifeTime string `json:"caCertificateLifeTime,omitempty"`
 
+	// Constraints of the certificate authorities generated when autoGenerated is true, e.g. to limit them to
+	// the domain of the cluster. The certificate authority issuing the certificates must carry constraints at least
+	// as strict, the ones of the secret which do not are skipped, and a new one is generated when autoGenerated is true.
+	// A certificate with a SAN outside the name constraints of the certificate authority is not issued.
+	// +kubebuilder:validation:Optional
+	Constraints *CAConstraintsSpec `json:"constraints,omitempty"`
+
 	// +kubebuilder:validation:Required
 	Secret *SecretSpec `json:"secret,omitempty"`
 }
 
+// CAConstraintsSpec are the basic and name constraints of the certificate authorities, see RFC 5280.
+type CAConstraintsSpec struct {
+	// MaxPathLength is the pathLenConstraint of the certificate authorities, 0 forbids intermediate
+	// certificate authorities below them.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxPathLength *int32 `json:"maxPathLength,omitempty"`
+
+	// PermittedDNSDomains are the domains of the DNS names the certificate authorities can sign, with their
+	// subdomains, e.g. cluster.local. A domain starting with a dot only permits its subdomains, e.g. .cluster.local.
+	// +kubebuilder:validation:Optional
+	PermittedDNSDomains []string `json:"permittedDNSDomains,omitempty"`
+
+	// ExcludedDNSDomains are the domains of the DNS names the certificate authorities can not sign.
+	// +kubebuilder:validation:Optional
+	ExcludedDNSDomains []string `json:"excludedDNSDomains,omitempty"`
+
+	// PermittedIPRanges are the CIDRs of the IP addresses the certificate authorities can sign, e.g. 10.0.0.0/8.
+	// +kubebuilder:validation:Optional
+	PermittedIPRanges []string `json:"permittedIPRanges,omitempty"`
+
+	// ExcludedIPRanges are the CIDRs of the IP addresses the certificate authorities can not sign.
+	// +kubebuilder:validation:Optional
+	ExcludedIPRanges []string `json:"excludedIPRanges,omitempty"`
+}
+
 type SecretSpec struct {
 	Name      string `json:"name,omitempty"`
 	Namespace string `json:"namespace,omitempty"`
 }
 
-// TODO implement the KerberosSpec
+// CustomSpec selects a backend registered in the csi driver at build time, e.g. a proprietary secret store.
+type CustomSpec struct {
+	// Type is the name the backend is registered with, it can not be the name of a built-in backend.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Type string `json:"type"`
+
+	// Parameters configure the backend, they are passed to it as is.
+	// +kubebuilder:validation:Optional
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// FileSpec reads the secrets from a directory of the nodes, e.g. on air-gapped nodes without Kubernetes Secrets.
+// The files of the directory <baseDir>/<secret class name> are the secret data, named after the files.
+// The directory must be visible at the same path in the csi driver container.
+type FileSpec struct {
+	// BaseDir is the absolute path of the directory holding a subdirectory per secret class.
+	// +kubebuilder:validation:Required
+	BaseDir string `json:"baseDir"`
+}
+
+// KerberosSpec creates the principals of the pods in a MIT Kerberos KDC and exports their keytabs,
+// with the kadmin client of the csi driver authenticated by the keytab of an admin principal.
 type KerberosSpec struct {
+	// Realm of the KDC and the admin principal, the principals of the pods are in the first realm of
+	// secrets.zncdata.dev/kerberosRealms of the volume, default is this realm.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Realm string `json:"realm"`
+
+	// KDC is the host of the KDC, with an optional port, e.g. "kdc.kerberos.svc.cluster.local:88".
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	KDC string `json:"kdc"`
+
+	// AdminServer is the host of the kadmin server, with an optional port, default is the host of the KDC.
+	// +kubebuilder:validation:Optional
+	AdminServer string `json:"adminServer,omitempty"`
+
+	// AdminPrincipal is the principal kadmin authenticates as, e.g. "secret-operator/admin@EXAMPLE.COM".
+	// It needs the add, inquire and extract privileges of the kadmin ACL, the keys are exported without being changed.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	AdminPrincipal string `json:"adminPrincipal"`
+
+	// AdminKeytabSecret is the secret with the keytab of the admin principal in its "keytab" key.
+	// +kubebuilder:validation:Required
+	AdminKeytabSecret *SecretSpec `json:"adminKeytabSecret"`
 }
 
 type K8sSearchSpec struct {
 	// +kubebuilder:validation:Required
 	SearchNamespace *SearchNamespaceSpec `json:"searchNamespace,omitempty"`
+
+	// PodLabels are the keys of the pod labels the secret must match, the secret must have each label
+	// with the same value as the pod. When set, exactly one secret must match.
+	// +kubebuilder:validation:Optional
+	PodLabels []string `json:"podLabels,omitempty"`
 }
 
+// SearchNamespaceSpec is the namespace where the secrets are searched, exactly one of the fields must be set.
 type SearchNamespaceSpec struct {
+	// Name is a fixed namespace, e.g. a central "pki" namespace, the secrets are read from it
+	// whatever the namespace of the pod is. Restrict the pods mounting the class with allowedNamespaces.
 	Name *string `json:"name,omitempty"`
 
+	// Pod searches the namespace of the pod mounting the volume.
 	Pod *PodSpec `json:"pod,omitempty"`
 }
 
 type PodSpec struct {
 }
 
+// VaultSpec reads secrets from the KV v2 secrets engine of HashiCorp Vault.
+// The csi driver logs in with the kubernetes auth method using the service account token of the pod,
+// then reads the secret at "<mountPath>/data/<pathPrefix>/<pod namespace>/<secret class>".
+type VaultSpec struct {
+	// Address of the vault server, e.g. https://vault.example.com:8200
+	// +kubebuilder:validation:Required
+	Address string `json:"address"`
+
+	// Mount path of the KV v2 secrets engine
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="secret"
+	MountPath string `json:"mountPath,omitempty"`
+
+	// Path prefix of the secrets in the KV v2 secrets engine
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="zncdata"
+	PathPrefix string `json:"pathPrefix,omitempty"`
+
+	// Role of the kubernetes auth method used to login
+	// +kubebuilder:validation:Required
+	Role string `json:"role"`
+
+	// Mount path of the kubernetes auth method
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="kubernetes"
+	AuthPath string `json:"authPath,omitempty"`
+
+	// Audience of the service account token used to login, it must be one of the audiences of the vault role,
+	// e.g. "vault". By default the token has the audiences of the apiserver.
+	// +kubebuilder:validation:Optional
+	Audience string `json:"audience,omitempty"`
+}
+
+// CertManagerSpec issues the certificates with cert-manager instead of a CA managed by the operator.
+// The csi driver creates a CertificateRequest in the namespace of the pod for each volume,
+// and waits for the referenced issuer to sign it. The private key never leaves the node.
+type CertManagerSpec struct {
+	// +kubebuilder:validation:Required
+	IssuerRef IssuerRefSpec `json:"issuerRef"`
+
+	// Timeout waiting for the CertificateRequest to be approved and signed.
+	// Use time.ParseDuration to parse the string
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="30s"
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// IssuerRefSpec references the cert-manager issuer signing the certificates.
+type IssuerRefSpec struct {
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// Kind of the issuer, Issuer or ClusterIssuer.
+	// An Issuer must exist in the namespace of each pod mounting the volume.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="Issuer"
+	Kind string `json:"kind,omitempty"`
+
+	// Group of the issuer, set it for external issuers.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="cert-manager.io"
+	Group string `json:"group,omitempty"`
+}
+
 // SecretClassStatus defines the observed state of SecretClass
 type SecretClassStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
@@ -25,6 +25,31 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AllowedNamespacesSpec) DeepCopyInto(out *AllowedNamespacesSpec) {
+	*out = *in
+	if in.Names != nil {
+		in, out := &in.Names, &out.Names
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AllowedNamespacesSpec.
+func (in *AllowedNamespacesSpec) DeepCopy() *AllowedNamespacesSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AllowedNamespacesSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AutoTlsSpec) DeepCopyInto(out *AutoTlsSpec) {
 	*out = *in
@@ -33,6 +58,16 @@ func (in *AutoTlsSpec) DeepCopyInto(out *AutoTlsSpec) {
 		*out = new(CASpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.ExtendedKeyUsages != nil {
+		in, out := &in.ExtendedKeyUsages, &out.ExtendedKeyUsages
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.KeyUsages != nil {
+		in, out := &in.KeyUsages, &out.KeyUsages
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AutoTlsSpec.
@@ -53,6 +88,21 @@ func (in *BackendSpec) DeepCopyInto(out *BackendSpec) {
 		*out = new(AutoTlsSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.CertManager != nil {
+		in, out := &in.CertManager, &out.CertManager
+		*out = new(CertManagerSpec)
+		**out = **in
+	}
+	if in.Custom != nil {
+		in, out := &in.Custom, &out.Custom
+		*out = new(CustomSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.File != nil {
+		in, out := &in.File, &out.File
+		*out = new(FileSpec)
+		**out = **in
+	}
 	if in.K8sSearch != nil {
 		in, out := &in.K8sSearch, &out.K8sSearch
 		*out = new(K8sSearchSpec)
@@ -63,6 +113,11 @@ func (in *BackendSpec) DeepCopyInto(out *BackendSpec) {
 		*out = new(KerberosSpec)
 		**out = **in
 	}
+	if in.Vault != nil {
+		in, out := &in.Vault, &out.Vault
+		*out = new(VaultSpec)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackendSpec.
@@ -75,9 +130,54 @@ func (in *BackendSpec) DeepCopy() *BackendSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CAConstraintsSpec) DeepCopyInto(out *CAConstraintsSpec) {
+	*out = *in
+	if in.MaxPathLength != nil {
+		in, out := &in.MaxPathLength, &out.MaxPathLength
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PermittedDNSDomains != nil {
+		in, out := &in.PermittedDNSDomains, &out.PermittedDNSDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludedDNSDomains != nil {
+		in, out := &in.ExcludedDNSDomains, &out.ExcludedDNSDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PermittedIPRanges != nil {
+		in, out := &in.PermittedIPRanges, &out.PermittedIPRanges
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludedIPRanges != nil {
+		in, out := &in.ExcludedIPRanges, &out.ExcludedIPRanges
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CAConstraintsSpec.
+func (in *CAConstraintsSpec) DeepCopy() *CAConstraintsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CAConstraintsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CASpec) DeepCopyInto(out *CASpec) {
 	*out = *in
+	if in.Constraints != nil {
+		in, out := &in.Constraints, &out.Constraints
+		*out = new(CAConstraintsSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Secret != nil {
 		in, out := &in.Secret, &out.Secret
 		*out = new(SecretSpec)
@@ -135,6 +235,74 @@ func (in *CSIProvisionerSpec) DeepCopy() *CSIProvisionerSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertManagerSpec) DeepCopyInto(out *CertManagerSpec) {
+	*out = *in
+	out.IssuerRef = in.IssuerRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertManagerSpec.
+func (in *CertManagerSpec) DeepCopy() *CertManagerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CertManagerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CustomSpec) DeepCopyInto(out *CustomSpec) {
+	*out = *in
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CustomSpec.
+func (in *CustomSpec) DeepCopy() *CustomSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CustomSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FileSpec) DeepCopyInto(out *FileSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FileSpec.
+func (in *FileSpec) DeepCopy() *FileSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FileSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IssuerRefSpec) DeepCopyInto(out *IssuerRefSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssuerRefSpec.
+func (in *IssuerRefSpec) DeepCopy() *IssuerRefSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IssuerRefSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *K8sSearchSpec) DeepCopyInto(out *K8sSearchSpec) {
 	*out = *in
@@ -143,6 +311,11 @@ func (in *K8sSearchSpec) DeepCopyInto(out *K8sSearchSpec) {
 		*out = new(SearchNamespaceSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.PodLabels != nil {
+		in, out := &in.PodLabels, &out.PodLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new K8sSearchSpec.
@@ -158,6 +331,11 @@ func (in *K8sSearchSpec) DeepCopy() *K8sSearchSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KerberosSpec) DeepCopyInto(out *KerberosSpec) {
 	*out = *in
+	if in.AdminKeytabSecret != nil {
+		in, out := &in.AdminKeytabSecret, &out.AdminKeytabSecret
+		*out = new(SecretSpec)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KerberosSpec.
@@ -448,6 +626,23 @@ func (in *SecretClassSpec) DeepCopyInto(out *SecretClassSpec) {
 		*out = new(BackendSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = new(AllowedNamespacesSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MountOptions != nil {
+		in, out := &in.MountOptions, &out.MountOptions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.VolumeDefaults != nil {
+		in, out := &in.VolumeDefaults, &out.VolumeDefaults
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretClassSpec.
@@ -496,3 +691,18 @@ func (in *SecretSpec) DeepCopy() *SecretSpec {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VaultSpec) DeepCopyInto(out *VaultSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultSpec.
+func (in *VaultSpec) DeepCopy() *VaultSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultSpec)
+	in.DeepCopyInto(out)
+	return out
+}
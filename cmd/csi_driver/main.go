@@ -21,19 +21,30 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/zncdata-labs/secret-operator/internal/csi"
+	secretbackend "github.com/zncdata-labs/secret-operator/internal/csi/backend"
 	"github.com/zncdata-labs/secret-operator/internal/csi/version"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace/noop"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	secretv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
@@ -43,7 +54,7 @@ import (
 var (
 	scheme     = runtime.NewScheme()
 	setupLog   = ctrl.Log.WithName("setup")
-	endpoint   = flag.String("endpoint", "unix://tmp/csi.sock", "CSI endpoint")
+	endpoint   = flag.String("endpoint", "unix://tmp/csi.sock", "CSI endpoint, unix://<path>, unix://@<abstract socket name> or tcp://<host>:<port>")
 	nodeID     = flag.String("nodeid", "", "node id")
 	driverName = flag.String("drivername", csi.DefaultDriverName, "name of the driver")
 
@@ -53,7 +64,67 @@ var (
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.",
 	)
-	versionInfo = flag.Bool("version", false, "Prints the version information")
+	versionInfo    = flag.Bool("version", false, "Prints the version information")
+	rotationWindow = flag.Duration("secret-rotation-window", time.Hour,
+		"Rotate the mounted secret in place when it expires within the window, 0 disables rotation.",
+	)
+	maxSecretSize = resource.QuantityValue{Quantity: resource.MustParse("8Mi")}
+
+	backendRetryAttempts = flag.Int("backend-retry-attempts", 3,
+		"Attempts to get the secret from the backend when it fails transiently, e.g. vault is unavailable, 1 disables the retry.",
+	)
+	backendRetryBaseDelay = flag.Duration("backend-retry-base-delay", 200*time.Millisecond,
+		"Delay before the first retry of a transient backend failure, doubled after each attempt.",
+	)
+	backendConcurrency = flag.String("backend-concurrency", "",
+		"Comma separated max concurrent requests per backend type, e.g. vault=10,kerberos=5, so a large rollout does not "+
+			"overwhelm the external systems. The requests over the limit wait until the request timeout. By default they are not limited.",
+	)
+
+	requestTimeout = flag.Duration("request-timeout", time.Minute,
+		"Max duration of a grpc call of kubelet, e.g. NodePublishVolume, a hanging backend fails it with DeadlineExceeded. "+
+			"0 disables the timeout.",
+	)
+
+	disablePodAnnotation = flag.Bool("disable-pod-annotation", false,
+		"Do not patch the expiration time and content hash annotations of the pods, so the driver needs no pod patch permission. "+
+			"The operator can not restart the pods with expired secrets then.",
+	)
+
+	apiReaderFallback = flag.Bool("api-reader-fallback", true,
+		"Read the SecretClasses and pods from the API server when they are not found in the cache of the driver, "+
+			"e.g. before the cache is synced after the driver started.",
+	)
+
+	enableFIFO = flag.Bool("enable-fifo", false,
+		"Allow the volumes with the fifo format, which write keys as named pipes served by the driver. "+
+			"Each value is written once, to the first reader of the pipe.",
+	)
+
+	kubeletDir         = flag.String("kubelet-dir", csi.DefaultKubeletDir, "Root directory of kubelet, the volumes are published under its pods directory.")
+	orphanReapInterval = flag.Duration("orphan-reap-interval", 10*time.Minute,
+		"Interval to unmount the volumes of the driver left behind by deleted pods, e.g. when kubelet missed the unpublish. "+
+			"A volume is unmounted when it is still orphan at the next check, 0 disables it.",
+	)
+
+	clusterDomain = flag.String("cluster-domain", "",
+		"Domain of the dns names of the pods and services, e.g. k8s.internal. By default it is detected from the search "+
+			"domains of "+pod_info.ResolvConfPath+", or "+pod_info.DefaultClusterDomain+".",
+	)
+
+	nodeAddressTypes = flag.String("node-address-types", "",
+		"Comma separated types of the addresses of the Node object used for the node scope, e.g. InternalDNS,InternalIP. "+
+			"By default the node name and all the addresses are used.",
+	)
+	nodeAddresses = flag.String("node-addresses", "",
+		"Comma separated hostnames and IPs of the node used for the node scope instead of the Node object, "+
+			"e.g. the routable hostname of the node when it differs from the node name.",
+	)
+
+	otlpEndpoint = flag.String("otlp-endpoint", "",
+		"OTLP gRPC endpoint the traces of the volume publishes are exported to, e.g. otel-collector:4317. "+
+			"The connection is not encrypted. By default the traces are not exported.",
+	)
 )
 
 func init() {
@@ -68,7 +139,12 @@ func main() {
 	opts := zap.Options{
 		Development: true,
 	}
+	// the logs are JSON, so the entries of a grpc call can be correlated by their requestID,
+	// --zap-encoder=console switches to the plain text
+	zap.JSONEncoder()(&opts)
 
+	flag.Var(&maxSecretSize, "max-secret-size",
+		"Max total size of the secret data of a volume, e.g. 8Mi, larger secrets fail to mount, 0 disables the limit.")
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
@@ -105,12 +181,25 @@ func main() {
 
 	ctx := ctrl.SetupSignalHandler()
 
-	go runMgr(ctx, mgr)
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+	defer shutdownTracing()
+
+	mgrDone := make(chan struct{})
+	go runMgr(ctx, mgr, mgrDone)
 
 	runDriver(ctx, mgr)
+
+	// wait for the manager to stop the metrics and health probe servers
+	<-mgrDone
+	setupLog.Info("stopped")
 }
 
-func runMgr(ctx context.Context, mgr ctrl.Manager) {
+func runMgr(ctx context.Context, mgr ctrl.Manager, done chan<- struct{}) {
+	defer close(done)
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
@@ -120,13 +209,112 @@ func runMgr(ctx context.Context, mgr ctrl.Manager) {
 
 func runDriver(ctx context.Context, mgr ctrl.Manager) {
 	setupLog.Info("starting driver", "driver", *driverName)
-	driver := csi.NewDriver(*driverName, *nodeID, *endpoint, mgr.GetClient())
+	// the client of the manager reads from the cache and can not watch
+	classWatcher, err := client.NewWithWatch(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+	if err != nil {
+		setupLog.Error(err, "unable to create secret class watcher")
+		os.Exit(1)
+	}
 
-	err := driver.Run(ctx, false)
+	types, err := pod_info.ParseNodeAddressTypes(*nodeAddressTypes)
+	if err != nil {
+		setupLog.Error(err, "invalid --node-address-types")
+		os.Exit(1)
+	}
+
+	concurrencyLimits, err := secretbackend.ParseConcurrencyLimits(*backendConcurrency)
+	if err != nil {
+		setupLog.Error(err, "invalid --backend-concurrency")
+		os.Exit(1)
+	}
+
+	domain := *clusterDomain
+	if domain == "" {
+		domain = detectClusterDomain()
+	}
+	setupLog.Info("cluster domain", "domain", domain)
+
+	opts := []csi.DriverOption{
+		csi.WithRotationWindow(*rotationWindow),
+		csi.WithMaxSecretSize(maxSecretSize.Value()),
+		csi.WithBackendRetry(secretbackend.RetryPolicy{MaxAttempts: *backendRetryAttempts, BaseDelay: *backendRetryBaseDelay}),
+		csi.WithBackendConcurrency(concurrencyLimits),
+		csi.WithSecretClassWatch(classWatcher),
+		csi.WithNodeAddressPolicy(pod_info.NodeAddressPolicy{Types: types, Addresses: pod_info.ParseAddresses(*nodeAddresses)}),
+		csi.WithClusterDomain(domain),
+		csi.WithEventRecorder(mgr.GetEventRecorderFor(*driverName)),
+		csi.WithRequestTimeout(*requestTimeout),
+		csi.WithPodAnnotationDisabled(*disablePodAnnotation),
+		csi.WithFIFOEnabled(*enableFIFO),
+		csi.WithOrphanReaper(*kubeletDir, *orphanReapInterval),
+	}
+	if *apiReaderFallback {
+		opts = append(opts, csi.WithAPIReader(mgr.GetAPIReader()))
+	}
+	driver := csi.NewDriver(*driverName, *nodeID, *endpoint, mgr.GetClient(), opts...)
+
+	err = driver.Run(ctx, false)
 	if err != nil {
 		fmt.Println("Failed to run driver", "error", err.Error())
 		os.Exit(1)
 	}
+	if ctx.Err() == nil {
+		setupLog.Info("driver stopped unexpectedly")
+		os.Exit(1)
+	}
+}
+
+// setupTracing installs the global tracer provider, exporting the spans to --otlp-endpoint,
+// or a no-op provider when it is not set. The returned function flushes the pending spans.
+func setupTracing(ctx context.Context) (func(), error) {
+	if *otlpEndpoint == "" {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return func() {}, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(*otlpEndpoint), otlptracegrpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(sdkresource.NewSchemaless(
+			semconv.ServiceName(*driverName),
+			semconv.K8SNodeName(*nodeID),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	setupLog.Info("exporting traces", "endpoint", *otlpEndpoint)
+
+	return func() {
+		// the signal context is done, the spans are flushed within their own deadline
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			setupLog.Error(err, "unable to flush the traces")
+		}
+	}, nil
+}
+
+// detectClusterDomain returns the cluster domain from the resolv.conf of the driver, the default cluster domain
+// when it can not be detected, e.g. the driver does not use the cluster dns.
+func detectClusterDomain() string {
+	f, err := os.Open(pod_info.ResolvConfPath)
+	if err != nil {
+		setupLog.Info("unable to read the cluster domain, use the default", "error", err.Error())
+		return pod_info.DefaultClusterDomain
+	}
+	defer f.Close()
+
+	domain, err := pod_info.ParseClusterDomain(f)
+	if err != nil {
+		setupLog.Info("unable to read the cluster domain, use the default", "error", err.Error())
+		return pod_info.DefaultClusterDomain
+	}
+	if domain == "" {
+		return pod_info.DefaultClusterDomain
+	}
+	return domain
 }
 
 func showVersion() {
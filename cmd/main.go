@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
@@ -36,6 +37,8 @@ import (
 	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
 	"github.com/zncdata-labs/secret-operator/internal/controller"
 	csicontroller "github.com/zncdata-labs/secret-operator/internal/controller/secretcsi"
+	"github.com/zncdata-labs/secret-operator/internal/csi/backend/ca"
+	webhookv1alpha1 "github.com/zncdata-labs/secret-operator/internal/webhook/v1alpha1"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -48,6 +51,29 @@ var (
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.",
 	)
+	enableWebhooks = flag.Bool("enable-webhooks", false,
+		"Enable the validating webhook of SecretClass. "+
+			"The serving certificate must be mounted in the webhook cert dir, see config/webhook.",
+	)
+	enablePodExpiry = flag.Bool("enable-pod-expiry", false,
+		"Restart the pods whose mounted secrets expire, so their owner recreates them with fresh secrets.",
+	)
+	podExpiryGracePeriod = flag.Duration("pod-expiry-grace-period", 10*time.Minute,
+		"How long before the secrets of a pod expire the pod is restarted.",
+	)
+	podExpiryDelete = flag.Bool("pod-expiry-delete", false,
+		"Delete the pods with expired secrets instead of evicting them, the evictions respect the PodDisruptionBudgets.",
+	)
+	enableCARotation = flag.Bool("enable-ca-rotation", false,
+		"Rotate the generated CAs of the autoTls SecretClasses ahead of their expiration, instead of waiting for the csi "+
+			"driver to rotate them when it issues a certificate, and prune the expired ones.",
+	)
+	caRotationWindow = flag.Duration("ca-rotation-window", 0,
+		"How long before the newest CA expires a new one is added to the CA secret. 0 is half of the CA lifetime.",
+	)
+	caPruneAfter = flag.Duration("ca-prune-after", 0,
+		"How long the expired CAs are kept in the CA secret. 0 removes them once expired.",
+	)
 )
 
 func init() {
@@ -107,6 +133,33 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "SecretCSI")
 		os.Exit(1)
 	}
+	if *enablePodExpiry {
+		if err = (&controller.PodExpiryReconciler{
+			Client:      mgr.GetClient(),
+			Scheme:      mgr.GetScheme(),
+			GracePeriod: *podExpiryGracePeriod,
+			Delete:      *podExpiryDelete,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "PodExpiry")
+			os.Exit(1)
+		}
+	}
+	if *enableCARotation {
+		if err = (&controller.CARotationReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+			Policy: ca.RotationPolicy{Window: *caRotationWindow, PruneAfter: *caPruneAfter},
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "CARotation")
+			os.Exit(1)
+		}
+	}
+	if *enableWebhooks {
+		if err = webhookv1alpha1.SetupSecretClassWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "SecretClass")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -0,0 +1,112 @@
+/*
+Copyright 2024 zncdata-labs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/clock"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	secretvs1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/internal/csi/backend"
+	"github.com/zncdata-labs/secret-operator/internal/csi/backend/ca"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// caRotationMaxInterval is the max delay before the CA secret is checked again,
+// so the changes made to the secret outside the operator are noticed.
+const caRotationMaxInterval = time.Hour
+
+// CARotationReconciler rotates the certificate authorities of the autoTls backends with autoGenerated ahead of
+// their expiration, instead of waiting for the csi driver to rotate them when it issues a certificate.
+// The new certificate authority is appended to the CA secret, the old ones are kept so the certificates they
+// issued are trusted until they expire, and are pruned once expired, see ca.RotateSecret.
+// The CA secret is created by the SecretClassReconciler.
+type CARotationReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Policy decides when the certificate authorities are rotated and pruned.
+	Policy ca.RotationPolicy
+
+	// Clock and Rand are replaced in tests, nil are the real clock and crypto/rand.
+	Clock clock.PassiveClock
+	Rand  io.Reader
+}
+
+//+kubebuilder:rbac:groups=secrets.zncdata.dev,resources=secretclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch
+
+// Reconcile rotates and prunes the certificate authorities of the CA secret of the SecretClass, and is requeued
+// when the secret needs to be rotated or pruned next. A misconfigured backend is skipped, it is reported by the
+// SecretClassReconciler.
+func (r *CARotationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &secretvs1alpha1.SecretClass{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	autoTls := instance.Spec.Backend.AutoTls
+	if autoTls == nil || autoTls.CA == nil || !autoTls.CA.AutoGenerated {
+		return ctrl.Result{}, nil
+	}
+
+	clk, random := r.Clock, r.Rand
+	if clk == nil {
+		clk = clock.RealClock{}
+	}
+	if random == nil {
+		random = rand.Reader
+	}
+	autoTlsBackend, err := backend.NewAutoTlsBackend(r.Client, nil, &volume.SecretVolumeSelector{Class: instance.Name}, autoTls, clk, random)
+	if err != nil {
+		logger.V(1).Info("Skip the CA rotation of the invalid SecretClass", "Name", instance.Name, "error", err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	result, err := autoTlsBackend.RotateCA(ctx, r.Policy)
+	if err != nil {
+		logger.Error(err, "Failed to rotate the CA secret of SecretClass", "Name", instance.Name)
+		return ctrl.Result{}, err
+	}
+	if result.Rotated != nil || len(result.Pruned) > 0 {
+		logger.Info("Rotated the CA secret of SecretClass", "Name", instance.Name,
+			"secret", autoTls.CA.Secret.Namespace+"/"+autoTls.CA.Secret.Name, "rotated", result.Rotated != nil, "pruned", result.Pruned)
+	}
+
+	requeueAfter := caRotationMaxInterval
+	if !result.NextCheck.IsZero() {
+		requeueAfter = min(max(result.NextCheck.Sub(clk.Now()), time.Second), caRotationMaxInterval)
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CARotationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("ca-rotation").
+		For(&secretvs1alpha1.SecretClass{}).
+		Complete(r)
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/zncdata-labs/secret-operator/internal/csi/backend/ca"
+)
+
+// getTestCACertificates returns the certificates of the CA secret ordered by expiration.
+func getTestCACertificates(t *testing.T, c client.Client) []*x509.Certificate {
+	secret, err := getTestCASecret(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var certs []*x509.Certificate
+	for name, data := range secret.Data {
+		if !strings.HasSuffix(name, ".crt") {
+			continue
+		}
+		if _, ok := secret.Data[strings.TrimSuffix(name, ".crt")+".key"]; !ok {
+			t.Errorf("certificate %s has no private key", name)
+		}
+		block, _ := pem.Decode(data)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(secret.Data) != 2*len(certs) {
+		t.Errorf("unexpected entries in the CA secret: %d for %d certificates", len(secret.Data), len(certs))
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].NotAfter.Before(certs[j].NotAfter) })
+	return certs
+}
+
+func TestCARotationReconcile(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	lifetime := 8760 * time.Hour
+	secretClass := newTestAutoTlsSecretClass(true)
+	bootstrap := newTestSecretClassReconciler(t, start, secretClass)
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(secretClass)}
+	if _, err := bootstrap.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first := getTestCACertificates(t, bootstrap.Client)[0]
+
+	clk := clocktesting.NewFakeClock(start)
+	r := &CARotationReconciler{
+		Client: bootstrap.Client,
+		Scheme: bootstrap.Scheme,
+		Policy: ca.RotationPolicy{Window: 30 * 24 * time.Hour, PruneAfter: 24 * time.Hour},
+		Clock:  clk,
+		Rand:   rand.Reader,
+	}
+	reconcile := func(wantCAs int) []*x509.Certificate {
+		t.Helper()
+		result, err := r.Reconcile(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.RequeueAfter <= 0 || result.RequeueAfter > caRotationMaxInterval {
+			t.Errorf("unexpected requeue after %s", result.RequeueAfter)
+		}
+		certs := getTestCACertificates(t, r.Client)
+		if len(certs) != wantCAs {
+			t.Fatalf("unexpected certificate authorities at %s: got %d, want %d", clk.Now(), len(certs), wantCAs)
+		}
+		return certs
+	}
+
+	// far from the expiration, nothing changes
+	clk.Step(time.Hour)
+	reconcile(1)
+
+	// the CA expiring within the window is rotated, the old one is kept
+	clk.SetTime(start.Add(lifetime - 30*24*time.Hour))
+	certs := reconcile(2)
+	if !certs[0].Equal(first) {
+		t.Error("the old certificate authority is not kept")
+	}
+	rotated := certs[1]
+	if want := clk.Now().Add(lifetime); !rotated.NotAfter.Equal(want) {
+		t.Errorf("unexpected expiration of the new certificate authority: got %s, want %s", rotated.NotAfter, want)
+	}
+	if err := rotated.CheckSignatureFrom(first); err != nil {
+		t.Errorf("the new certificate authority is not signed by the old one: %v", err)
+	}
+	// the new one is not rotated again
+	reconcile(2)
+
+	// the expired CA is kept for the prune delay
+	clk.SetTime(start.Add(lifetime + time.Hour))
+	reconcile(2)
+
+	// then pruned
+	clk.SetTime(start.Add(lifetime + 25*time.Hour))
+	if certs := reconcile(1); !certs[0].Equal(rotated) {
+		t.Errorf("unexpected certificate authority left: serial number %s", certs[0].SerialNumber)
+	}
+}
+
+func TestCARotationReconcileDefaultWindow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	secretClass := newTestAutoTlsSecretClass(true)
+	bootstrap := newTestSecretClassReconciler(t, start, secretClass)
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(secretClass)}
+	if _, err := bootstrap.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clk := clocktesting.NewFakeClock(start.Add(4379 * time.Hour))
+	r := &CARotationReconciler{Client: bootstrap.Client, Scheme: bootstrap.Scheme, Clock: clk, Rand: rand.Reader}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if certs := getTestCACertificates(t, r.Client); len(certs) != 1 {
+		t.Fatalf("rotated before half of the CA lifetime: %d certificate authorities", len(certs))
+	}
+
+	// half of the lifetime is left
+	clk.SetTime(start.Add(4380 * time.Hour))
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if certs := getTestCACertificates(t, r.Client); len(certs) != 2 {
+		t.Fatalf("not rotated at half of the CA lifetime: %d certificate authorities", len(certs))
+	}
+}
+
+func TestCARotationReconcileNotGenerated(t *testing.T) {
+	secretClass := newTestAutoTlsSecretClass(false)
+	bootstrap := newTestSecretClassReconciler(t, time.Now(), secretClass)
+	r := &CARotationReconciler{Client: bootstrap.Client, Scheme: bootstrap.Scheme, Rand: rand.Reader}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(secretClass)}
+
+	result, err := r.Reconcile(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter != 0 {
+		t.Errorf("the CA managed by the user is requeued after %s", result.RequeueAfter)
+	}
+	if _, err := getTestCASecret(r.Client); err == nil {
+		t.Error("the CA secret managed by the user is created")
+	}
+}
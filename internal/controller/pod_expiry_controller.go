@@ -0,0 +1,140 @@
+/*
+Copyright 2024 zncdata-labs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/clock"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// podEvictionRetryInterval is the delay before evicting a pod again when the eviction is refused,
+// e.g. by a PodDisruptionBudget.
+const podEvictionRetryInterval = 30 * time.Second
+
+// PodExpiryReconciler restarts the pods whose secrets expire, so their owner recreates them with fresh secrets.
+// The csi driver records the soonest expiration time of the secrets mounted by a pod in the
+// secrets.zncdata.dev/expirationTime annotation, the pod is evicted, or deleted, GracePeriod before that time.
+// The pods without owner are restarted too, they are not recreated.
+type PodExpiryReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// GracePeriod is how long before the expiration time the pod is restarted.
+	GracePeriod time.Duration
+	// Delete deletes the expired pods instead of evicting them, the evictions respect the PodDisruptionBudgets.
+	Delete bool
+	// Clock is replaced in tests, nil is the real clock.
+	Clock clock.PassiveClock
+}
+
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=core,resources=pods/eviction,verbs=create
+
+// Reconcile restarts the pod when its secrets expire within the grace period, otherwise it is requeued
+// at that time. A pod with an invalid expiration time is ignored.
+func (r *PodExpiryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, req.NamespacedName, pod); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if pod.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	value, ok := pod.Annotations[volume.SecretZncdataExpirationTime]
+	if !ok {
+		return ctrl.Result{}, nil
+	}
+	expiresTime, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		logger.Info("Ignore pod with invalid expiration time", "pod", req.NamespacedName, "expirationTime", value)
+		return ctrl.Result{}, nil
+	}
+
+	restartTime := time.Unix(expiresTime, 0).Add(-r.GracePeriod)
+	if wait := restartTime.Sub(r.clock().Now()); wait > 0 {
+		logger.V(5).Info("Pod secrets not expired yet", "pod", req.NamespacedName, "restartTime", restartTime)
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	if r.Delete {
+		// the pod recreated with the same name has fresh secrets
+		uid := pod.UID
+		if err := r.Client.Delete(ctx, pod, &client.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}}); err != nil {
+			if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+				return ctrl.Result{}, nil
+			}
+			return ctrl.Result{}, err
+		}
+		logger.Info("Deleted pod with expired secrets", "pod", req.NamespacedName, "expirationTime", time.Unix(expiresTime, 0))
+		return ctrl.Result{}, nil
+	}
+
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+	}
+	if err := r.SubResource("eviction").Create(ctx, pod, eviction); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		// the eviction would violate a PodDisruptionBudget
+		if apierrors.IsTooManyRequests(err) {
+			logger.Info("Eviction of pod with expired secrets refused, retry later", "pod", req.NamespacedName,
+				"error", err.Error())
+			return ctrl.Result{RequeueAfter: podEvictionRetryInterval}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	logger.Info("Evicted pod with expired secrets", "pod", req.NamespacedName, "expirationTime", time.Unix(expiresTime, 0))
+	return ctrl.Result{}, nil
+}
+
+func (r *PodExpiryReconciler) clock() clock.PassiveClock {
+	if r.Clock == nil {
+		return clock.RealClock{}
+	}
+	return r.Clock
+}
+
+// SetupWithManager sets up the controller with the Manager, only the pods with an expiration time are watched.
+func (r *PodExpiryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	hasExpirationTime := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		_, ok := obj.GetAnnotations()[volume.SecretZncdataExpirationTime]
+		return ok
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("pod-expiry").
+		For(&corev1.Pod{}, builder.WithPredicates(hasExpirationTime)).
+		Complete(r)
+}
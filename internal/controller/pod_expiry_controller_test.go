@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func newTestExpiringPod(expiresTime time.Time) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "default",
+			UID:       "test-uid",
+			Annotations: map[string]string{
+				volume.SecretZncdataExpirationTime: strconv.FormatInt(expiresTime.Unix(), 10),
+			},
+		},
+	}
+}
+
+func TestPodExpiryReconcile(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	grace := 10 * time.Minute
+
+	tests := []struct {
+		name        string
+		expiresTime time.Time
+		delete      bool
+		restarted   bool
+		requeue     time.Duration
+	}{
+		{
+			name:        "before expiry",
+			expiresTime: now.Add(time.Hour),
+			requeue:     time.Hour - grace,
+		},
+		{
+			name:        "within grace period, evicted",
+			expiresTime: now.Add(5 * time.Minute),
+			restarted:   true,
+		},
+		{
+			name:        "expired, evicted",
+			expiresTime: now.Add(-time.Minute),
+			restarted:   true,
+		},
+		{
+			name:        "expired, deleted",
+			expiresTime: now.Add(-time.Minute),
+			delete:      true,
+			restarted:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestExpiringPod(tt.expiresTime)
+			evicted, deleted := false, false
+			c := fake.NewClientBuilder().
+				WithScheme(clientgoscheme.Scheme).
+				WithObjects(pod).
+				WithInterceptorFuncs(interceptor.Funcs{
+					SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+						evicted = subResourceName == "eviction"
+						return c.SubResource(subResourceName).Create(ctx, obj, subResource, opts...)
+					},
+					Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+						deleted = true
+						return c.Delete(ctx, obj, opts...)
+					},
+				}).
+				Build()
+			r := &PodExpiryReconciler{
+				Client:      c,
+				Scheme:      clientgoscheme.Scheme,
+				GracePeriod: grace,
+				Delete:      tt.delete,
+				Clock:       clocktesting.NewFakePassiveClock(now),
+			}
+
+			result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.RequeueAfter != tt.requeue {
+				t.Errorf("unexpected requeue: got %s, want %s", result.RequeueAfter, tt.requeue)
+			}
+			if tt.restarted && evicted == tt.delete {
+				t.Errorf("unexpected restart: evicted %t, deleted %t, want delete %t", evicted, deleted, tt.delete)
+			}
+
+			err = c.Get(context.Background(), client.ObjectKeyFromObject(pod), &corev1.Pod{})
+			if gone := apierrors.IsNotFound(err); gone != tt.restarted {
+				t.Errorf("unexpected pod: restarted %t, want %t, error %v", gone, tt.restarted, err)
+			}
+		})
+	}
+}
+
+func TestPodExpiryReconcileEvictionRefused(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pod := newTestExpiringPod(now.Add(-time.Minute))
+	c := fake.NewClientBuilder().
+		WithScheme(clientgoscheme.Scheme).
+		WithObjects(pod).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				return apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+			},
+		}).
+		Build()
+	r := &PodExpiryReconciler{Client: c, Scheme: clientgoscheme.Scheme, Clock: clocktesting.NewFakePassiveClock(now)}
+
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "test-pod", Namespace: "default"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter != podEvictionRetryInterval {
+		t.Errorf("unexpected requeue: got %s, want %s", result.RequeueAfter, podEvictionRetryInterval)
+	}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(pod), &corev1.Pod{}); err != nil {
+		t.Errorf("pod should not be deleted: %v", err)
+	}
+}
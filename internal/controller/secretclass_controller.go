@@ -18,40 +18,129 @@ package controller
 
 import (
 	"context"
+	"crypto/rand"
+	"io"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	secretvs1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/internal/csi/backend"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+const (
+	// SecretClassConditionReady is true when the backend of the SecretClass is valid.
+	SecretClassConditionReady = "Ready"
+
+	SecretClassReasonBackendValid   = "BackendValid"
+	SecretClassReasonBackendInvalid = "BackendInvalid"
+
+	secretClassValidateInterval = 5 * time.Minute
 )
 
 // SecretClassReconciler reconciles a SecretClass object
 type SecretClassReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Clock and Rand are replaced in tests, nil are the real clock and crypto/rand.
+	Clock clock.PassiveClock
+	Rand  io.Reader
 }
 
 //+kubebuilder:rbac:groups=secrets.zncdata.dev,resources=secretclasses,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=secrets.zncdata.dev,resources=secretclasses/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=secrets.zncdata.dev,resources=secretclasses/finalizers,verbs=update
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the SecretClass object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.15.0/pkg/reconcile
+// Reconcile validates the backend of the SecretClass, and reports the result in the Ready condition.
+// The backend depends on resources outside the SecretClass, e.g. the CA secret or vault,
+// so the SecretClass is validated again periodically.
+// The CA secret of an autoTls backend with autoGenerated is created first when it has no valid CA.
 func (r *SecretClassReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	logger := log.FromContext(ctx)
+
+	instance := &secretvs1alpha1.SecretClass{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			logger.V(5).Info("SecretClass resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "Failed to get SecretClass")
+		return ctrl.Result{}, err
+	}
+
+	logger.V(1).Info("Reconciling SecretClass", "Name", instance.Name)
+
+	condition := metav1.Condition{
+		Type:               SecretClassConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             SecretClassReasonBackendValid,
+		Message:            "Backend is valid",
+		ObservedGeneration: instance.Generation,
+	}
+
+	volumeSelector := &volume.SecretVolumeSelector{Class: instance.Name}
+	if err := r.bootstrapCA(ctx, instance, volumeSelector); err != nil {
+		logger.Error(err, "Failed to create the CA secret of SecretClass", "Name", instance.Name)
+		return ctrl.Result{}, err
+	}
+	if err := backend.NewBackend(r.Client, nil, volumeSelector, instance).Validate(ctx); err != nil {
+		logger.V(1).Info("SecretClass backend is invalid", "Name", instance.Name, "error", err.Error())
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = SecretClassReasonBackendInvalid
+		condition.Message = err.Error()
+	}
+
+	if meta.SetStatusCondition(&instance.Status.Conditions, condition) {
+		if err := r.Status().Update(ctx, instance); err != nil {
+			logger.Error(err, "Failed to update SecretClass status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: secretClassValidateInterval}, nil
+}
+
+// bootstrapCA creates the CA secret of the autoTls backend, when it is generated by the operator.
+// A misconfigured backend is skipped, it is reported by the validation.
+func (r *SecretClassReconciler) bootstrapCA(
+	ctx context.Context,
+	secretClass *secretvs1alpha1.SecretClass,
+	volumeSelector *volume.SecretVolumeSelector,
+) error {
+	autoTls := secretClass.Spec.Backend.AutoTls
+	if autoTls == nil || autoTls.CA == nil || !autoTls.CA.AutoGenerated {
+		return nil
+	}
 
-	// TODO(user): your logic here
+	clk, random := r.Clock, r.Rand
+	if clk == nil {
+		clk = clock.RealClock{}
+	}
+	if random == nil {
+		random = rand.Reader
+	}
+	autoTlsBackend, err := backend.NewAutoTlsBackend(r.Client, nil, volumeSelector, autoTls, clk, random)
+	if err != nil {
+		return nil
+	}
 
-	return ctrl.Result{}, nil
+	created, err := autoTlsBackend.BootstrapCA(ctx)
+	if err != nil {
+		return err
+	}
+	if created {
+		log.FromContext(ctx).Info("Created the CA secret of SecretClass", "Name", secretClass.Name,
+			"secret", autoTls.CA.Secret.Namespace+"/"+autoTls.CA.Secret.Name)
+	}
+	return nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
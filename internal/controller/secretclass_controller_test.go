@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+)
+
+func newTestAutoTlsSecretClass(autoGenerated bool) *secretsv1alpha1.SecretClass {
+	return &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "tls"},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{
+				AutoTls: &secretsv1alpha1.AutoTlsSpec{
+					CA: &secretsv1alpha1.CASpec{
+						AutoGenerated:         autoGenerated,
+						CACertificateLifeTime: "8760h",
+						CommonName:            "Test CA",
+						Secret:                &secretsv1alpha1.SecretSpec{Name: "secret-provisioner-tls-ca", Namespace: "default"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func newTestSecretClassReconciler(t *testing.T, now time.Time, objs ...client.Object) *SecretClassReconciler {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := secretsv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&secretsv1alpha1.SecretClass{}).
+		Build()
+	return &SecretClassReconciler{
+		Client: c,
+		Scheme: scheme,
+		Clock:  clocktesting.NewFakePassiveClock(now),
+		Rand:   rand.Reader,
+	}
+}
+
+func getTestCASecret(c client.Client) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(context.Background(), client.ObjectKey{Name: "secret-provisioner-tls-ca", Namespace: "default"}, secret)
+	return secret, err
+}
+
+func TestSecretClassReconcileBootstrapCA(t *testing.T) {
+	now := time.Now()
+	secretClass := newTestAutoTlsSecretClass(true)
+	r := newTestSecretClassReconciler(t, now, secretClass)
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(secretClass)}
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret, err := getTestCASecret(r.Client)
+	if err != nil {
+		t.Fatalf("CA secret not created: %v", err)
+	}
+	var certs []*x509.Certificate
+	for name, data := range secret.Data {
+		if !strings.HasSuffix(name, ".crt") {
+			continue
+		}
+		block, _ := pem.Decode(data)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) != 1 || len(secret.Data) != 2 {
+		t.Fatalf("expected one certificate authority, got %d entries", len(secret.Data))
+	}
+	if !certs[0].IsCA || certs[0].Subject.CommonName != "Test CA" {
+		t.Errorf("unexpected certificate authority: CA %t, common name %q", certs[0].IsCA, certs[0].Subject.CommonName)
+	}
+	if want := now.Add(8760 * time.Hour); certs[0].NotAfter.Sub(want).Abs() > time.Second {
+		t.Errorf("unexpected expiration: got %s, want %s", certs[0].NotAfter, want)
+	}
+
+	if err := r.Get(context.Background(), req.NamespacedName, secretClass); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(secretClass.Status.Conditions, SecretClassConditionReady) {
+		t.Errorf("expected the SecretClass to be ready, got %v", secretClass.Status.Conditions)
+	}
+
+	// the valid CA is kept
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	again, err := getTestCASecret(r.Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Data, secret.Data) {
+		t.Error("CA secret regenerated while its certificate authority is valid")
+	}
+
+	// an expired CA is replaced
+	r.Clock = clocktesting.NewFakePassiveClock(now.Add(8761 * time.Hour))
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	replaced, err := getTestCASecret(r.Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(replaced.Data, secret.Data) {
+		t.Error("expired CA secret not replaced")
+	}
+}
+
+func TestSecretClassReconcileBootstrapCADisabled(t *testing.T) {
+	secretClass := newTestAutoTlsSecretClass(false)
+	r := newTestSecretClassReconciler(t, time.Now(), secretClass)
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(secretClass)}
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := getTestCASecret(r.Client); !apierrors.IsNotFound(err) {
+		t.Errorf("expected no CA secret, got %v", err)
+	}
+
+	if err := r.Get(context.Background(), req.NamespacedName, secretClass); err != nil {
+		t.Fatal(err)
+	}
+	if meta.IsStatusConditionTrue(secretClass.Status.Conditions, SecretClassConditionReady) {
+		t.Error("expected the SecretClass without CA not to be ready")
+	}
+}
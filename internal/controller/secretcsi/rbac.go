@@ -93,6 +93,11 @@ func (r *RBAC) buildClusterRole() *rbacv1.ClusterRole {
 				Resources: []string{"nodes"},
 				Verbs:     []string{"get", "list", "watch"},
 			},
+			{
+				APIGroups: []string{""},
+				Resources: []string{"namespaces"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
 			{
 				APIGroups: []string{""},
 				Resources: []string{"secrets"},
@@ -103,6 +108,21 @@ func (r *RBAC) buildClusterRole() *rbacv1.ClusterRole {
 				Resources: []string{"pods"},
 				Verbs:     []string{"get", "list", "watch", "patch"},
 			},
+			{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
+			{
+				APIGroups: []string{""},
+				Resources: []string{"serviceaccounts/token"},
+				Verbs:     []string{"create"},
+			},
+			{
+				APIGroups: []string{""},
+				Resources: []string{"services"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
 			{
 				APIGroups: []string{""},
 				Resources: []string{"events"},
@@ -118,6 +138,16 @@ func (r *RBAC) buildClusterRole() *rbacv1.ClusterRole {
 				Resources: []string{"persistentvolumeclaims"},
 				Verbs:     []string{"get", "list", "watch"},
 			},
+			{
+				APIGroups: []string{"apps"},
+				Resources: []string{"deployments", "statefulsets", "daemonsets"},
+				Verbs:     []string{"get", "list", "watch", "patch"},
+			},
+			{
+				APIGroups: []string{"apps"},
+				Resources: []string{"replicasets"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
 			{
 				APIGroups: []string{"storage.k8s.io"},
 				Resources: []string{"csidrivers"},
@@ -133,6 +163,11 @@ func (r *RBAC) buildClusterRole() *rbacv1.ClusterRole {
 				Resources: []string{"secretclasses"},
 				Verbs:     []string{"get", "list", "watch"},
 			},
+			{
+				APIGroups: []string{"cert-manager.io"},
+				Resources: []string{"certificaterequests"},
+				Verbs:     []string{"get", "create", "delete"},
+			},
 		},
 	}
 	return obj
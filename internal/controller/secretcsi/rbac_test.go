@@ -0,0 +1,51 @@
+package secret_csi_plugin
+
+import (
+	"slices"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+)
+
+// allowed returns whether one of the rules allows the verb on the resource of the api group.
+func allowed(rules []rbacv1.PolicyRule, apiGroup, resource, verb string) bool {
+	for _, rule := range rules {
+		if slices.Contains(rule.APIGroups, apiGroup) && slices.Contains(rule.Resources, resource) && slices.Contains(rule.Verbs, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBuildClusterRole(t *testing.T) {
+	cr := &secretsv1alpha1.SecretCSI{ObjectMeta: metav1.ObjectMeta{Name: "secretcsi", Namespace: "default"}}
+	clusterRole := NewRBAC(nil, cr).buildClusterRole()
+
+	tests := []struct {
+		apiGroup string
+		resource string
+		verbs    []string
+	}{
+		// the template ConfigMaps of the volumes
+		{apiGroup: "", resource: "configmaps", verbs: []string{"get", "list", "watch"}},
+		// the workloads restarted on rotation, found through the owner references of the pods
+		{apiGroup: "apps", resource: "deployments", verbs: []string{"get", "list", "watch", "patch"}},
+		{apiGroup: "apps", resource: "statefulsets", verbs: []string{"get", "list", "watch", "patch"}},
+		{apiGroup: "apps", resource: "daemonsets", verbs: []string{"get", "list", "watch", "patch"}},
+		{apiGroup: "apps", resource: "replicasets", verbs: []string{"get", "list", "watch"}},
+	}
+	for _, tt := range tests {
+		for _, verb := range tt.verbs {
+			if !allowed(clusterRole.Rules, tt.apiGroup, tt.resource, verb) {
+				t.Errorf("expected %s of %q %s to be allowed", verb, tt.apiGroup, tt.resource)
+			}
+		}
+	}
+	// the ReplicaSets are only read to find their Deployment, the Deployment is patched
+	if allowed(clusterRole.Rules, "apps", "replicasets", "patch") {
+		t.Error("expected patch of replicasets not to be allowed")
+	}
+}
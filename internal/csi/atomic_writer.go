@@ -0,0 +1,215 @@
+package csi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// dataDirName is the symlink to the directory of the current secret data.
+	dataDirName = "..data"
+
+	// newDataDirName is the temporary symlink renamed to dataDirName, so the swap is atomic.
+	newDataDirName = "..data_tmp"
+
+	// tsDirPrefix is the prefix of the timestamped directories holding the secret data.
+	tsDirPrefix = "..2006_01_02_15_04_05."
+
+	// tmpFilePattern is the pattern of the temporary files renamed to the secret files once fully written.
+	tmpFilePattern = "..tmp_*"
+)
+
+// writeData writes the data to the target path atomically, the same way kubelet writes the secret volumes.
+// The data is a map of key-value pairs, the key is the file name, and the value is the file content.
+//
+// The files are written to a new timestamped directory, each one to a temporary file renamed once fully written,
+// then the ..data symlink is swapped to it with a rename, and each key is a symlink to ..data/<key>. A reader resolving ..data sees either the old or the new data,
+// never a mix of them, so the secret can be rotated in place safely.
+//
+// The files are written with the given permission, and owned by the given uid and gid.
+// A uid or gid of -1 keeps the owner of the file unchanged.
+func (n *NodeServer) writeData(targetPath string, data map[string][]byte, mode fs.FileMode, uid, gid int) error {
+	// validate all keys before any file is created, so a bad key never leaves a partially written volume
+	for name := range data {
+		if err := validateFileName(name); err != nil {
+			return err
+		}
+	}
+
+	tsDir, err := n.writeTsDir(targetPath, data, mode, uid, gid)
+	if err != nil {
+		return err
+	}
+
+	// swap the data directory atomically
+	newDataDir := filepath.Join(targetPath, newDataDirName)
+	if err := os.Symlink(filepath.Base(tsDir), newDataDir); err != nil {
+		_ = os.RemoveAll(tsDir)
+		return err
+	}
+	if err := os.Rename(newDataDir, filepath.Join(targetPath, dataDirName)); err != nil {
+		_ = os.Remove(newDataDir)
+		_ = os.RemoveAll(tsDir)
+		return err
+	}
+
+	if err := createUserVisibleFiles(targetPath, data); err != nil {
+		return err
+	}
+	if err := removeUserVisibleFiles(targetPath, data); err != nil {
+		return err
+	}
+
+	if err := removeOldTsDirs(targetPath, filepath.Base(tsDir)); err != nil {
+		return err
+	}
+
+	logger.V(5).Info("Data written", "target", targetPath, "dataDir", filepath.Base(tsDir))
+	return nil
+}
+
+// writeTsDir writes the data to a new timestamped directory under the target path, and returns its path.
+// The directory is removed if any file fails to be written.
+func (n *NodeServer) writeTsDir(targetPath string, data map[string][]byte, mode fs.FileMode, uid, gid int) (tsDir string, err error) {
+	tsDir, err = os.MkdirTemp(targetPath, n.clock.Now().UTC().Format(tsDirPrefix))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(tsDir)
+		}
+	}()
+
+	// os.MkdirTemp creates the directory with 0700, the pod must be able to read it
+	if err := os.Chmod(tsDir, 0755); err != nil {
+		return "", err
+	}
+	if err := chown(tsDir, uid, gid); err != nil {
+		return "", err
+	}
+
+	for name, content := range data {
+		fileName := filepath.Join(tsDir, name)
+		if err := writeFile(fileName, bytes.NewReader(content), mode, uid, gid); err != nil {
+			return "", err
+		}
+		logger.V(5).Info("File written", "file", fileName)
+	}
+	return tsDir, nil
+}
+
+// writeFile writes the content to a temporary file in the same directory, and renames it to the file once it is
+// fully written and synced, the rename is atomic on the same filesystem. An interrupted write never leaves
+// a truncated file, the temporary file is removed, or by removeOldTsDirs when the driver was killed.
+func writeFile(fileName string, content io.Reader, mode fs.FileMode, uid, gid int) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(fileName), tmpFilePattern)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if _, err := io.Copy(f, content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", fileName, err)
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	// os.CreateTemp creates the file with 0600, set the mode explicitly
+	if err := f.Chmod(mode); err != nil {
+		return err
+	}
+	if err := chown(f.Name(), uid, gid); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fileName)
+}
+
+func chown(name string, uid, gid int) error {
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+	if err := os.Chown(name, uid, gid); err != nil {
+		return fmt.Errorf("failed to change owner of %s to %d:%d, make sure the csi driver runs with enough privilege: %w",
+			name, uid, gid, err)
+	}
+	return nil
+}
+
+// createUserVisibleFiles links each key to ..data/<key>.
+// A regular file with the same name, e.g. written before the atomic writer is used, is replaced.
+func createUserVisibleFiles(targetPath string, data map[string][]byte) error {
+	for name := range data {
+		fileName := filepath.Join(targetPath, name)
+		link := filepath.Join(dataDirName, name)
+
+		current, err := os.Readlink(fileName)
+		if err == nil && current == link {
+			continue
+		}
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(link, fileName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeUserVisibleFiles removes the links of the keys not in the data any more.
+func removeUserVisibleFiles(targetPath string, data map[string][]byte) error {
+	entries, err := os.ReadDir(targetPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if _, ok := data[name]; ok || strings.HasPrefix(name, "..") || entry.Type()&fs.ModeSymlink == 0 {
+			continue
+		}
+		link, err := os.Readlink(filepath.Join(targetPath, name))
+		if err != nil {
+			return err
+		}
+		if link != filepath.Join(dataDirName, name) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(targetPath, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeOldTsDirs removes the timestamped directories except the current one,
+// including the ones left behind when a previous write was interrupted.
+func removeOldTsDirs(targetPath, current string) error {
+	entries, err := os.ReadDir(targetPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !strings.HasPrefix(name, "..") || name == current {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(targetPath, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package csi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/utils/mount"
+)
+
+func readDataDirs(t *testing.T, targetPath string) []string {
+	entries, err := os.ReadDir(targetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), "..") {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+	return dirs
+}
+
+func TestWriteDataAtomic(t *testing.T) {
+	n := NewNodeServer("test-node", mount.NewFakeMounter(nil), nil)
+	targetPath := t.TempDir()
+
+	if err := n.writeData(targetPath, map[string][]byte{"a": []byte("1"), "b": []byte("1")}, 0640, -1, -1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := n.writeData(targetPath, map[string][]byte{"a": []byte("2"), "c": []byte("2")}, 0640, -1, -1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, expected := range map[string]string{"a": "2", "c": "2"} {
+		link, err := os.Readlink(filepath.Join(targetPath, name))
+		if err != nil {
+			t.Fatalf("expected %s to be a symlink: %v", name, err)
+		}
+		if link != filepath.Join(dataDirName, name) {
+			t.Errorf("unexpected link of %s: got %s", name, link)
+		}
+		data, err := os.ReadFile(filepath.Join(targetPath, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("unexpected content of %s: got %q, want %q", name, data, expected)
+		}
+	}
+
+	if _, err := os.Lstat(filepath.Join(targetPath, "b")); !os.IsNotExist(err) {
+		t.Errorf("expected removed key b to be deleted, got %v", err)
+	}
+
+	dirs := readDataDirs(t, targetPath)
+	if len(dirs) != 1 {
+		t.Fatalf("expected only the current data directory, got %v", dirs)
+	}
+	if current, _ := os.Readlink(filepath.Join(targetPath, dataDirName)); current != dirs[0] {
+		t.Errorf("expected %s to point to %s, got %s", dataDirName, dirs[0], current)
+	}
+
+	info, err := os.Stat(filepath.Join(targetPath, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("unexpected file mode: got %o, want %o", info.Mode().Perm(), 0640)
+	}
+}
+
+func TestWriteDataRemovesStaleDataDirs(t *testing.T) {
+	n := NewNodeServer("test-node", mount.NewFakeMounter(nil), nil)
+	targetPath := t.TempDir()
+
+	// left behind by an interrupted write
+	if err := os.Mkdir(filepath.Join(targetPath, "..2024_01_01_00_00_00.123"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.writeData(targetPath, map[string][]byte{"a": []byte("1")}, 0640, -1, -1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dirs := readDataDirs(t, targetPath); len(dirs) != 1 {
+		t.Errorf("expected stale data directories to be removed, got %v", dirs)
+	}
+}
+
+// interruptedReader returns the first half of the content, then fails like a write killed midway.
+type interruptedReader struct {
+	content []byte
+	read    bool
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, errors.New("interrupted")
+	}
+	r.read = true
+	return copy(p, r.content[:len(r.content)/2]), nil
+}
+
+func TestWriteFileInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "tls.key")
+	if err := writeFile(fileName, strings.NewReader("old key"), 0640, -1, -1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := writeFile(fileName, &interruptedReader{content: []byte("new private key")}, 0640, -1, -1)
+	if err == nil {
+		t.Fatal("expected error for the interrupted write")
+	}
+
+	// the previous file is untouched, and no temporary file remains
+	if data, err := os.ReadFile(fileName); err != nil || string(data) != "old key" {
+		t.Errorf("unexpected file after the interrupted write: %q, error %v", data, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("unexpected files after the interrupted write: %v", entries)
+	}
+
+	if err := writeFile(fileName, io.MultiReader(strings.NewReader("new "), strings.NewReader("key")), 0600, -1, -1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(fileName); string(data) != "new key" || info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected file: %q, mode %s", data, info.Mode())
+	}
+}
+
+// TestWriteDataConsistentSnapshot updates the data while readers read all the files through the
+// resolved ..data directory, the files read from the same snapshot must be from the same version.
+func TestWriteDataConsistentSnapshot(t *testing.T) {
+	n := NewNodeServer("test-node", mount.NewFakeMounter(nil), nil)
+	targetPath := t.TempDir()
+
+	write := func(version int) error {
+		content := []byte(fmt.Sprintf("v%d", version))
+		return n.writeData(targetPath, map[string][]byte{"tls.crt": content, "tls.key": content}, 0640, -1, -1)
+	}
+	if err := write(0); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				link, err := os.Readlink(filepath.Join(targetPath, dataDirName))
+				if err != nil {
+					t.Errorf("failed to resolve %s: %v", dataDirName, err)
+					return
+				}
+				dir := filepath.Join(targetPath, link)
+				cert, certErr := os.ReadFile(filepath.Join(dir, "tls.crt"))
+				key, keyErr := os.ReadFile(filepath.Join(dir, "tls.key"))
+				// the snapshot may be removed by the next update while it is read
+				if certErr != nil || keyErr != nil {
+					continue
+				}
+				if string(cert) != string(key) {
+					t.Errorf("inconsistent snapshot: tls.crt is %s, tls.key is %s", cert, key)
+					return
+				}
+			}
+		}()
+	}
+
+	for version := 1; version <= 200; version++ {
+		if err := write(version); err != nil {
+			t.Errorf("failed to write version %d: %v", version, err)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+}
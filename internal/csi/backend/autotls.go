@@ -3,11 +3,24 @@ package backend
 import (
 	"context"
 	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/zncdata-labs/secret-operator/pkg/format"
 	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
 	"github.com/zncdata-labs/secret-operator/pkg/util"
 	"github.com/zncdata-labs/secret-operator/pkg/volume"
+	"k8s.io/utils/clock"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
@@ -15,11 +28,43 @@ import (
 )
 
 const (
-	KeystoreP12FileName   = "keystore.p12"
-	TruststoreP12FileName = "truststore.p12"
-	PEMTlsCertFileName    = "tls.crt"
-	PEMTlsKeyFileName     = "tls.key"
-	PEMCaCertFileName     = "ca.crt"
+	PEMTlsCertFileName = format.PEMTlsCertFileName
+	PEMTlsKeyFileName  = format.PEMTlsKeyFileName
+	PEMCaCertFileName  = format.PEMCaCertFileName
+)
+
+const (
+	// defaultCertLifetime is the lifetime of the certificate when the volume does not specify
+	// secrets.zncdata.dev/autoTlsCertLifetime.
+	defaultCertLifetime = 24 * time.Hour
+
+	// defaultMaxCertificateLifeTime is used when the secret class does not specify maxCertificateLifeTime.
+	defaultMaxCertificateLifeTime = 360 * time.Hour
+
+	// defaultNotBeforeSkew is used when the secret class does not specify notBeforeSkew,
+	// maxNotBeforeSkew caps it, a certificate backdated further hides a wrong clock.
+	defaultNotBeforeSkew = 5 * time.Minute
+	maxNotBeforeSkew     = time.Hour
+
+	// defaultSpiffeTrustDomain is used when the secret class does not specify spiffeTrustDomain.
+	defaultSpiffeTrustDomain = "cluster.local"
+)
+
+// spiffeTrustDomainRegexp matches the trust domains allowed by the SPIFFE ID specification.
+var spiffeTrustDomainRegexp = regexp.MustCompile(`^[a-z0-9._-]+$`)
+
+// maxCommonNameLength is the upper bound of the common name, see ub-common-name in RFC 5280.
+const maxCommonNameLength = 64
+
+// commonNamePlaceholderRegexp matches the placeholders of the common name template, e.g. {pod}.
+var commonNamePlaceholderRegexp = regexp.MustCompile(`\{[^{}]*\}`)
+
+// The placeholders of the common name template.
+const (
+	commonNamePlaceholderPod       = "{pod}"
+	commonNamePlaceholderNamespace = "{namespace}"
+	commonNamePlaceholderService   = "{service}"
+	commonNamePlaceholderNode      = "{node}"
 )
 
 type AutoTlsBackend struct {
@@ -27,8 +72,18 @@ type AutoTlsBackend struct {
 	podInfo                *pod_info.PodInfo
 	volumeSelector         *volume.SecretVolumeSelector
 	maxCertificateLifeTime time.Duration
-
-	ca *secretsv1alpha1.CASpec
+	notBeforeSkew          time.Duration
+	jitterFactor           float64
+	keyAlgorithm           ca.KeyAlgorithm
+	usages                 ca.Usages
+	spiffeTrustDomain      string
+	commonNameTemplate     string
+
+	ca            *secretsv1alpha1.CASpec
+	caConstraints *ca.Constraints
+
+	clock  clock.PassiveClock
+	random io.Reader
 }
 
 func NewAutoTlsBackend(
@@ -36,64 +91,277 @@ func NewAutoTlsBackend(
 	podInfo *pod_info.PodInfo,
 	volumeSelector *volume.SecretVolumeSelector,
 	autotls *secretsv1alpha1.AutoTlsSpec,
+	clock clock.PassiveClock,
+	random io.Reader,
 ) (*AutoTlsBackend, error) {
-	maxCertificateLifeTime, err := time.ParseDuration(autotls.MaxCertificateLifeTime)
+	if autotls.CA == nil || autotls.CA.Secret == nil {
+		return nil, fmt.Errorf("%w: autoTls ca secret is not set in secret class", ErrSecretClassInvalid)
+	}
+	if _, err := time.ParseDuration(autotls.CA.CACertificateLifeTime); err != nil {
+		return nil, fmt.Errorf("%w: invalid caCertificateLifeTime %q: %w", ErrSecretClassInvalid, autotls.CA.CACertificateLifeTime, err)
+	}
+
+	maxCertificateLifeTime := defaultMaxCertificateLifeTime
+	if autotls.MaxCertificateLifeTime != "" {
+		d, err := time.ParseDuration(autotls.MaxCertificateLifeTime)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid maxCertificateLifeTime %q: %w", ErrSecretClassInvalid, autotls.MaxCertificateLifeTime, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("%w: invalid maxCertificateLifeTime %q: must be greater than zero", ErrSecretClassInvalid, autotls.MaxCertificateLifeTime)
+		}
+		maxCertificateLifeTime = d
+	}
+
+	notBeforeSkew := defaultNotBeforeSkew
+	if autotls.NotBeforeSkew != "" {
+		d, err := time.ParseDuration(autotls.NotBeforeSkew)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid notBeforeSkew %q: %w", ErrSecretClassInvalid, autotls.NotBeforeSkew, err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("%w: invalid notBeforeSkew %q: must not be negative", ErrSecretClassInvalid, autotls.NotBeforeSkew)
+		}
+		if d > maxNotBeforeSkew {
+			logger.V(1).Info("notBeforeSkew exceeds the max skew, use the max one", "notBeforeSkew", d, "max", maxNotBeforeSkew)
+			d = maxNotBeforeSkew
+		}
+		notBeforeSkew = d
+	}
+
+	keyAlgorithm, err := ca.ParseKeyAlgorithm(autotls.KeyAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSecretClassInvalid, err)
+	}
+
+	keyUsage, err := ca.ParseKeyUsages(autotls.KeyUsages)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSecretClassInvalid, err)
+	}
+	// only the RSA keys can encipher
+	if keyUsage&x509.KeyUsageKeyEncipherment != 0 && keyAlgorithm != ca.KeyAlgorithmRSA2048 && keyAlgorithm != ca.KeyAlgorithmRSA4096 {
+		return nil, fmt.Errorf("%w: key usage %s requires a RSA key algorithm, got %s",
+			ErrSecretClassInvalid, ca.KeyUsageKeyEncipherment, keyAlgorithm)
+	}
+	extKeyUsages, err := ca.ParseExtKeyUsages(autotls.ExtendedKeyUsages)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSecretClassInvalid, err)
+	}
+
+	spiffeTrustDomain := defaultSpiffeTrustDomain
+	if autotls.SpiffeTrustDomain != "" {
+		if !spiffeTrustDomainRegexp.MatchString(autotls.SpiffeTrustDomain) {
+			return nil, fmt.Errorf("%w: invalid spiffeTrustDomain %q: must only contain lowercase letters, digits, '.', '-' and '_'",
+				ErrSecretClassInvalid, autotls.SpiffeTrustDomain)
+		}
+		spiffeTrustDomain = autotls.SpiffeTrustDomain
+	}
+
+	for _, placeholder := range commonNamePlaceholderRegexp.FindAllString(autotls.CommonNameTemplate, -1) {
+		switch placeholder {
+		case commonNamePlaceholderPod, commonNamePlaceholderNamespace, commonNamePlaceholderService, commonNamePlaceholderNode:
+		default:
+			return nil, fmt.Errorf("%w: unknown placeholder %s in commonNameTemplate %q", ErrSecretClassInvalid, placeholder, autotls.CommonNameTemplate)
+		}
+	}
+
+	caConstraints, err := parseCAConstraints(autotls.CA.Constraints)
 	if err != nil {
 		return nil, err
 	}
 
+	jitterFactor := float64(autotls.CertificateJitterPercent) / 100
+	if volumeSelector.AutoTlsCertJitterFactor != 0 {
+		jitterFactor = volumeSelector.AutoTlsCertJitterFactor
+	}
+
 	return &AutoTlsBackend{
 		client:                 client,
 		podInfo:                podInfo,
 		volumeSelector:         volumeSelector,
 		maxCertificateLifeTime: maxCertificateLifeTime,
+		notBeforeSkew:          notBeforeSkew,
+		jitterFactor:           jitterFactor,
+		keyAlgorithm:           keyAlgorithm,
+		usages:                 ca.Usages{KeyUsage: keyUsage, ExtKeyUsages: extKeyUsages},
+		spiffeTrustDomain:      spiffeTrustDomain,
+		commonNameTemplate:     autotls.CommonNameTemplate,
 		ca:                     autotls.CA,
+		caConstraints:          caConstraints,
+		clock:                  clock,
+		random:                 random,
 	}, nil
 }
 
-func (a *AutoTlsBackend) getCertLife() (time.Duration, error) {
-	// TODO: implement
-	return time.Duration(10 * time.Hour), nil
+// parseCAConstraints returns the constraints of the certificate authorities, nil when the spec is nil.
+// The returned error is an ErrSecretClassInvalid.
+func parseCAConstraints(spec *secretsv1alpha1.CAConstraintsSpec) (*ca.Constraints, error) {
+	if spec == nil {
+		return nil, nil
+	}
+
+	constraints := &ca.Constraints{}
+	if spec.MaxPathLength != nil {
+		if *spec.MaxPathLength < 0 {
+			return nil, fmt.Errorf("%w: invalid ca constraints maxPathLength %d: must not be negative", ErrSecretClassInvalid, *spec.MaxPathLength)
+		}
+		maxPathLen := int(*spec.MaxPathLength)
+		constraints.MaxPathLen = &maxPathLen
+	}
+
+	for _, domains := range []struct {
+		name   string
+		values []string
+		out    *[]string
+	}{
+		{name: "permittedDNSDomains", values: spec.PermittedDNSDomains, out: &constraints.PermittedDNSDomains},
+		{name: "excludedDNSDomains", values: spec.ExcludedDNSDomains, out: &constraints.ExcludedDNSDomains},
+	} {
+		for _, domain := range domains.values {
+			if strings.TrimPrefix(domain, ".") == "" || strings.ContainsAny(domain, " *") {
+				return nil, fmt.Errorf("%w: invalid ca constraints %s %q: must be a DNS domain", ErrSecretClassInvalid, domains.name, domain)
+			}
+			*domains.out = append(*domains.out, strings.ToLower(domain))
+		}
+	}
+
+	for _, ipRanges := range []struct {
+		name   string
+		values []string
+		out    *[]*net.IPNet
+	}{
+		{name: "permittedIPRanges", values: spec.PermittedIPRanges, out: &constraints.PermittedIPRanges},
+		{name: "excludedIPRanges", values: spec.ExcludedIPRanges, out: &constraints.ExcludedIPRanges},
+	} {
+		for _, cidr := range ipRanges.values {
+			_, ipRange, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid ca constraints %s %q: %w", ErrSecretClassInvalid, ipRanges.name, cidr, err)
+			}
+			*ipRanges.out = append(*ipRanges.out, ipRange)
+		}
+	}
+	return constraints, nil
 }
 
-func (a *AutoTlsBackend) certificateFormat() volume.SecretFormat {
-	return a.volumeSelector.Format
+// requestedCertLife returns the certificate lifetime requested by the volume, or the default one,
+// capped to the max certificate lifetime of the secret class.
+// The ttl of the volume shortens it, a ttl longer than the max certificate lifetime is refused.
+func (a *AutoTlsBackend) requestedCertLife() (time.Duration, error) {
+	certLife := defaultCertLifetime
+	if a.volumeSelector.AutoTlsCertLifetime != 0 {
+		certLife = a.volumeSelector.AutoTlsCertLifetime
+	}
+	if certLife < 0 {
+		return 0, fmt.Errorf("%w: %s %s must not be negative", ErrInvalidVolumeContext, volume.CertLifeTime, certLife)
+	}
+	if certLife > a.maxCertificateLifeTime {
+		logger.V(1).Info("Requested certificate lifetime exceeds the max certificate lifetime, use the max one",
+			"requested", certLife, "max", a.maxCertificateLifeTime)
+		certLife = a.maxCertificateLifeTime
+	}
+	if ttl := a.volumeSelector.TTL; ttl != 0 {
+		if ttl > a.maxCertificateLifeTime {
+			return 0, fmt.Errorf("%w: %s %s exceeds the max certificate lifetime %s of the secret class",
+				ErrInvalidVolumeContext, volume.TTL, ttl, a.maxCertificateLifeTime)
+		}
+		certLife = min(certLife, ttl)
+	}
+	return certLife, nil
 }
 
-// Convert the certificate to the format required by the volume
-// If the format is PKCS12, the certificate will be converted to PKCS12 format,
-// otherwise it will be converted to PEM format.
-func (a *AutoTlsBackend) certificateConvert(serverCert *ca.Certificate, caCert *ca.Certificate) (map[string]string, error) {
-	format := a.certificateFormat()
+// getCertLife returns the lifetime of the certificate to issue.
+// The certificate must not outlive the CA signing it, so the lifetime is clamped to the remaining validity of the CA.
+func (a *AutoTlsBackend) getCertLife(now time.Time, caNotAfter time.Time) (time.Duration, error) {
+	certLife, err := a.requestedCertLife()
+	if err != nil {
+		return 0, err
+	}
 
-	if format == volume.SecretFormatTLSP12 {
-		logger.Info("Converting certificate to PKCS12 format")
-		password := a.volumeSelector.TlsPKCS12Password
-		cas := []*x509.Certificate{caCert.Certificate}
+	remaining := caNotAfter.Sub(now)
+	if remaining <= 0 {
+		return 0, fmt.Errorf("%w: certificate authority expired at %s", ErrSecretClassInvalid, caNotAfter)
+	}
+	if certLife > remaining {
+		logger.V(1).Info("Requested certificate lifetime exceeds the validity of the certificate authority, clamp it",
+			"requested", certLife, "caNotAfter", caNotAfter)
+		certLife = remaining
+	}
+	return certLife - a.jitter(certLife), nil
+}
 
-		truststore, err := caCert.TrustStoreP12(password, cas)
-		if err != nil {
-			return nil, err
-		}
-		keyStore, err := serverCert.KeyStoreP12(password, cas)
-		if err != nil {
-			return nil, err
+// jitter returns the random part of the certificate lifetime to cut off, up to the jitter factor.
+// The random source is seeded by the pod UID, so the same pod always gets the same jitter,
+// and the expiration time does not drift when the volume is mounted again.
+func (a *AutoTlsBackend) jitter(certLife time.Duration) time.Duration {
+	if a.jitterFactor <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(a.podInfo.Pod.GetUID()))
+	r := rand.New(rand.NewSource(int64(h.Sum64())))
+	return time.Duration(float64(certLife) * a.jitterFactor * r.Float64())
+}
+
+// Convert the certificate to PEM format, ca.crt is the bundle of all the trusted CA certificates.
+// The conversion to the format required by the volume, e.g. PKCS12, is done by the node after
+// the secret data is returned, so every backend returning PEM data can be converted the same way.
+func (a *AutoTlsBackend) certificateConvert(serverCert *ca.Certificate, caCerts []*x509.Certificate) (map[string][]byte, error) {
+	keyPEM, err := serverCert.PrivateKeyPEM()
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		PEMTlsCertFileName: serverCert.CertificatePEM(),
+		PEMTlsKeyFileName:  keyPEM,
+		PEMCaCertFileName:  caBundle(caCerts),
+	}, nil
+}
+
+func caBundle(caCerts []*x509.Certificate) []byte {
+	var bundle []byte
+	for _, caCert := range caCerts {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})...)
+	}
+	return bundle
+}
+
+// getTrustAnchors returns only the trusted CA certificates in ca.crt, for the caOnly mode.
+// No certificate is signed, but the bundle expires like a certificate would, clamped to the first trusted CA
+// to expire, so the pod gets the CAs added by the rotation and drops the expired ones.
+func (a *AutoTlsBackend) getTrustAnchors(ctx context.Context, now time.Time) (*util.SecretContent, error) {
+	_, trustedCertificates, err := a.getCertificateAuthority(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	caNotAfter := trustedCertificates[0].NotAfter
+	for _, caCert := range trustedCertificates[1:] {
+		if caCert.NotAfter.Before(caNotAfter) {
+			caNotAfter = caCert.NotAfter
 		}
-		return map[string]string{
-			KeystoreP12FileName:   string(keyStore),
-			TruststoreP12FileName: string(truststore),
-		}, nil
 	}
-	logger.Info("Converting certificate to PEM format")
-	return map[string]string{
-		PEMTlsCertFileName: string(serverCert.CertificatePEM()),
-		PEMTlsKeyFileName:  string(serverCert.PrivateKeyPEM()),
-		PEMCaCertFileName:  string(caCert.CertificatePEM()),
+
+	duration, err := a.getCertLife(now, caNotAfter)
+	if err != nil {
+		return nil, err
+	}
+	expiresTime := now.Add(duration).Unix()
+
+	return &util.SecretContent{
+		Data:        map[string][]byte{PEMCaCertFileName: caBundle(trustedCertificates)},
+		ExpiresTime: &expiresTime,
 	}, nil
 }
 
 func (a *AutoTlsBackend) GetSecretData(ctx context.Context) (*util.SecretContent, error) {
-	certificateAuthority, err := a.getCertificateAuthority(ctx)
+	now := a.clock.Now()
+
+	if a.volumeSelector.AutoTls == volume.AutoTlsModeCAOnly {
+		return a.getTrustAnchors(ctx, now)
+	}
+
+	certificateAuthority, trustedCertificates, err := a.getCertificateAuthority(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -103,25 +371,49 @@ func (a *AutoTlsBackend) GetSecretData(ctx context.Context) (*util.SecretContent
 		return nil, err
 	}
 
-	duration, err := a.getCertLife()
+	duration, err := a.getCertLife(now, certificateAuthority.Certificate.NotAfter)
 	if err != nil {
 		return nil, err
 	}
 
-	notAfter := time.Now().Add(duration)
+	notAfter := now.Add(duration)
 
-	cnName := a.getCommonName()
+	cnName, err := a.getCommonName(addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	var uris []*url.URL
+	if a.volumeSelector.AutoTlsSpiffe {
+		uris = []*url.URL{a.getSpiffeID()}
+		// the mesh proxies connect to the pod ips, whatever the scope of the volume
+		addresses, err = a.withPodIPs(addresses)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// after the common name, so it is still derived from the scope
+	addresses = a.withExtraSANs(addresses)
 
 	serverCert, err := certificateAuthority.SignServerCertificate(
+		a.random,
+		a.keyAlgorithm,
 		cnName,
 		addresses,
+		uris,
+		a.usages,
+		// tolerate the clients whose clock is behind, the lifetime still starts now
+		now.Add(-a.notBeforeSkew),
 		notAfter,
 	)
+	if errors.Is(err, ca.ErrNameConstraintViolation) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidVolumeContext, err)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := a.certificateConvert(serverCert, certificateAuthority.PublicCertificate())
+	data, err := a.certificateConvert(serverCert, trustedCertificates)
 	if err != nil {
 		return nil, err
 	}
@@ -134,14 +426,135 @@ func (a *AutoTlsBackend) GetSecretData(ctx context.Context) (*util.SecretContent
 	}, nil
 }
 
-func (a *AutoTlsBackend) getCommonName() string {
-	return a.podInfo.GetPodName()
+// Validate implements Backend.
+// It checks the CA secret contains valid certificate authorities, the lifetimes in the secret class are checked
+// by NewAutoTlsBackend. The CA secret is not created or rotated here.
+func (a *AutoTlsBackend) Validate(ctx context.Context) error {
+	return ca.ValidateSecret(ctx, a.client, a.clock.Now(), a.ca.AutoGenerated, a.caConstraints, a.ca.Secret.Name, a.ca.Secret.Namespace)
+}
+
+// BootstrapCA creates the CA secret with a self-signed certificate authority when autoGenerated is true and
+// the secret has no valid certificate authority, and returns whether it was created.
+// So the CA exists before the first volume is published, e.g. for the clients trusting it.
+func (a *AutoTlsBackend) BootstrapCA(ctx context.Context) (bool, error) {
+	if !a.ca.AutoGenerated {
+		return false, nil
+	}
+	caCertificateLifeTime, err := time.ParseDuration(a.ca.CACertificateLifeTime)
+	if err != nil {
+		return false, fmt.Errorf("%w: invalid caCertificateLifeTime %q: %w", ErrSecretClassInvalid, a.ca.CACertificateLifeTime, err)
+	}
+	return ca.Bootstrap(ctx, a.client, a.clock, a.random, caCertificateLifeTime, a.ca.CommonName, a.caConstraints,
+		a.ca.Secret.Name, a.ca.Secret.Namespace)
+}
+
+// RotateCA rotates and prunes the certificate authorities of the CA secret by the policy when autoGenerated is true,
+// see ca.RotateSecret. The returned result is nil when the CA is not generated by the operator.
+func (a *AutoTlsBackend) RotateCA(ctx context.Context, policy ca.RotationPolicy) (*ca.RotationResult, error) {
+	if !a.ca.AutoGenerated {
+		return nil, nil
+	}
+	caCertificateLifeTime, err := time.ParseDuration(a.ca.CACertificateLifeTime)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid caCertificateLifeTime %q: %w", ErrSecretClassInvalid, a.ca.CACertificateLifeTime, err)
+	}
+	return ca.RotateSecret(ctx, a.client, a.clock, a.random, caCertificateLifeTime, a.caConstraints, policy,
+		a.ca.Secret.Name, a.ca.Secret.Namespace)
+}
+
+// getCommonName renders the common name template of the secret class. Without template, the common name is
+// the first DNS name of the addresses short enough, or the pod name.
+func (a *AutoTlsBackend) getCommonName(addresses []pod_info.Address) (string, error) {
+	if a.commonNameTemplate == "" {
+		for _, address := range addresses {
+			if address.Hostname != "" && len(address.Hostname) <= maxCommonNameLength {
+				return address.Hostname, nil
+			}
+		}
+		return a.podInfo.GetPodName(), nil
+	}
+
+	var err error
+	commonName := commonNamePlaceholderRegexp.ReplaceAllStringFunc(a.commonNameTemplate, func(placeholder string) string {
+		switch placeholder {
+		case commonNamePlaceholderPod:
+			return a.podInfo.GetPodName()
+		case commonNamePlaceholderNamespace:
+			return a.podInfo.GetPodNamespace()
+		case commonNamePlaceholderNode:
+			return a.podInfo.GetNodeName()
+		case commonNamePlaceholderService:
+			service := a.getServiceName()
+			if service == "" {
+				err = fmt.Errorf("%w: commonNameTemplate %q requires a service in the scope or a subdomain of pod %s/%s",
+					ErrInvalidVolumeContext, a.commonNameTemplate, a.podInfo.GetPodNamespace(), a.podInfo.GetPodName())
+			}
+			return service
+		}
+		return placeholder
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(commonName) > maxCommonNameLength {
+		return "", fmt.Errorf("%w: common name %q rendered from commonNameTemplate %q exceeds %d characters",
+			ErrSecretClassInvalid, commonName, a.commonNameTemplate, maxCommonNameLength)
+	}
+	return commonName, nil
+}
+
+// getServiceName returns the first service of the scope, or the subdomain of the pod, i.e. its headless service.
+func (a *AutoTlsBackend) getServiceName() string {
+	if len(a.volumeSelector.Scope.Services) > 0 {
+		return a.volumeSelector.Scope.Services[0]
+	}
+	return a.podInfo.Pod.Spec.Subdomain
 }
 
 func (a *AutoTlsBackend) getAddresses(ctx context.Context) ([]pod_info.Address, error) {
 	return a.podInfo.GetScopedAddresses(ctx)
 }
 
+// getSpiffeID returns the SPIFFE ID of the pod, "spiffe://<trust domain>/ns/<namespace>/sa/<service account>".
+func (a *AutoTlsBackend) getSpiffeID() *url.URL {
+	return &url.URL{
+		Scheme: "spiffe",
+		Host:   a.spiffeTrustDomain,
+		Path:   fmt.Sprintf("/ns/%s/sa/%s", a.podInfo.GetPodNamespace(), a.podInfo.GetServiceAccountName()),
+	}
+}
+
+// withPodIPs appends the pod ips missing from the addresses.
+func (a *AutoTlsBackend) withPodIPs(addresses []pod_info.Address) ([]pod_info.Address, error) {
+	for _, ipStr := range a.podInfo.GetPodIPs() {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid pod ip: %s from pod %s", ipStr, a.podInfo.GetPodName())
+		}
+		if !slices.ContainsFunc(addresses, func(address pod_info.Address) bool { return ip.Equal(address.IP) }) {
+			addresses = append(addresses, pod_info.Address{IP: ip})
+		}
+	}
+	return addresses, nil
+}
+
+// withExtraSANs appends the SANs of the volume missing from the addresses, see volume.AutoTlsSANs.
+// The SANs are validated by the volume selector, a SAN which is not an IP address is a DNS name.
+func (a *AutoTlsBackend) withExtraSANs(addresses []pod_info.Address) []pod_info.Address {
+	for _, san := range a.volumeSelector.AutoTlsSANs {
+		if ip := net.ParseIP(san); ip != nil {
+			if !slices.ContainsFunc(addresses, func(address pod_info.Address) bool { return ip.Equal(address.IP) }) {
+				addresses = append(addresses, pod_info.Address{IP: ip})
+			}
+			continue
+		}
+		if !slices.ContainsFunc(addresses, func(address pod_info.Address) bool { return strings.EqualFold(san, address.Hostname) }) {
+			addresses = append(addresses, pod_info.Address{Hostname: san})
+		}
+	}
+	return addresses
+}
+
 func (a *AutoTlsBackend) SignCertificate(ctx context.Context, ca *ca.CertificateAuthority) error {
 
 	panic("not implemented")
@@ -152,7 +565,8 @@ func (a *AutoTlsBackend) SignCertificate(ctx context.Context, ca *ca.Certificate
 
 }
 
-// Get CAs from the data in the secret, and get an older CA from them.
+// Get CAs from the data in the secret, and get the newest CA from them to sign the certificate,
+// together with all the valid CA certificates in the secret to trust.
 //
 // During the process of getting CAs from secret data, expired CAs will be filtered out.
 // If there is no available CA in the end, this situation may be that there is no available data in the secret, or the CA has expired,
@@ -161,32 +575,40 @@ func (a *AutoTlsBackend) SignCertificate(ctx context.Context, ca *ca.Certificate
 // During the process of getting the certificate, it will check whether the certificate is about to expire,
 // and the check condition is whether it has exceeded half of the maximum certificate validity period.
 // If it is about to expire, a new certificate will be generated when auto is true.
-func (a *AutoTlsBackend) getCertificateAuthority(ctx context.Context) (*ca.CertificateAuthority, error) {
+//
+// The newest CA is the one expiring last, the lifetime of the certificate is clamped to it.
+// While the CA is rotated, the clients still trusting only the old CA get the new one from ca.crt.
+func (a *AutoTlsBackend) getCertificateAuthority(ctx context.Context) (*ca.CertificateAuthority, []*x509.Certificate, error) {
 
 	caCertificateLifeTime, err := time.ParseDuration(a.ca.CACertificateLifeTime)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("%w: invalid caCertificateLifeTime %q: %w", ErrSecretClassInvalid, a.ca.CACertificateLifeTime, err)
 	}
 
 	certManager, err := ca.NewCertificateManager(
 		ctx,
 		a.client,
+		a.clock,
+		a.random,
 		caCertificateLifeTime,
 		a.ca.AutoGenerated,
+		a.ca.CommonName,
+		a.caConstraints,
 		a.ca.Secret.Name,
 		a.ca.Secret.Namespace,
 	)
+	if errors.Is(err, ca.ErrCACertificateNotFound) {
+		return nil, nil, fmt.Errorf("%w: %w in secret %s/%s", ErrSecretClassInvalid, err, a.ca.Secret.Namespace, a.ca.Secret.Name)
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	atAfter := time.Now().Add(a.maxCertificateLifeTime) // server cert lifetime in secret class configed
-
-	certificateAuthority, err := certManager.GetCertificateAuthority(atAfter)
+	certificateAuthority, err := certManager.GetLatestCertificateAuthority()
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("%w: %w", ErrSecretClassInvalid, err)
 	}
 
-	return certificateAuthority, nil
+	return certificateAuthority, certManager.TrustedCertificates(), nil
 
 }
@@ -0,0 +1,1206 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/internal/csi/backend/ca"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+const (
+	testCASecretName      = "test-ca"
+	testCASecretNamespace = "default"
+)
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := secretsv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+// newTestCASecret generates a self-signed CA, and returns it with the secret storing it.
+func newTestCASecret(t *testing.T, notAfter time.Time) (*ca.CertificateAuthority, *corev1.Secret) {
+	return newTestConstrainedCASecret(t, notAfter, nil)
+}
+
+// newTestConstrainedCASecret generates a self-signed CA with the constraints, and returns it with the secret storing it.
+func newTestConstrainedCASecret(t *testing.T, notAfter time.Time, constraints *ca.Constraints) (*ca.CertificateAuthority, *corev1.Secret) {
+	certificateAuthority, err := ca.NewSelfSignedCertificateAuthority(rand.Reader, "", time.Now(), notAfter, constraints, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(certificateAuthority.PrivateKey),
+	})
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testCASecretName,
+			Namespace: testCASecretNamespace,
+		},
+		Data: map[string][]byte{
+			certificateAuthority.SerialNumber() + ".crt": certificateAuthority.CertificatePEM(),
+			certificateAuthority.SerialNumber() + ".key": keyPEM,
+		},
+	}
+	return certificateAuthority, secret
+}
+
+func newTestPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "default",
+			UID:       "6f2b6c1e-4f2b-4c55-9d57-7b8f3c1b0d0a",
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "test-node",
+		},
+		Status: corev1.PodStatus{
+			PodIP: "10.0.0.10",
+			PodIPs: []corev1.PodIP{
+				{IP: "10.0.0.10"},
+			},
+		},
+	}
+}
+
+func newTestAutoTlsSpec() *secretsv1alpha1.AutoTlsSpec {
+	return &secretsv1alpha1.AutoTlsSpec{
+		CA: &secretsv1alpha1.CASpec{
+			AutoGenerated:         false,
+			CACertificateLifeTime: "8760h",
+			Secret: &secretsv1alpha1.SecretSpec{
+				Name:      testCASecretName,
+				Namespace: testCASecretNamespace,
+			},
+		},
+		MaxCertificateLifeTime: "360h",
+	}
+}
+
+func newTestAutoTlsBackend(
+	t *testing.T,
+	c client.Client,
+	pod *corev1.Pod,
+	volumeSelector *volume.SecretVolumeSelector,
+	spec *secretsv1alpha1.AutoTlsSpec,
+) *AutoTlsBackend {
+	podInfo := pod_info.NewPodInfo(c, pod, volumeSelector)
+	backend, err := NewAutoTlsBackend(c, podInfo, volumeSelector, spec, clock.RealClock{}, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func parseCertificatePEM(t *testing.T, data []byte) *x509.Certificate {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("failed to decode certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestAutoTlsBackendGetSecretData(t *testing.T) {
+	certificateAuthority, caSecret := newTestCASecret(t, time.Now().Add(365*24*time.Hour))
+	pod := newTestPod()
+
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+
+	volumeSelector := &volume.SecretVolumeSelector{
+		Class:  "tls",
+		Format: volume.SecretFormatTLSPEM,
+		Scope: volume.SecretScope{
+			Pod: volume.ScopePod,
+		},
+	}
+
+	backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, newTestAutoTlsSpec())
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{PEMTlsCertFileName, PEMTlsKeyFileName, PEMCaCertFileName} {
+		if _, ok := content.Data[name]; !ok {
+			t.Errorf("missing %s in secret data", name)
+		}
+	}
+
+	if content.ExpiresTime == nil {
+		t.Fatalf("expected expires time to be set")
+	}
+
+	cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName])
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificateAuthority.Certificate)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+		t.Errorf("certificate is not signed by the test CA: %v", err)
+	}
+
+	if len(cert.IPAddresses) != 1 || !cert.IPAddresses[0].Equal(net.ParseIP("10.0.0.10")) {
+		t.Errorf("unexpected ip SAN: got %v, want %v", cert.IPAddresses, "10.0.0.10")
+	}
+
+	if cert.NotAfter.Unix() != *content.ExpiresTime {
+		t.Errorf("unexpected expires time: got %d, want %d", *content.ExpiresTime, cert.NotAfter.Unix())
+	}
+}
+
+func TestAutoTlsBackendKeyAlgorithm(t *testing.T) {
+	tests := []struct {
+		keyAlgorithm string
+		want         x509.PublicKeyAlgorithm
+		keyUsage     x509.KeyUsage
+	}{
+		{keyAlgorithm: "", want: x509.RSA, keyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment},
+		{keyAlgorithm: "rsa:2048", want: x509.RSA, keyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment},
+		{keyAlgorithm: "rsa:4096", want: x509.RSA, keyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment},
+		{keyAlgorithm: "ecdsa:P256", want: x509.ECDSA, keyUsage: x509.KeyUsageDigitalSignature},
+		{keyAlgorithm: "ecdsa:P384", want: x509.ECDSA, keyUsage: x509.KeyUsageDigitalSignature},
+		{keyAlgorithm: "ed25519", want: x509.Ed25519, keyUsage: x509.KeyUsageDigitalSignature},
+	}
+
+	certificateAuthority, caSecret := newTestCASecret(t, time.Now().Add(365*24*time.Hour))
+	pod := newTestPod()
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+	roots := x509.NewCertPool()
+	roots.AddCert(certificateAuthority.Certificate)
+
+	for _, tt := range tests {
+		t.Run(tt.keyAlgorithm, func(t *testing.T) {
+			spec := newTestAutoTlsSpec()
+			spec.KeyAlgorithm = tt.keyAlgorithm
+			volumeSelector := &volume.SecretVolumeSelector{Class: "tls", Scope: volume.SecretScope{Pod: volume.ScopePod}}
+			backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, spec)
+
+			content, err := backend.GetSecretData(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName])
+			if cert.PublicKeyAlgorithm != tt.want {
+				t.Errorf("unexpected public key algorithm: got %s, want %s", cert.PublicKeyAlgorithm, tt.want)
+			}
+			if cert.KeyUsage != tt.keyUsage {
+				t.Errorf("unexpected key usage: got %b, want %b", cert.KeyUsage, tt.keyUsage)
+			}
+			if _, err := cert.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+				t.Errorf("certificate is not signed by the test CA: %v", err)
+			}
+			// the key parses and matches the certificate
+			if _, err := tls.X509KeyPair(content.Data[PEMTlsCertFileName], content.Data[PEMTlsKeyFileName]); err != nil {
+				t.Errorf("invalid key pair: %v", err)
+			}
+		})
+	}
+}
+
+func TestAutoTlsBackendSpiffe(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustDomain    string
+		serviceAccount string
+		want           string
+	}{
+		{name: "default", want: "spiffe://cluster.local/ns/default/sa/default"},
+		{name: "trust domain", trustDomain: "example.org", serviceAccount: "web", want: "spiffe://example.org/ns/default/sa/web"},
+	}
+
+	certificateAuthority, caSecret := newTestCASecret(t, time.Now().Add(365*24*time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(certificateAuthority.Certificate)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestPod()
+			pod.Spec.ServiceAccountName = tt.serviceAccount
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+			spec := newTestAutoTlsSpec()
+			spec.SpiffeTrustDomain = tt.trustDomain
+			// the pod ip is added without the pod scope
+			volumeSelector := &volume.SecretVolumeSelector{Class: "tls", AutoTlsSpiffe: true}
+			backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, spec)
+
+			content, err := backend.GetSecretData(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName])
+			if len(cert.URIs) != 1 || cert.URIs[0].String() != tt.want {
+				t.Errorf("unexpected URI SANs: got %v, want %s", cert.URIs, tt.want)
+			}
+			if len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "10.0.0.10" {
+				t.Errorf("unexpected IP SANs: got %v, want the pod ip", cert.IPAddresses)
+			}
+			if _, err := cert.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+				t.Errorf("certificate is not signed by the test CA: %v", err)
+			}
+		})
+	}
+}
+
+func TestAutoTlsBackendNoSpiffe(t *testing.T) {
+	_, caSecret := newTestCASecret(t, time.Now().Add(365*24*time.Hour))
+	pod := newTestPod()
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+	volumeSelector := &volume.SecretVolumeSelector{Class: "tls", Scope: volume.SecretScope{Pod: volume.ScopePod}}
+	backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, newTestAutoTlsSpec())
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName]); len(cert.URIs) != 0 {
+		t.Errorf("unexpected URI SANs: %v", cert.URIs)
+	}
+}
+
+func TestAutoTlsBackendListenerScope(t *testing.T) {
+	_, caSecret := newTestCASecret(t, time.Now().Add(365*24*time.Hour))
+	pod := newTestPod()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-lb", Namespace: pod.Namespace},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.10", Hostname: "web.example.com"}},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod, svc).Build()
+	volumeSelector := &volume.SecretVolumeSelector{Class: "tls", Scope: volume.SecretScope{Listeners: []string{"web-lb"}}}
+	backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, newTestAutoTlsSpec())
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName])
+	if len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "203.0.113.10" {
+		t.Errorf("unexpected IP SANs: got %v, want the load balancer ip", cert.IPAddresses)
+	}
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "web.example.com" {
+		t.Errorf("unexpected DNS SANs: got %v, want the load balancer hostname", cert.DNSNames)
+	}
+}
+
+func TestAutoTlsBackendSANs(t *testing.T) {
+	_, caSecret := newTestCASecret(t, time.Now().Add(365*24*time.Hour))
+	pod := newTestPod()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-lb", Namespace: pod.Namespace},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.10", Hostname: "web.example.com"}},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod, svc).Build()
+	volumeSelector := &volume.SecretVolumeSelector{
+		Class: "tls",
+		Scope: volume.SecretScope{Listeners: []string{"web-lb"}},
+		// the SANs of the scope are not added twice
+		AutoTlsSANs: []string{"a.example.com", "WEB.example.com", "10.0.0.5", "203.0.113.10"},
+	}
+	backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, newTestAutoTlsSpec())
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName])
+	if want := []string{"web.example.com", "a.example.com"}; !slices.Equal(cert.DNSNames, want) {
+		t.Errorf("unexpected DNS SANs: got %v, want %v", cert.DNSNames, want)
+	}
+	var ips []string
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	if want := []string{"203.0.113.10", "10.0.0.5"}; !slices.Equal(ips, want) {
+		t.Errorf("unexpected IP SANs: got %v, want %v", ips, want)
+	}
+	if cert.Subject.CommonName != "web.example.com" {
+		t.Errorf("unexpected common name: got %s, want the name of the scope", cert.Subject.CommonName)
+	}
+}
+
+func TestAutoTlsBackendInvalidSpiffeTrustDomain(t *testing.T) {
+	spec := newTestAutoTlsSpec()
+	spec.SpiffeTrustDomain = "Example.org/ns"
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	volumeSelector := &volume.SecretVolumeSelector{Class: "tls"}
+
+	_, err := NewAutoTlsBackend(c, pod_info.NewPodInfo(c, newTestPod(), volumeSelector), volumeSelector, spec, clock.RealClock{}, rand.Reader)
+	if !errors.Is(err, ErrSecretClassInvalid) {
+		t.Errorf("unexpected error: got %v, want %v", err, ErrSecretClassInvalid)
+	}
+}
+
+func TestAutoTlsBackendCommonName(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  string
+		subdomain string
+		scope     volume.SecretScope
+		want      string
+		wantErr   error
+	}{
+		{name: "default pod scope", scope: volume.SecretScope{Pod: volume.ScopePod}, want: "10-0-0-10.default.pod.cluster.local"},
+		{name: "default without dns name", want: "test-pod"},
+		{name: "pod", template: "{pod}", scope: volume.SecretScope{Pod: volume.ScopePod}, want: "test-pod"},
+		{name: "fixed", template: "kafka-broker", want: "kafka-broker"},
+		{name: "service", template: "{service}.{namespace}.svc", subdomain: "web", want: "web.default.svc"},
+		{name: "node and pod", template: "{node}/{pod}", want: "test-node/test-pod"},
+		{name: "service missing", template: "{service}", wantErr: ErrInvalidVolumeContext},
+		{name: "too long", template: strings.Repeat("x", 60) + "-{pod}", wantErr: ErrSecretClassInvalid},
+	}
+
+	_, caSecret := newTestCASecret(t, time.Now().Add(365*24*time.Hour))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestPod()
+			pod.Spec.Subdomain = tt.subdomain
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+			spec := newTestAutoTlsSpec()
+			spec.CommonNameTemplate = tt.template
+			volumeSelector := &volume.SecretVolumeSelector{Class: "tls", Scope: tt.scope}
+			backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, spec)
+
+			content, err := backend.GetSecretData(context.Background())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("unexpected error: got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName]); cert.Subject.CommonName != tt.want {
+				t.Errorf("unexpected common name: got %q, want %q", cert.Subject.CommonName, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoTlsBackendInvalidCommonNameTemplate(t *testing.T) {
+	spec := newTestAutoTlsSpec()
+	spec.CommonNameTemplate = "{pod}.{cluster}"
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	volumeSelector := &volume.SecretVolumeSelector{Class: "tls"}
+
+	_, err := NewAutoTlsBackend(c, pod_info.NewPodInfo(c, newTestPod(), volumeSelector), volumeSelector, spec, clock.RealClock{}, rand.Reader)
+	if !errors.Is(err, ErrSecretClassInvalid) {
+		t.Errorf("unexpected error: got %v, want %v", err, ErrSecretClassInvalid)
+	}
+}
+
+func TestAutoTlsBackendUsages(t *testing.T) {
+	tests := []struct {
+		name              string
+		keyAlgorithm      string
+		keyUsages         []string
+		extendedKeyUsages []string
+		wantKeyUsage      x509.KeyUsage
+		wantExtKeyUsages  []x509.ExtKeyUsage
+	}{
+		{
+			name:             "default",
+			wantKeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			wantExtKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		},
+		{
+			name:              "server only",
+			extendedKeyUsages: []string{"serverAuth"},
+			wantKeyUsage:      x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			wantExtKeyUsages:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		},
+		{
+			name:              "client only",
+			extendedKeyUsages: []string{"clientAuth", "clientAuth"},
+			wantKeyUsage:      x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			wantExtKeyUsages:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		},
+		{
+			name:              "digital signature only",
+			keyUsages:         []string{"digitalSignature"},
+			extendedKeyUsages: []string{"serverAuth", "clientAuth"},
+			wantKeyUsage:      x509.KeyUsageDigitalSignature,
+			wantExtKeyUsages:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		},
+		{
+			name:             "ecdsa digital signature",
+			keyAlgorithm:     "ecdsa:P256",
+			keyUsages:        []string{"digitalSignature"},
+			wantKeyUsage:     x509.KeyUsageDigitalSignature,
+			wantExtKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		},
+	}
+
+	_, caSecret := newTestCASecret(t, time.Now().Add(365*24*time.Hour))
+	pod := newTestPod()
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newTestAutoTlsSpec()
+			spec.KeyAlgorithm = tt.keyAlgorithm
+			spec.KeyUsages = tt.keyUsages
+			spec.ExtendedKeyUsages = tt.extendedKeyUsages
+			volumeSelector := &volume.SecretVolumeSelector{Class: "tls"}
+			backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, spec)
+
+			content, err := backend.GetSecretData(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName])
+			if cert.KeyUsage != tt.wantKeyUsage {
+				t.Errorf("unexpected key usage: got %b, want %b", cert.KeyUsage, tt.wantKeyUsage)
+			}
+			if !slices.Equal(cert.ExtKeyUsage, tt.wantExtKeyUsages) {
+				t.Errorf("unexpected extended key usages: got %v, want %v", cert.ExtKeyUsage, tt.wantExtKeyUsages)
+			}
+		})
+	}
+}
+
+func TestAutoTlsBackendInvalidUsages(t *testing.T) {
+	tests := []struct {
+		name              string
+		keyAlgorithm      string
+		keyUsages         []string
+		extendedKeyUsages []string
+	}{
+		{name: "unknown key usage", keyUsages: []string{"certSign"}},
+		{name: "unknown extended key usage", extendedKeyUsages: []string{"codeSigning"}},
+		{name: "key encipherment without rsa", keyAlgorithm: "ed25519", keyUsages: []string{"keyEncipherment"}},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newTestAutoTlsSpec()
+			spec.KeyAlgorithm = tt.keyAlgorithm
+			spec.KeyUsages = tt.keyUsages
+			spec.ExtendedKeyUsages = tt.extendedKeyUsages
+			volumeSelector := &volume.SecretVolumeSelector{Class: "tls"}
+
+			_, err := NewAutoTlsBackend(c, pod_info.NewPodInfo(c, newTestPod(), volumeSelector), volumeSelector, spec, clock.RealClock{}, rand.Reader)
+			if !errors.Is(err, ErrSecretClassInvalid) {
+				t.Errorf("unexpected error: got %v, want %v", err, ErrSecretClassInvalid)
+			}
+		})
+	}
+}
+
+func TestAutoTlsBackendInvalidKeyAlgorithm(t *testing.T) {
+	spec := newTestAutoTlsSpec()
+	spec.KeyAlgorithm = "dsa:1024"
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	volumeSelector := &volume.SecretVolumeSelector{Class: "tls"}
+
+	_, err := NewAutoTlsBackend(c, pod_info.NewPodInfo(c, newTestPod(), volumeSelector), volumeSelector, spec, clock.RealClock{}, rand.Reader)
+	if !errors.Is(err, ErrSecretClassInvalid) {
+		t.Errorf("unexpected error: got %v, want %v", err, ErrSecretClassInvalid)
+	}
+}
+
+func TestAutoTlsBackendCAOnly(t *testing.T) {
+	tests := []struct {
+		name       string
+		caNotAfter time.Duration
+		want       time.Duration
+	}{
+		{
+			name:       "default lifetime",
+			caNotAfter: 365 * 24 * time.Hour,
+			want:       defaultCertLifetime,
+		},
+		{
+			name:       "clamped to CA",
+			caNotAfter: time.Hour,
+			want:       time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificateAuthority, caSecret := newTestCASecret(t, time.Now().Add(tt.caNotAfter))
+			pod := newTestPod()
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+
+			volumeSelector := &volume.SecretVolumeSelector{
+				Class:   "tls",
+				Format:  volume.SecretFormatTLSPEM,
+				Scope:   volume.SecretScope{Pod: volume.ScopePod},
+				AutoTls: volume.AutoTlsModeCAOnly,
+			}
+			backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, newTestAutoTlsSpec())
+
+			now := time.Now()
+			content, err := backend.GetSecretData(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(content.Data) != 1 {
+				t.Errorf("expected only %s in secret data, got %d files", PEMCaCertFileName, len(content.Data))
+			}
+			bundle := parseCertificatesPEM(t, content.Data[PEMCaCertFileName])
+			if len(bundle) != 1 || !bundle[0].Equal(certificateAuthority.Certificate) {
+				t.Errorf("expected the test CA in %s, got %d certificates", PEMCaCertFileName, len(bundle))
+			}
+
+			if content.ExpiresTime == nil {
+				t.Fatalf("expected expires time to be set")
+			}
+			expiresTime := time.Unix(*content.ExpiresTime, 0)
+			if expiresTime.After(certificateAuthority.Certificate.NotAfter) {
+				t.Errorf("trust bundle expires after the CA: got %s, CA not after %s", expiresTime, certificateAuthority.Certificate.NotAfter)
+			}
+			if diff := expiresTime.Sub(now.Add(tt.want)); diff < -time.Minute || diff > time.Minute {
+				t.Errorf("unexpected expires time: got %s, want about %s", expiresTime, now.Add(tt.want))
+			}
+		})
+	}
+}
+
+// errReader is a random source which always fails.
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("no randomness")
+}
+
+func TestAutoTlsBackendFakeClock(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	certificateAuthority, err := ca.NewSelfSignedCertificateAuthority(rand.Reader, "", now.Add(-time.Hour), now.Add(365*24*time.Hour), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: testCASecretName, Namespace: testCASecretNamespace},
+		Data: map[string][]byte{
+			certificateAuthority.SerialNumber() + ".crt": certificateAuthority.CertificatePEM(),
+			certificateAuthority.SerialNumber() + ".key": pem.EncodeToMemory(&pem.Block{
+				Type:  "RSA PRIVATE KEY",
+				Bytes: x509.MarshalPKCS1PrivateKey(certificateAuthority.PrivateKey),
+			}),
+		},
+	}
+	pod := newTestPod()
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+	volumeSelector := &volume.SecretVolumeSelector{
+		Class: "tls",
+		Scope: volume.SecretScope{Pod: volume.ScopePod},
+	}
+	secretClass := &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "tls"},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{AutoTls: newTestAutoTlsSpec()},
+		},
+	}
+	podInfo := pod_info.NewPodInfo(c, pod, volumeSelector)
+
+	backend := NewBackend(c, podInfo, volumeSelector, secretClass).WithClock(clocktesting.NewFakePassiveClock(now))
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName])
+	if want := now.Add(-defaultNotBeforeSkew); !cert.NotBefore.Equal(want) {
+		t.Errorf("unexpected not before: got %s, want %s", cert.NotBefore, want)
+	}
+	if want := now.Add(defaultCertLifetime); !cert.NotAfter.Equal(want) || *content.ExpiresTime != want.Unix() {
+		t.Errorf("unexpected expiration: got %s and %d, want %s", cert.NotAfter, *content.ExpiresTime, want)
+	}
+
+	backend = NewBackend(c, podInfo, volumeSelector, secretClass).
+		WithClock(clocktesting.NewFakePassiveClock(now)).
+		WithRand(errReader{})
+	if _, err := backend.GetSecretData(context.Background()); err == nil {
+		t.Errorf("expected error when the random source fails")
+	}
+}
+
+func TestAutoTlsBackendNotBeforeSkew(t *testing.T) {
+	tests := []struct {
+		name          string
+		notBeforeSkew string
+		want          time.Duration
+	}{
+		{name: "default", want: defaultNotBeforeSkew},
+		{name: "configured", notBeforeSkew: "10m", want: 10 * time.Minute},
+		{name: "disabled", notBeforeSkew: "0s", want: 0},
+		{name: "clamped to max", notBeforeSkew: "48h", want: maxNotBeforeSkew},
+	}
+
+	// the CA is valid long before the skew
+	now := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	_, caSecret := newTestCASecret(t, now.Add(365*24*time.Hour))
+	pod := newTestPod()
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newTestAutoTlsSpec()
+			spec.NotBeforeSkew = tt.notBeforeSkew
+			volumeSelector := &volume.SecretVolumeSelector{Class: "tls", Scope: volume.SecretScope{Pod: volume.ScopePod}}
+			backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, spec)
+			backend.clock = clocktesting.NewFakePassiveClock(now)
+
+			content, err := backend.GetSecretData(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName])
+			if want := now.Add(-tt.want); !cert.NotBefore.Equal(want) {
+				t.Errorf("unexpected not before: got %s, want %s", cert.NotBefore, want)
+			}
+			// the skew does not extend the lifetime
+			if want := now.Add(defaultCertLifetime); !cert.NotAfter.Equal(want) {
+				t.Errorf("unexpected not after: got %s, want %s", cert.NotAfter, want)
+			}
+		})
+	}
+}
+
+func TestAutoTlsBackendInvalidNotBeforeSkew(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	for _, notBeforeSkew := range []string{"5 minutes", "-1m"} {
+		t.Run(notBeforeSkew, func(t *testing.T) {
+			spec := newTestAutoTlsSpec()
+			spec.NotBeforeSkew = notBeforeSkew
+			volumeSelector := &volume.SecretVolumeSelector{Class: "tls"}
+
+			_, err := NewAutoTlsBackend(c, pod_info.NewPodInfo(c, newTestPod(), volumeSelector), volumeSelector, spec, clock.RealClock{}, rand.Reader)
+			if !errors.Is(err, ErrSecretClassInvalid) {
+				t.Errorf("unexpected error: got %v, want %v", err, ErrSecretClassInvalid)
+			}
+		})
+	}
+}
+
+func TestAutoTlsBackendCANotFound(t *testing.T) {
+	pod := newTestPod()
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(pod).Build()
+
+	volumeSelector := &volume.SecretVolumeSelector{Class: "tls"}
+	backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, newTestAutoTlsSpec())
+
+	if _, err := backend.GetSecretData(context.Background()); err == nil {
+		t.Errorf("expected error when CA secret does not exist and autoGenerated is disabled")
+	}
+}
+
+func TestAutoTlsBackendGetCertLife(t *testing.T) {
+	now := time.Now()
+	farCANotAfter := now.Add(365 * 24 * time.Hour)
+
+	tests := []struct {
+		name       string
+		requested  time.Duration
+		ttl        time.Duration
+		caNotAfter time.Time
+		want       time.Duration
+		wantErr    bool
+	}{
+		{
+			name:       "default",
+			caNotAfter: farCANotAfter,
+			want:       defaultCertLifetime,
+		},
+		{
+			name:       "override",
+			requested:  48 * time.Hour,
+			caNotAfter: farCANotAfter,
+			want:       48 * time.Hour,
+		},
+		{
+			name:       "clamp-to-max",
+			requested:  1000 * time.Hour,
+			caNotAfter: farCANotAfter,
+			want:       360 * time.Hour,
+		},
+		{
+			name:       "clamp-to-ca",
+			requested:  48 * time.Hour,
+			caNotAfter: now.Add(2 * time.Hour),
+			want:       2 * time.Hour,
+		},
+		{
+			name:       "ttl-shortens-default",
+			ttl:        time.Hour,
+			caNotAfter: farCANotAfter,
+			want:       time.Hour,
+		},
+		{
+			name:       "ttl-shortens-override",
+			requested:  48 * time.Hour,
+			ttl:        30 * time.Hour,
+			caNotAfter: farCANotAfter,
+			want:       30 * time.Hour,
+		},
+		{
+			name:       "ttl-does-not-extend",
+			ttl:        48 * time.Hour,
+			caNotAfter: farCANotAfter,
+			want:       defaultCertLifetime,
+		},
+		{
+			name:       "ttl-exceeds-max",
+			ttl:        1000 * time.Hour,
+			caNotAfter: farCANotAfter,
+			wantErr:    true,
+		},
+		{
+			name:       "ttl-clamped-to-ca",
+			ttl:        time.Hour,
+			caNotAfter: now.Add(30 * time.Minute),
+			want:       30 * time.Minute,
+		},
+		{
+			name:       "negative",
+			requested:  -time.Hour,
+			caNotAfter: farCANotAfter,
+			wantErr:    true,
+		},
+		{
+			name:       "ca-expired",
+			caNotAfter: now.Add(-time.Hour),
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			volumeSelector := &volume.SecretVolumeSelector{Class: "tls", AutoTlsCertLifetime: tt.requested, TTL: tt.ttl}
+			backend := newTestAutoTlsBackend(t, nil, newTestPod(), volumeSelector, newTestAutoTlsSpec())
+
+			got, err := backend.getCertLife(now, tt.caNotAfter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("unexpected lifetime: got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoTlsBackendCertLifeClampedToCA(t *testing.T) {
+	certificateAuthority, caSecret := newTestCASecret(t, time.Now().Add(2*time.Hour))
+	pod := newTestPod()
+
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+
+	volumeSelector := &volume.SecretVolumeSelector{
+		Class:               "tls",
+		Scope:               volume.SecretScope{Pod: volume.ScopePod},
+		AutoTlsCertLifetime: 48 * time.Hour,
+	}
+	backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, newTestAutoTlsSpec())
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName])
+	if cert.NotAfter.After(certificateAuthority.Certificate.NotAfter) {
+		t.Errorf("certificate outlives the CA: cert notAfter %s, CA notAfter %s", cert.NotAfter, certificateAuthority.Certificate.NotAfter)
+	}
+	if *content.ExpiresTime != cert.NotAfter.Unix() {
+		t.Errorf("unexpected expires time: got %d, want %d", *content.ExpiresTime, cert.NotAfter.Unix())
+	}
+}
+
+// addTestCA generates another self-signed CA, and adds it to the CA secret.
+// When withKey is false, only the certificate is added, the CA is trusted but not used to sign.
+func addTestCA(t *testing.T, secret *corev1.Secret, notAfter time.Time, withKey bool) *ca.CertificateAuthority {
+	certificateAuthority, other := newTestCASecret(t, notAfter)
+	for name, data := range other.Data {
+		if !withKey && name == certificateAuthority.SerialNumber()+".key" {
+			continue
+		}
+		secret.Data[name] = data
+	}
+	return certificateAuthority
+}
+
+func parseCertificatesPEM(t *testing.T, data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return certs
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+}
+
+func TestAutoTlsBackendCARotationOverlap(t *testing.T) {
+	oldCA, caSecret := newTestCASecret(t, time.Now().Add(30*24*time.Hour))
+	newCA := addTestCA(t, caSecret, time.Now().Add(365*24*time.Hour), true)
+	retiredCA := addTestCA(t, caSecret, time.Now().Add(7*24*time.Hour), false)
+	expiredCA := addTestCA(t, caSecret, time.Now().Add(-time.Hour), false)
+	pod := newTestPod()
+
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+
+	volumeSelector := &volume.SecretVolumeSelector{
+		Class: "tls",
+		Scope: volume.SecretScope{Pod: volume.ScopePod},
+	}
+	backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, newTestAutoTlsSpec())
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the certificate is signed by the newest CA
+	cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName])
+	if err := cert.CheckSignatureFrom(newCA.Certificate); err != nil {
+		t.Errorf("certificate is not signed by the newest CA: %v", err)
+	}
+
+	// ca.crt contains all the valid CAs, ordered by expiration time
+	bundle := parseCertificatesPEM(t, content.Data[PEMCaCertFileName])
+	expected := []*x509.Certificate{retiredCA.Certificate, oldCA.Certificate, newCA.Certificate}
+	if len(bundle) != len(expected) {
+		t.Fatalf("unexpected CA bundle size: got %d, want %d", len(bundle), len(expected))
+	}
+	for i := range expected {
+		if !bundle[i].Equal(expected[i]) {
+			t.Errorf("unexpected CA at %d: got serial %s, want %s", i, bundle[i].SerialNumber, expected[i].SerialNumber)
+		}
+	}
+	for _, caCert := range bundle {
+		if caCert.Equal(expiredCA.Certificate) {
+			t.Errorf("expired CA should not be in the bundle")
+		}
+	}
+
+	// clients trusting the bundle accept the certificate
+	roots := x509.NewCertPool()
+	for _, caCert := range bundle {
+		roots.AddCert(caCert)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+		t.Errorf("certificate is not trusted by the CA bundle: %v", err)
+	}
+}
+
+func TestAutoTlsBackendCertLifeJitter(t *testing.T) {
+	now := time.Now()
+	caNotAfter := now.Add(365 * 24 * time.Hour)
+	spec := newTestAutoTlsSpec()
+	spec.CertificateJitterPercent = 20
+
+	volumeSelector := &volume.SecretVolumeSelector{Class: "tls", AutoTlsCertLifetime: 100 * time.Hour}
+	backend := newTestAutoTlsBackend(t, nil, newTestPod(), volumeSelector, spec)
+
+	certLife, err := backend.getCertLife(now, caNotAfter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if certLife > 100*time.Hour || certLife < 80*time.Hour {
+		t.Errorf("lifetime with jitter out of range: got %s, want in [80h, 100h]", certLife)
+	}
+
+	// the same pod always gets the same jitter
+	again := newTestAutoTlsBackend(t, nil, newTestPod(), volumeSelector, spec)
+	if againLife, _ := again.getCertLife(now, caNotAfter); againLife != certLife {
+		t.Errorf("jitter is not deterministic per pod: got %s and %s", certLife, againLife)
+	}
+
+	// the volume jitter factor overrides the secret class
+	volumeSelector = &volume.SecretVolumeSelector{Class: "tls", AutoTlsCertLifetime: 100 * time.Hour, AutoTlsCertJitterFactor: 0.5}
+	backend = newTestAutoTlsBackend(t, nil, newTestPod(), volumeSelector, spec)
+	if backend.jitterFactor != 0.5 {
+		t.Errorf("unexpected jitter factor: got %f, want 0.5", backend.jitterFactor)
+	}
+}
+
+func TestAutoTlsBackendValidate(t *testing.T) {
+	_, validSecret := newTestCASecret(t, time.Now().Add(365*24*time.Hour))
+	_, expiredSecret := newTestCASecret(t, time.Now().Add(-time.Hour))
+	invalidSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: testCASecretName, Namespace: testCASecretNamespace},
+		Data: map[string][]byte{
+			"broken.crt": []byte("not a certificate"),
+			"broken.key": []byte("not a key"),
+		},
+	}
+
+	tests := []struct {
+		name          string
+		secret        *corev1.Secret
+		autoGenerated bool
+		wantErr       bool
+	}{
+		{
+			name:   "valid",
+			secret: validSecret,
+		},
+		{
+			name:    "not found",
+			wantErr: true,
+		},
+		{
+			name:          "not found with autoGenerated",
+			autoGenerated: true,
+		},
+		{
+			name:    "expired",
+			secret:  expiredSecret,
+			wantErr: true,
+		},
+		{
+			name:          "expired with autoGenerated",
+			secret:        expiredSecret,
+			autoGenerated: true,
+		},
+		{
+			name:          "invalid",
+			secret:        invalidSecret,
+			autoGenerated: true,
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(newTestScheme(t))
+			if tt.secret != nil {
+				builder = builder.WithObjects(tt.secret.DeepCopy())
+			}
+			c := builder.Build()
+
+			spec := newTestAutoTlsSpec()
+			spec.CA.AutoGenerated = tt.autoGenerated
+			backend, err := NewAutoTlsBackend(c, nil, &volume.SecretVolumeSelector{Class: "tls"}, spec, clock.RealClock{}, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = backend.Validate(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v, wantErr %t", err, tt.wantErr)
+			}
+
+			// validation must not create the CA secret
+			if tt.secret == nil {
+				secret := &corev1.Secret{}
+				err := c.Get(context.Background(), client.ObjectKey{Namespace: testCASecretNamespace, Name: testCASecretName}, secret)
+				if client.IgnoreNotFound(err) != nil || err == nil {
+					t.Errorf("expected CA secret not to be created, got: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// newTestCAConstraintsSpec limits the CA to the cluster domain and the pod network, without intermediate CAs.
+func newTestCAConstraintsSpec() *secretsv1alpha1.CAConstraintsSpec {
+	maxPathLength := int32(0)
+	return &secretsv1alpha1.CAConstraintsSpec{
+		MaxPathLength:       &maxPathLength,
+		PermittedDNSDomains: []string{"cluster.local"},
+		PermittedIPRanges:   []string{"10.0.0.0/8"},
+	}
+}
+
+func TestAutoTlsBackendCAConstraints(t *testing.T) {
+	pod := newTestPod()
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(pod).Build()
+	spec := newTestAutoTlsSpec()
+	spec.CA.AutoGenerated = true
+	spec.CA.Constraints = newTestCAConstraintsSpec()
+	volumeSelector := &volume.SecretVolumeSelector{Class: "tls", Scope: volume.SecretScope{Pod: volume.ScopePod}}
+	backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, spec)
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	caCert := parseCertificatePEM(t, content.Data[PEMCaCertFileName])
+	if caCert.MaxPathLen != 0 || !caCert.MaxPathLenZero {
+		t.Errorf("unexpected path length constraint: got %d, want 0", caCert.MaxPathLen)
+	}
+	if !slices.Equal(caCert.PermittedDNSDomains, []string{"cluster.local"}) || !caCert.PermittedDNSDomainsCritical {
+		t.Errorf("unexpected critical permitted DNS domains: got %v, %t", caCert.PermittedDNSDomains, caCert.PermittedDNSDomainsCritical)
+	}
+	if len(caCert.PermittedIPRanges) != 1 || caCert.PermittedIPRanges[0].String() != "10.0.0.0/8" {
+		t.Errorf("unexpected permitted IP ranges: got %v", caCert.PermittedIPRanges)
+	}
+
+	// the clients enforce the constraints when verifying the certificate
+	cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName])
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+		t.Errorf("certificate does not verify against the constrained CA: %v", err)
+	}
+}
+
+func TestAutoTlsBackendCAConstraintsOutOfScopeSAN(t *testing.T) {
+	constraints := &ca.Constraints{PermittedDNSDomains: []string{"cluster.local"}}
+	_, caSecret := newTestConstrainedCASecret(t, time.Now().Add(365*24*time.Hour), constraints)
+	pod := newTestPod()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-lb", Namespace: pod.Namespace},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.10", Hostname: "web.example.com"}},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod, svc).Build()
+	spec := newTestAutoTlsSpec()
+	spec.CA.Constraints = &secretsv1alpha1.CAConstraintsSpec{PermittedDNSDomains: []string{"cluster.local"}}
+	volumeSelector := &volume.SecretVolumeSelector{Class: "tls", Scope: volume.SecretScope{Listeners: []string{"web-lb"}}}
+	backend := newTestAutoTlsBackend(t, c, pod, volumeSelector, spec)
+
+	_, err := backend.GetSecretData(context.Background())
+	if !errors.Is(err, ErrInvalidVolumeContext) || !errors.Is(err, ca.ErrNameConstraintViolation) {
+		t.Fatalf("unexpected error: got %v, want %v", err, ca.ErrNameConstraintViolation)
+	}
+	if !strings.Contains(err.Error(), "web.example.com") {
+		t.Errorf("the error does not name the SAN: %v", err)
+	}
+}
+
+func TestAutoTlsBackendCAConstraintsIncompatible(t *testing.T) {
+	looser := &ca.Constraints{PermittedDNSDomains: []string{"local"}}
+	tests := []struct {
+		name        string
+		constraints *ca.Constraints
+	}{
+		{name: "unconstrained"},
+		{name: "looser", constraints: looser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, caSecret := newTestConstrainedCASecret(t, time.Now().Add(365*24*time.Hour), tt.constraints)
+			pod := newTestPod()
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+			spec := newTestAutoTlsSpec()
+			spec.CA.Constraints = newTestCAConstraintsSpec()
+			backend := newTestAutoTlsBackend(t, c, pod, &volume.SecretVolumeSelector{Class: "tls"}, spec)
+
+			// the imported CA is refused before issuing
+			if err := backend.Validate(context.Background()); !errors.Is(err, ca.ErrCAConstraintsIncompatible) {
+				t.Errorf("unexpected validate error: got %v, want %v", err, ca.ErrCAConstraintsIncompatible)
+			}
+			_, err := backend.GetSecretData(context.Background())
+			if !errors.Is(err, ErrSecretClassInvalid) || !errors.Is(err, ca.ErrCAConstraintsIncompatible) {
+				t.Errorf("unexpected error: got %v, want %v", err, ca.ErrCAConstraintsIncompatible)
+			}
+		})
+	}
+}
+
+func TestAutoTlsBackendCAConstraintsRegenerated(t *testing.T) {
+	unconstrained, caSecret := newTestCASecret(t, time.Now().Add(365*24*time.Hour))
+	pod := newTestPod()
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+	spec := newTestAutoTlsSpec()
+	spec.CA.AutoGenerated = true
+	spec.CA.Constraints = newTestCAConstraintsSpec()
+	backend := newTestAutoTlsBackend(t, c, pod, &volume.SecretVolumeSelector{Class: "tls"}, spec)
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName])
+	if bytes.Equal(cert.AuthorityKeyId, unconstrained.Certificate.SubjectKeyId) {
+		t.Errorf("certificate is issued by the CA generated before the constraints")
+	}
+	// the old CA is still trusted until it expires
+	bundle := string(content.Data[PEMCaCertFileName])
+	if !strings.Contains(bundle, string(unconstrained.CertificatePEM())) {
+		t.Errorf("the CA generated before the constraints is not trusted any more")
+	}
+}
+
+func TestAutoTlsBackendInvalidCAConstraints(t *testing.T) {
+	negative := int32(-1)
+	tests := []struct {
+		name        string
+		constraints *secretsv1alpha1.CAConstraintsSpec
+	}{
+		{name: "negative path length", constraints: &secretsv1alpha1.CAConstraintsSpec{MaxPathLength: &negative}},
+		{name: "empty domain", constraints: &secretsv1alpha1.CAConstraintsSpec{PermittedDNSDomains: []string{"."}}},
+		{name: "wildcard domain", constraints: &secretsv1alpha1.CAConstraintsSpec{ExcludedDNSDomains: []string{"*.example.com"}}},
+		{name: "invalid ip range", constraints: &secretsv1alpha1.CAConstraintsSpec{PermittedIPRanges: []string{"10.0.0.0"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newTestAutoTlsSpec()
+			spec.CA.Constraints = tt.constraints
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+			volumeSelector := &volume.SecretVolumeSelector{Class: "tls"}
+
+			_, err := NewAutoTlsBackend(c, pod_info.NewPodInfo(c, newTestPod(), volumeSelector), volumeSelector, spec, clock.RealClock{}, rand.Reader)
+			if !errors.Is(err, ErrSecretClassInvalid) {
+				t.Errorf("unexpected error: got %v, want %v", err, ErrSecretClassInvalid)
+			}
+		})
+	}
+}
@@ -2,11 +2,17 @@ package backend
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
 
 	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
 	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
 	"github.com/zncdata-labs/secret-operator/pkg/util"
 	"github.com/zncdata-labs/secret-operator/pkg/volume"
+	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -17,6 +23,10 @@ var (
 
 type IBackend interface {
 	GetSecretData(ctx context.Context) (*util.SecretContent, error)
+
+	// Validate checks the configuration of the backend without issuing any secret,
+	// it does not depend on the pod, and must not modify anything.
+	Validate(ctx context.Context) error
 }
 
 type Backend struct {
@@ -24,6 +34,12 @@ type Backend struct {
 	podInfo        *pod_info.PodInfo
 	volumeSelector *volume.SecretVolumeSelector
 	secretClass    *secretsv1alpha1.SecretClass
+	cache          *Cache
+	tokens         *TokenCache
+	clock          clock.PassiveClock
+	rand           io.Reader
+	retry          RetryPolicy
+	limiter        *ConcurrencyLimiter
 }
 
 func NewBackend(
@@ -37,43 +53,269 @@ func NewBackend(
 		podInfo:        PodInfo,
 		volumeSelector: VolumeSelector,
 		secretClass:    secretClass,
+		clock:          clock.RealClock{},
+		rand:           rand.Reader,
 	}
 }
 
-func (b *Backend) backendImpl() (IBackend, error) {
+// WithCache makes the backend return the cached secret data when it is fresh.
+// The data of autoTls and certManager backends is never cached, as each certificate must be unique.
+// A volume with noCache always fetches the data from the backend, and caches it for the other volumes.
+func (b *Backend) WithCache(cache *Cache) *Backend {
+	b.cache = cache
+	return b
+}
 
-	backend := b.secretClass.Spec.Backend
+// WithTokenCache makes the backends reuse the service account tokens requested for the pod until they are refreshed.
+func (b *Backend) WithTokenCache(tokens *TokenCache) *Backend {
+	b.tokens = tokens
+	return b
+}
 
-	if backend.Kerberos != nil {
-		panic("not implemented")
+// WithClock replaces the real clock used to issue and validate the secrets, e.g. by a fake clock in tests.
+func (b *Backend) WithClock(clock clock.PassiveClock) *Backend {
+	b.clock = clock
+	return b
+}
+
+// WithRand replaces crypto/rand.Reader as the source of the keys and serial numbers of the certificates.
+func (b *Backend) WithRand(rand io.Reader) *Backend {
+	b.rand = rand
+	return b
+}
+
+// WithRetry retries the transient failures to get the secret data, by default it is not retried.
+func (b *Backend) WithRetry(policy RetryPolicy) *Backend {
+	b.retry = policy
+	return b
+}
+
+// WithConcurrencyLimiter makes the requests to the backend wait for a slot of the limiter,
+// each attempt of the retry waits again. By default the requests are not limited.
+func (b *Backend) WithConcurrencyLimiter(limiter *ConcurrencyLimiter) *Backend {
+	b.limiter = limiter
+	return b
+}
+
+// Backend types of the secret class, used to label metrics. The type of a custom backend is the name it is
+// registered with, see RegisterBackend.
+const (
+	BackendTypeAutoTls     = "autoTls"
+	BackendTypeCertManager = "certManager"
+	BackendTypeFile        = "file"
+	BackendTypeK8sSearch   = "k8sSearch"
+	BackendTypeKerberos    = "kerberos"
+	BackendTypeVault       = "vault"
+	BackendTypeUnknown     = "unknown"
+)
+
+// BackendType returns the type of the backend configured in the secret class.
+// The order of the checks is the same as the backend resolution.
+func BackendType(secretClass *secretsv1alpha1.SecretClass) string {
+	if secretClass == nil || secretClass.Spec.Backend == nil {
+		return BackendTypeUnknown
 	}
+	backend := secretClass.Spec.Backend
+	switch {
+	case backend.Kerberos != nil:
+		return BackendTypeKerberos
+	case backend.AutoTls != nil:
+		return BackendTypeAutoTls
+	case backend.CertManager != nil:
+		return BackendTypeCertManager
+	case backend.File != nil:
+		return BackendTypeFile
+	case backend.K8sSearch != nil:
+		return BackendTypeK8sSearch
+	case backend.Vault != nil:
+		return BackendTypeVault
+	case backend.Custom != nil && backend.Custom.Type != "":
+		return backend.Custom.Type
+	default:
+		return BackendTypeUnknown
+	}
+}
 
+// configuredBackends returns the types of the backends set in the spec.
+func configuredBackends(backend *secretsv1alpha1.BackendSpec) []string {
+	var configured []string
 	if backend.AutoTls != nil {
-		return NewAutoTlsBackend(
-			b.client,
-			b.podInfo,
-			b.volumeSelector,
-			backend.AutoTls,
-		)
+		configured = append(configured, BackendTypeAutoTls)
+	}
+	if backend.CertManager != nil {
+		configured = append(configured, BackendTypeCertManager)
+	}
+	if backend.Custom != nil {
+		configured = append(configured, "custom "+backend.Custom.Type)
+	}
+	if backend.File != nil {
+		configured = append(configured, BackendTypeFile)
 	}
-
 	if backend.K8sSearch != nil {
-		return NewK8sSearchBackend(
-			b.client,
-			b.podInfo,
-			b.volumeSelector,
-			backend.K8sSearch,
-		)
+		configured = append(configured, BackendTypeK8sSearch)
+	}
+	if backend.Kerberos != nil {
+		configured = append(configured, BackendTypeKerberos)
 	}
+	if backend.Vault != nil {
+		configured = append(configured, BackendTypeVault)
+	}
+	return configured
+}
 
-	panic("can not find backend")
+// ValidateSecretClass checks the backend configuration of the secret class the same way as when a volume is
+// published, without reaching the cluster or the backend, e.g. to reject an invalid secret class on admission.
+// Exactly one backend must be configured. A custom backend not registered in this binary is not checked,
+// e.g. the operator does not know the backends registered in the csi driver.
+func ValidateSecretClass(secretClass *secretsv1alpha1.SecretClass) error {
+	_, err := NewBackend(nil, nil, &volume.SecretVolumeSelector{Class: secretClass.Name}, secretClass).backendImpl()
+	if errors.Is(err, errBackendNotRegistered) && secretClass.Spec.Backend.Custom != nil {
+		return nil
+	}
+	return err
+}
+
+// ValidateVolumeSelector checks the options of the volume are supported by the backend of one of the secret classes,
+// instead of being ignored, e.g. the tls formats for a kerberos backend, or autoTlsSpiffe for a vault backend.
+// The secret classes with an unknown backend are rejected when their backend is created, and the options of the
+// custom backends are up to them.
+func ValidateVolumeSelector(volumeSelector *volume.SecretVolumeSelector, secretClasses []*secretsv1alpha1.SecretClass) error {
+	backendTypes := make([]string, 0, len(secretClasses))
+	for _, secretClass := range secretClasses {
+		backendType := BackendType(secretClass)
+		if !slices.Contains(builtinBackendTypes, backendType) {
+			return nil
+		}
+		backendTypes = append(backendTypes, backendType)
+	}
+
+	formats := volumeSelector.Formats()
+	formatOption := func(format volume.SecretFormat) string {
+		return volume.SecretsZncdataFormat + " " + string(format)
+	}
+	tlsBackendTypes := []string{BackendTypeAutoTls, BackendTypeCertManager, BackendTypeFile, BackendTypeK8sSearch, BackendTypeVault}
+	requirements := []struct {
+		option       string
+		set          bool
+		backendTypes []string
+	}{
+		{option: formatOption(volume.SecretFormatTLSPEM), set: slices.Contains(formats, volume.SecretFormatTLSPEM), backendTypes: tlsBackendTypes},
+		{option: formatOption(volume.SecretFormatTLSP12), set: slices.Contains(formats, volume.SecretFormatTLSP12), backendTypes: tlsBackendTypes},
+		{option: formatOption(volume.SecretFormatTLSJKS), set: slices.Contains(formats, volume.SecretFormatTLSJKS), backendTypes: tlsBackendTypes},
+		{option: formatOption(volume.SecretFormatKerberos), set: slices.Contains(formats, volume.SecretFormatKerberos), backendTypes: []string{BackendTypeKerberos}},
+		{option: volume.AutoTls, set: volumeSelector.AutoTls != "", backendTypes: []string{BackendTypeAutoTls, BackendTypeCertManager}},
+		{option: volume.CertLifeTime, set: volumeSelector.AutoTlsCertLifetime != 0, backendTypes: []string{BackendTypeAutoTls, BackendTypeCertManager}},
+		{option: volume.CertJitterFactor, set: volumeSelector.AutoTlsCertJitterFactor != 0, backendTypes: []string{BackendTypeAutoTls}},
+		{option: volume.AutoTlsSpiffe, set: volumeSelector.AutoTlsSpiffe, backendTypes: []string{BackendTypeAutoTls}},
+		{option: volume.AutoTlsSANs, set: len(volumeSelector.AutoTlsSANs) > 0, backendTypes: []string{BackendTypeAutoTls}},
+		{option: volume.SecretsZncdataKerberosRealms, set: len(volumeSelector.KerberosRealms) > 0, backendTypes: []string{BackendTypeKerberos}},
+		{option: volume.SecretsZncdataKerberosServiceNames, set: len(volumeSelector.KerberosServiceNames) > 0, backendTypes: []string{BackendTypeKerberos}},
+	}
+	for _, requirement := range requirements {
+		if requirement.set && !slices.ContainsFunc(backendTypes, func(backendType string) bool {
+			return slices.Contains(requirement.backendTypes, backendType)
+		}) {
+			return fmt.Errorf("%w: %s requires a secret class with a backend of %v, got %v",
+				ErrInvalidVolumeContext, requirement.option, requirement.backendTypes, backendTypes)
+		}
+	}
+	return nil
+}
+
+// backendImpl creates the backend of the secret class with the factory registered for its type.
+func (b *Backend) backendImpl() (IBackend, error) {
+
+	backend := b.secretClass.Spec.Backend
+
+	if backend == nil {
+		return nil, fmt.Errorf("%w: backend is not configured in secret class %s", ErrSecretClassInvalid, b.secretClass.Name)
+	}
+	if configured := configuredBackends(backend); len(configured) > 1 {
+		return nil, fmt.Errorf("%w: backends %v are mutually exclusive in secret class %s",
+			ErrSecretClassInvalid, configured, b.secretClass.Name)
+	}
+
+	backendType := BackendType(b.secretClass)
+	if backendType == BackendTypeUnknown {
+		return nil, fmt.Errorf("%w: can not find backend in secret class %s", ErrSecretClassInvalid, b.secretClass.Name)
+	}
+	if backend.Custom != nil && slices.Contains(builtinBackendTypes, backendType) {
+		return nil, fmt.Errorf("%w: custom backend of secret class %s can not be the built-in backend %s",
+			ErrSecretClassInvalid, b.secretClass.Name, backendType)
+	}
+
+	factory, ok := backendFactory(backendType)
+	if !ok {
+		return nil, fmt.Errorf("%w: %w: %s of secret class %s",
+			ErrSecretClassInvalid, errBackendNotRegistered, backendType, b.secretClass.Name)
+	}
+	return factory(&BackendConfig{
+		Client:         b.client,
+		PodInfo:        b.podInfo,
+		VolumeSelector: b.volumeSelector,
+		SecretClass:    b.secretClass,
+		TokenCache:     b.tokens,
+		Clock:          b.clock,
+		Rand:           b.rand,
+	})
 }
 
 func (b *Backend) GetSecretData(ctx context.Context) (*util.SecretContent, error) {
+	backendType := BackendType(b.secretClass)
+	cacheable := b.cache != nil && backendCacheable(backendType)
+	key := NewCacheKey(b.volumeSelector)
+	if cacheable && !b.volumeSelector.NoCache {
+		if content, ok := b.cache.Get(key); ok {
+			util.LoggerFromContext(ctx, logger).V(5).Info("Secret data cache hit", "key", key)
+			return content, nil
+		}
+	}
+
 	impl, err := b.backendImpl()
 	if err != nil {
-		return nil, err
+		return nil, b.wrapError(err)
+	}
+
+	var content *util.SecretContent
+	err = b.retry.do(ctx, func(ctx context.Context) error {
+		release, err := b.limiter.acquire(ctx, backendType)
+		if err != nil {
+			return err
+		}
+		defer release()
+		content, err = impl.GetSecretData(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, b.wrapError(err)
 	}
 
-	return impl.GetSecretData(ctx)
+	if cacheable {
+		b.cache.Set(key, content)
+	}
+	return content, nil
+}
+
+// Validate checks the backend configured in the secret class, e.g. the referenced secret exists.
+// It does not need a pod, so the pod info may be nil, it is used to report whether the secret class is ready.
+// A custom backend not registered in this binary is not checked, like ValidateSecretClass.
+func (b *Backend) Validate(ctx context.Context) error {
+	impl, err := b.backendImpl()
+	if errors.Is(err, errBackendNotRegistered) && b.secretClass.Spec.Backend.Custom != nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return impl.Validate(ctx)
+}
+
+// wrapError adds the secret class, the backend type and the pod to the error of the backend.
+func (b *Backend) wrapError(err error) error {
+	if b.podInfo == nil {
+		return fmt.Errorf("%s backend of secret class %s: %w", BackendType(b.secretClass), b.secretClass.Name, err)
+	}
+	return fmt.Errorf("%s backend of secret class %s, pod %s/%s: %w",
+		BackendType(b.secretClass), b.secretClass.Name, b.podInfo.GetPodNamespace(), b.podInfo.GetPodName(), err)
 }
@@ -0,0 +1,117 @@
+package backend
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func TestBackendType(t *testing.T) {
+	tests := []struct {
+		name     string
+		backend  *secretsv1alpha1.BackendSpec
+		expected string
+	}{
+		{
+			name:     "not configured",
+			expected: BackendTypeUnknown,
+		},
+		{
+			name:     "autoTls",
+			backend:  &secretsv1alpha1.BackendSpec{AutoTls: &secretsv1alpha1.AutoTlsSpec{}},
+			expected: BackendTypeAutoTls,
+		},
+		{
+			name:     "certManager",
+			backend:  &secretsv1alpha1.BackendSpec{CertManager: &secretsv1alpha1.CertManagerSpec{}},
+			expected: BackendTypeCertManager,
+		},
+		{
+			name:     "file",
+			backend:  &secretsv1alpha1.BackendSpec{File: &secretsv1alpha1.FileSpec{}},
+			expected: BackendTypeFile,
+		},
+		{
+			name:     "k8sSearch",
+			backend:  &secretsv1alpha1.BackendSpec{K8sSearch: &secretsv1alpha1.K8sSearchSpec{}},
+			expected: BackendTypeK8sSearch,
+		},
+		{
+			name:     "vault",
+			backend:  &secretsv1alpha1.BackendSpec{Vault: &secretsv1alpha1.VaultSpec{}},
+			expected: BackendTypeVault,
+		},
+		{
+			name:     "custom",
+			backend:  &secretsv1alpha1.BackendSpec{Custom: &secretsv1alpha1.CustomSpec{Type: "store"}},
+			expected: "store",
+		},
+		{
+			name:     "empty",
+			backend:  &secretsv1alpha1.BackendSpec{},
+			expected: BackendTypeUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secretClass := &secretsv1alpha1.SecretClass{
+				Spec: secretsv1alpha1.SecretClassSpec{Backend: tt.backend},
+			}
+			if got := BackendType(secretClass); got != tt.expected {
+				t.Errorf("unexpected backend type: got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateVolumeSelector(t *testing.T) {
+	autoTls := &secretsv1alpha1.BackendSpec{AutoTls: &secretsv1alpha1.AutoTlsSpec{}}
+	certManager := &secretsv1alpha1.BackendSpec{CertManager: &secretsv1alpha1.CertManagerSpec{}}
+	kerberos := &secretsv1alpha1.BackendSpec{Kerberos: &secretsv1alpha1.KerberosSpec{}}
+	k8sSearch := &secretsv1alpha1.BackendSpec{K8sSearch: &secretsv1alpha1.K8sSearchSpec{}}
+
+	tests := []struct {
+		name     string
+		selector volume.SecretVolumeSelector
+		backends []*secretsv1alpha1.BackendSpec
+		wantErr  bool
+	}{
+		{name: "no option", backends: []*secretsv1alpha1.BackendSpec{kerberos}},
+		{name: "pem with k8sSearch", selector: volume.SecretVolumeSelector{Format: "tls-pem"}, backends: []*secretsv1alpha1.BackendSpec{k8sSearch}},
+		{name: "pem with kerberos", selector: volume.SecretVolumeSelector{Format: "tls-pem"}, backends: []*secretsv1alpha1.BackendSpec{kerberos}, wantErr: true},
+		{name: "p12 with kerberos", selector: volume.SecretVolumeSelector{Format: "tls-pkcs12"}, backends: []*secretsv1alpha1.BackendSpec{kerberos}, wantErr: true},
+		{name: "jks with kerberos", selector: volume.SecretVolumeSelector{Format: "tls-jks"}, backends: []*secretsv1alpha1.BackendSpec{kerberos}, wantErr: true},
+		{name: "pem with kerberos and autoTls", selector: volume.SecretVolumeSelector{Format: "tls-pem"}, backends: []*secretsv1alpha1.BackendSpec{kerberos, autoTls}},
+		{name: "kerberos format with autoTls", selector: volume.SecretVolumeSelector{Format: "kerberos"}, backends: []*secretsv1alpha1.BackendSpec{autoTls}, wantErr: true},
+		{name: "ca only with certManager", selector: volume.SecretVolumeSelector{AutoTls: volume.AutoTlsModeCAOnly}, backends: []*secretsv1alpha1.BackendSpec{certManager}},
+		{name: "ca only with k8sSearch", selector: volume.SecretVolumeSelector{AutoTls: volume.AutoTlsModeCAOnly}, backends: []*secretsv1alpha1.BackendSpec{k8sSearch}, wantErr: true},
+		{name: "lifetime with certManager", selector: volume.SecretVolumeSelector{AutoTlsCertLifetime: time.Hour}, backends: []*secretsv1alpha1.BackendSpec{certManager}},
+		{name: "lifetime with k8sSearch", selector: volume.SecretVolumeSelector{AutoTlsCertLifetime: time.Hour}, backends: []*secretsv1alpha1.BackendSpec{k8sSearch}, wantErr: true},
+		{name: "jitter with certManager", selector: volume.SecretVolumeSelector{AutoTlsCertJitterFactor: 0.2}, backends: []*secretsv1alpha1.BackendSpec{certManager}, wantErr: true},
+		{name: "spiffe with autoTls", selector: volume.SecretVolumeSelector{AutoTlsSpiffe: true}, backends: []*secretsv1alpha1.BackendSpec{autoTls}},
+		{name: "spiffe with certManager", selector: volume.SecretVolumeSelector{AutoTlsSpiffe: true}, backends: []*secretsv1alpha1.BackendSpec{certManager}, wantErr: true},
+		{name: "sans with autoTls", selector: volume.SecretVolumeSelector{AutoTlsSANs: []string{"a.example.com"}}, backends: []*secretsv1alpha1.BackendSpec{autoTls}},
+		{name: "sans with k8sSearch", selector: volume.SecretVolumeSelector{AutoTlsSANs: []string{"a.example.com"}}, backends: []*secretsv1alpha1.BackendSpec{k8sSearch}, wantErr: true},
+		{name: "kerberos realms with autoTls", selector: volume.SecretVolumeSelector{KerberosRealms: []string{"EXAMPLE.COM"}}, backends: []*secretsv1alpha1.BackendSpec{autoTls}, wantErr: true},
+		{name: "kerberos services with autoTls", selector: volume.SecretVolumeSelector{KerberosServiceNames: []string{"HTTP"}}, backends: []*secretsv1alpha1.BackendSpec{autoTls}, wantErr: true},
+		{name: "kerberos services with kerberos", selector: volume.SecretVolumeSelector{KerberosServiceNames: []string{"HTTP"}}, backends: []*secretsv1alpha1.BackendSpec{kerberos}},
+		{name: "unknown backend", selector: volume.SecretVolumeSelector{AutoTlsSpiffe: true}, backends: []*secretsv1alpha1.BackendSpec{{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var secretClasses []*secretsv1alpha1.SecretClass
+			for _, backend := range tt.backends {
+				secretClasses = append(secretClasses, &secretsv1alpha1.SecretClass{Spec: secretsv1alpha1.SecretClassSpec{Backend: backend}})
+			}
+			err := ValidateVolumeSelector(&tt.selector, secretClasses)
+			if tt.wantErr != errors.Is(err, ErrInvalidVolumeContext) || (!tt.wantErr && err != nil) {
+				t.Errorf("unexpected error: got %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package ca
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -10,8 +14,11 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
+	"net/url"
 	"regexp"
+	"slices"
 	"time"
 
 	pkcs12 "software.sslmate.com/src/go-pkcs12"
@@ -24,9 +31,107 @@ type PEMkeyPair struct {
 	KeyPEMBlock  []byte
 }
 
+// KeyAlgorithm is the algorithm and size of the private keys of the signed certificates.
+type KeyAlgorithm string
+
+const (
+	KeyAlgorithmRSA2048   KeyAlgorithm = "rsa:2048"
+	KeyAlgorithmRSA4096   KeyAlgorithm = "rsa:4096"
+	KeyAlgorithmECDSAP256 KeyAlgorithm = "ecdsa:P256"
+	KeyAlgorithmECDSAP384 KeyAlgorithm = "ecdsa:P384"
+	KeyAlgorithmEd25519   KeyAlgorithm = "ed25519"
+
+	// DefaultKeyAlgorithm is the algorithm of the private keys when none is configured.
+	DefaultKeyAlgorithm = KeyAlgorithmRSA2048
+)
+
+// ParseKeyAlgorithm returns the key algorithm of the value, an empty value is DefaultKeyAlgorithm.
+func ParseKeyAlgorithm(value string) (KeyAlgorithm, error) {
+	switch algorithm := KeyAlgorithm(value); algorithm {
+	case "":
+		return DefaultKeyAlgorithm, nil
+	case KeyAlgorithmRSA2048, KeyAlgorithmRSA4096, KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384, KeyAlgorithmEd25519:
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("unsupported key algorithm %q", value)
+	}
+}
+
+// The key usages and extended key usages of the leaf certificates.
+const (
+	KeyUsageDigitalSignature = "digitalSignature"
+	KeyUsageKeyEncipherment  = "keyEncipherment"
+	ExtKeyUsageServerAuth    = "serverAuth"
+	ExtKeyUsageClientAuth    = "clientAuth"
+)
+
+// Usages are the key usages of a leaf certificate, the zero values keep the defaults.
+type Usages struct {
+	// KeyUsage defaults to digitalSignature, and keyEncipherment for the RSA keys.
+	KeyUsage x509.KeyUsage
+	// ExtKeyUsages defaults to serverAuth and clientAuth.
+	ExtKeyUsages []x509.ExtKeyUsage
+}
+
+// ParseKeyUsages returns the key usage of the values, 0 when there is none.
+func ParseKeyUsages(values []string) (x509.KeyUsage, error) {
+	var keyUsage x509.KeyUsage
+	for _, value := range values {
+		switch value {
+		case KeyUsageDigitalSignature:
+			keyUsage |= x509.KeyUsageDigitalSignature
+		case KeyUsageKeyEncipherment:
+			keyUsage |= x509.KeyUsageKeyEncipherment
+		default:
+			return 0, fmt.Errorf("unsupported key usage %q", value)
+		}
+	}
+	return keyUsage, nil
+}
+
+// ParseExtKeyUsages returns the extended key usages of the values, each listed once, nil when there is none.
+func ParseExtKeyUsages(values []string) ([]x509.ExtKeyUsage, error) {
+	var extKeyUsages []x509.ExtKeyUsage
+	for _, value := range values {
+		var extKeyUsage x509.ExtKeyUsage
+		switch value {
+		case ExtKeyUsageServerAuth:
+			extKeyUsage = x509.ExtKeyUsageServerAuth
+		case ExtKeyUsageClientAuth:
+			extKeyUsage = x509.ExtKeyUsageClientAuth
+		default:
+			return nil, fmt.Errorf("unsupported extended key usage %q", value)
+		}
+		if !slices.Contains(extKeyUsages, extKeyUsage) {
+			extKeyUsages = append(extKeyUsages, extKeyUsage)
+		}
+	}
+	return extKeyUsages, nil
+}
+
+// generatePrivateKey generates a private key of the algorithm, an empty algorithm is DefaultKeyAlgorithm.
+func generatePrivateKey(random io.Reader, algorithm KeyAlgorithm) (crypto.Signer, error) {
+	switch algorithm {
+	case "", KeyAlgorithmRSA2048:
+		return rsa.GenerateKey(random, 2048)
+	case KeyAlgorithmRSA4096:
+		return rsa.GenerateKey(random, 4096)
+	case KeyAlgorithmECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), random)
+	case KeyAlgorithmECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), random)
+	case KeyAlgorithmEd25519:
+		_, privateKey, err := ed25519.GenerateKey(random)
+		return privateKey, err
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm %q", algorithm)
+	}
+}
+
 type Certificate struct {
 	Certificate *x509.Certificate
-	PrivateKey  *rsa.PrivateKey
+	// PrivateKey is a *rsa.PrivateKey, a *ecdsa.PrivateKey or an ed25519.PrivateKey.
+	PrivateKey crypto.Signer
 }
 
 func NewCertificateFromData(certPEM []byte, keyPEM []byte) (*Certificate, error) {
@@ -36,9 +141,13 @@ func NewCertificateFromData(certPEM []byte, keyPEM []byte) (*Certificate, error)
 		return nil, err
 	}
 
+	privateKey, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", cert.PrivateKey)
+	}
 	return &Certificate{
 		Certificate: cert.Leaf,
-		PrivateKey:  cert.PrivateKey.(*rsa.PrivateKey),
+		PrivateKey:  privateKey,
 	}, nil
 }
 
@@ -46,8 +155,17 @@ func (c *Certificate) CertificatePEM() []byte {
 	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Certificate.Raw})
 }
 
-func (c *Certificate) PrivateKeyPEM() []byte {
-	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(c.PrivateKey)})
+// PrivateKeyPEM returns the private key in PKCS #1 for RSA keys, like before the other algorithms were supported,
+// and in PKCS #8 otherwise.
+func (c *Certificate) PrivateKeyPEM() ([]byte, error) {
+	if privateKey, ok := c.PrivateKey.(*rsa.PrivateKey); ok {
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}), nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(c.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
 }
 
 func (c *Certificate) TrustStoreP12(password string, caCerts []*x509.Certificate) ([]byte, error) {
@@ -58,6 +176,7 @@ func (c *Certificate) KeyStoreP12(password string, caCerts []*x509.Certificate)
 	return pkcs12.Modern.Encode(c.PrivateKey, c.Certificate, caCerts, password)
 }
 
+// CertificateAuthority signs the certificates with its RSA key, which signs the public keys of every KeyAlgorithm.
 type CertificateAuthority struct {
 	Certificate *x509.Certificate
 	PrivateKey  *rsa.PrivateKey
@@ -78,21 +197,29 @@ func NewCertificateAuthorityFromData(
 		return nil, err
 	}
 
+	privateKey, ok := tlsCert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", tlsCert.PrivateKey)
+	}
 	return NewCertificateAuthority(
-		&Certificate{Certificate: x509Cert, PrivateKey: tlsCert.PrivateKey.(*rsa.PrivateKey)},
+		&Certificate{Certificate: x509Cert, PrivateKey: privateKey},
 	)
 }
 
-// NewCertificateAuthorityFromSecret creates a new CertificateAuthority from a secret
+// NewCertificateAuthority creates a CertificateAuthority from a CA certificate with an RSA private key.
 func NewCertificateAuthority(root *Certificate) (*CertificateAuthority, error) {
 	// check cert is a CA
 	if !root.Certificate.IsCA {
 		return nil, errors.New("root certificate is not a CA")
 	}
+	privateKey, ok := root.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T of certificate authority, must be RSA", root.PrivateKey)
+	}
 
 	return &CertificateAuthority{
 		Certificate: root.Certificate,
-		PrivateKey:  root.PrivateKey,
+		PrivateKey:  privateKey,
 	}, nil
 }
 
@@ -115,19 +242,26 @@ func (c *CertificateAuthority) CertificatePEM() []byte {
 	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Certificate.Raw})
 }
 
-func (c *CertificateAuthority) SignCertificate(template *x509.Certificate) (*Certificate, error) {
+// SignCertificate signs the template with a new private key of the algorithm, the template must set NotBefore and NotAfter.
+// random is the source of the private key, the serial number and the signature, usually crypto/rand.Reader.
+// A SAN outside the name constraints of the certificate authority is refused with an ErrNameConstraintViolation.
+func (c *CertificateAuthority) SignCertificate(random io.Reader, keyAlgorithm KeyAlgorithm, template *x509.Certificate) (*Certificate, error) {
+	if err := checkNameConstraints(c.Certificate, template); err != nil {
+		return nil, err
+	}
+
 	// Generate a new private key
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	privateKey, err := generatePrivateKey(random, keyAlgorithm)
 	if err != nil {
 		return nil, err
 	}
 
-	publicKeySum, err := publicKeySHA256(&privateKey.PublicKey)
+	publicKeySum, err := publicKeySHA256(privateKey.Public())
 	if err != nil {
 		return nil, err
 	}
 
-	serialNumber, err := generateSerialNumber()
+	serialNumber, err := generateSerialNumber(random)
 	if err != nil {
 		return nil, err
 	}
@@ -139,12 +273,17 @@ func (c *CertificateAuthority) SignCertificate(template *x509.Certificate) (*Cer
 	template.Issuer = c.Certificate.Subject
 	template.SubjectKeyId = publicKeySum[:]
 	template.AuthorityKeyId = c.Certificate.SubjectKeyId
-	template.PublicKey = &privateKey.PublicKey
-	template.NotBefore = time.Now()
-	// see http://golang.org/pkg/crypto/x509/#KeyUsage
-	template.KeyUsage = x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	template.PublicKey = privateKey.Public()
+	// see http://golang.org/pkg/crypto/x509/#KeyUsage, the key usage of the template is kept when set
+	if template.KeyUsage == 0 {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		// only the RSA keys encipher the TLS keys, ECDSA and Ed25519 keys only sign
+		if _, ok := privateKey.(*rsa.PrivateKey); ok {
+			template.KeyUsage |= x509.KeyUsageKeyEncipherment
+		}
+	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, c.Certificate, &privateKey.PublicKey, c.PrivateKey)
+	certBytes, err := x509.CreateCertificate(random, template, c.Certificate, privateKey.Public(), c.PrivateKey)
 	if err != nil {
 		return nil, err
 	}
@@ -162,48 +301,64 @@ func (c *CertificateAuthority) SignCertificate(template *x509.Certificate) (*Cer
 	}, nil
 }
 
+// SignServerCertificate signs a certificate for the addresses, uris are added as URI SANs, e.g. SPIFFE IDs.
 func (c *CertificateAuthority) SignServerCertificate(
+	random io.Reader,
+	keyAlgorithm KeyAlgorithm,
 	commonName string,
 	addresses []pod_info.Address,
-	notAfter time.Time,
+	uris []*url.URL,
+	usages Usages,
+	notBefore, notAfter time.Time,
 ) (*Certificate, error) {
+	extKeyUsages := usages.ExtKeyUsages
+	if len(extKeyUsages) == 0 {
+		extKeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+	}
 
 	template := &x509.Certificate{
 		Subject: pkix.Name{
 			CommonName: commonName,
 		},
-		NotAfter: notAfter,
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
 
 		// see http://golang.org/pkg/crypto/x509/#ExtKeyUsage
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		ExtKeyUsage: extKeyUsages,
+		KeyUsage:    usages.KeyUsage,
+		URIs:        uris,
 	}
 
 	buildSANExt(template, addresses)
 
-	return c.SignCertificate(template)
+	return c.SignCertificate(random, keyAlgorithm, template)
 }
 
 func (c *CertificateAuthority) SignClientCertificate(
+	random io.Reader,
+	keyAlgorithm KeyAlgorithm,
 	commonName string,
 	addresses []pod_info.Address,
-	notAfter time.Time,
+	notBefore, notAfter time.Time,
 ) (*Certificate, error) {
 	template := &x509.Certificate{
 		Subject: pkix.Name{
 			CommonName: commonName,
 		},
-		NotAfter: notAfter,
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
 		// see http://golang.org/pkg/crypto/x509/#ExtKeyUsage
 		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
 	}
 
 	buildSANExt(template, addresses)
 
-	return c.SignCertificate(template)
+	return c.SignCertificate(random, keyAlgorithm, template)
 }
 
-func (c *CertificateAuthority) Rotate(notAfter time.Time) (*CertificateAuthority, error) {
-	newCA, err := NewSelfSignedCertificateAuthority(notAfter, c.Certificate, c.PrivateKey)
+// Rotate creates a new certificate authority signed by this one, with the same common name and the constraints.
+func (c *CertificateAuthority) Rotate(random io.Reader, notBefore, notAfter time.Time, constraints *Constraints) (*CertificateAuthority, error) {
+	newCA, err := NewSelfSignedCertificateAuthority(random, c.Certificate.Subject.CommonName, notBefore, notAfter, constraints, c.Certificate, c.PrivateKey)
 	if err != nil {
 		return nil, err
 	}
@@ -212,9 +367,21 @@ func (c *CertificateAuthority) Rotate(notAfter time.Time) (*CertificateAuthority
 	return newCA, nil
 }
 
-func NewSelfSignedCertificateAuthority(expeiry time.Time, parent *x509.Certificate, parentPrivateKey *rsa.PrivateKey) (*CertificateAuthority, error) {
+// DefaultCommonName is the common name of the self-signed certificate authorities when none is configured.
+const DefaultCommonName = "secret-operator self-signed CA"
+
+// NewSelfSignedCertificateAuthority creates a certificate authority, self-signed when parent is nil.
+// An empty common name is DefaultCommonName, and nil constraints are unconstrained.
+func NewSelfSignedCertificateAuthority(
+	random io.Reader,
+	commonName string,
+	notBefore, expeiry time.Time,
+	constraints *Constraints,
+	parent *x509.Certificate,
+	parentPrivateKey *rsa.PrivateKey,
+) (*CertificateAuthority, error) {
 	// Generate a new private key
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	privateKey, err := rsa.GenerateKey(random, 2048)
 	if err != nil {
 		return nil, err
 	}
@@ -224,11 +391,14 @@ func NewSelfSignedCertificateAuthority(expeiry time.Time, parent *x509.Certifica
 		return nil, err
 	}
 
+	if commonName == "" {
+		commonName = DefaultCommonName
+	}
 	subectName := pkix.Name{
-		CommonName: "secret-operator self-signed CA",
+		CommonName: commonName,
 	}
 
-	serialNumber, err := generateSerialNumber()
+	serialNumber, err := generateSerialNumber(random)
 	if err != nil {
 		return nil, err
 	}
@@ -243,11 +413,12 @@ func NewSelfSignedCertificateAuthority(expeiry time.Time, parent *x509.Certifica
 		Issuer:                subectName,
 		AuthorityKeyId:        publicKeySum[:],
 		PublicKey:             &privateKey.PublicKey,
-		NotBefore:             time.Now(),
+		NotBefore:             notBefore,
 		NotAfter:              expeiry,
 		// see http://golang.org/pkg/crypto/x509/#KeyUsage
 		KeyUsage: x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 	}
+	constraints.apply(template)
 
 	if parent == nil {
 		parent = template
@@ -257,7 +428,7 @@ func NewSelfSignedCertificateAuthority(expeiry time.Time, parent *x509.Certifica
 		parentPrivateKey = privateKey
 	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, parent, &privateKey.PublicKey, parentPrivateKey)
+	certBytes, err := x509.CreateCertificate(random, template, parent, &privateKey.PublicKey, parentPrivateKey)
 	if err != nil {
 		return nil, err
 	}
@@ -274,9 +445,9 @@ func NewSelfSignedCertificateAuthority(expeiry time.Time, parent *x509.Certifica
 }
 
 // generate a 64-bit serial number
-func generateSerialNumber() (*big.Int, error) {
+func generateSerialNumber(random io.Reader) (*big.Int, error) {
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 64)
-	return rand.Int(rand.Reader, serialNumberLimit)
+	return rand.Int(random, serialNumberLimit)
 }
 
 func buildSANExt(template *x509.Certificate, addresses []pod_info.Address) {
@@ -291,7 +462,7 @@ func buildSANExt(template *x509.Certificate, addresses []pod_info.Address) {
 }
 
 // Compute the SHA-256 hash of the public key
-func publicKeySHA256(publicKey *rsa.PublicKey) ([]byte, error) {
+func publicKeySHA256(publicKey crypto.PublicKey) ([]byte, error) {
 	publicKeyBytes, err := x509.MarshalPKIXPublicKey(publicKey)
 	if err != nil {
 		return nil, err
@@ -2,13 +2,19 @@ package ca
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"io"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/zncdata-labs/secret-operator/pkg/resource"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -20,14 +26,23 @@ var (
 var (
 	ErrCACertificateNotFound = errors.New("CA certificate not found")
 	ErrCAPrivateKeyNotFound  = errors.New("CA private key not found")
+	ErrCANotValidLongEnough  = errors.New("no certificate authority is valid long enough")
 )
 
 type CertificateManager struct {
 	client                 client.Client
+	clock                  clock.PassiveClock
+	rand                   io.Reader
 	caCertficateLifetime   time.Duration
 	auto                   bool
+	commonName             string
+	constraints            *Constraints
 	name, namespace        string
 	certificateAuthorities []*CertificateAuthority
+
+	// caCertificates are all the certificates found in the .crt entries of the secret, including the ones
+	// without private key, e.g. the retired CA still trusted during rotation, and the rest of a chain.
+	caCertificates []*x509.Certificate
 }
 
 // NewCertificateManager creates a new CertificateManager
@@ -36,26 +51,39 @@ type CertificateManager struct {
 // If the secret does not exist, and auto is disabled, return error.
 // If the secret exists, get certificate authorities from the secret.
 // Now, pem key supports only RSA 256.
+// The clock decides which certificate authorities are expired or need rotation,
+// and rand is the source of the keys of the new certificate authorities, named commonName.
+// The certificate authorities must carry constraints compatible with constraints to issue certificates,
+// when auto is enabled and none does, a new one is created with the constraints.
 func NewCertificateManager(
 	ctx context.Context,
 	client client.Client,
+	clock clock.PassiveClock,
+	rand io.Reader,
 	caCertficateLifetime time.Duration,
 	auto bool,
+	commonName string,
+	constraints *Constraints,
 	name, namespace string,
 ) (*CertificateManager, error) {
 	obj := &CertificateManager{
 		client:               client,
+		clock:                clock,
+		rand:                 rand,
 		caCertficateLifetime: caCertficateLifetime,
 		auto:                 auto,
+		commonName:           commonName,
+		constraints:          constraints,
 		name:                 name,
 		namespace:            namespace,
 	}
 
-	pemKeyPairs, err := obj.getSecret(ctx)
+	pemKeyPairs, caCertificates, err := obj.getSecret(ctx)
 
 	if err != nil {
 		return nil, err
 	}
+	obj.caCertificates = caCertificates
 
 	cas, err := obj.getCertificateAuthorities(ctx, pemKeyPairs)
 	if err != nil {
@@ -67,20 +95,115 @@ func NewCertificateManager(
 	return obj, nil
 }
 
-// get pem key pairs from a secret
+// ValidateSecret checks the certificate authorities in the secret without modifying it.
+// Every key pair in the secret must parse. When auto is disabled, at least one certificate authority
+// must still be valid at now and compatible with the constraints, otherwise it is fine that the secret
+// does not exist yet, as it will be created.
+func ValidateSecret(ctx context.Context, client client.Client, now time.Time, auto bool, constraints *Constraints, name, namespace string) error {
+	c := &CertificateManager{
+		client:    client,
+		auto:      auto,
+		name:      name,
+		namespace: namespace,
+	}
+
+	pemKeyPairs, _, err := c.getSecret(ctx)
+	if err != nil {
+		return err
+	}
+
+	valid := 0
+	var constraintsErr error
+	for _, keyPair := range pemKeyPairs {
+		ca, err := NewCertificateAuthorityFromData(keyPair.CertPEMBlock, keyPair.KeyPEMBlock)
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate authority in secret %s/%s: %w", namespace, name, err)
+		}
+		if !ca.Certificate.NotAfter.After(now) {
+			continue
+		}
+		if err := ca.CheckConstraints(constraints); err != nil {
+			constraintsErr = err
+			continue
+		}
+		valid++
+	}
+
+	if valid == 0 && !auto {
+		if constraintsErr != nil {
+			return fmt.Errorf("%w in secret %s/%s", constraintsErr, namespace, name)
+		}
+		return fmt.Errorf("%w in secret %s/%s", ErrCACertificateNotFound, namespace, name)
+	}
+
+	return nil
+}
+
+// Bootstrap creates the secret with a new self-signed certificate authority when the secret has no valid
+// certificate authority, e.g. it does not exist yet, and returns whether it was created.
+// The secret is not changed when a certificate authority is still valid, it is rotated by the csi driver.
+// A secret with a key pair which does not parse is not overwritten. The new one carries the constraints.
+func Bootstrap(
+	ctx context.Context,
+	client client.Client,
+	clock clock.PassiveClock,
+	rand io.Reader,
+	caCertficateLifetime time.Duration,
+	commonName string,
+	constraints *Constraints,
+	name, namespace string,
+) (bool, error) {
+	c := &CertificateManager{
+		client:               client,
+		clock:                clock,
+		rand:                 rand,
+		caCertficateLifetime: caCertficateLifetime,
+		auto:                 true,
+		commonName:           commonName,
+		constraints:          constraints,
+		name:                 name,
+		namespace:            namespace,
+	}
+
+	pemKeyPairs, _, err := c.getSecret(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, keyPair := range pemKeyPairs {
+		ca, err := NewCertificateAuthorityFromData(keyPair.CertPEMBlock, keyPair.KeyPEMBlock)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse certificate authority in secret %s/%s: %w", namespace, name, err)
+		}
+		if ca.Certificate.NotAfter.After(clock.Now()) {
+			return false, nil
+		}
+	}
+
+	ca, err := c.createSelfSignedCertificateAuthority(caCertficateLifetime)
+	if err != nil {
+		return false, err
+	}
+	if err := c.saveCertificateAuthorities(ctx, []*CertificateAuthority{ca}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// get pem key pairs and all CA certificates from a secret
 // if the secret does not exist, return nil.
 // when auto is enabled, it will create a new self-signed certificate authority
-func (c *CertificateManager) getSecret(ctx context.Context) ([]PEMkeyPair, error) {
+func (c *CertificateManager) getSecret(ctx context.Context) ([]PEMkeyPair, []*x509.Certificate, error) {
 	secret := &corev1.Secret{}
 	err := c.client.Get(ctx, client.ObjectKey{Namespace: c.namespace, Name: c.name}, secret)
 	if err != nil {
 		if client.IgnoreNotFound(err) != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	var keyPairs []PEMkeyPair
+	var caCertificates []*x509.Certificate
 
 	for certName, cert := range secret.Data {
 		if strings.HasSuffix(certName, ".crt") {
@@ -88,10 +211,36 @@ func (c *CertificateManager) getSecret(ctx context.Context) ([]PEMkeyPair, error
 			if privateKey, ok := secret.Data[privateKeyName]; ok {
 				keyPairs = append(keyPairs, PEMkeyPair{cert, privateKey})
 			}
+
+			certs, err := parseCertificatesPEM(cert)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse %s in secret %s/%s: %w", certName, c.namespace, c.name, err)
+			}
+			caCertificates = append(caCertificates, certs...)
 		}
 	}
 
-	return keyPairs, nil
+	return keyPairs, caCertificates, nil
+}
+
+// parseCertificatesPEM parses all the certificates in the PEM data, e.g. a chain.
+func parseCertificatesPEM(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
 }
 
 // save pem key pairs to a secret
@@ -158,7 +307,7 @@ func (c *CertificateManager) getCertificateAuthorities(ctx context.Context, pemK
 		if err != nil {
 			return nil, err
 		}
-		if ca.Certificate.NotAfter.Before(time.Now()) {
+		if ca.Certificate.NotAfter.Before(c.clock.Now()) {
 			logger.V(0).Info("Certificate authority is expired, skip it.", "serialNumber", ca.SerialNumber(), "notAfter", ca.Certificate.NotAfter)
 			continue
 		}
@@ -167,11 +316,23 @@ func (c *CertificateManager) getCertificateAuthorities(ctx context.Context, pemK
 
 	logger.V(0).Info("Found vaild certificate authorities", "count", len(cas))
 
-	if len(cas) == 0 {
-		if !c.auto {
-			logger.V(0).Info("Could not find any certificate authorities, and auto-generate is disabled, please create manually")
-			return nil, ErrCACertificateNotFound
+	if len(cas) == 0 && !c.auto {
+		logger.V(0).Info("Could not find any certificate authorities, and auto-generate is disabled, please create manually")
+		return nil, ErrCACertificateNotFound
+	}
+
+	// the certificate authorities generated before the constraints were configured are kept, still trusted
+	// until they expire, but a new one issues the certificates
+	if c.auto && len(cas) > 0 && len(c.compatible(cas)) == 0 {
+		logger.V(0).Info("No certificate authority is compatible with the constraints, create a new one")
+		ca, err := c.createSelfSignedCertificateAuthority(c.caCertficateLifetime)
+		if err != nil {
+			return nil, err
 		}
+		cas = append(cas, ca)
+	}
+
+	if len(cas) == 0 {
 
 		logger.V(1).Info("Could not find any certificate authorities, created a new self-signed certificate authority")
 		ca, err := c.createSelfSignedCertificateAuthority(c.caCertficateLifetime)
@@ -193,9 +354,12 @@ func (c *CertificateManager) getCertificateAuthorities(ctx context.Context, pemK
 		return nil, err
 	}
 
-	// save certificate authorities
-	if err := c.saveCertificateAuthorities(ctx, cas); err != nil {
-		return nil, err
+	// save certificate authorities, only when auto is enabled,
+	// otherwise the certificate authorities are managed by user.
+	if c.auto {
+		if err := c.saveCertificateAuthorities(ctx, cas); err != nil {
+			return nil, err
+		}
 	}
 
 	return cas, nil
@@ -205,8 +369,8 @@ func (c *CertificateManager) getCertificateAuthorities(ctx context.Context, pemK
 func (c *CertificateManager) createSelfSignedCertificateAuthority(
 	caCertficateLifetime time.Duration,
 ) (*CertificateAuthority, error) {
-	notAfter := time.Now().Add(caCertficateLifetime)
-	ca, err := NewSelfSignedCertificateAuthority(notAfter, nil, nil)
+	now := c.clock.Now()
+	ca, err := NewSelfSignedCertificateAuthority(c.rand, c.commonName, now, now.Add(caCertficateLifetime), c.constraints, nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -229,9 +393,10 @@ func (c *CertificateManager) rotateCertificateAuthority(
 		}
 	}
 
-	if time.Now().Add(c.caCertficateLifetime / 2).After(newestCA.Certificate.NotAfter) {
+	now := c.clock.Now()
+	if now.Add(c.caCertficateLifetime / 2).After(newestCA.Certificate.NotAfter) {
 		if c.auto {
-			newCA, err := newestCA.Rotate(time.Now().Add(c.caCertficateLifetime))
+			newCA, err := newestCA.Rotate(c.rand, now, now.Add(c.caCertficateLifetime), c.constraints)
 			if err != nil {
 				return nil, err
 			}
@@ -278,6 +443,10 @@ func (c *CertificateManager) GetCertificateAuthority(
 		}
 	}
 
+	if len(filtedCAs) == 0 {
+		return nil, fmt.Errorf("%w, none is valid after %s, the certificate authorities may expire soon", ErrCANotValidLongEnough, atAfter)
+	}
+
 	// oldese certificate authority
 	certificateAuthority := filtedCAs[0]
 
@@ -290,3 +459,62 @@ func (c *CertificateManager) GetCertificateAuthority(
 
 	return certificateAuthority, nil
 }
+
+// GetLatestCertificateAuthority returns the certificate authority compatible with the constraints expiring last.
+func (c *CertificateManager) GetLatestCertificateAuthority() (*CertificateAuthority, error) {
+	if len(c.certificateAuthorities) == 0 {
+		return nil, ErrCACertificateNotFound
+	}
+	var latest *CertificateAuthority
+	for _, ca := range c.compatible(c.certificateAuthorities) {
+		if latest == nil || ca.Certificate.NotAfter.After(latest.Certificate.NotAfter) {
+			latest = ca
+		}
+	}
+	if latest == nil {
+		return nil, c.certificateAuthorities[0].CheckConstraints(c.constraints)
+	}
+	return latest, nil
+}
+
+// compatible returns the certificate authorities compatible with the constraints.
+func (c *CertificateManager) compatible(cas []*CertificateAuthority) []*CertificateAuthority {
+	var compatible []*CertificateAuthority
+	for _, ca := range cas {
+		if err := ca.CheckConstraints(c.constraints); err != nil {
+			logger.V(1).Info("Certificate authority is not compatible with the constraints, skip it",
+				"serialNumber", ca.SerialNumber(), "reason", err.Error())
+			continue
+		}
+		compatible = append(compatible, ca)
+	}
+	return compatible
+}
+
+// TrustedCertificates returns all the valid CA certificates, the ones of the certificate authorities and the ones
+// only found in the secret, ordered by expiration time. Clients should trust all of them, so the certificates
+// issued by the old CA and the new CA are both accepted while the CA is rotated.
+func (c *CertificateManager) TrustedCertificates() []*x509.Certificate {
+	now := c.clock.Now()
+	seen := map[string]bool{}
+	var certs []*x509.Certificate
+
+	candidates := make([]*x509.Certificate, 0, len(c.certificateAuthorities)+len(c.caCertificates))
+	for _, ca := range c.certificateAuthorities {
+		candidates = append(candidates, ca.Certificate)
+	}
+	candidates = append(candidates, c.caCertificates...)
+
+	for _, cert := range candidates {
+		if !cert.IsCA || cert.NotAfter.Before(now) || seen[string(cert.Raw)] {
+			continue
+		}
+		seen[string(cert.Raw)] = true
+		certs = append(certs, cert)
+	}
+
+	sort.SliceStable(certs, func(i, j int) bool {
+		return certs[i].NotAfter.Before(certs[j].NotAfter)
+	})
+	return certs
+}
@@ -0,0 +1,165 @@
+package ca
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrCAConstraintsIncompatible means no certificate authority carries constraints at least as strict as the
+	// configured ones, so it can not issue certificates.
+	ErrCAConstraintsIncompatible = errors.New("certificate authority constraints incompatible")
+
+	// ErrNameConstraintViolation means a SAN of the certificate falls outside the name constraints of the CA.
+	ErrNameConstraintViolation = errors.New("name constraint violation")
+)
+
+// Constraints are the basic and name constraints of the certificate authorities, see RFC 5280.
+// A nil Constraints is unconstrained.
+type Constraints struct {
+	// MaxPathLen is the pathLenConstraint, nil when unset. 0 forbids intermediate CAs below the certificate authority.
+	MaxPathLen *int
+
+	// PermittedDNSDomains and ExcludedDNSDomains match the domain and its subdomains,
+	// or only the subdomains when the domain starts with a dot, e.g. ".cluster.local".
+	PermittedDNSDomains []string
+	ExcludedDNSDomains  []string
+
+	PermittedIPRanges []*net.IPNet
+	ExcludedIPRanges  []*net.IPNet
+}
+
+// hasNameConstraints returns whether any name constraint is set.
+func (c *Constraints) hasNameConstraints() bool {
+	return len(c.PermittedDNSDomains) > 0 || len(c.ExcludedDNSDomains) > 0 ||
+		len(c.PermittedIPRanges) > 0 || len(c.ExcludedIPRanges) > 0
+}
+
+// apply sets the constraints to the template of a certificate authority.
+// The name constraints are critical, as required by RFC 5280.
+func (c *Constraints) apply(template *x509.Certificate) {
+	if c == nil {
+		return
+	}
+	if c.MaxPathLen != nil {
+		template.MaxPathLen = *c.MaxPathLen
+		template.MaxPathLenZero = *c.MaxPathLen == 0
+	}
+	template.PermittedDNSDomains = c.PermittedDNSDomains
+	template.ExcludedDNSDomains = c.ExcludedDNSDomains
+	template.PermittedIPRanges = c.PermittedIPRanges
+	template.ExcludedIPRanges = c.ExcludedIPRanges
+	template.PermittedDNSDomainsCritical = c.hasNameConstraints()
+}
+
+// CheckConstraints checks the certificate authority carries constraints at least as strict as the constraints:
+// a path length not longer, permitted names inside the permitted ones, and the excluded names excluded too.
+// The returned error is an ErrCAConstraintsIncompatible.
+func (c *CertificateAuthority) CheckConstraints(constraints *Constraints) error {
+	if constraints == nil {
+		return nil
+	}
+	cert := c.Certificate
+	incompatible := func(format string, args ...any) error {
+		return fmt.Errorf("%w: certificate authority %s %s", ErrCAConstraintsIncompatible, c.SerialNumber(), fmt.Sprintf(format, args...))
+	}
+
+	if constraints.MaxPathLen != nil {
+		unlimited := cert.MaxPathLen < 0 || (cert.MaxPathLen == 0 && !cert.MaxPathLenZero)
+		if unlimited || cert.MaxPathLen > *constraints.MaxPathLen {
+			return incompatible("does not limit the path length to %d", *constraints.MaxPathLen)
+		}
+	}
+
+	if len(constraints.PermittedDNSDomains) > 0 {
+		if len(cert.PermittedDNSDomains) == 0 {
+			return incompatible("does not limit the DNS domains to %v", constraints.PermittedDNSDomains)
+		}
+		for _, domain := range cert.PermittedDNSDomains {
+			if !slices.ContainsFunc(constraints.PermittedDNSDomains, func(permitted string) bool { return domainWithin(domain, permitted) }) {
+				return incompatible("permits the DNS domain %q outside %v", domain, constraints.PermittedDNSDomains)
+			}
+		}
+	}
+	for _, domain := range constraints.ExcludedDNSDomains {
+		if !slices.ContainsFunc(cert.ExcludedDNSDomains, func(excluded string) bool { return domainWithin(domain, excluded) }) {
+			return incompatible("does not exclude the DNS domain %q", domain)
+		}
+	}
+
+	if len(constraints.PermittedIPRanges) > 0 {
+		if len(cert.PermittedIPRanges) == 0 {
+			return incompatible("does not limit the IP ranges to %v", constraints.PermittedIPRanges)
+		}
+		for _, ipRange := range cert.PermittedIPRanges {
+			if !slices.ContainsFunc(constraints.PermittedIPRanges, func(permitted *net.IPNet) bool { return ipRangeWithin(ipRange, permitted) }) {
+				return incompatible("permits the IP range %s outside %v", ipRange, constraints.PermittedIPRanges)
+			}
+		}
+	}
+	for _, ipRange := range constraints.ExcludedIPRanges {
+		if !slices.ContainsFunc(cert.ExcludedIPRanges, func(excluded *net.IPNet) bool { return ipRangeWithin(ipRange, excluded) }) {
+			return incompatible("does not exclude the IP range %s", ipRange)
+		}
+	}
+	return nil
+}
+
+// checkNameConstraints checks the DNS and IP SANs of the template against the name constraints of the CA
+// certificate, like the clients verifying the certificate would. The returned error is an ErrNameConstraintViolation.
+func checkNameConstraints(caCert *x509.Certificate, template *x509.Certificate) error {
+	for _, name := range template.DNSNames {
+		if len(caCert.PermittedDNSDomains) > 0 && !slices.ContainsFunc(caCert.PermittedDNSDomains, func(permitted string) bool { return matchDomain(name, permitted) }) {
+			return fmt.Errorf("%w: DNS name %q is not in the permitted domains %v of the certificate authority",
+				ErrNameConstraintViolation, name, caCert.PermittedDNSDomains)
+		}
+		if slices.ContainsFunc(caCert.ExcludedDNSDomains, func(excluded string) bool { return matchDomain(name, excluded) }) {
+			return fmt.Errorf("%w: DNS name %q is in the excluded domains %v of the certificate authority",
+				ErrNameConstraintViolation, name, caCert.ExcludedDNSDomains)
+		}
+	}
+	for _, ip := range template.IPAddresses {
+		if len(caCert.PermittedIPRanges) > 0 && !slices.ContainsFunc(caCert.PermittedIPRanges, func(permitted *net.IPNet) bool { return permitted.Contains(ip) }) {
+			return fmt.Errorf("%w: IP address %s is not in the permitted ranges %v of the certificate authority",
+				ErrNameConstraintViolation, ip, caCert.PermittedIPRanges)
+		}
+		if slices.ContainsFunc(caCert.ExcludedIPRanges, func(excluded *net.IPNet) bool { return excluded.Contains(ip) }) {
+			return fmt.Errorf("%w: IP address %s is in the excluded ranges %v of the certificate authority",
+				ErrNameConstraintViolation, ip, caCert.ExcludedIPRanges)
+		}
+	}
+	return nil
+}
+
+// matchDomain returns whether the DNS name matches the domain constraint, the domain and its subdomains,
+// or only the subdomains when the constraint starts with a dot. An empty constraint matches every name.
+func matchDomain(name, constraint string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	constraint = strings.ToLower(constraint)
+	if constraint == "" {
+		return true
+	}
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(name, constraint)
+	}
+	return name == constraint || strings.HasSuffix(name, "."+constraint)
+}
+
+// domainWithin returns whether all the names matched by the domain constraint inner are matched by outer.
+func domainWithin(inner, outer string) bool {
+	if domain, ok := strings.CutPrefix(inner, "."); ok {
+		return matchDomain(domain, outer) || strings.EqualFold(inner, outer)
+	}
+	return matchDomain(inner, outer)
+}
+
+// ipRangeWithin returns whether the IP range inner is inside outer.
+func ipRangeWithin(inner, outer *net.IPNet) bool {
+	innerOnes, innerBits := inner.Mask.Size()
+	outerOnes, outerBits := outer.Mask.Size()
+	return innerBits == outerBits && innerOnes >= outerOnes && outer.Contains(inner.IP)
+}
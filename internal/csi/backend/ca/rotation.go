@@ -0,0 +1,154 @@
+package ca
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RotationPolicy decides when the operator rotates and prunes the certificate authorities of a generated CA secret.
+type RotationPolicy struct {
+	// Window is how long before the newest certificate authority expires a new one is added to the secret,
+	// 0 is half of the CA lifetime, when the csi driver rotates it too. A window not shorter than the CA lifetime
+	// is half of it too, the new certificate authorities would be rotated right away.
+	Window time.Duration
+	// PruneAfter is how long the expired certificate authorities are kept in the secret after they expire,
+	// 0 removes them once expired.
+	PruneAfter time.Duration
+}
+
+// RotationResult is what RotateSecret changed in the CA secret.
+type RotationResult struct {
+	// Rotated is the certificate authority added to the secret, nil when none was added.
+	Rotated *CertificateAuthority
+	// Pruned are the entries removed from the secret, e.g. "0a:1b:...crt".
+	Pruned []string
+	// NextCheck is when the secret needs to be rotated or pruned next, zero when the secret has
+	// no valid certificate authority, it is created by Bootstrap.
+	NextCheck time.Time
+}
+
+// RotateSecret rotates the certificate authorities of the generated CA secret ahead of their expiration:
+// when the newest valid one expires within the window of the policy, a new one signed by it is appended to the
+// secret, the old ones are kept, so the certificates they issued are trusted until they expire.
+// The certificate authorities expired for longer than PruneAfter are removed, the other entries of the secret
+// are kept. Nothing is done when the secret has no valid certificate authority, it is created by Bootstrap.
+// The secret is updated with the resource version it was read with, a concurrent update fails with a conflict.
+func RotateSecret(
+	ctx context.Context,
+	c client.Client,
+	clock clock.PassiveClock,
+	rand io.Reader,
+	caCertficateLifetime time.Duration,
+	constraints *Constraints,
+	policy RotationPolicy,
+	name, namespace string,
+) (*RotationResult, error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+
+	now := clock.Now()
+	window := policy.Window
+	if window <= 0 || window >= caCertficateLifetime {
+		window = caCertficateLifetime / 2
+	}
+
+	result := &RotationResult{}
+	var newest *CertificateAuthority
+	for certName, certPEM := range secret.Data {
+		if !strings.HasSuffix(certName, ".crt") {
+			continue
+		}
+		keyPEM, ok := secret.Data[strings.TrimSuffix(certName, ".crt")+".key"]
+		if !ok {
+			continue
+		}
+		ca, err := NewCertificateAuthorityFromData(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate authority %s in secret %s/%s: %w", certName, namespace, name, err)
+		}
+		if ca.Certificate.NotAfter.After(now) && (newest == nil || ca.Certificate.NotAfter.After(newest.Certificate.NotAfter)) {
+			newest = ca
+		}
+	}
+	if newest == nil {
+		return result, nil
+	}
+
+	changed := false
+	rotateTime := newest.Certificate.NotAfter.Add(-window)
+	if !now.Before(rotateTime) {
+		newCA, err := newest.Rotate(rand, now, now.Add(caCertficateLifetime), constraints)
+		if err != nil {
+			return nil, err
+		}
+		serialNumber := formatSerialNumber(newCA.Certificate.SerialNumber)
+		secret.Data[serialNumber+".crt"] = newCA.CertificatePEM()
+		secret.Data[serialNumber+".key"] = newCA.privateKeyPEM()
+		result.Rotated = newCA
+		rotateTime = newCA.Certificate.NotAfter.Add(-window)
+		changed = true
+	}
+	result.NextCheck = rotateTime
+
+	for certName, certPEM := range secret.Data {
+		if !strings.HasSuffix(certName, ".crt") {
+			continue
+		}
+		certs, err := parseCertificatesPEM(certPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s in secret %s/%s: %w", certName, namespace, name, err)
+		}
+		if len(certs) == 0 {
+			continue
+		}
+		// the entry is pruned once all its certificates expired, e.g. a chain
+		var notAfter time.Time
+		for _, cert := range certs {
+			if cert.NotAfter.After(notAfter) {
+				notAfter = cert.NotAfter
+			}
+		}
+		pruneTime := notAfter.Add(policy.PruneAfter)
+		if now.Before(pruneTime) {
+			if pruneTime.Before(result.NextCheck) {
+				result.NextCheck = pruneTime
+			}
+			continue
+		}
+		keyName := strings.TrimSuffix(certName, ".crt") + ".key"
+		delete(secret.Data, certName)
+		result.Pruned = append(result.Pruned, certName)
+		if _, ok := secret.Data[keyName]; ok {
+			delete(secret.Data, keyName)
+			result.Pruned = append(result.Pruned, keyName)
+		}
+		changed = true
+	}
+	sort.Strings(result.Pruned)
+
+	if !changed {
+		return result, nil
+	}
+	if err := c.Update(ctx, secret); err != nil {
+		return nil, err
+	}
+	if result.Rotated != nil {
+		logger.V(0).Info("Rotated certificate authority ahead of its expiration", "name", name, "namespace", namespace,
+			"serialNumber", newest.SerialNumber(), "newSerialNumber", result.Rotated.SerialNumber(),
+			"notAfter", result.Rotated.Certificate.NotAfter)
+	}
+	if len(result.Pruned) > 0 {
+		logger.V(0).Info("Pruned expired certificate authorities", "name", name, "namespace", namespace, "entries", result.Pruned)
+	}
+	return result, nil
+}
@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/zncdata-labs/secret-operator/pkg/util"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+	"k8s.io/utils/clock"
+)
+
+// CacheKey identifies the secret data of a volume, the volumes with the same key get the same data from the backend.
+type CacheKey struct {
+	Namespace string
+	Pod       string
+	// PodUID tells apart the pods recreated with the same name, e.g. the pods of a StatefulSet,
+	// which must not get the data cached for their predecessor.
+	PodUID string
+	Class  string
+	Scope  string
+	// KerberosRealms and KerberosServiceNames select the principals of the kerberos backend,
+	// the volumes of a pod with other service names must not get the same keytab.
+	KerberosRealms       string
+	KerberosServiceNames string
+}
+
+// NewCacheKey returns the cache key of the volume, made of all the fields of the volume read by the cacheable backends.
+func NewCacheKey(volumeSelector *volume.SecretVolumeSelector) CacheKey {
+	attributes := volumeSelector.ToMap()
+	return CacheKey{
+		Namespace:            volumeSelector.PodNamespace,
+		Pod:                  volumeSelector.Pod,
+		PodUID:               volumeSelector.PodUID,
+		Class:                volumeSelector.Class,
+		Scope:                attributes[volume.SecretsZncdataScope],
+		KerberosRealms:       attributes[volume.SecretsZncdataKerberosRealms],
+		KerberosServiceNames: attributes[volume.SecretsZncdataKerberosServiceNames],
+	}
+}
+
+type cacheEntry struct {
+	content  *util.SecretContent
+	cachedAt time.Time
+}
+
+// Cache keeps the secret data fetched from the backend for a short time, so the volumes mounted again and again,
+// e.g. during rolling restarts, do not hit the apiserver or the external secret store every time.
+// It is safe for concurrent use.
+type Cache struct {
+	ttl   time.Duration
+	clock clock.PassiveClock
+
+	lock    sync.Mutex
+	entries map[CacheKey]cacheEntry
+}
+
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		clock:   clock.RealClock{},
+		entries: map[CacheKey]cacheEntry{},
+	}
+}
+
+// WithClock replaces the real clock, used to expire the cached entries.
+func (c *Cache) WithClock(clock clock.PassiveClock) *Cache {
+	c.clock = clock
+	return c
+}
+
+// Get returns a copy of the cached secret content, if it is cached within the ttl.
+func (c *Cache) Get(key CacheKey) (*util.SecretContent, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if c.clock.Since(entry.cachedAt) >= c.ttl {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return copySecretContent(entry.content), true
+}
+
+// Set caches a copy of the secret content, the expired entries are dropped at the same time.
+func (c *Cache) Set(key CacheKey, content *util.SecretContent) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.clock.Now()
+	for k, entry := range c.entries {
+		if now.Sub(entry.cachedAt) >= c.ttl {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cacheEntry{content: copySecretContent(content), cachedAt: now}
+}
+
+// Invalidate drops the cached secret content of the key.
+func (c *Cache) Invalidate(key CacheKey) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.entries, key)
+}
+
+// InvalidateClass drops the cached secret content of all the volumes of the secret class,
+// e.g. when the secret class is changed.
+func (c *Cache) InvalidateClass(class string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key := range c.entries {
+		if key.Class == class {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func copySecretContent(content *util.SecretContent) *util.SecretContent {
+	copied := &util.SecretContent{Data: make(map[string][]byte, len(content.Data))}
+	for key, value := range content.Data {
+		copied.Data[key] = slices.Clone(value)
+	}
+	if content.ExpiresTime != nil {
+		expiresTime := *content.ExpiresTime
+		copied.ExpiresTime = &expiresTime
+	}
+	return copied
+}
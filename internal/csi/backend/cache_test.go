@@ -0,0 +1,205 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/util"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// newTestCountingClient returns a client counting the list calls, the k8sSearch backend lists secrets.
+func newTestCountingClient(t *testing.T, lists *int, objs ...client.Object) client.Client {
+	return fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(objs...).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				*lists++
+				return c.List(ctx, list, opts...)
+			},
+		}).
+		Build()
+}
+
+func newTestK8sSearchSecretClass() *secretsv1alpha1.SecretClass {
+	return &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "tls"},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{
+				K8sSearch: &secretsv1alpha1.K8sSearchSpec{
+					SearchNamespace: &secretsv1alpha1.SearchNamespaceSpec{Pod: &secretsv1alpha1.PodSpec{}},
+				},
+			},
+		},
+	}
+}
+
+func TestBackendCacheHit(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "default",
+			Labels:    map[string]string{volume.SecretsZncdataClass: "tls"},
+		},
+		Data: map[string][]byte{"username": []byte("admin")},
+	}
+	lists := 0
+	c := newTestCountingClient(t, &lists, secret)
+	pod := newTestPod()
+	volumeSelector := &volume.SecretVolumeSelector{Class: "tls", Pod: pod.Name, PodNamespace: pod.Namespace}
+	cache := NewCache(time.Minute)
+
+	for i := 0; i < 3; i++ {
+		backend := NewBackend(c, pod_info.NewPodInfo(c, pod, volumeSelector), volumeSelector, newTestK8sSearchSecretClass()).WithCache(cache)
+		content, err := backend.GetSecretData(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(content.Data["username"]) != "admin" {
+			t.Errorf("unexpected data: %v", content.Data)
+		}
+		// the cached data must not be changed by the caller
+		content.Data["username"][0] = 'x'
+	}
+	if lists != 1 {
+		t.Errorf("expected the secret to be listed once, got %d", lists)
+	}
+
+	cache.Invalidate(NewCacheKey(volumeSelector))
+	backend := NewBackend(c, pod_info.NewPodInfo(c, pod, volumeSelector), volumeSelector, newTestK8sSearchSecretClass()).WithCache(cache)
+	if _, err := backend.GetSecretData(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lists != 2 {
+		t.Errorf("expected the secret to be listed again after invalidate, got %d", lists)
+	}
+}
+
+func TestBackendNoCache(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "default",
+			Labels:    map[string]string{volume.SecretsZncdataClass: "tls"},
+		},
+		Data: map[string][]byte{"username": []byte("admin")},
+	}
+	lists := 0
+	c := newTestCountingClient(t, &lists, secret)
+	pod := newTestPod()
+	volumeSelector := &volume.SecretVolumeSelector{Class: "tls", Pod: pod.Name, PodNamespace: pod.Namespace, NoCache: true}
+	cache := NewCache(time.Minute)
+	cache.Set(NewCacheKey(volumeSelector), &util.SecretContent{Data: map[string][]byte{"username": []byte("stale")}})
+
+	backend := NewBackend(c, pod_info.NewPodInfo(c, pod, volumeSelector), volumeSelector, newTestK8sSearchSecretClass()).WithCache(cache)
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(content.Data["username"]) != "admin" || lists != 1 {
+		t.Errorf("expected the secret fetched from the backend, got %q after %d lists", content.Data["username"], lists)
+	}
+
+	// the fresh data is cached for the other volumes
+	cached, ok := cache.Get(NewCacheKey(volumeSelector))
+	if !ok || string(cached.Data["username"]) != "admin" {
+		t.Errorf("expected the fresh data cached, got %v", cached)
+	}
+}
+
+func TestBackendCacheRecreatedPod(t *testing.T) {
+	cache := NewCache(time.Minute)
+	volumeSelector := &volume.SecretVolumeSelector{Class: "tls", Pod: "web-0", PodNamespace: "default", PodUID: "uid-1"}
+	cache.Set(NewCacheKey(volumeSelector), &util.SecretContent{Data: map[string][]byte{"username": []byte("admin")}})
+
+	// the pod of the StatefulSet is recreated with the same name
+	recreated := *volumeSelector
+	recreated.PodUID = "uid-2"
+	if _, ok := cache.Get(NewCacheKey(&recreated)); ok {
+		t.Error("expected the recreated pod not to get the data cached for its predecessor")
+	}
+	if _, ok := cache.Get(NewCacheKey(volumeSelector)); !ok {
+		t.Error("expected the data of the first pod to stay cached")
+	}
+}
+
+func TestBackendCacheExpired(t *testing.T) {
+	fakeClock := clocktesting.NewFakePassiveClock(time.Now())
+	cache := NewCache(time.Minute).WithClock(fakeClock)
+	key := CacheKey{Namespace: "default", Pod: "test-pod", Class: "tls"}
+	cache.Set(key, &util.SecretContent{Data: map[string][]byte{"username": []byte("admin")}})
+
+	fakeClock.SetTime(fakeClock.Now().Add(time.Minute))
+	if _, ok := cache.Get(key); ok {
+		t.Errorf("expected cache entry to expire")
+	}
+}
+
+func TestBackendCacheInvalidateClass(t *testing.T) {
+	cache := NewCache(time.Minute)
+	content := &util.SecretContent{Data: map[string][]byte{"username": []byte("admin")}}
+	tlsKeys := []CacheKey{
+		{Namespace: "default", Pod: "a", Class: "tls"},
+		{Namespace: "default", Pod: "b", Class: "tls"},
+	}
+	other := CacheKey{Namespace: "default", Pod: "a", Class: "shared"}
+	for _, key := range append(tlsKeys, other) {
+		cache.Set(key, content)
+	}
+
+	cache.InvalidateClass("tls")
+	for _, key := range tlsKeys {
+		if _, ok := cache.Get(key); ok {
+			t.Errorf("expected %v to be invalidated", key)
+		}
+	}
+	if _, ok := cache.Get(other); !ok {
+		t.Errorf("expected the other class to stay cached")
+	}
+}
+
+func TestBackendCacheBypassAutoTls(t *testing.T) {
+	_, caSecret := newTestCASecret(t, time.Now().Add(365*24*time.Hour))
+	pod := newTestPod()
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(caSecret, pod).Build()
+	volumeSelector := &volume.SecretVolumeSelector{
+		Class:        "tls",
+		Pod:          pod.Name,
+		PodNamespace: pod.Namespace,
+		Scope:        volume.SecretScope{Pod: volume.ScopePod},
+	}
+	secretClass := &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "tls"},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{AutoTls: newTestAutoTlsSpec()},
+		},
+	}
+	cache := NewCache(time.Minute)
+
+	var certs []string
+	for i := 0; i < 2; i++ {
+		backend := NewBackend(c, pod_info.NewPodInfo(c, pod, volumeSelector), volumeSelector, secretClass).WithCache(cache)
+		content, err := backend.GetSecretData(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		certs = append(certs, string(content.Data[PEMTlsCertFileName]))
+	}
+	if certs[0] == certs[1] {
+		t.Errorf("expected a new certificate for each call, autoTls must not be cached")
+	}
+	if _, ok := cache.Get(NewCacheKey(volumeSelector)); ok {
+		t.Errorf("autoTls secret data should not be cached")
+	}
+}
@@ -0,0 +1,331 @@
+package backend
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"time"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/util"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CertificateRequestGVK is the cert-manager CertificateRequest, it is handled as unstructured object,
+// so the csi driver does not depend on the cert-manager api module.
+var CertificateRequestGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "CertificateRequest"}
+
+const (
+	defaultCertManagerTimeout     = 30 * time.Second
+	defaultCertManagerIssuerKind  = "Issuer"
+	defaultCertManagerIssuerGroup = "cert-manager.io"
+
+	certManagerPollInterval = time.Second
+	certManagerKeySize      = 2048
+
+	// Conditions and reasons of the CertificateRequest set by cert-manager.
+	certificateRequestConditionReady          = "Ready"
+	certificateRequestConditionDenied         = "Denied"
+	certificateRequestConditionInvalidRequest = "InvalidRequest"
+	certificateRequestReasonFailed            = "Failed"
+)
+
+type CertManagerBackend struct {
+	client         client.Client
+	podInfo        *pod_info.PodInfo
+	volumeSelector *volume.SecretVolumeSelector
+	certManager    *secretsv1alpha1.CertManagerSpec
+	timeout        time.Duration
+
+	random io.Reader
+}
+
+func NewCertManagerBackend(
+	client client.Client,
+	podInfo *pod_info.PodInfo,
+	volumeSelector *volume.SecretVolumeSelector,
+	certManagerSpec *secretsv1alpha1.CertManagerSpec,
+	random io.Reader,
+) (*CertManagerBackend, error) {
+	if certManagerSpec == nil {
+		return nil, fmt.Errorf("%w: certManager spec is nil in secret class", ErrSecretClassInvalid)
+	}
+
+	if certManagerSpec.IssuerRef.Name == "" {
+		return nil, fmt.Errorf("%w: certManager issuerRef name is empty in secret class", ErrSecretClassInvalid)
+	}
+
+	timeout := defaultCertManagerTimeout
+	if certManagerSpec.Timeout != "" {
+		d, err := time.ParseDuration(certManagerSpec.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid certManager timeout %q: %w", ErrSecretClassInvalid, certManagerSpec.Timeout, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("%w: invalid certManager timeout %q: must be greater than zero", ErrSecretClassInvalid, certManagerSpec.Timeout)
+		}
+		timeout = d
+	}
+
+	return &CertManagerBackend{
+		client:         client,
+		podInfo:        podInfo,
+		volumeSelector: volumeSelector,
+		certManager:    certManagerSpec,
+		timeout:        timeout,
+		random:         random,
+	}, nil
+}
+
+func (c *CertManagerBackend) issuerRef() map[string]interface{} {
+	kind := c.certManager.IssuerRef.Kind
+	if kind == "" {
+		kind = defaultCertManagerIssuerKind
+	}
+	group := c.certManager.IssuerRef.Group
+	if group == "" {
+		group = defaultCertManagerIssuerGroup
+	}
+	return map[string]interface{}{
+		"name":  c.certManager.IssuerRef.Name,
+		"kind":  kind,
+		"group": group,
+	}
+}
+
+// certLife returns the lifetime requested to the issuer, the issuer may sign a shorter certificate.
+// The ttl of the volume shortens it.
+func (c *CertManagerBackend) certLife() (time.Duration, error) {
+	certLife := defaultCertLifetime
+	if c.volumeSelector.AutoTlsCertLifetime != 0 {
+		certLife = c.volumeSelector.AutoTlsCertLifetime
+	}
+	if certLife < 0 {
+		return 0, fmt.Errorf("%w: %s %s must not be negative", ErrInvalidVolumeContext, volume.CertLifeTime, certLife)
+	}
+	if ttl := c.volumeSelector.TTL; ttl != 0 {
+		certLife = min(certLife, ttl)
+	}
+	return certLife, nil
+}
+
+// certificateSigningRequest returns the PEM encoded CSR of the pod, the common name is the pod name,
+// and the SANs are the addresses in the scope of the volume.
+func (c *CertManagerBackend) certificateSigningRequest(ctx context.Context, key *rsa.PrivateKey) ([]byte, error) {
+	addresses, err := c.podInfo.GetScopedAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: c.podInfo.GetPodName()},
+	}
+	for _, address := range addresses {
+		if address.IP != nil {
+			template.IPAddresses = append(template.IPAddresses, address.IP)
+		} else {
+			template.DNSNames = append(template.DNSNames, address.Hostname)
+		}
+	}
+
+	der, err := x509.CreateCertificateRequest(c.random, template, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// newCertificateRequest returns the CertificateRequest of the CSR, owned by the pod,
+// so it is garbage collected with the pod if the csi driver fails to delete it.
+func (c *CertManagerBackend) newCertificateRequest(csr []byte, certLife time.Duration) *unstructured.Unstructured {
+	pod := c.podInfo.Pod
+
+	cr := &unstructured.Unstructured{}
+	cr.SetGroupVersionKind(CertificateRequestGVK)
+	cr.SetGenerateName(pod.GetName() + "-")
+	cr.SetNamespace(pod.GetNamespace())
+	cr.SetLabels(map[string]string{volume.SecretsZncdataClass: c.volumeSelector.Class})
+	cr.SetOwnerReferences([]metav1.OwnerReference{
+		{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       pod.GetName(),
+			UID:        pod.GetUID(),
+		},
+	})
+	cr.Object["spec"] = map[string]interface{}{
+		"request":   base64.StdEncoding.EncodeToString(csr),
+		"issuerRef": c.issuerRef(),
+		"duration":  certLife.String(),
+		"usages": []interface{}{
+			"digital signature",
+			"key encipherment",
+			"server auth",
+			"client auth",
+		},
+	}
+	return cr
+}
+
+// certificateRequestCondition returns the status, the reason and the message of the condition,
+// the status is empty when the condition is not set.
+func certificateRequestCondition(cr *unstructured.Unstructured, conditionType string) (string, string, string) {
+	conditions, _, _ := unstructured.NestedSlice(cr.Object, "status", "conditions")
+	for _, condition := range conditions {
+		c, ok := condition.(map[string]interface{})
+		if !ok || c["type"] != conditionType {
+			continue
+		}
+		status, _ := c["status"].(string)
+		reason, _ := c["reason"].(string)
+		message, _ := c["message"].(string)
+		return status, reason, message
+	}
+	return "", "", ""
+}
+
+// certificateRequestSigned checks whether the CertificateRequest is signed,
+// a denied or failed request is an error, cert-manager does not retry it.
+func certificateRequestSigned(cr *unstructured.Unstructured) (bool, error) {
+	if status, reason, message := certificateRequestCondition(cr, certificateRequestConditionDenied); status == string(metav1.ConditionTrue) {
+		return false, fmt.Errorf("certificate request %s/%s is denied: %s: %s", cr.GetNamespace(), cr.GetName(), reason, message)
+	}
+	if status, reason, message := certificateRequestCondition(cr, certificateRequestConditionInvalidRequest); status == string(metav1.ConditionTrue) {
+		return false, fmt.Errorf("certificate request %s/%s is invalid: %s: %s", cr.GetNamespace(), cr.GetName(), reason, message)
+	}
+
+	status, reason, message := certificateRequestCondition(cr, certificateRequestConditionReady)
+	if status == string(metav1.ConditionFalse) && reason == certificateRequestReasonFailed {
+		return false, fmt.Errorf("certificate request %s/%s failed: %s", cr.GetNamespace(), cr.GetName(), message)
+	}
+	if status != string(metav1.ConditionTrue) {
+		return false, nil
+	}
+	certificate, _, _ := unstructured.NestedString(cr.Object, "status", "certificate")
+	return certificate != "", nil
+}
+
+// waitCertificateRequest polls the CertificateRequest until it is signed, denied or failed, or the timeout expires.
+func (c *CertManagerBackend) waitCertificateRequest(ctx context.Context, cr *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	key := client.ObjectKeyFromObject(cr)
+	signed := &unstructured.Unstructured{}
+	signed.SetGroupVersionKind(CertificateRequestGVK)
+
+	err := wait.PollUntilContextTimeout(ctx, certManagerPollInterval, c.timeout, true, func(ctx context.Context) (bool, error) {
+		if err := c.client.Get(ctx, key, signed); err != nil {
+			util.LoggerFromContext(ctx, logger).V(1).Info("Failed to get certificate request, retry", "certificateRequest", key, "error", err.Error())
+			return false, nil
+		}
+		return certificateRequestSigned(signed)
+	})
+	if err != nil {
+		if wait.Interrupted(err) {
+			status, reason, message := certificateRequestCondition(signed, certificateRequestConditionReady)
+			return nil, fmt.Errorf("%w: certificate request %s is not signed within %s, ready condition %q: %s: %s",
+				ErrBackendTimeout, key, c.timeout, status, reason, message)
+		}
+		return nil, err
+	}
+	return signed, nil
+}
+
+// decodeCertificateRequestStatus returns the PEM certificate chain and CA of the signed CertificateRequest.
+func decodeCertificateRequestStatus(cr *unstructured.Unstructured, field string) ([]byte, error) {
+	value, _, err := unstructured.NestedString(cr.Object, "status", field)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s in certificate request %s/%s: %w", field, cr.GetNamespace(), cr.GetName(), err)
+	}
+	return decoded, nil
+}
+
+func (c *CertManagerBackend) GetSecretData(ctx context.Context) (*util.SecretContent, error) {
+	if c.volumeSelector.AutoTls == volume.AutoTlsModeCAOnly {
+		return nil, fmt.Errorf("%w: %s %s is not supported by certManager backend",
+			ErrInvalidVolumeContext, volume.AutoTls, volume.AutoTlsModeCAOnly)
+	}
+
+	certLife, err := c.certLife()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(c.random, certManagerKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	csr, err := c.certificateSigningRequest(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := c.newCertificateRequest(csr, certLife)
+	if err := c.client.Create(ctx, cr); err != nil {
+		return nil, fmt.Errorf("%w: create certificate request in namespace %s: %w", ErrBackendUnavailable, cr.GetNamespace(), err)
+	}
+	util.LoggerFromContext(ctx, logger).V(1).Info("Created certificate request",
+		"certificateRequest", client.ObjectKeyFromObject(cr), "issuer", c.issuerRef())
+
+	// The private key is not in the request, so it is useless once the certificate is read.
+	defer func() {
+		if err := c.client.Delete(context.WithoutCancel(ctx), cr); client.IgnoreNotFound(err) != nil {
+			util.LoggerFromContext(ctx, logger).Error(err, "Failed to delete certificate request, it is deleted with the pod",
+				"certificateRequest", client.ObjectKeyFromObject(cr))
+		}
+	}()
+
+	signed, err := c.waitCertificateRequest(ctx, cr)
+	if err != nil {
+		return nil, err
+	}
+
+	certificatePEM, err := decodeCertificateRequestStatus(signed, "certificate")
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := decodeCertificateRequestStatus(signed, "ca")
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(certificatePEM)
+	if block == nil {
+		return nil, fmt.Errorf("no certificate in certificate request %s/%s", signed.GetNamespace(), signed.GetName())
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate in certificate request %s/%s: %w", signed.GetNamespace(), signed.GetName(), err)
+	}
+
+	expiresTime := certificate.NotAfter.Unix()
+	return &util.SecretContent{
+		Data: map[string][]byte{
+			PEMTlsCertFileName: certificatePEM,
+			PEMTlsKeyFileName:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+			PEMCaCertFileName:  caPEM,
+		},
+		ExpiresTime: &expiresTime,
+	}, nil
+}
+
+// Validate implements Backend.
+// The secret class is checked when the backend is created. The issuer is not checked,
+// an Issuer lives in the namespace of each pod, and cert-manager reports its readiness.
+func (c *CertManagerBackend) Validate(ctx context.Context) error {
+	return nil
+}
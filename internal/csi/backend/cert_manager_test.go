@@ -0,0 +1,254 @@
+package backend
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/internal/csi/backend/ca"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// newTestCertManagerClient returns a client simulating cert-manager, the CertificateRequest read back
+// is updated by the issuer function, the stored object is not changed.
+func newTestCertManagerClient(t *testing.T, issuer func(cr *unstructured.Unstructured)) client.Client {
+	return fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(newTestPod()).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if err := c.Get(ctx, key, obj, opts...); err != nil {
+					return err
+				}
+				if cr, ok := obj.(*unstructured.Unstructured); ok && cr.GroupVersionKind() == CertificateRequestGVK {
+					issuer(cr)
+				}
+				return nil
+			},
+		}).
+		Build()
+}
+
+// signCertificateRequest signs the CSR of the CertificateRequest with the CA, like a cert-manager CA issuer.
+func signCertificateRequest(t *testing.T, certificateAuthority *ca.CertificateAuthority, cr *unstructured.Unstructured) {
+	request, _, _ := unstructured.NestedString(cr.Object, "spec", "request")
+	csrPEM, err := base64.StdEncoding.DecodeString(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		t.Fatalf("failed to decode certificate request PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	duration, _, _ := unstructured.NestedString(cr.Object, "spec", "duration")
+	certLife, err := time.ParseDuration(duration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		IPAddresses:  csr.IPAddresses,
+		NotBefore:    now,
+		NotAfter:     now.Add(certLife),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, certificateAuthority.Certificate, csr.PublicKey, certificateAuthority.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	setCertificateRequestStatus(cr, "Ready", "True", "Issued", "Certificate fetched from issuer successfully")
+	_ = unstructured.SetNestedField(cr.Object, base64.StdEncoding.EncodeToString(certificate), "status", "certificate")
+	_ = unstructured.SetNestedField(cr.Object, base64.StdEncoding.EncodeToString(certificateAuthority.CertificatePEM()), "status", "ca")
+}
+
+func setCertificateRequestStatus(cr *unstructured.Unstructured, conditionType, status, reason, message string) {
+	conditions, _, _ := unstructured.NestedSlice(cr.Object, "status", "conditions")
+	conditions = append(conditions, map[string]interface{}{
+		"type":    conditionType,
+		"status":  status,
+		"reason":  reason,
+		"message": message,
+	})
+	_ = unstructured.SetNestedSlice(cr.Object, conditions, "status", "conditions")
+}
+
+func newTestCertManagerBackend(t *testing.T, c client.Client, spec *secretsv1alpha1.CertManagerSpec) *CertManagerBackend {
+	volumeSelector := &volume.SecretVolumeSelector{
+		Class: "cert-manager",
+		Scope: volume.SecretScope{Pod: volume.ScopePod},
+	}
+	podInfo := pod_info.NewPodInfo(c, newTestPod(), volumeSelector)
+	backend, err := NewCertManagerBackend(c, podInfo, volumeSelector, spec, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestCertManagerBackendGetSecretData(t *testing.T) {
+	certificateAuthority, _ := newTestCASecret(t, time.Now().Add(365*24*time.Hour))
+	var issued *unstructured.Unstructured
+	c := newTestCertManagerClient(t, func(cr *unstructured.Unstructured) {
+		issued = cr.DeepCopy()
+		signCertificateRequest(t, certificateAuthority, cr)
+	})
+	backend := newTestCertManagerBackend(t, c, &secretsv1alpha1.CertManagerSpec{
+		IssuerRef: secretsv1alpha1.IssuerRefSpec{Name: "ca-issuer"},
+	})
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := tls.X509KeyPair(content.Data[PEMTlsCertFileName], content.Data[PEMTlsKeyFileName]); err != nil {
+		t.Errorf("certificate does not match the private key: %v", err)
+	}
+	cert := parseCertificatePEM(t, content.Data[PEMTlsCertFileName])
+	if cert.Subject.CommonName != "test-pod" {
+		t.Errorf("unexpected common name: %s", cert.Subject.CommonName)
+	}
+	if len(cert.IPAddresses) == 0 || cert.IPAddresses[0].String() != "10.0.0.10" {
+		t.Errorf("expected the pod IP in the certificate, got %v", cert.IPAddresses)
+	}
+	if err := cert.CheckSignatureFrom(certificateAuthority.Certificate); err != nil {
+		t.Errorf("certificate is not signed by the issuer CA: %v", err)
+	}
+	if string(content.Data[PEMCaCertFileName]) != string(certificateAuthority.CertificatePEM()) {
+		t.Errorf("unexpected ca.crt: %s", content.Data[PEMCaCertFileName])
+	}
+	if content.ExpiresTime == nil || *content.ExpiresTime != cert.NotAfter.Unix() {
+		t.Errorf("expected expires time %d, got %v", cert.NotAfter.Unix(), content.ExpiresTime)
+	}
+
+	if issued == nil {
+		t.Fatalf("certificate request was not read")
+	}
+	if issued.GetNamespace() != "default" || !strings.HasPrefix(issued.GetName(), "test-pod-") {
+		t.Errorf("unexpected certificate request %s/%s", issued.GetNamespace(), issued.GetName())
+	}
+	if owners := issued.GetOwnerReferences(); len(owners) != 1 || owners[0].Kind != "Pod" || owners[0].Name != "test-pod" {
+		t.Errorf("expected the certificate request owned by the pod, got %v", owners)
+	}
+	issuerRef, _, _ := unstructured.NestedStringMap(issued.Object, "spec", "issuerRef")
+	expectedIssuerRef := map[string]string{"name": "ca-issuer", "kind": "Issuer", "group": "cert-manager.io"}
+	for key, value := range expectedIssuerRef {
+		if issuerRef[key] != value {
+			t.Errorf("unexpected issuerRef %s: got %q, want %q", key, issuerRef[key], value)
+		}
+	}
+
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(CertificateRequestGVK.GroupVersion().WithKind(CertificateRequestGVK.Kind + "List"))
+	if err := c.List(context.Background(), list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 0 {
+		t.Errorf("expected the certificate request deleted, got %d", len(list.Items))
+	}
+}
+
+func TestCertManagerBackendNotSigned(t *testing.T) {
+	tests := []struct {
+		name    string
+		issuer  func(cr *unstructured.Unstructured)
+		timeout bool
+		message string
+	}{
+		{
+			name: "denied",
+			issuer: func(cr *unstructured.Unstructured) {
+				setCertificateRequestStatus(cr, "Denied", "True", "PolicyDenied", "common name is not allowed")
+				setCertificateRequestStatus(cr, "Ready", "False", "Denied", "The CertificateRequest was denied")
+			},
+			message: "common name is not allowed",
+		},
+		{
+			name: "failed",
+			issuer: func(cr *unstructured.Unstructured) {
+				setCertificateRequestStatus(cr, "Ready", "False", "Failed", "issuer is not ready")
+			},
+			message: "issuer is not ready",
+		},
+		{
+			name: "pending",
+			issuer: func(cr *unstructured.Unstructured) {
+				setCertificateRequestStatus(cr, "Ready", "False", "Pending", "Waiting on certificate issuance")
+			},
+			timeout: true,
+			message: "Waiting on certificate issuance",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newTestCertManagerBackend(t, newTestCertManagerClient(t, tt.issuer), &secretsv1alpha1.CertManagerSpec{
+				IssuerRef: secretsv1alpha1.IssuerRefSpec{Name: "ca-issuer", Kind: "ClusterIssuer"},
+				Timeout:   "10ms",
+			})
+
+			_, err := backend.GetSecretData(context.Background())
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if errors.Is(err, ErrBackendTimeout) != tt.timeout {
+				t.Errorf("unexpected timeout error: %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("expected %q in error, got: %v", tt.message, err)
+			}
+		})
+	}
+}
+
+func TestNewCertManagerBackendInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		spec *secretsv1alpha1.CertManagerSpec
+	}{
+		{name: "nil spec"},
+		{name: "empty issuer name", spec: &secretsv1alpha1.CertManagerSpec{}},
+		{
+			name: "invalid timeout",
+			spec: &secretsv1alpha1.CertManagerSpec{IssuerRef: secretsv1alpha1.IssuerRefSpec{Name: "ca-issuer"}, Timeout: "soon"},
+		},
+		{
+			name: "negative timeout",
+			spec: &secretsv1alpha1.CertManagerSpec{IssuerRef: secretsv1alpha1.IssuerRefSpec{Name: "ca-issuer"}, Timeout: "-1s"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCertManagerBackend(nil, nil, &volume.SecretVolumeSelector{}, tt.spec, rand.Reader)
+			if !errors.Is(err, ErrSecretClassInvalid) {
+				t.Errorf("expected ErrSecretClassInvalid, got: %v", err)
+			}
+		})
+	}
+}
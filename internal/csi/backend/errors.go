@@ -0,0 +1,29 @@
+package backend
+
+import "errors"
+
+// Errors returned by the backends, callers check them with errors.Is to decide how to report the failure,
+// e.g. the csi node maps them to grpc codes. The errors are wrapped with the context of the failure.
+var (
+	// ErrSecretClassNotFound means the secret class referenced by the volume does not exist.
+	ErrSecretClassNotFound = errors.New("secret class not found")
+
+	// ErrSecretClassInvalid means the secret class is not configured correctly, it must be fixed before retrying.
+	ErrSecretClassInvalid = errors.New("invalid secret class")
+
+	// ErrInvalidVolumeContext means the volume requests something the backend can not provide.
+	ErrInvalidVolumeContext = errors.New("invalid volume context")
+
+	// ErrSecretNotFound means the backend has no secret for the volume.
+	ErrSecretNotFound = errors.New("secret not found")
+
+	// ErrPermissionDenied means the backend denied the access to the secret, e.g. by the vault policy of the role,
+	// retrying does not help until the access is granted.
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrBackendUnavailable means the backend can not be reached or refused the request, retrying may succeed.
+	ErrBackendUnavailable = errors.New("backend unavailable")
+
+	// ErrBackendTimeout means the backend did not provide the secret in time, e.g. the certificate was not signed.
+	ErrBackendTimeout = errors.New("backend timed out")
+)
@@ -0,0 +1,117 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/util"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// FileBackend reads the secret data from the files of a directory of the node, <baseDir>/<secret class name>.
+// Each regular file is a key of the secret data, the hidden files and the subdirectories are skipped.
+// The symlinks are followed, but the files must resolve within the base directory.
+type FileBackend struct {
+	baseDir        string
+	volumeSelector *volume.SecretVolumeSelector
+}
+
+func NewFileBackend(volumeSelector *volume.SecretVolumeSelector, fileSpec *secretsv1alpha1.FileSpec) (*FileBackend, error) {
+	if fileSpec.BaseDir == "" || !filepath.IsAbs(fileSpec.BaseDir) {
+		return nil, fmt.Errorf("%w: file baseDir %q must be an absolute path", ErrSecretClassInvalid, fileSpec.BaseDir)
+	}
+	return &FileBackend{
+		baseDir:        filepath.Clean(fileSpec.BaseDir),
+		volumeSelector: volumeSelector,
+	}, nil
+}
+
+// GetSecretData implements Backend.
+func (f *FileBackend) GetSecretData(ctx context.Context) (*util.SecretContent, error) {
+	baseDir, err := filepath.EvalSymlinks(f.baseDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: base directory %s does not exist on the node", ErrSecretNotFound, f.baseDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	class := f.volumeSelector.Class
+	if class == "" || class == "." || class == ".." || strings.ContainsRune(class, filepath.Separator) {
+		return nil, fmt.Errorf("%w: invalid secret class name %q for a directory", ErrInvalidVolumeContext, class)
+	}
+	dir, err := resolveWithin(baseDir, class)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: directory %s does not exist on the node", ErrSecretNotFound, filepath.Join(f.baseDir, class))
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string][]byte{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		path, err := resolveWithin(baseDir, filepath.Join(class, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			// a dangling symlink, or the file was removed meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		value, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		data[name] = value
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: no file in directory %s on the node", ErrSecretNotFound, dir)
+	}
+
+	util.LoggerFromContext(ctx, logger).V(1).Info("Read the secret files of the node", "dir", dir, "files", len(data))
+	return &util.SecretContent{Data: data}, nil
+}
+
+// resolveWithin returns the path of name in the base directory with the symlinks resolved,
+// it fails when the path resolves outside of the base directory, which must have no symlinks.
+func resolveWithin(baseDir, name string) (string, error) {
+	path, err := filepath.EvalSymlinks(filepath.Join(baseDir, name))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(baseDir, path)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("%s resolves to %s, outside of the base directory %s", name, path, baseDir)
+	}
+	return path, nil
+}
+
+// Validate implements Backend.
+// The base directory is on the nodes, so only its path is checked by NewFileBackend.
+func (f *FileBackend) Validate(ctx context.Context) error {
+	return nil
+}
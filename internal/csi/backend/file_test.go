@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// newTestFileBaseDir returns a base directory with the files of the secret class tls.
+func newTestFileBaseDir(t *testing.T) string {
+	baseDir := t.TempDir()
+	classDir := filepath.Join(baseDir, "tls")
+	if err := os.MkdirAll(filepath.Join(classDir, "subdir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{
+		"username":      "admin",
+		"password":      "secret",
+		".hidden":       "skipped",
+		"subdir/nested": "skipped",
+	} {
+		if err := os.WriteFile(filepath.Join(classDir, name), []byte(value), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return baseDir
+}
+
+func TestFileBackendGetSecretData(t *testing.T) {
+	baseDir := newTestFileBaseDir(t)
+	backend, err := NewFileBackend(&volume.SecretVolumeSelector{Class: "tls"}, &secretsv1alpha1.FileSpec{BaseDir: baseDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string][]byte{"username": []byte("admin"), "password": []byte("secret")}
+	if !reflect.DeepEqual(content.Data, want) {
+		t.Errorf("unexpected data: got %q, want %q", content.Data, want)
+	}
+	if content.ExpiresTime != nil {
+		t.Errorf("unexpected expires time: %d", *content.ExpiresTime)
+	}
+}
+
+func TestFileBackendNotFound(t *testing.T) {
+	baseDir := newTestFileBaseDir(t)
+	if err := os.Mkdir(filepath.Join(baseDir, "empty"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		baseDir string
+		class   string
+	}{
+		{name: "base dir missing", baseDir: filepath.Join(baseDir, "missing"), class: "tls"},
+		{name: "class dir missing", baseDir: baseDir, class: "shared"},
+		{name: "class dir empty", baseDir: baseDir, class: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := NewFileBackend(&volume.SecretVolumeSelector{Class: tt.class}, &secretsv1alpha1.FileSpec{BaseDir: tt.baseDir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := backend.GetSecretData(context.Background()); !errors.Is(err, ErrSecretNotFound) {
+				t.Errorf("unexpected error: got %v, want %v", err, ErrSecretNotFound)
+			}
+		})
+	}
+}
+
+func TestFileBackendTraversal(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "shadow"), []byte("root"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file of the class links outside of the base directory
+	baseDir := newTestFileBaseDir(t)
+	if err := os.Symlink(filepath.Join(outside, "shadow"), filepath.Join(baseDir, "tls", "shadow")); err != nil {
+		t.Fatal(err)
+	}
+	backend, err := NewFileBackend(&volume.SecretVolumeSelector{Class: "tls"}, &secretsv1alpha1.FileSpec{BaseDir: baseDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := backend.GetSecretData(context.Background()); err == nil {
+		t.Errorf("expected an error reading a file outside of the base directory, got %q", content.Data)
+	}
+
+	// the directory of the class links outside of the base directory
+	baseDir = t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(baseDir, "tls")); err != nil {
+		t.Fatal(err)
+	}
+	backend, err = NewFileBackend(&volume.SecretVolumeSelector{Class: "tls"}, &secretsv1alpha1.FileSpec{BaseDir: baseDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := backend.GetSecretData(context.Background()); err == nil {
+		t.Errorf("expected an error reading a directory outside of the base directory, got %q", content.Data)
+	}
+
+	// the class name is not a directory name
+	backend, err = NewFileBackend(&volume.SecretVolumeSelector{Class: ".."}, &secretsv1alpha1.FileSpec{BaseDir: baseDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.GetSecretData(context.Background()); !errors.Is(err, ErrInvalidVolumeContext) {
+		t.Errorf("unexpected error: got %v, want %v", err, ErrInvalidVolumeContext)
+	}
+}
+
+func TestFileBackendInvalidBaseDir(t *testing.T) {
+	for _, baseDir := range []string{"", "secrets"} {
+		if _, err := NewFileBackend(&volume.SecretVolumeSelector{Class: "tls"}, &secretsv1alpha1.FileSpec{BaseDir: baseDir}); !errors.Is(err, ErrSecretClassInvalid) {
+			t.Errorf("unexpected error of base dir %q: got %v, want %v", baseDir, err, ErrSecretClassInvalid)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package backend
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"strings"
 
@@ -19,6 +18,7 @@ type K8sSearchBackend struct {
 	podInfo         *pod_info.PodInfo
 	volumeSelector  *volume.SecretVolumeSelector
 	searchNamespace *secretsv1alpha1.SearchNamespaceSpec
+	podLabels       []string
 }
 
 func NewK8sSearchBackend(
@@ -29,11 +29,16 @@ func NewK8sSearchBackend(
 ) (*K8sSearchBackend, error) {
 
 	if k8sSearchSpec == nil {
-		return nil, errors.New("k8sSearchSpec is nil in secret class")
+		return nil, fmt.Errorf("%w: k8sSearchSpec is nil in secret class", ErrSecretClassInvalid)
 	}
 
 	if k8sSearchSpec.SearchNamespace == nil {
-		return nil, errors.New("searchNamespace is nil in secret class")
+		return nil, fmt.Errorf("%w: searchNamespace is nil in secret class", ErrSecretClassInvalid)
+	}
+
+	// reading the secrets of another namespace must be explicit, a class setting both is ambiguous
+	if k8sSearchSpec.SearchNamespace.Name != nil && k8sSearchSpec.SearchNamespace.Pod != nil {
+		return nil, fmt.Errorf("%w: searchNamespace name and pod can not be used together", ErrSecretClassInvalid)
 	}
 
 	return &K8sSearchBackend{
@@ -41,6 +46,7 @@ func NewK8sSearchBackend(
 		podInfo:         podInfo,
 		volumeSelector:  volumeSelector,
 		searchNamespace: k8sSearchSpec.SearchNamespace,
+		podLabels:       k8sSearchSpec.PodLabels,
 	}, nil
 }
 
@@ -50,7 +56,7 @@ func (k *K8sSearchBackend) GetPod() *corev1.Pod {
 
 func (k *K8sSearchBackend) namespace() (*string, error) {
 	if k.searchNamespace == nil {
-		return nil, errors.New("searchNamespace is nil")
+		return nil, fmt.Errorf("%w: searchNamespace is nil", ErrSecretClassInvalid)
 	}
 
 	if k.searchNamespace.Pod != nil {
@@ -62,14 +68,16 @@ func (k *K8sSearchBackend) namespace() (*string, error) {
 		return k.searchNamespace.Name, nil
 	}
 
-	return nil, errors.New("can not found namespace name in searchNamespace field")
+	return nil, fmt.Errorf("%w: can not found namespace name in searchNamespace field", ErrSecretClassInvalid)
 }
 
-// GetSecretData implements Backend.
+// getSecret returns the secret matching the labels, the first one when many secrets match.
+// If unique is true, it is an error that many secrets match.
 func (k *K8sSearchBackend) getSecret(
 	ctx context.Context,
 	namespace string,
 	matchingLabels map[string]string,
+	unique bool,
 ) (*corev1.Secret, error) {
 	objs := &corev1.SecretList{}
 
@@ -84,19 +92,34 @@ func (k *K8sSearchBackend) getSecret(
 	}
 
 	if len(objs.Items) == 0 {
-		return nil, fmt.Errorf("can not found secret in namespace %s with labels: %v", namespace, matchingLabels)
+		return nil, fmt.Errorf("%w: can not found secret in namespace %s with labels: %v", ErrSecretNotFound, namespace, matchingLabels)
+	}
+
+	if unique && len(objs.Items) > 1 {
+		names := make([]string, 0, len(objs.Items))
+		for _, obj := range objs.Items {
+			names = append(names, obj.Name)
+		}
+		return nil, fmt.Errorf("%w: found %d secrets in namespace %s with labels: %v, expected only one: %v",
+			ErrSecretClassInvalid, len(objs.Items), namespace, matchingLabels, names)
 	}
 
 	secret := &objs.Items[0]
 
-	logger.V(5).Info("found secret total, use first", "total", len(objs.Items), "secret", secret.Name, "namespace", secret.Namespace)
+	util.LoggerFromContext(ctx, logger).V(5).Info("found secret total, use first",
+		"total", len(objs.Items), "secret", secret.Name, "namespace", secret.Namespace)
 
 	return secret, nil
 }
 
 // matchingLabels returns the labels that should be used to search for the secret.
-// The labels are based on the secret class and the volume selector.
-func (k *K8sSearchBackend) matchingLabels() map[string]string {
+// The labels are based on the secret class, the volume selector and the pod labels configured in the secret class.
+func (k *K8sSearchBackend) matchingLabels() (map[string]string, error) {
+	podLabels, err := k.podInfo.GetPodLabelValues(k.podLabels)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSecretNotFound, err)
+	}
+
 	labels := map[string]string{
 		volume.SecretsZncdataClass: k.volumeSelector.Class,
 	}
@@ -118,7 +141,11 @@ func (k *K8sSearchBackend) matchingLabels() map[string]string {
 
 	// TODO: add listener label when listener volume is supported
 
-	return labels
+	for key, value := range podLabels {
+		labels[key] = value
+	}
+
+	return labels, nil
 }
 
 // GetSecretData implements Backend.
@@ -129,29 +156,36 @@ func (k *K8sSearchBackend) GetSecretData(ctx context.Context) (*util.SecretConte
 		return nil, err
 	}
 
-	matchingLabels := k.matchingLabels()
-
-	secret, err := k.getSecret(ctx, *namespace, matchingLabels)
+	matchingLabels, err := k.matchingLabels()
 	if err != nil {
 		return nil, err
 	}
 
-	decoded, err := DecodeSecretData(secret.Data)
+	secret, err := k.getSecret(ctx, *namespace, matchingLabels, len(k.podLabels) > 0)
 	if err != nil {
 		return nil, err
 	}
 
+	// the data of the secret is passed through as is, it may be binary, e.g. a keytab
 	return &util.SecretContent{
-		Data: decoded,
+		Data: secret.Data,
 	}, nil
 }
 
-// DecodeSecretData decodes the secret data.
-// secret data is base64 encoded.
-func DecodeSecretData(data map[string][]byte) (map[string]string, error) {
-	decoded := make(map[string]string)
-	for k, v := range data {
-		decoded[k] = string(v)
+// Validate implements Backend.
+// When the secret class searches a fixed namespace, a secret labeled with the secret class must exist in it.
+// When it searches the namespace of the pod, the secret can only be checked when a pod mounts the volume.
+func (k *K8sSearchBackend) Validate(ctx context.Context) error {
+	if k.searchNamespace.Pod != nil {
+		return nil
+	}
+
+	if k.searchNamespace.Name == nil {
+		return fmt.Errorf("%w: can not found namespace name in searchNamespace field", ErrSecretClassInvalid)
 	}
-	return decoded, nil
+
+	_, err := k.getSecret(ctx, *k.searchNamespace.Name, map[string]string{
+		volume.SecretsZncdataClass: k.volumeSelector.Class,
+	}, false)
+	return err
 }
@@ -0,0 +1,220 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func TestK8sSearchBackendValidate(t *testing.T) {
+	searchNamespace := "secrets"
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: searchNamespace,
+			Labels:    map[string]string{volume.SecretsZncdataClass: "tls"},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		class           string
+		searchNamespace *secretsv1alpha1.SearchNamespaceSpec
+		wantErr         bool
+	}{
+		{
+			name:            "secret found",
+			class:           "tls",
+			searchNamespace: &secretsv1alpha1.SearchNamespaceSpec{Name: &searchNamespace},
+		},
+		{
+			name:            "secret not found",
+			class:           "other",
+			searchNamespace: &secretsv1alpha1.SearchNamespaceSpec{Name: &searchNamespace},
+			wantErr:         true,
+		},
+		{
+			name:            "pod namespace",
+			class:           "other",
+			searchNamespace: &secretsv1alpha1.SearchNamespaceSpec{Pod: &secretsv1alpha1.PodSpec{}},
+		},
+		{
+			name:            "no namespace",
+			class:           "tls",
+			searchNamespace: &secretsv1alpha1.SearchNamespaceSpec{},
+			wantErr:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(secret).Build()
+			secretClass := &secretsv1alpha1.SecretClass{
+				ObjectMeta: metav1.ObjectMeta{Name: tt.class},
+				Spec: secretsv1alpha1.SecretClassSpec{
+					Backend: &secretsv1alpha1.BackendSpec{
+						K8sSearch: &secretsv1alpha1.K8sSearchSpec{SearchNamespace: tt.searchNamespace},
+					},
+				},
+			}
+
+			err := NewBackend(c, nil, &volume.SecretVolumeSelector{Class: tt.class}, secretClass).Validate(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestK8sSearchBackendSearchNamespace(t *testing.T) {
+	pki := "pki"
+	central := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "central",
+			Namespace: pki,
+			Labels:    map[string]string{volume.SecretsZncdataClass: "tls"},
+		},
+		Data: map[string][]byte{"name": []byte("central")},
+	}
+	local := newTestLabeledSecret("local", map[string]string{volume.SecretsZncdataClass: "tls"})
+
+	tests := []struct {
+		name            string
+		searchNamespace *secretsv1alpha1.SearchNamespaceSpec
+		expected        string
+		wantErr         bool
+	}{
+		{
+			name:            "fixed namespace",
+			searchNamespace: &secretsv1alpha1.SearchNamespaceSpec{Name: &pki},
+			expected:        "central",
+		},
+		{
+			name:            "pod namespace",
+			searchNamespace: &secretsv1alpha1.SearchNamespaceSpec{Pod: &secretsv1alpha1.PodSpec{}},
+			expected:        "local",
+		},
+		{
+			name:            "both",
+			searchNamespace: &secretsv1alpha1.SearchNamespaceSpec{Name: &pki, Pod: &secretsv1alpha1.PodSpec{}},
+			wantErr:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestPod()
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(pod, central, local).Build()
+			volumeSelector := &volume.SecretVolumeSelector{Class: "tls"}
+
+			backend, err := NewK8sSearchBackend(c, pod_info.NewPodInfo(c, pod, volumeSelector), volumeSelector, &secretsv1alpha1.K8sSearchSpec{
+				SearchNamespace: tt.searchNamespace,
+			})
+			if tt.wantErr {
+				if !errors.Is(err, ErrSecretClassInvalid) {
+					t.Errorf("expected ErrSecretClassInvalid, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := backend.GetSecretData(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(content.Data["name"]) != tt.expected {
+				t.Errorf("unexpected secret: got %s, want %s", content.Data["name"], tt.expected)
+			}
+		})
+	}
+}
+
+func newTestLabeledSecret(name string, labels map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    labels,
+		},
+		Data: map[string][]byte{"name": []byte(name)},
+	}
+}
+
+func TestK8sSearchBackendPodLabels(t *testing.T) {
+	web := newTestLabeledSecret("web", map[string]string{volume.SecretsZncdataClass: "tls", "app": "web"})
+	db := newTestLabeledSecret("db", map[string]string{volume.SecretsZncdataClass: "tls", "app": "db"})
+	webCopy := newTestLabeledSecret("web-copy", map[string]string{volume.SecretsZncdataClass: "tls", "app": "web"})
+
+	tests := []struct {
+		name      string
+		podLabels map[string]string
+		secrets   []*corev1.Secret
+		expected  string
+		wantErr   bool
+	}{
+		{
+			name:      "matched",
+			podLabels: map[string]string{"app": "web"},
+			secrets:   []*corev1.Secret{web, db},
+			expected:  "web",
+		},
+		{
+			name:      "pod label missing",
+			podLabels: map[string]string{"tier": "web"},
+			secrets:   []*corev1.Secret{web, db},
+			wantErr:   true,
+		},
+		{
+			name:      "no secret matched",
+			podLabels: map[string]string{"app": "cache"},
+			secrets:   []*corev1.Secret{web, db},
+			wantErr:   true,
+		},
+		{
+			name:      "many secrets matched",
+			podLabels: map[string]string{"app": "web"},
+			secrets:   []*corev1.Secret{web, webCopy, db},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestPod()
+			pod.Labels = tt.podLabels
+
+			builder := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(pod)
+			for _, secret := range tt.secrets {
+				builder = builder.WithObjects(secret.DeepCopy())
+			}
+			c := builder.Build()
+
+			volumeSelector := &volume.SecretVolumeSelector{Class: "tls"}
+			backend, err := NewK8sSearchBackend(c, pod_info.NewPodInfo(c, pod, volumeSelector), volumeSelector, &secretsv1alpha1.K8sSearchSpec{
+				SearchNamespace: &secretsv1alpha1.SearchNamespaceSpec{Pod: &secretsv1alpha1.PodSpec{}},
+				PodLabels:       []string{"app"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := backend.GetSecretData(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && string(content.Data["name"]) != tt.expected {
+				t.Errorf("unexpected secret: got %s, want %s", content.Data["name"], tt.expected)
+			}
+		})
+	}
+}
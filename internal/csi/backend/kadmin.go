@@ -0,0 +1,233 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/internal/keytab"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// kadminCommand is the kadmin client of MIT Kerberos, it must be installed in the csi driver image.
+	kadminCommand = "kadmin"
+
+	// kadminKeytabKey is the key of the admin keytab in the secret of the kerberos spec.
+	kadminKeytabKey = "keytab"
+
+	kadminAdminKeytabFileName  = "admin.keytab"
+	kadminExportKeytabFileName = "export.keytab"
+	kadminKrb5ConfFileName     = "krb5.conf"
+)
+
+// kadminRunner runs kadmin with the arguments and the environment, and returns its stdout and stderr.
+type kadminRunner func(ctx context.Context, env []string, args ...string) (stdout, stderr []byte, err error)
+
+func runKadmin(ctx context.Context, env []string, args ...string) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, kadminCommand, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// Kadmin is the KerberosAdmin of a MIT Kerberos KDC, it runs a kadmin query for each operation,
+// authenticated with the keytab of the admin principal read from the secret of the kerberos spec.
+// The krb5.conf of kadmin is generated from the spec, the csi driver needs none.
+type Kadmin struct {
+	client client.Client
+	spec   *secretsv1alpha1.KerberosSpec
+	run    kadminRunner
+}
+
+func NewKadmin(client client.Client, spec *secretsv1alpha1.KerberosSpec) (*Kadmin, error) {
+	if spec == nil {
+		return nil, fmt.Errorf("%w: kerberos spec is nil in secret class", ErrSecretClassInvalid)
+	}
+	if spec.Realm == "" {
+		return nil, fmt.Errorf("%w: kerberos realm is empty in secret class", ErrSecretClassInvalid)
+	}
+	if spec.KDC == "" {
+		return nil, fmt.Errorf("%w: kerberos kdc is empty in secret class", ErrSecretClassInvalid)
+	}
+	if spec.AdminPrincipal == "" {
+		return nil, fmt.Errorf("%w: kerberos adminPrincipal is empty in secret class", ErrSecretClassInvalid)
+	}
+	if spec.AdminKeytabSecret == nil || spec.AdminKeytabSecret.Name == "" || spec.AdminKeytabSecret.Namespace == "" {
+		return nil, fmt.Errorf("%w: kerberos adminKeytabSecret name and namespace are required in secret class", ErrSecretClassInvalid)
+	}
+	for name, value := range map[string]string{"realm": spec.Realm, "kdc": spec.KDC, "adminServer": spec.AdminServer, "adminPrincipal": spec.AdminPrincipal} {
+		if !validKadminArgument(value) {
+			return nil, fmt.Errorf("%w: invalid kerberos %s %q in secret class", ErrSecretClassInvalid, name, value)
+		}
+	}
+
+	return &Kadmin{
+		client: client,
+		spec:   spec,
+		run:    runKadmin,
+	}, nil
+}
+
+// validKadminArgument returns whether the value can be passed in a kadmin query or the krb5.conf as a single word.
+func validKadminArgument(value string) bool {
+	return !strings.ContainsAny(value, " \t\r\n\"'\\{}=")
+}
+
+// adminServer returns the kadmin server, default is the host of the KDC, the port of the KDC is not the kadmin port.
+func (k *Kadmin) adminServer() string {
+	if k.spec.AdminServer != "" {
+		return k.spec.AdminServer
+	}
+	if host, _, err := net.SplitHostPort(k.spec.KDC); err == nil {
+		return host
+	}
+	return k.spec.KDC
+}
+
+// adminKeytab reads the keytab of the admin principal from the secret.
+func (k *Kadmin) adminKeytab(ctx context.Context) ([]byte, error) {
+	ref := k.spec.AdminKeytabSecret
+	secret := &corev1.Secret{}
+	if err := k.client.Get(ctx, client.ObjectKey{Name: ref.Name, Namespace: ref.Namespace}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: kerberos admin keytab secret %s/%s not found", ErrSecretClassInvalid, ref.Namespace, ref.Name)
+		}
+		return nil, err
+	}
+	data := secret.Data[kadminKeytabKey]
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: kerberos admin keytab secret %s/%s has no %q key",
+			ErrSecretClassInvalid, ref.Namespace, ref.Name, kadminKeytabKey)
+	}
+	if _, err := keytab.Parse(data); err != nil {
+		return nil, fmt.Errorf("%w: invalid keytab in kerberos admin keytab secret %s/%s: %w",
+			ErrSecretClassInvalid, ref.Namespace, ref.Name, err)
+	}
+	return data, nil
+}
+
+// krb5Conf returns the krb5.conf of kadmin, locating the KDC and the kadmin server of the realm.
+func (k *Kadmin) krb5Conf() string {
+	return fmt.Sprintf(`[libdefaults]
+  default_realm = %[1]s
+  dns_lookup_kdc = false
+  dns_lookup_realm = false
+  rdns = false
+
+[realms]
+  %[1]s = {
+    kdc = %[2]s
+    admin_server = %[3]s
+  }
+`, k.spec.Realm, k.spec.KDC, k.adminServer())
+}
+
+// query runs the kadmin query in a temporary directory holding the admin keytab and the krb5.conf,
+// query is given the directory, e.g. to export a keytab into it, and result reads the output of kadmin.
+func (k *Kadmin) query(ctx context.Context, query func(dir string) string, result func(dir string, stdout, stderr string) error) error {
+	adminKeytab, err := k.adminKeytab(ctx)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "kadmin-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Error(err, "Failed to remove the kadmin directory", "dir", dir)
+		}
+	}()
+	adminKeytabPath := filepath.Join(dir, kadminAdminKeytabFileName)
+	if err := os.WriteFile(adminKeytabPath, adminKeytab, 0600); err != nil {
+		return err
+	}
+	krb5ConfPath := filepath.Join(dir, kadminKrb5ConfFileName)
+	if err := os.WriteFile(krb5ConfPath, []byte(k.krb5Conf()), 0600); err != nil {
+		return err
+	}
+
+	q := query(dir)
+	stdout, stderr, err := k.run(ctx, []string{"KRB5_CONFIG=" + krb5ConfPath},
+		"-k", "-t", adminKeytabPath, "-p", k.spec.AdminPrincipal, "-r", k.spec.Realm, "-s", k.adminServer(), "-q", q)
+	if err != nil {
+		return kadminError(q, err, string(stderr))
+	}
+	return result(dir, string(stdout), string(stderr))
+}
+
+// kadminError wraps the error of the kadmin query with the backend error of its output.
+func kadminError(query string, err error, stderr string) error {
+	stderr = strings.TrimSpace(stderr)
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return fmt.Errorf("%w: %s is not installed in the csi driver: %w", ErrSecretClassInvalid, kadminCommand, err)
+	case strings.Contains(stderr, "Operation requires"):
+		return fmt.Errorf("%w: kadmin query %q: %s", ErrPermissionDenied, query, stderr)
+	case err != nil:
+		return fmt.Errorf("%w: kadmin query %q: %w: %s", ErrBackendUnavailable, query, err, stderr)
+	default:
+		return fmt.Errorf("%w: kadmin query %q: %s", ErrBackendUnavailable, query, stderr)
+	}
+}
+
+// EnsurePrincipal implements KerberosAdmin.
+// The principal is created without a password policy, an existing principal is kept as is.
+func (k *Kadmin) EnsurePrincipal(ctx context.Context, principal string) error {
+	if !validKadminArgument(principal) {
+		return fmt.Errorf("%w: invalid principal %q", ErrInvalidVolumeContext, principal)
+	}
+	return k.query(ctx,
+		func(dir string) string {
+			return "addprinc -clearpolicy -randkey " + principal
+		},
+		func(dir string, stdout, stderr string) error {
+			if strings.Contains(stdout, fmt.Sprintf("Principal %q created", principal)) || strings.Contains(stderr, "already exists") {
+				return nil
+			}
+			// kadmin exits with 0 when a query fails, the failure is only written to stderr
+			return kadminError("addprinc "+principal, nil, stderr)
+		})
+}
+
+// ExportKeytab implements KerberosAdmin.
+// The keys are exported with -norandkey, which requires the extract privilege of the admin principal.
+func (k *Kadmin) ExportKeytab(ctx context.Context, principal string) ([]byte, error) {
+	if !validKadminArgument(principal) {
+		return nil, fmt.Errorf("%w: invalid principal %q", ErrInvalidVolumeContext, principal)
+	}
+	var data []byte
+	err := k.query(ctx,
+		func(dir string) string {
+			return fmt.Sprintf("ktadd -k %s -norandkey %s", filepath.Join(dir, kadminExportKeytabFileName), principal)
+		},
+		func(dir string, stdout, stderr string) error {
+			exported, err := os.ReadFile(filepath.Join(dir, kadminExportKeytabFileName))
+			if errors.Is(err, os.ErrNotExist) {
+				return kadminError("ktadd "+principal, nil, stderr)
+			}
+			data = exported
+			return err
+		})
+	return data, err
+}
+
+// Validate implements KerberosAdmin, it checks the admin keytab secret.
+func (k *Kadmin) Validate(ctx context.Context) error {
+	_, err := k.adminKeytab(ctx)
+	return err
+}
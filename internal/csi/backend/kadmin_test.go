@@ -0,0 +1,334 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/internal/keytab"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func newTestKeytab(t *testing.T, principal string) []byte {
+	parsed, err := keytab.ParsePrincipal(principal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &keytab.Keytab{Entries: []keytab.Entry{
+		{Principal: parsed, NameType: keytab.NameTypePrincipal, Timestamp: time.Now(), KVNO: 1, KeyType: 18, Key: []byte(principal)},
+	}}
+	data, err := k.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func newTestKerberosSpec() *secretsv1alpha1.KerberosSpec {
+	return &secretsv1alpha1.KerberosSpec{
+		Realm:             "EXAMPLE.COM",
+		KDC:               "kdc.example.com:88",
+		AdminPrincipal:    "admin/admin@EXAMPLE.COM",
+		AdminKeytabSecret: &secretsv1alpha1.SecretSpec{Name: "kadmin", Namespace: "kerberos"},
+	}
+}
+
+// fakeKDC answers the kadmin queries, after checking the admin keytab and the krb5.conf passed to kadmin.
+type fakeKDC struct {
+	t           *testing.T
+	adminKeytab []byte
+	principals  []string
+	// stderr is written instead of running the query, e.g. an error of the kadmin server.
+	stderr string
+}
+
+func (f *fakeKDC) run(ctx context.Context, env []string, args ...string) ([]byte, []byte, error) {
+	flags := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-k" {
+			continue
+		}
+		flags[args[i]] = args[i+1]
+		i++
+	}
+	if adminKeytab, err := os.ReadFile(flags["-t"]); err != nil || !bytes.Equal(adminKeytab, f.adminKeytab) {
+		f.t.Errorf("unexpected admin keytab %s: %v", flags["-t"], err)
+	}
+	if flags["-p"] != "admin/admin@EXAMPLE.COM" || flags["-r"] != "EXAMPLE.COM" || flags["-s"] != "kdc.example.com" {
+		f.t.Errorf("unexpected kadmin arguments: %v", args)
+	}
+	if len(env) != 1 || !strings.HasPrefix(env[0], "KRB5_CONFIG=") {
+		f.t.Fatalf("unexpected kadmin environment: %v", env)
+	}
+	krb5Conf, err := os.ReadFile(strings.TrimPrefix(env[0], "KRB5_CONFIG="))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	for _, want := range []string{"kdc = kdc.example.com:88", "admin_server = kdc.example.com"} {
+		if !strings.Contains(string(krb5Conf), want) {
+			f.t.Errorf("expected %q in krb5.conf:\n%s", want, krb5Conf)
+		}
+	}
+	if f.stderr != "" {
+		return nil, []byte(f.stderr), nil
+	}
+
+	query := strings.Fields(flags["-q"])
+	principal := query[len(query)-1]
+	switch query[0] {
+	case "addprinc":
+		if slices.Contains(f.principals, principal) {
+			return nil, []byte(fmt.Sprintf("add_principal: Principal or policy already exists while creating %q.\n", principal)), nil
+		}
+		f.principals = append(f.principals, principal)
+		return []byte(fmt.Sprintf("Principal %q created.\n", principal)), nil, nil
+	case "ktadd":
+		if !slices.Contains(f.principals, principal) {
+			return nil, []byte(fmt.Sprintf("kadmin: Principal %s does not exist.\n", principal)), nil
+		}
+		if err := os.WriteFile(query[2], newTestKeytab(f.t, principal), 0600); err != nil {
+			f.t.Fatal(err)
+		}
+		return nil, nil, nil
+	}
+	return nil, []byte("kadmin: Unknown request"), nil
+}
+
+func newTestKadmin(t *testing.T) (*Kadmin, *fakeKDC) {
+	adminKeytab := newTestKeytab(t, "admin/admin@EXAMPLE.COM")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kadmin", Namespace: "kerberos"},
+		Data:       map[string][]byte{"keytab": adminKeytab},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(secret).Build()
+	admin, err := NewKadmin(c, newTestKerberosSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdc := &fakeKDC{t: t, adminKeytab: adminKeytab}
+	admin.run = kdc.run
+	return admin, kdc
+}
+
+func TestKadminKerberosBackend(t *testing.T) {
+	admin, kdc := newTestKadmin(t)
+	pod := newTestPod()
+	volumeSelector := &volume.SecretVolumeSelector{
+		Class:                "kerberos",
+		Scope:                volume.SecretScope{Pod: volume.ScopePod},
+		KerberosServiceNames: []string{"HTTP"},
+	}
+	podInfo := pod_info.NewPodInfo(fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(pod).Build(), pod, volumeSelector)
+	backend, err := NewKerberosBackend(admin, "EXAMPLE.COM", podInfo, volumeSelector)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the principals are created once, the keytab is exported again
+	for i := 0; i < 2; i++ {
+		content, err := backend.GetSecretData(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		k, err := keytab.Parse(content.Data[KerberosKeytabFileName])
+		if err != nil {
+			t.Fatalf("failed to parse keytab: %v", err)
+		}
+		if principals := k.Principals(); !slices.Equal(principals, kdc.principals) {
+			t.Errorf("unexpected principals in keytab: got %v, want %v", principals, kdc.principals)
+		}
+	}
+	if !slices.Contains(kdc.principals, "HTTP/10-0-0-10.default.pod.cluster.local@EXAMPLE.COM") {
+		t.Errorf("unexpected principals created: %v", kdc.principals)
+	}
+}
+
+// withTestKadmin replaces the kerberos backend registered, so it runs the queries against the fake KDC.
+func withTestKadmin(t *testing.T, admin *Kadmin) {
+	registryLock.Lock()
+	registered := registry[BackendTypeKerberos]
+	registry[BackendTypeKerberos] = registration{
+		factory: func(config *BackendConfig) (IBackend, error) {
+			return NewKerberosBackend(admin, config.SecretClass.Spec.Backend.Kerberos.Realm, config.PodInfo, config.VolumeSelector)
+		},
+		cacheable: registered.cacheable,
+	}
+	registryLock.Unlock()
+	t.Cleanup(func() {
+		registryLock.Lock()
+		defer registryLock.Unlock()
+		registry[BackendTypeKerberos] = registered
+	})
+}
+
+func TestKerberosBackendCacheServiceNames(t *testing.T) {
+	admin, _ := newTestKadmin(t)
+	withTestKadmin(t, admin)
+	secretClass := &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "kerberos"},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{Kerberos: newTestKerberosSpec()},
+		},
+	}
+	pod := newTestPod()
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(pod).Build()
+	cache := NewCache(time.Minute)
+
+	// the volumes of the pod only differ in their service names, each one gets the keytab of its own principals
+	for _, service := range []string{"HTTP", "HDFS"} {
+		volumeSelector := &volume.SecretVolumeSelector{
+			Class:                secretClass.Name,
+			Pod:                  pod.Name,
+			PodNamespace:         pod.Namespace,
+			Scope:                volume.SecretScope{Pod: volume.ScopePod},
+			KerberosServiceNames: []string{service},
+		}
+		backend := NewBackend(c, pod_info.NewPodInfo(c, pod, volumeSelector), volumeSelector, secretClass).WithCache(cache)
+		content, err := backend.GetSecretData(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		k, err := keytab.Parse(content.Data[KerberosKeytabFileName])
+		if err != nil {
+			t.Fatalf("failed to parse keytab: %v", err)
+		}
+		want := service + "/10-0-0-10.default.pod.cluster.local@EXAMPLE.COM"
+		if principals := k.Principals(); !slices.Equal(principals, []string{want}) {
+			t.Errorf("unexpected principals in keytab of service %s: got %v, want %v", service, principals, []string{want})
+		}
+	}
+}
+
+func TestKadminErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   error
+	}{
+		{
+			name:   "permission denied",
+			stderr: "add_principal: Operation requires ``add'' privilege while creating \"HTTP/host@EXAMPLE.COM\".",
+			want:   ErrPermissionDenied,
+		},
+		{
+			name:   "kdc unreachable",
+			stderr: "kadmin: Cannot contact any KDC for realm 'EXAMPLE.COM' while initializing kadmin interface",
+			want:   ErrBackendUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin, kdc := newTestKadmin(t)
+			kdc.stderr = tt.stderr
+			if err := admin.EnsurePrincipal(context.Background(), "HTTP/host@EXAMPLE.COM"); !errors.Is(err, tt.want) {
+				t.Errorf("unexpected error: got %v, want %v", err, tt.want)
+			}
+			if _, err := admin.ExportKeytab(context.Background(), "HTTP/host@EXAMPLE.COM"); !errors.Is(err, tt.want) {
+				t.Errorf("unexpected error: got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	admin, _ := newTestKadmin(t)
+	if _, err := admin.ExportKeytab(context.Background(), "HTTP/missing@EXAMPLE.COM"); !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("unexpected error of a missing principal: got %v, want %v", err, ErrBackendUnavailable)
+	}
+	if err := admin.EnsurePrincipal(context.Background(), "HTTP/host@EXAMPLE.COM -q"); !errors.Is(err, ErrInvalidVolumeContext) {
+		t.Errorf("unexpected error of an invalid principal: got %v, want %v", err, ErrInvalidVolumeContext)
+	}
+}
+
+func TestKadminCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found in PATH")
+	}
+	// kadmin prints the created principal, the last argument of the query
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor arg; do query=$arg; done\necho \"Principal \\\"${query##* }\\\" created.\"\n"
+	if err := os.WriteFile(filepath.Join(dir, kadminCommand), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	admin, _ := newTestKadmin(t)
+	admin.run = runKadmin
+	if err := admin.EnsurePrincipal(context.Background(), "HTTP/host@EXAMPLE.COM"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewKadminInvalid(t *testing.T) {
+	tests := map[string]func(spec *secretsv1alpha1.KerberosSpec){
+		"no realm":           func(spec *secretsv1alpha1.KerberosSpec) { spec.Realm = "" },
+		"no kdc":             func(spec *secretsv1alpha1.KerberosSpec) { spec.KDC = "" },
+		"no admin principal": func(spec *secretsv1alpha1.KerberosSpec) { spec.AdminPrincipal = "" },
+		"no admin keytab":    func(spec *secretsv1alpha1.KerberosSpec) { spec.AdminKeytabSecret = nil },
+		"invalid kdc":        func(spec *secretsv1alpha1.KerberosSpec) { spec.KDC = "kdc.example.com }" },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			spec := newTestKerberosSpec()
+			mutate(spec)
+			if _, err := NewKadmin(nil, spec); !errors.Is(err, ErrSecretClassInvalid) {
+				t.Errorf("unexpected error: got %v, want %v", err, ErrSecretClassInvalid)
+			}
+		})
+	}
+}
+
+func TestKerberosBackendValidate(t *testing.T) {
+	secretClass := &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "kerberos"},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{Kerberos: newTestKerberosSpec()},
+		},
+	}
+	if err := ValidateSecretClass(secretClass); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		secret *corev1.Secret
+		want   error
+	}{
+		{name: "admin keytab", secret: &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "kadmin", Namespace: "kerberos"},
+			Data:       map[string][]byte{"keytab": newTestKeytab(t, "admin/admin@EXAMPLE.COM")},
+		}},
+		{name: "no admin keytab secret", want: ErrSecretClassInvalid},
+		{name: "invalid admin keytab", secret: &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "kadmin", Namespace: "kerberos"},
+			Data:       map[string][]byte{"keytab": []byte("invalid")},
+		}, want: ErrSecretClassInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objs []client.Object
+			if tt.secret != nil {
+				objs = append(objs, tt.secret)
+			}
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objs...).Build()
+			err := NewBackend(c, nil, &volume.SecretVolumeSelector{Class: secretClass.Name}, secretClass).Validate(context.Background())
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("unexpected error: got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
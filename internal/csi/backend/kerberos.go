@@ -1,11 +1,142 @@
 package backend
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"slices"
 
+	"github.com/zncdata-labs/secret-operator/internal/keytab"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/util"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// KerberosKeytabFileName is the keytab written to the volume.
+const KerberosKeytabFileName = "keytab"
+
+// KerberosAdmin manages the principals of the KDC, see Kadmin. Its errors wrap the errors of the backends,
+// e.g. ErrBackendUnavailable when the KDC can not be reached, or ErrPermissionDenied.
+type KerberosAdmin interface {
+	// EnsurePrincipal creates the principal with random keys if it does not exist.
+	EnsurePrincipal(ctx context.Context, principal string) error
+	// ExportKeytab returns the keytab with the keys of every version of the principal, the keys are not changed.
+	ExportKeytab(ctx context.Context, principal string) ([]byte, error)
+	// Validate checks the configuration of the client, e.g. the credentials exist, without connecting to the KDC.
+	Validate(ctx context.Context) error
+}
+
+// KerberosBackend writes a single keytab with a principal "<service>/<fqdn>@<realm>" for each service name
+// of the volume and each scoped hostname of the pod, so a pod running several services mounts one keytab.
+// The realm is the first of the kerberos realms of the volume, default is the realm of the secret class.
 type KerberosBackend struct {
+	admin          KerberosAdmin
+	realm          string
+	podInfo        *pod_info.PodInfo
+	volumeSelector *volume.SecretVolumeSelector
+}
+
+func NewKerberosBackend(
+	admin KerberosAdmin,
+	realm string,
+	podInfo *pod_info.PodInfo,
+	volumeSelector *volume.SecretVolumeSelector,
+) (*KerberosBackend, error) {
+	if admin == nil {
+		return nil, fmt.Errorf("%w: kerberos backend has no KDC admin client", ErrSecretClassInvalid)
+	}
+	return &KerberosBackend{
+		admin:          admin,
+		realm:          realm,
+		podInfo:        podInfo,
+		volumeSelector: volumeSelector,
+	}, nil
+}
+
+// principals returns the principals of the pod, each listed once.
+// The IP addresses are skipped, the principals of the services are bound to the hostnames.
+func (k *KerberosBackend) principals(ctx context.Context) ([]string, error) {
+	realm := k.realm
+	if len(k.volumeSelector.KerberosRealms) > 0 && k.volumeSelector.KerberosRealms[0] != "" {
+		realm = k.volumeSelector.KerberosRealms[0]
+	}
+	if realm == "" {
+		return nil, fmt.Errorf("%w: %s is required by the kerberos backend", ErrInvalidVolumeContext, volume.SecretsZncdataKerberosRealms)
+	}
+	if len(k.volumeSelector.KerberosServiceNames) == 0 {
+		return nil, fmt.Errorf("%w: %s is required by the kerberos backend", ErrInvalidVolumeContext, volume.SecretsZncdataKerberosServiceNames)
+	}
+
+	addresses, err := k.podInfo.GetScopedAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var principals []string
+	for _, service := range k.volumeSelector.KerberosServiceNames {
+		for _, address := range addresses {
+			if address.Hostname == "" {
+				continue
+			}
+			principal := fmt.Sprintf("%s/%s@%s", service, address.Hostname, realm)
+			if !slices.Contains(principals, principal) {
+				principals = append(principals, principal)
+			}
+		}
+	}
+	if len(principals) == 0 {
+		return nil, fmt.Errorf("%w: no hostname in scope %+v of pod %s/%s",
+			ErrInvalidVolumeContext, k.volumeSelector.Scope, k.podInfo.GetPodNamespace(), k.podInfo.GetPodName())
+	}
+	return principals, nil
 }
 
 // GetSecretData implements Backend.
-func (k *KerberosBackend) GetSecretData(ctx context.Context) (map[string]string, error) {
-	panic("unimplemented")
+// The principals are created when missing, and their keytabs are merged, keeping the keys of every version,
+// so the clients holding the tickets of a previous key version are still accepted.
+func (k *KerberosBackend) GetSecretData(ctx context.Context) (*util.SecretContent, error) {
+	principals, err := k.principals(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keytabs := make([]*keytab.Keytab, 0, len(principals))
+	for _, principal := range principals {
+		if err := k.admin.EnsurePrincipal(ctx, principal); err != nil {
+			return nil, fmt.Errorf("create principal %s: %w", principal, err)
+		}
+		data, err := k.admin.ExportKeytab(ctx, principal)
+		if err != nil {
+			return nil, fmt.Errorf("export keytab of principal %s: %w", principal, err)
+		}
+		parsed, err := keytab.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the keytab of principal %s: %w", principal, err)
+		}
+		keytabs = append(keytabs, parsed)
+	}
+
+	merged := keytab.Merge(keytabs...)
+	exported := merged.Principals()
+	if missing := slices.DeleteFunc(slices.Clone(principals), func(principal string) bool {
+		return slices.Contains(exported, principal)
+	}); len(missing) > 0 {
+		return nil, fmt.Errorf("the exported keytabs have no key of principals %v", missing)
+	}
+
+	data, err := merged.Encode()
+	if err != nil {
+		return nil, err
+	}
+	util.LoggerFromContext(ctx, logger).V(1).Info("Merged the keytabs of the pod",
+		"pod", k.podInfo.GetPodName(), "namespace", k.podInfo.GetPodNamespace(),
+		"principals", principals, "entries", len(merged.Entries))
+
+	return &util.SecretContent{
+		Data: map[string][]byte{KerberosKeytabFileName: data},
+	}, nil
+}
+
+// Validate implements Backend.
+func (k *KerberosBackend) Validate(ctx context.Context) error {
+	return k.admin.Validate(ctx)
 }
@@ -0,0 +1,152 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/zncdata-labs/secret-operator/internal/keytab"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// fakeKerberosAdmin is a KDC with two key versions for each principal.
+type fakeKerberosAdmin struct {
+	principals []string
+}
+
+func (a *fakeKerberosAdmin) EnsurePrincipal(ctx context.Context, principal string) error {
+	if !slices.Contains(a.principals, principal) {
+		a.principals = append(a.principals, principal)
+	}
+	return nil
+}
+
+func (a *fakeKerberosAdmin) ExportKeytab(ctx context.Context, name string) ([]byte, error) {
+	if !slices.Contains(a.principals, name) {
+		return nil, errors.New("principal does not exist")
+	}
+	principal, err := keytab.ParsePrincipal(name)
+	if err != nil {
+		return nil, err
+	}
+	k := &keytab.Keytab{}
+	for kvno := uint32(1); kvno <= 2; kvno++ {
+		k.Entries = append(k.Entries, keytab.Entry{
+			Principal: principal,
+			NameType:  keytab.NameTypeSrvHost,
+			Timestamp: time.Now(),
+			KVNO:      kvno,
+			KeyType:   18,
+			Key:       []byte(name),
+		})
+	}
+	return k.Encode()
+}
+
+func (a *fakeKerberosAdmin) Validate(ctx context.Context) error {
+	return nil
+}
+
+func newTestKerberosBackend(t *testing.T, admin KerberosAdmin, serviceNames []string) *KerberosBackend {
+	pod := newTestPod()
+	pod.Spec.Subdomain = "web"
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(pod).Build()
+	volumeSelector := &volume.SecretVolumeSelector{
+		Class:                "kerberos",
+		Scope:                volume.SecretScope{Pod: volume.ScopePod},
+		KerberosRealms:       []string{"EXAMPLE.COM"},
+		KerberosServiceNames: serviceNames,
+	}
+	backend, err := NewKerberosBackend(admin, "", pod_info.NewPodInfo(c, pod, volumeSelector), volumeSelector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestKerberosBackendGetSecretData(t *testing.T) {
+	admin := &fakeKerberosAdmin{}
+	backend := newTestKerberosBackend(t, admin, []string{"HTTP", "hdfs"})
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	k, err := keytab.Parse(content.Data[KerberosKeytabFileName])
+	if err != nil {
+		t.Fatalf("failed to parse keytab: %v", err)
+	}
+	expected := []string{
+		"HTTP/10-0-0-10.default.pod.cluster.local@EXAMPLE.COM",
+		"HTTP/test-pod.web.default.svc.cluster.local@EXAMPLE.COM",
+		"HTTP/web.default.svc.cluster.local@EXAMPLE.COM",
+		"hdfs/10-0-0-10.default.pod.cluster.local@EXAMPLE.COM",
+		"hdfs/test-pod.web.default.svc.cluster.local@EXAMPLE.COM",
+		"hdfs/web.default.svc.cluster.local@EXAMPLE.COM",
+	}
+	if principals := k.Principals(); !reflect.DeepEqual(principals, expected) {
+		t.Errorf("unexpected principals in keytab:\ngot  %v\nwant %v", principals, expected)
+	}
+	if len(k.Entries) != 2*len(expected) {
+		t.Errorf("expected the keys of both versions, got %d entries", len(k.Entries))
+	}
+	if len(admin.principals) != len(expected) {
+		t.Errorf("expected %d principals created, got %v", len(expected), admin.principals)
+	}
+}
+
+func TestKerberosBackendDefaultRealm(t *testing.T) {
+	backend := newTestKerberosBackend(t, &fakeKerberosAdmin{}, []string{"HTTP"})
+	backend.realm = "DEFAULT.COM"
+	backend.volumeSelector.KerberosRealms = nil
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	k, err := keytab.Parse(content.Data[KerberosKeytabFileName])
+	if err != nil {
+		t.Fatalf("failed to parse keytab: %v", err)
+	}
+	for _, principal := range k.Principals() {
+		if !strings.HasSuffix(principal, "@DEFAULT.COM") {
+			t.Errorf("expected principal %s in the realm of the secret class", principal)
+		}
+	}
+}
+
+func TestKerberosBackendInvalidVolume(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector *volume.SecretVolumeSelector
+	}{
+		{
+			name:     "no realm",
+			selector: &volume.SecretVolumeSelector{KerberosServiceNames: []string{"HTTP"}},
+		},
+		{
+			name:     "no service name",
+			selector: &volume.SecretVolumeSelector{KerberosRealms: []string{"EXAMPLE.COM"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := NewKerberosBackend(&fakeKerberosAdmin{}, "", nil, tt.selector)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := backend.GetSecretData(context.Background()); !errors.Is(err, ErrInvalidVolumeContext) {
+				t.Errorf("expected ErrInvalidVolumeContext, got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/zncdata-labs/secret-operator/pkg/util"
+)
+
+// ConcurrencyLimiter bounds the concurrent requests to the backends per backend type, so a large rollout
+// does not overwhelm vault or a KDC. The requests over the limit wait for a slot until their context is done.
+// It is safe for concurrent use.
+type ConcurrencyLimiter struct {
+	slots map[string]chan struct{}
+}
+
+// NewConcurrencyLimiter creates a limiter with the max concurrent requests per backend type,
+// the backend types without a positive limit are not limited.
+func NewConcurrencyLimiter(limits map[string]int) *ConcurrencyLimiter {
+	slots := map[string]chan struct{}{}
+	for backendType, limit := range limits {
+		if limit > 0 {
+			slots[backendType] = make(chan struct{}, limit)
+		}
+	}
+	return &ConcurrencyLimiter{slots: slots}
+}
+
+// acquire waits for a slot of the backend type, and returns the function releasing it.
+// The returned error is an ErrBackendTimeout when the context is done while waiting.
+// A nil limiter does not limit anything.
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, backendType string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	slots, ok := l.slots[backendType]
+	if !ok {
+		return func() {}, nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	default:
+	}
+
+	util.LoggerFromContext(ctx, logger).V(1).Info("Backend concurrency limit reached, wait for a slot", "backendType", backendType, "limit", cap(slots))
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: waiting for one of the %d concurrent %s backend requests: %w",
+			ErrBackendTimeout, cap(slots), backendType, ctx.Err())
+	}
+}
+
+// ParseConcurrencyLimits parses the comma separated limits per backend type, e.g. "vault=10,kerberos=5",
+// the custom backends must be registered before.
+// An empty value has no limit.
+func ParseConcurrencyLimits(value string) (map[string]int, error) {
+	limits := map[string]int{}
+	if strings.TrimSpace(value) == "" {
+		return limits, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		backendType, limitStr, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid backend concurrency limit %q: must be <backend type>=<limit>", item)
+		}
+		if backendTypes := RegisteredBackends(); !slices.Contains(backendTypes, backendType) {
+			return nil, fmt.Errorf("invalid backend concurrency limit %q: unknown backend type %q, must be one of %s",
+				item, backendType, strings.Join(backendTypes, ", "))
+		}
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid backend concurrency limit %q: limit must be a positive integer", item)
+		}
+		limits[backendType] = limit
+	}
+	return limits, nil
+}
@@ -0,0 +1,134 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func TestBackendConcurrencyLimit(t *testing.T) {
+	server := newTestVaultServer(t)
+	defer server.Close()
+
+	// count the concurrent reads of the secret, each one is slow
+	var inflight, maxInflight atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/secret/data/zncdata/default/vault" {
+			current := inflight.Add(1)
+			defer inflight.Add(-1)
+			for {
+				observed := maxInflight.Load()
+				if current <= observed || maxInflight.CompareAndSwap(observed, current) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+
+	c := newTestVaultClient(t, testVaultJWT)
+	secretClass := &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault"},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{
+				Vault: &secretsv1alpha1.VaultSpec{Address: slow.URL, Role: testVaultRole},
+			},
+		},
+	}
+	limiter := NewConcurrencyLimiter(map[string]int{BackendTypeVault: 2})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			volumeSelector := &volume.SecretVolumeSelector{Class: "vault"}
+			backend := NewBackend(c, pod_info.NewPodInfo(c, newTestPod(), volumeSelector), volumeSelector, secretClass).
+				WithConcurrencyLimiter(limiter)
+			_, err := backend.GetSecretData(context.Background())
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if max := maxInflight.Load(); max > 2 || max == 0 {
+		t.Errorf("unexpected concurrent vault reads: got %d, want at most 2", max)
+	}
+}
+
+func TestConcurrencyLimiterContextDone(t *testing.T) {
+	limiter := NewConcurrencyLimiter(map[string]int{BackendTypeKerberos: 1})
+	release, err := limiter.acquire(context.Background(), BackendTypeKerberos)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the queued request gives up at its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = limiter.acquire(ctx, BackendTypeKerberos)
+	if !errors.Is(err, ErrBackendTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: got %v, want %v", err, ErrBackendTimeout)
+	}
+
+	// the other backend types are not limited
+	if _, err := limiter.acquire(ctx, BackendTypeVault); err != nil {
+		t.Errorf("unexpected error for an unlimited backend type: %v", err)
+	}
+
+	release()
+	release, err = limiter.acquire(context.Background(), BackendTypeKerberos)
+	if err != nil {
+		t.Fatalf("the slot is not released: %v", err)
+	}
+	release()
+}
+
+func TestParseConcurrencyLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]int
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: map[string]int{}},
+		{name: "limits", value: "vault=10, kerberos=5", want: map[string]int{BackendTypeVault: 10, BackendTypeKerberos: 5}},
+		{name: "missing limit", value: "vault", wantErr: true},
+		{name: "unknown backend type", value: "ldap=5", wantErr: true},
+		{name: "zero limit", value: "vault=0", wantErr: true},
+		{name: "invalid limit", value: "vault=ten", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits, err := ParseConcurrencyLimits(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: got %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(limits, tt.want) {
+				t.Errorf("unexpected limits: got %v, want %v", limits, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// BackendConfig is what a backend is created with for a volume, or for a secret class to validate it.
+type BackendConfig struct {
+	Client client.Client
+	// PodInfo is the pod mounting the volume, nil when the secret class is validated.
+	PodInfo        *pod_info.PodInfo
+	VolumeSelector *volume.SecretVolumeSelector
+	SecretClass    *secretsv1alpha1.SecretClass
+	TokenCache     *TokenCache
+	Clock          clock.PassiveClock
+	Rand           io.Reader
+}
+
+// BackendFactory creates the backend of the secret class. The error of an invalid configuration wraps
+// ErrSecretClassInvalid or ErrInvalidVolumeContext, see the errors of the backends.
+type BackendFactory func(config *BackendConfig) (IBackend, error)
+
+// errBackendNotRegistered means the custom backend of the secret class is not registered in this binary,
+// e.g. it is registered in the csi driver only, the operator can not check it.
+var errBackendNotRegistered = errors.New("backend is not registered")
+
+// BackendOption configures the registration of a backend.
+type BackendOption func(*registration)
+
+// Cacheable caches the secret data of the backend, when the csi driver caches the secret data. Only a backend
+// returning the same data to the volumes of a pod may be cached, e.g. a backend reading a secret store,
+// not a backend issuing a new certificate for each volume.
+func Cacheable() BackendOption {
+	return func(r *registration) {
+		r.cacheable = true
+	}
+}
+
+type registration struct {
+	factory   BackendFactory
+	cacheable bool
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]registration{}
+)
+
+// builtinBackendTypes are the backends configured by their own field of the secret class spec,
+// a custom backend can not be registered with their names.
+var builtinBackendTypes = []string{
+	BackendTypeAutoTls, BackendTypeCertManager, BackendTypeFile, BackendTypeK8sSearch, BackendTypeKerberos, BackendTypeVault,
+}
+
+func init() {
+	registerBackend(BackendTypeAutoTls, func(config *BackendConfig) (IBackend, error) {
+		return NewAutoTlsBackend(config.Client, config.PodInfo, config.VolumeSelector,
+			config.SecretClass.Spec.Backend.AutoTls, config.Clock, config.Rand)
+	})
+	registerBackend(BackendTypeCertManager, func(config *BackendConfig) (IBackend, error) {
+		return NewCertManagerBackend(config.Client, config.PodInfo, config.VolumeSelector,
+			config.SecretClass.Spec.Backend.CertManager, config.Rand)
+	})
+	registerBackend(BackendTypeFile, func(config *BackendConfig) (IBackend, error) {
+		return NewFileBackend(config.VolumeSelector, config.SecretClass.Spec.Backend.File)
+	}, Cacheable())
+	registerBackend(BackendTypeK8sSearch, func(config *BackendConfig) (IBackend, error) {
+		return NewK8sSearchBackend(config.Client, config.PodInfo, config.VolumeSelector,
+			config.SecretClass.Spec.Backend.K8sSearch)
+	}, Cacheable())
+	// the keys of the principals are exported without being changed, the keytabs of a pod are cached
+	registerBackend(BackendTypeKerberos, func(config *BackendConfig) (IBackend, error) {
+		kerberos := config.SecretClass.Spec.Backend.Kerberos
+		admin, err := NewKadmin(config.Client, kerberos)
+		if err != nil {
+			return nil, err
+		}
+		return NewKerberosBackend(admin, kerberos.Realm, config.PodInfo, config.VolumeSelector)
+	}, Cacheable())
+	registerBackend(BackendTypeVault, func(config *BackendConfig) (IBackend, error) {
+		return NewVaultBackend(config.Client, config.PodInfo, config.VolumeSelector,
+			config.SecretClass.Spec.Backend.Vault, config.TokenCache, config.Clock)
+	}, Cacheable())
+}
+
+// RegisterBackend registers the factory of a custom backend, selected by the secret classes with
+// spec.backend.custom.type set to the name. The parameters of the custom spec are in the secret class
+// of the config. It must be called before the csi driver starts, e.g. in an init function of a file
+// added to cmd/csi_driver, and panics when the name is empty, built-in or already registered.
+// The data of a custom backend is not cached, unless it is registered with the Cacheable option.
+func RegisterBackend(name string, factory BackendFactory, opts ...BackendOption) {
+	if slices.Contains(builtinBackendTypes, name) {
+		panic(fmt.Sprintf("backend %q is built-in", name))
+	}
+	registerBackend(name, factory, opts...)
+}
+
+func registerBackend(name string, factory BackendFactory, opts ...BackendOption) {
+	if name == "" || name == BackendTypeUnknown {
+		panic(fmt.Sprintf("invalid backend name %q", name))
+	}
+	if factory == nil {
+		panic(fmt.Sprintf("backend %q has no factory", name))
+	}
+
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("backend %q is already registered", name))
+	}
+	r := registration{factory: factory}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	registry[name] = r
+}
+
+// RegisteredBackends returns the sorted names of the built-in and the registered custom backends.
+func RegisteredBackends() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// backendFactory returns the factory registered for the backend type.
+func backendFactory(backendType string) (BackendFactory, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	r, ok := registry[backendType]
+	return r.factory, ok
+}
+
+// backendCacheable returns whether the backend type is registered with the Cacheable option.
+func backendCacheable(backendType string) bool {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return registry[backendType].cacheable
+}
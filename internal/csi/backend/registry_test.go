@@ -0,0 +1,185 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/util"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// storeBackend returns the parameters of the custom spec as the secret data.
+type storeBackend struct {
+	data map[string][]byte
+}
+
+func (s *storeBackend) GetSecretData(ctx context.Context) (*util.SecretContent, error) {
+	return &util.SecretContent{Data: s.data}, nil
+}
+
+func (s *storeBackend) Validate(ctx context.Context) error {
+	return nil
+}
+
+func init() {
+	RegisterBackend("test-store", func(config *BackendConfig) (IBackend, error) {
+		parameters := config.SecretClass.Spec.Backend.Custom.Parameters
+		if parameters["invalid"] != "" {
+			return nil, fmt.Errorf("%w: invalid parameter", ErrSecretClassInvalid)
+		}
+		data := map[string][]byte{}
+		for key, value := range parameters {
+			data[key] = []byte(value)
+		}
+		return &storeBackend{data: data}, nil
+	})
+}
+
+func newCustomSecretClass(backendType string, parameters map[string]string) *secretsv1alpha1.SecretClass {
+	return &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "store"},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{
+				Custom: &secretsv1alpha1.CustomSpec{Type: backendType, Parameters: parameters},
+			},
+		},
+	}
+}
+
+func TestCustomBackend(t *testing.T) {
+	secretClass := newCustomSecretClass("test-store", map[string]string{"token": "secret"})
+	b := NewBackend(nil, nil, &volume.SecretVolumeSelector{Class: secretClass.Name}, secretClass)
+
+	content, err := b.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(content.Data["token"]) != "secret" {
+		t.Errorf("unexpected secret data: %v", content.Data)
+	}
+	if err := b.Validate(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !slices.Contains(RegisteredBackends(), "test-store") {
+		t.Errorf("test-store is not in the registered backends %v", RegisteredBackends())
+	}
+	if _, err := ParseConcurrencyLimits("test-store=2"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// countingBackend counts the fetches of the secret data.
+type countingBackend struct {
+	fetches *int
+}
+
+func (c *countingBackend) GetSecretData(ctx context.Context) (*util.SecretContent, error) {
+	*c.fetches++
+	return &util.SecretContent{Data: map[string][]byte{"token": []byte("secret")}}, nil
+}
+
+func (c *countingBackend) Validate(ctx context.Context) error {
+	return nil
+}
+
+func TestCustomBackendCacheable(t *testing.T) {
+	var uncachedFetches, cachedFetches int
+	RegisterBackend("test-uncached-store", func(config *BackendConfig) (IBackend, error) {
+		return &countingBackend{fetches: &uncachedFetches}, nil
+	})
+	RegisterBackend("test-cached-store", func(config *BackendConfig) (IBackend, error) {
+		return &countingBackend{fetches: &cachedFetches}, nil
+	}, Cacheable())
+
+	tests := []struct {
+		backendType string
+		fetches     *int
+		want        int
+	}{
+		// not cached by default, a custom backend may issue new secrets for each volume
+		{backendType: "test-uncached-store", fetches: &uncachedFetches, want: 2},
+		{backendType: "test-cached-store", fetches: &cachedFetches, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backendType, func(t *testing.T) {
+			cache := NewCache(time.Minute)
+			secretClass := newCustomSecretClass(tt.backendType, nil)
+			for i := 0; i < 2; i++ {
+				b := NewBackend(nil, nil, &volume.SecretVolumeSelector{Class: secretClass.Name, Pod: "test-pod"}, secretClass).
+					WithCache(cache)
+				if _, err := b.GetSecretData(context.Background()); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if *tt.fetches != tt.want {
+				t.Errorf("unexpected fetches: got %d, want %d", *tt.fetches, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSecretClassCustom(t *testing.T) {
+	withVault := newCustomSecretClass("test-store", nil)
+	withVault.Spec.Backend.Vault = &secretsv1alpha1.VaultSpec{}
+
+	tests := []struct {
+		name        string
+		secretClass *secretsv1alpha1.SecretClass
+		wantErr     bool
+	}{
+		{name: "registered", secretClass: newCustomSecretClass("test-store", nil)},
+		{name: "invalid parameters", secretClass: newCustomSecretClass("test-store", map[string]string{"invalid": "true"}), wantErr: true},
+		// registered in the csi driver only, the operator can not check it
+		{name: "not registered", secretClass: newCustomSecretClass("other-store", nil)},
+		{name: "built-in", secretClass: newCustomSecretClass(BackendTypeVault, nil), wantErr: true},
+		{name: "no type", secretClass: newCustomSecretClass("", nil), wantErr: true},
+		{name: "with vault", secretClass: withVault, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSecretClass(tt.secretClass)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("unexpected error: got %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrSecretClassInvalid) {
+				t.Errorf("unexpected error: got %v, want %v", err, ErrSecretClassInvalid)
+			}
+		})
+	}
+
+	// the publish fails when the backend is not registered in the csi driver
+	secretClass := newCustomSecretClass("other-store", nil)
+	b := NewBackend(nil, nil, &volume.SecretVolumeSelector{Class: secretClass.Name}, secretClass)
+	if _, err := b.GetSecretData(context.Background()); !errors.Is(err, ErrSecretClassInvalid) {
+		t.Errorf("unexpected error: got %v, want %v", err, ErrSecretClassInvalid)
+	}
+}
+
+func TestRegisterBackendInvalid(t *testing.T) {
+	factory := func(config *BackendConfig) (IBackend, error) {
+		return &storeBackend{}, nil
+	}
+	for name, register := range map[string]func(){
+		"built-in":   func() { RegisterBackend(BackendTypeVault, factory) },
+		"duplicate":  func() { RegisterBackend("test-store", factory) },
+		"empty":      func() { RegisterBackend("", factory) },
+		"no factory": func() { RegisterBackend("no-factory", nil) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+			register()
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/zncdata-labs/secret-operator/pkg/util"
+)
+
+// RetryPolicy bounds the retries of the transient failures of the backends, e.g. a vault or apiserver hiccup,
+// so the volume does not wait for the coarse retry interval of kubelet.
+// The delay doubles after each failed attempt, starting from BaseDelay.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one, less than 2 disables the retry.
+	MaxAttempts int
+	BaseDelay   time.Duration
+}
+
+// isTransient returns whether retrying the failed request may succeed.
+// The invalid requests, and the missing secrets or secret classes are not transient.
+func isTransient(err error) bool {
+	return errors.Is(err, ErrBackendUnavailable) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err)
+}
+
+// do calls fn until it succeeds, fails with a non transient error, or the attempts are exhausted.
+// It does not wait beyond the deadline of the context, the last error is returned instead.
+func (p RetryPolicy) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	delay := p.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= p.MaxAttempts || !isTransient(err) {
+			return err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		util.LoggerFromContext(ctx, logger).V(1).Info("Transient backend failure, retry", "attempt", attempt, "delay", delay, "error", err.Error())
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
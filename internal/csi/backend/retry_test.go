@@ -0,0 +1,153 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	unavailable := fmt.Errorf("%w: vault is sealed", ErrBackendUnavailable)
+
+	tests := []struct {
+		name        string
+		policy      RetryPolicy
+		errs        []error
+		timeout     time.Duration
+		wantCalls   int
+		wantSuccess bool
+	}{
+		{
+			name:        "fails twice then succeeds",
+			policy:      RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+			errs:        []error{unavailable, unavailable},
+			wantCalls:   3,
+			wantSuccess: true,
+		},
+		{
+			name:      "attempts exhausted",
+			policy:    RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+			errs:      []error{unavailable, unavailable},
+			wantCalls: 2,
+		},
+		{
+			name:        "apiserver unavailable",
+			policy:      RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+			errs:        []error{fmt.Errorf("list secrets: %w", apierrors.NewServiceUnavailable("etcd leader changed"))},
+			wantCalls:   2,
+			wantSuccess: true,
+		},
+		{
+			name:      "not found is not retried",
+			policy:    RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+			errs:      []error{fmt.Errorf("%w: vault secret does not exist", ErrSecretNotFound)},
+			wantCalls: 1,
+		},
+		{
+			name:      "invalid volume context is not retried",
+			policy:    RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+			errs:      []error{fmt.Errorf("%w: negative lifetime", ErrInvalidVolumeContext)},
+			wantCalls: 1,
+		},
+		{
+			name:      "disabled",
+			policy:    RetryPolicy{},
+			errs:      []error{unavailable},
+			wantCalls: 1,
+		},
+		{
+			name:      "delay exceeds the deadline",
+			policy:    RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour},
+			errs:      []error{unavailable},
+			timeout:   time.Minute,
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			calls := 0
+			err := tt.policy.do(ctx, func(ctx context.Context) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if (err == nil) != tt.wantSuccess {
+				t.Errorf("unexpected error: %v, want success %t", err, tt.wantSuccess)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("unexpected calls: got %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestBackendRetryVault(t *testing.T) {
+	server := newTestVaultServer(t)
+	defer server.Close()
+
+	// vault is unavailable for the first two reads
+	failures := 0
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/secret/data/zncdata/default/vault" && failures < 2 {
+			failures++
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"errors":["Vault is sealed"]}`))
+			return
+		}
+		server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+
+	c := newTestVaultClient(t, testVaultJWT)
+	volumeSelector := &volume.SecretVolumeSelector{Class: "vault"}
+	secretClass := &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault"},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{
+				Vault: &secretsv1alpha1.VaultSpec{Address: flaky.URL, Role: testVaultRole},
+			},
+		},
+	}
+	backend := NewBackend(c, pod_info.NewPodInfo(c, newTestPod(), volumeSelector), volumeSelector, secretClass).
+		WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(content.Data["username"]) != "admin" {
+		t.Errorf("unexpected data: %v", content.Data)
+	}
+	if failures != 2 {
+		t.Errorf("expected the two failures to be retried, got %d", failures)
+	}
+
+	// without retry, the failure is returned
+	failures = 0
+	_, err = NewBackend(c, pod_info.NewPodInfo(c, newTestPod(), volumeSelector), volumeSelector, secretClass).
+		GetSecretData(context.Background())
+	if !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("expected ErrBackendUnavailable without retry, got: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package backend
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenRefreshRatio is the part of the lifetime of a service account token after which it is requested again,
+// like kubelet refreshes the projected service account tokens.
+const tokenRefreshRatio = 0.8
+
+// tokenCacheKey identifies a service account token, the tokens are bound to the pod.
+type tokenCacheKey struct {
+	namespace      string
+	serviceAccount string
+	podUID         string
+	audience       string
+}
+
+type cachedToken struct {
+	token     string
+	refreshAt time.Time
+}
+
+// TokenCache keeps the service account tokens requested to authenticate to the backends, so the volumes
+// of a pod and their rotations reuse a token instead of requesting a new one every time.
+// A token is refreshed before it expires. It is safe for concurrent use.
+type TokenCache struct {
+	lock   sync.Mutex
+	tokens map[tokenCacheKey]cachedToken
+}
+
+func NewTokenCache() *TokenCache {
+	return &TokenCache{tokens: map[tokenCacheKey]cachedToken{}}
+}
+
+// get returns the cached token, unless it must be refreshed.
+func (c *TokenCache) get(key tokenCacheKey, now time.Time) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cached, ok := c.tokens[key]
+	if !ok {
+		return "", false
+	}
+	if !now.Before(cached.refreshAt) {
+		delete(c.tokens, key)
+		return "", false
+	}
+	return cached.token, true
+}
+
+// set caches the token issued at now, the tokens to refresh are dropped at the same time.
+func (c *TokenCache) set(key tokenCacheKey, token string, now, expiration time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for k, cached := range c.tokens {
+		if !now.Before(cached.refreshAt) {
+			delete(c.tokens, k)
+		}
+	}
+	lifetime := expiration.Sub(now)
+	c.tokens[key] = cachedToken{
+		token:     token,
+		refreshAt: now.Add(time.Duration(float64(lifetime) * tokenRefreshRatio)),
+	}
+}
@@ -0,0 +1,364 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/util"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultVaultMountPath  = "secret"
+	defaultVaultPathPrefix = "zncdata"
+	defaultVaultAuthPath   = "kubernetes"
+
+	// vaultTokenExpirationSeconds is the lifetime of the service account token used to login vault,
+	// it is the minimum lifetime allowed by kubernetes, the token is reused by the logins of the pod
+	// until it is refreshed, see TokenCache.
+	vaultTokenExpirationSeconds int64 = 600
+
+	vaultRequestTimeout = 10 * time.Second
+)
+
+type VaultBackend struct {
+	client         client.Client
+	podInfo        *pod_info.PodInfo
+	volumeSelector *volume.SecretVolumeSelector
+	vault          *secretsv1alpha1.VaultSpec
+	// tokens caches the service account tokens, nil requests a token for each login.
+	tokens *TokenCache
+	clock  clock.PassiveClock
+
+	httpClient *http.Client
+}
+
+func NewVaultBackend(
+	client client.Client,
+	podInfo *pod_info.PodInfo,
+	volumeSelector *volume.SecretVolumeSelector,
+	vaultSpec *secretsv1alpha1.VaultSpec,
+	tokens *TokenCache,
+	clock clock.PassiveClock,
+) (*VaultBackend, error) {
+	if vaultSpec == nil {
+		return nil, fmt.Errorf("%w: vault spec is nil in secret class", ErrSecretClassInvalid)
+	}
+
+	if vaultSpec.Address == "" {
+		return nil, fmt.Errorf("%w: vault address is empty in secret class", ErrSecretClassInvalid)
+	}
+
+	if vaultSpec.Role == "" {
+		return nil, fmt.Errorf("%w: vault role is empty in secret class", ErrSecretClassInvalid)
+	}
+
+	return &VaultBackend{
+		client:         client,
+		podInfo:        podInfo,
+		volumeSelector: volumeSelector,
+		vault:          vaultSpec,
+		tokens:         tokens,
+		clock:          clock,
+		httpClient:     &http.Client{Timeout: vaultRequestTimeout},
+	}, nil
+}
+
+func (v *VaultBackend) mountPath() string {
+	if v.vault.MountPath == "" {
+		return defaultVaultMountPath
+	}
+	return strings.Trim(v.vault.MountPath, "/")
+}
+
+func (v *VaultBackend) pathPrefix() string {
+	if v.vault.PathPrefix == "" {
+		return defaultVaultPathPrefix
+	}
+	return strings.Trim(v.vault.PathPrefix, "/")
+}
+
+func (v *VaultBackend) authPath() string {
+	if v.vault.AuthPath == "" {
+		return defaultVaultAuthPath
+	}
+	return strings.Trim(v.vault.AuthPath, "/")
+}
+
+// secretPath returns the path of the secret in the KV v2 secrets engine,
+// it is "<pathPrefix>/<pod namespace>/<secret class>".
+func (v *VaultBackend) secretPath() string {
+	return strings.Join([]string{v.pathPrefix(), v.podInfo.GetPodNamespace(), v.volumeSelector.Class}, "/")
+}
+
+func (v *VaultBackend) url(path string) string {
+	return strings.TrimSuffix(v.vault.Address, "/") + "/v1/" + path
+}
+
+// serviceAccountToken requests a token of the pod service account with the audience of the secret class,
+// the token is bound to the pod. A cached token is returned until it must be refreshed.
+func (v *VaultBackend) serviceAccountToken(ctx context.Context) (string, error) {
+	pod := v.podInfo.Pod
+
+	serviceAccountName := pod.Spec.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = "default"
+	}
+
+	key := tokenCacheKey{
+		namespace:      pod.GetNamespace(),
+		serviceAccount: serviceAccountName,
+		podUID:         string(pod.GetUID()),
+		audience:       v.vault.Audience,
+	}
+	now := v.clock.Now()
+	if v.tokens != nil {
+		if token, ok := v.tokens.get(key, now); ok {
+			return token, nil
+		}
+	}
+
+	var audiences []string
+	if v.vault.Audience != "" {
+		audiences = []string{v.vault.Audience}
+	}
+	expirationSeconds := vaultTokenExpirationSeconds
+	tokenRequest := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         audiences,
+			ExpirationSeconds: &expirationSeconds,
+			BoundObjectRef: &authenticationv1.BoundObjectReference{
+				Kind:       "Pod",
+				APIVersion: "v1",
+				Name:       pod.GetName(),
+				UID:        pod.GetUID(),
+			},
+		},
+	}
+
+	serviceAccount := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceAccountName,
+			Namespace: pod.GetNamespace(),
+		},
+	}
+
+	if err := v.client.SubResource("token").Create(ctx, serviceAccount, tokenRequest); err != nil {
+		return "", fmt.Errorf("failed to request token of service account %s/%s: %w", pod.GetNamespace(), serviceAccountName, err)
+	}
+
+	if v.tokens != nil {
+		// the apiserver may shorten the lifetime of the token
+		expiration := tokenRequest.Status.ExpirationTimestamp.Time
+		if expiration.IsZero() {
+			expiration = now.Add(time.Duration(expirationSeconds) * time.Second)
+		}
+		v.tokens.set(key, tokenRequest.Status.Token, now, expiration)
+	}
+	return tokenRequest.Status.Token, nil
+}
+
+type vaultErrorResponse struct {
+	Errors []string `json:"errors"`
+}
+
+type vaultLoginResponse struct {
+	Auth *struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+}
+
+type vaultKVv2Response struct {
+	Data *struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+// vaultStatusError is returned when vault responds with an error status. The server errors and the throttled
+// requests are an ErrBackendUnavailable, which is retried, 403 is an ErrPermissionDenied and 404 an ErrSecretNotFound.
+// The other statuses are not retried. The failed logins are all an ErrBackendUnavailable, see login.
+type vaultStatusError struct {
+	statusCode int
+	messages   []string
+}
+
+func (e *vaultStatusError) Error() string {
+	if len(e.messages) > 0 {
+		return fmt.Sprintf("vault responded with status %d: %s", e.statusCode, strings.Join(e.messages, "; "))
+	}
+	return fmt.Sprintf("vault responded with status %d", e.statusCode)
+}
+
+func (e *vaultStatusError) Unwrap() error {
+	switch {
+	case e.statusCode >= 500, e.statusCode == http.StatusTooManyRequests:
+		return ErrBackendUnavailable
+	case e.statusCode == http.StatusForbidden:
+		return ErrPermissionDenied
+	case e.statusCode == http.StatusNotFound:
+		return ErrSecretNotFound
+	default:
+		return nil
+	}
+}
+
+// do sends the request to vault, and decodes the response to out.
+// When vault responds with an error, the error messages of vault are returned, see vaultStatusError.
+// Failures to reach vault are ErrBackendUnavailable.
+func (v *VaultBackend) do(ctx context.Context, method, path, token string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, v.url(path), reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		errResp := &vaultErrorResponse{}
+		_ = json.Unmarshal(data, errResp)
+		return &vaultStatusError{statusCode: resp.StatusCode, messages: errResp.Errors}
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+type vaultHealthResponse struct {
+	Initialized bool `json:"initialized"`
+	Sealed      bool `json:"sealed"`
+}
+
+// Validate implements Backend.
+// Logging in vault needs the service account token of a pod, so only the health of vault is checked.
+// Standby nodes are considered healthy, as they forward the requests to the active node.
+func (v *VaultBackend) Validate(ctx context.Context) error {
+	resp := &vaultHealthResponse{}
+	if err := v.do(ctx, http.MethodGet, "sys/health?standbyok=true&perfstandbyok=true", "", nil, resp); err != nil {
+		return fmt.Errorf("vault at %s is not healthy: %w", v.vault.Address, err)
+	}
+
+	if !resp.Initialized || resp.Sealed {
+		return fmt.Errorf("vault at %s is not ready: initialized=%t, sealed=%t", v.vault.Address, resp.Initialized, resp.Sealed)
+	}
+
+	return nil
+}
+
+// login logs in vault with the kubernetes auth method, and returns the vault client token.
+// Any failure to login is an ErrBackendUnavailable, retried like an unreachable vault, e.g. the role of the
+// secret class is being configured in vault.
+func (v *VaultBackend) login(ctx context.Context) (string, error) {
+	jwt, err := v.serviceAccountToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	resp := &vaultLoginResponse{}
+	body := map[string]string{
+		"role": v.vault.Role,
+		"jwt":  jwt,
+	}
+	if err := v.do(ctx, http.MethodPost, "auth/"+v.authPath()+"/login", "", body, resp); err != nil {
+		// not a missing secret, the auth method is not enabled at the path
+		if errors.Is(err, ErrSecretNotFound) {
+			return "", fmt.Errorf("%w: failed to login vault with role %s, no auth method at auth/%s: %v",
+				ErrBackendUnavailable, v.vault.Role, v.authPath(), err)
+		}
+		// the status error is not wrapped, a denied login is not a denied secret
+		return "", fmt.Errorf("%w: failed to login vault with role %s: %v", ErrBackendUnavailable, v.vault.Role, err)
+	}
+
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("%w: failed to login vault with role %s: no client token in response", ErrBackendUnavailable, v.vault.Role)
+	}
+
+	return resp.Auth.ClientToken, nil
+}
+
+// readSecret reads the secret from the KV v2 secrets engine.
+// String values are returned as is, other values are encoded as json.
+func (v *VaultBackend) readSecret(ctx context.Context, token string) (map[string][]byte, error) {
+	path := v.mountPath() + "/data/" + v.secretPath()
+
+	resp := &vaultKVv2Response{}
+	if err := v.do(ctx, http.MethodGet, path, token, nil, resp); err != nil {
+		var statusErr *vaultStatusError
+		if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: vault secret %s does not exist", ErrSecretNotFound, path)
+		}
+		return nil, fmt.Errorf("failed to read vault secret %s: %w", path, err)
+	}
+
+	if resp.Data == nil {
+		return nil, fmt.Errorf("failed to read vault secret %s: no data in response", path)
+	}
+
+	data := make(map[string][]byte, len(resp.Data.Data))
+	for key, value := range resp.Data.Data {
+		if s, ok := value.(string); ok {
+			data[key] = []byte(s)
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		data[key] = encoded
+	}
+
+	util.LoggerFromContext(ctx, logger).V(1).Info("read secret from vault", "path", path, "keys", len(data))
+
+	return data, nil
+}
+
+// GetSecretData implements Backend.
+func (v *VaultBackend) GetSecretData(ctx context.Context) (*util.SecretContent, error) {
+	token, err := v.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := v.readSecret(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &util.SecretContent{
+		Data: data,
+	}, nil
+}
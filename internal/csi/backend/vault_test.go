@@ -0,0 +1,286 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+const (
+	testVaultRole  = "app"
+	testVaultJWT   = "test-jwt"
+	testVaultToken = "test-vault-token"
+)
+
+// newTestVaultServer returns a fake vault server, which accepts the test jwt and serves one KV v2 secret.
+func newTestVaultServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/auth/kubernetes/login":
+			body := map[string]string{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("failed to decode login request: %v", err)
+			}
+			if body["role"] != testVaultRole || body["jwt"] != testVaultJWT {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"` + testVaultToken + `"}}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/data/zncdata/default/vault":
+			if r.Header.Get("X-Vault-Token") != testVaultToken {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"data":{"username":"admin","port":5432}}}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/sys/health":
+			_, _ = w.Write([]byte(`{"initialized":true,"sealed":false}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+}
+
+func newTestVaultClient(t *testing.T, jwt string) client.Client {
+	pod := newTestPod()
+	return fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(pod).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				tokenRequest, ok := subResource.(*authenticationv1.TokenRequest)
+				if !ok || subResourceName != "token" {
+					t.Fatalf("unexpected sub resource create: %s", subResourceName)
+				}
+				if obj.GetName() != "default" || obj.GetNamespace() != "default" {
+					t.Errorf("unexpected service account: %s/%s", obj.GetNamespace(), obj.GetName())
+				}
+				tokenRequest.Status.Token = jwt
+				return nil
+			},
+		}).
+		Build()
+}
+
+func newTestVaultBackend(t *testing.T, c client.Client, address string) *VaultBackend {
+	volumeSelector := &volume.SecretVolumeSelector{Class: "vault"}
+	podInfo := pod_info.NewPodInfo(c, newTestPod(), volumeSelector)
+	backend, err := NewVaultBackend(c, podInfo, volumeSelector, &secretsv1alpha1.VaultSpec{
+		Address: address,
+		Role:    testVaultRole,
+	}, nil, clock.RealClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestVaultBackendGetSecretData(t *testing.T) {
+	server := newTestVaultServer(t)
+	defer server.Close()
+
+	backend := newTestVaultBackend(t, newTestVaultClient(t, testVaultJWT), server.URL)
+
+	content, err := backend.GetSecretData(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"username": "admin",
+		"port":     "5432",
+	}
+	if len(content.Data) != len(expected) {
+		t.Errorf("unexpected data: got %v, want %v", content.Data, expected)
+	}
+	for key, value := range expected {
+		if string(content.Data[key]) != value {
+			t.Errorf("unexpected value of %s: got %q, want %q", key, content.Data[key], value)
+		}
+	}
+}
+
+func TestVaultBackendLoginFailed(t *testing.T) {
+	server := newTestVaultServer(t)
+	defer server.Close()
+
+	backend := newTestVaultBackend(t, newTestVaultClient(t, "invalid-jwt"), server.URL)
+
+	_, err := backend.GetSecretData(context.Background())
+	if err == nil {
+		t.Fatalf("expected error when login vault with invalid jwt")
+	}
+	if !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected vault error message in error, got: %v", err)
+	}
+	if !errors.Is(err, ErrBackendUnavailable) || errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected a backend unavailable error, got: %v", err)
+	}
+}
+
+func TestVaultBackendStatusErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		want       error
+		transient  bool
+	}{
+		{name: "bad request", statusCode: http.StatusBadRequest},
+		{name: "forbidden", statusCode: http.StatusForbidden, want: ErrPermissionDenied},
+		{name: "not found", statusCode: http.StatusNotFound, want: ErrSecretNotFound},
+		{name: "too many requests", statusCode: http.StatusTooManyRequests, want: ErrBackendUnavailable, transient: true},
+		{name: "internal server error", statusCode: http.StatusInternalServerError, want: ErrBackendUnavailable, transient: true},
+		{name: "sealed", statusCode: http.StatusServiceUnavailable, want: ErrBackendUnavailable, transient: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestVaultServer(t)
+			defer server.Close()
+			handler := server.Config.Handler
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet && r.URL.Path == "/v1/secret/data/zncdata/default/vault" {
+					w.WriteHeader(tt.statusCode)
+					_, _ = w.Write([]byte(`{"errors":["vault error"]}`))
+					return
+				}
+				handler.ServeHTTP(w, r)
+			})
+
+			backend := newTestVaultBackend(t, newTestVaultClient(t, testVaultJWT), server.URL)
+			_, err := backend.GetSecretData(context.Background())
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, sentinel := range []error{ErrPermissionDenied, ErrSecretNotFound, ErrBackendUnavailable} {
+				if errors.Is(err, sentinel) != (sentinel == tt.want) {
+					t.Errorf("unexpected error: got %v, want %v", err, tt.want)
+				}
+			}
+			if isTransient(err) != tt.transient {
+				t.Errorf("unexpected transient error %v: got %t, want %t", err, isTransient(err), tt.transient)
+			}
+		})
+	}
+}
+
+func TestVaultBackendLoginAuthNotFound(t *testing.T) {
+	server := newTestVaultServer(t)
+	defer server.Close()
+
+	volumeSelector := &volume.SecretVolumeSelector{Class: "vault"}
+	c := newTestVaultClient(t, testVaultJWT)
+	backend, err := NewVaultBackend(c, pod_info.NewPodInfo(c, newTestPod(), volumeSelector), volumeSelector, &secretsv1alpha1.VaultSpec{
+		Address:  server.URL,
+		Role:     testVaultRole,
+		AuthPath: "missing",
+	}, nil, clock.RealClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backend.GetSecretData(context.Background()); !errors.Is(err, ErrBackendUnavailable) || errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("unexpected error: got %v, want %v", err, ErrBackendUnavailable)
+	}
+}
+
+func TestVaultBackendValidate(t *testing.T) {
+	server := newTestVaultServer(t)
+	defer server.Close()
+
+	backend := newTestVaultBackend(t, newTestVaultClient(t, testVaultJWT), server.URL)
+	if err := backend.Validate(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	sealed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"initialized":true,"sealed":true}`))
+	}))
+	defer sealed.Close()
+
+	backend = newTestVaultBackend(t, newTestVaultClient(t, testVaultJWT), sealed.URL)
+	if err := backend.Validate(context.Background()); err == nil {
+		t.Errorf("expected error when vault is sealed")
+	}
+}
+
+func TestVaultBackendTokenAudienceAndCache(t *testing.T) {
+	server := newTestVaultServer(t)
+	defer server.Close()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fakeClock := clocktesting.NewFakePassiveClock(now)
+
+	requests := 0
+	c := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(newTestPod()).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				tokenRequest := subResource.(*authenticationv1.TokenRequest)
+				if len(tokenRequest.Spec.Audiences) != 1 || tokenRequest.Spec.Audiences[0] != "vault" {
+					t.Errorf("unexpected audiences: %v", tokenRequest.Spec.Audiences)
+				}
+				if tokenRequest.Spec.BoundObjectRef == nil || tokenRequest.Spec.BoundObjectRef.Kind != "Pod" {
+					t.Errorf("token is not bound to the pod: %+v", tokenRequest.Spec.BoundObjectRef)
+				}
+				requests++
+				tokenRequest.Status.Token = testVaultJWT
+				tokenRequest.Status.ExpirationTimestamp = metav1.NewTime(fakeClock.Now().Add(10 * time.Minute))
+				return nil
+			},
+		}).
+		Build()
+
+	volumeSelector := &volume.SecretVolumeSelector{Class: "vault"}
+	podInfo := pod_info.NewPodInfo(c, newTestPod(), volumeSelector)
+	backend, err := NewVaultBackend(c, podInfo, volumeSelector, &secretsv1alpha1.VaultSpec{
+		Address:  server.URL,
+		Role:     testVaultRole,
+		Audience: "vault",
+	}, NewTokenCache(), fakeClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		elapsed  time.Duration
+		requests int
+	}{
+		{elapsed: 0, requests: 1},
+		// the cached token is reused
+		{elapsed: 5 * time.Minute, requests: 1},
+		// the token is refreshed after 80% of its lifetime
+		{elapsed: 3 * time.Minute, requests: 2},
+		{elapsed: time.Minute, requests: 2},
+	}
+	for i, step := range steps {
+		fakeClock.SetTime(fakeClock.Now().Add(step.elapsed))
+		if _, err := backend.GetSecretData(context.Background()); err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+		if requests != step.requests {
+			t.Errorf("step %d: got %d token requests, want %d", i, requests, step.requests)
+		}
+	}
+}
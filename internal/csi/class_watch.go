@@ -0,0 +1,124 @@
+package csi
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+)
+
+// classWatchRetryInterval is the delay before watching the secret classes again when the watch fails or ends.
+const classWatchRetryInterval = 5 * time.Second
+
+// StartSecretClassWatch runs WatchSecretClasses in the background until the context is done
+// or the node server is shut down.
+func (n *NodeServer) StartSecretClassWatch(ctx context.Context, watcher client.WithWatch) {
+	n.workers.Add(1)
+	go func() {
+		defer n.workers.Done()
+		n.WatchSecretClasses(ctx, watcher)
+	}()
+}
+
+// WatchSecretClasses rewrites the mounted volumes of a secret class when its spec is changed,
+// e.g. the CA secret reference is replaced, so the pods get the new secrets without a restart,
+// and the sidecars watching the volume see "..data" swapped like a rotation.
+// Only a change of the generation triggers the rewrite, the status updates of the controller are ignored.
+// The watch is started again when it ends, the classes changed in between are rewritten then.
+func (n *NodeServer) WatchSecretClasses(ctx context.Context, watcher client.WithWatch) {
+	logger.Info("Secret class watch started")
+
+	// generations are the last seen generations of the secret classes
+	generations := map[string]int64{}
+	for {
+		n.watchSecretClasses(ctx, watcher, generations)
+
+		select {
+		case <-ctx.Done():
+			logger.Info("Secret class watch stopped")
+			return
+		case <-n.stopCh:
+			logger.Info("Secret class watch stopped")
+			return
+		case <-n.clock.After(classWatchRetryInterval):
+		}
+	}
+}
+
+// watchSecretClasses handles the events of one watch until it ends.
+func (n *NodeServer) watchSecretClasses(ctx context.Context, watcher client.WithWatch, generations map[string]int64) {
+	w, err := watcher.Watch(ctx, &secretsv1alpha1.SecretClassList{})
+	if err != nil {
+		logger.Error(err, "Failed to watch secret classes, retry later", "interval", classWatchRetryInterval)
+		return
+	}
+	defer w.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.stopCh:
+			return
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				logger.V(1).Info("Secret class watch ended, watch again", "interval", classWatchRetryInterval)
+				return
+			}
+			secretClass, ok := event.Object.(*secretsv1alpha1.SecretClass)
+			if !ok {
+				// e.g. the status of a watch.Error event
+				continue
+			}
+
+			name := secretClass.Name
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				last, seen := generations[name]
+				generations[name] = secretClass.Generation
+				// a class seen for the first time has nothing to rewrite, no volume could be mounted without it
+				if seen && last != secretClass.Generation {
+					n.reloadSecretClass(ctx, name)
+				}
+			case watch.Deleted:
+				// the mounted volumes keep the last secrets, like the rotation of a deleted class fails
+				delete(generations, name)
+			}
+		}
+	}
+}
+
+// reloadSecretClass drops the cached secrets of the secret class, and rewrites the volumes using it.
+// A volume failing to be rewritten is retried by the rotation.
+func (n *NodeServer) reloadSecretClass(ctx context.Context, name string) {
+	n.cache.InvalidateClass(name)
+
+	mounts := n.classMounts(name)
+	logger.Info("Secret class changed, rewrite the volumes using it", "secretClass", name, "volumes", len(mounts))
+
+	now := n.clock.Now()
+	for _, m := range mounts {
+		if n.isShuttingDown() {
+			return
+		}
+		n.rotate(ctx, m, now)
+	}
+}
+
+// classMounts returns the mounted volumes using the secret class.
+func (n *NodeServer) classMounts(name string) []*mountedVolume {
+	n.mountsLock.Lock()
+	defer n.mountsLock.Unlock()
+
+	var mounts []*mountedVolume
+	for _, m := range n.mounts {
+		if slices.Contains(m.volumeSelector.SecretClasses(), name) {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts
+}
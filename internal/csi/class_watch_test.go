@@ -0,0 +1,81 @@
+package csi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/utils/mount"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+)
+
+func TestWatchSecretClasses(t *testing.T) {
+	secret := newTestSecret()
+	watcher := watch.NewFake()
+	c := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(newTestSecretClass(), newTestPod(), secret).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Watch: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) (watch.Interface, error) {
+				return watcher, nil
+			},
+		}).
+		Build()
+	n := NewNodeServer("test-node", mount.NewFakeMounter(nil), c)
+	request := newTestPublishRequest(t)
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n.WatchSecretClasses(ctx, c)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// the secret changes, the volume is only rewritten when the secret class spec changes
+	secret.Data["username"] = []byte("root")
+	if err := c.Update(context.Background(), secret); err != nil {
+		t.Fatal(err)
+	}
+
+	secretClass := func(name string, generation int64) *secretsv1alpha1.SecretClass {
+		class := newTestSecretClass()
+		class.ObjectMeta = metav1.ObjectMeta{Name: name, Generation: generation}
+		return class
+	}
+	readUsername := func() string {
+		data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), "username"))
+		if err != nil {
+			t.Fatalf("failed to read secret file: %v", err)
+		}
+		return string(data)
+	}
+
+	// the fake watcher blocks until the event is received, so an event is handled
+	// once the next one is sent
+	watcher.Add(secretClass("tls", 1))
+	watcher.Modify(secretClass("tls", 1))
+	watcher.Add(secretClass("other", 1))
+	if got := readUsername(); got != "admin" {
+		t.Errorf("expected the volume not to be rewritten without a spec change, got %q", got)
+	}
+
+	watcher.Modify(secretClass("tls", 2))
+	watcher.Modify(secretClass("other", 1))
+	if got := readUsername(); got != "root" {
+		t.Errorf("expected the volume to be rewritten after the spec change, got %q", got)
+	}
+}
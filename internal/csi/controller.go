@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"regexp"
+	"sync"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/zncdata-labs/secret-operator/pkg/volume"
@@ -25,11 +26,22 @@ var (
 			Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
 		},
 	}
+
+	// controllerCaps are the rpcs of the controller. PUBLISH_UNPUBLISH_VOLUME is not advertised, the secret volumes
+	// are tmpfs mounted by the node, there is nothing to attach, and the CSIDriver sets attachRequired: false.
+	controllerCaps = []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+	}
 )
 
+// ControllerServer provisions the volumes of PVC, the secret volume context is copied from the PVC annotations
+// to the volume context of PV, then it is delivered to the node server when the volume is published.
 type ControllerServer struct {
-	client  client.Client
-	volumes map[string]int64
+	client client.Client
+
+	volumesLock sync.Mutex
+	volumes     map[string]int64
 }
 
 var _ csi.ControllerServer = &ControllerServer{}
@@ -47,11 +59,6 @@ func (c *ControllerServer) CreateVolume(ctx context.Context, request *csi.Create
 	}
 
 	requiredCap := request.CapacityRange.GetRequiredBytes()
-	if existCap, ok := c.volumes[request.Name]; ok && existCap < requiredCap {
-		return nil, status.Errorf(codes.AlreadyExists, "Volume: %q, capacity bytes: %d", request.Name, requiredCap)
-	}
-
-	c.volumes[request.Name] = requiredCap
 
 	if request.Parameters["secretFinalizer"] == "true" {
 		logger.V(1).Info("Finalizer is true")
@@ -64,12 +71,18 @@ func (c *ControllerServer) CreateVolume(ctx context.Context, request *csi.Create
 	// - 'csi.storage.k8s.io/pvc/name'
 	// - 'csi.storage.k8s.io/pvc/namespace'
 	// ref: https://github.com/kubernetes-csi/external-provisioner?tab=readme-ov-file#command-line-options
-	volumeSelector, err := c.getVolumeContext(request.Parameters)
-
+	volumeSelector, err := c.getVolumeContext(ctx, request.Parameters)
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "Get secret Volume refer error: %v", err)
+		return nil, err
 	}
 
+	c.volumesLock.Lock()
+	defer c.volumesLock.Unlock()
+	if existCap, ok := c.volumes[request.Name]; ok && existCap < requiredCap {
+		return nil, status.Errorf(codes.AlreadyExists, "Volume: %q, capacity bytes: %d", request.Name, requiredCap)
+	}
+	c.volumes[request.Name] = requiredCap
+
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:      request.GetName(),
@@ -99,9 +112,9 @@ func validateCreateVolumeRequest(request *csi.CreateVolumeRequest) error {
 	return nil
 }
 
-func (c *ControllerServer) getPvc(name, namespace string) (*corev1.PersistentVolumeClaim, error) {
+func (c *ControllerServer) getPvc(ctx context.Context, name, namespace string) (*corev1.PersistentVolumeClaim, error) {
 	pvc := &corev1.PersistentVolumeClaim{}
-	err := c.client.Get(context.Background(), client.ObjectKey{
+	err := c.client.Get(ctx, client.ObjectKey{
 		Namespace: namespace,
 		Name:      name,
 	}, pvc)
@@ -119,7 +132,11 @@ func (c *ControllerServer) getPvc(name, namespace string) (*corev1.PersistentVol
 //     'csi.storage.k8s.io/pvc/name' and 'csi.storage.k8s.io/pvc/namespace' from params.
 //   - get PVC by k8s client with PVC name and namespace, then get annotations from PVC.
 //   - get 'secrets.zncdata.dev/class' and 'secrets.zncdata.dev/scope' from PVC annotations.
-func (c *ControllerServer) getVolumeContext(createVolumeRequestParams map[string]string) (*volume.SecretVolumeSelector, error) {
+//     The class annotation is required, so the misconfigured PVC is reported here rather than when the pod is started.
+//     Without it, the 'secretClassName' parameter of the StorageClass is the class, for the PVCs provisioned before
+//     the annotation was used.
+//   - add the PVC name and namespace, so the node can find the pod owning the PVC.
+func (c *ControllerServer) getVolumeContext(ctx context.Context, createVolumeRequestParams map[string]string) (*volume.SecretVolumeSelector, error) {
 	pvcName, pvcNameExists := createVolumeRequestParams["csi.storage.k8s.io/pvc/name"]
 	pvcNamespace, pvcNamespaceExists := createVolumeRequestParams["csi.storage.k8s.io/pvc/namespace"]
 
@@ -127,18 +144,30 @@ func (c *ControllerServer) getVolumeContext(createVolumeRequestParams map[string
 		return nil, status.Error(codes.InvalidArgument, "ensure '--extra-create-metadata' args are added in the sidecar of the csi-provisioner container.")
 	}
 
-	pvc, err := c.getPvc(pvcName, pvcNamespace)
+	pvc, err := c.getPvc(ctx, pvcName, pvcNamespace)
 	if err != nil {
 
 		return nil, status.Errorf(codes.NotFound, "PVC: %q, Namespace: %q. Detail: %v", pvcName, pvcNamespace, err)
 	}
 
-	volumeSelector, err := volume.NewVolumeSelectorFromMap(pvc.GetAnnotations())
+	annotations := pvc.GetAnnotations()
+	if class, ok := createVolumeRequestParams[volume.SecretClassName]; ok {
+		annotations = volume.MergeDefaults(annotations, map[string]string{volume.SecretClassName: class})
+	}
+	volumeSelector, err := volume.NewVolumeSelectorFromMap(annotations)
 
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "Get secret Volume refer error: %v", err)
 	}
 
+	if len(volumeSelector.SecretClasses()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "PVC: %q, Namespace: %q. Annotation %q or %q, or StorageClass parameter %q is required",
+			pvcName, pvcNamespace, volume.SecretsZncdataClass, volume.SecretsZncdataClasses, volume.SecretClassName)
+	}
+	// the node finds the pod of a generic ephemeral volume by the PVC, to pre-fetch the secret when the volume is staged
+	volumeSelector.PVCName = pvcName
+	volumeSelector.PVCNamespace = pvcNamespace
+
 	return volumeSelector, nil
 }
 
@@ -159,10 +188,15 @@ func (c *ControllerServer) DeleteVolume(ctx context.Context, request *csi.Delete
 		return &csi.DeleteVolumeResponse{}, nil
 	}
 
+	// There is nothing to clean up for the secret volume, the secret files live in the tmpfs of the node.
+	// DeleteVolume must be idempotent, so an unknown volume is not an error.
+	c.volumesLock.Lock()
+	defer c.volumesLock.Unlock()
 	if _, ok := c.volumes[request.VolumeId]; !ok {
-		// return nil, status.Errorf(codes.NotFound, "Volume ID: %q", request.VolumeId)
-		logger.V(1).Info("Volume not found, skip delete volume")
+		logger.V(1).Info("Volume not found, skip delete volume", "volumeID", request.VolumeId)
+		return &csi.DeleteVolumeResponse{}, nil
 	}
+	delete(c.volumes, request.VolumeId)
 
 	return &csi.DeleteVolumeResponse{}, nil
 }
@@ -175,12 +209,30 @@ func (c *ControllerServer) validateDeleteVolumeRequest(request *csi.DeleteVolume
 	return nil
 }
 
+// ControllerPublishVolume succeeds without doing anything, there is nothing to attach to the node.
+// It is only called when an external-attacher is deployed although PUBLISH_UNPUBLISH_VOLUME is not advertised,
+// e.g. by a CSIDriver with attachRequired: true, and must not fail the VolumeAttachment.
 func (c *ControllerServer) ControllerPublishVolume(ctx context.Context, request *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	if request.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+	if request.GetNodeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Node ID is required")
+	}
+	if request.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability is required")
+	}
+
+	return &csi.ControllerPublishVolumeResponse{}, nil
 }
 
+// ControllerUnpublishVolume succeeds without doing anything, like ControllerPublishVolume.
 func (c *ControllerServer) ControllerUnpublishVolume(ctx context.Context, request *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	if request.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
 
 func (c *ControllerServer) ValidateVolumeCapabilities(ctx context.Context, request *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
@@ -203,7 +255,9 @@ func (c *ControllerServer) ValidateVolumeCapabilities(ctx context.Context, reque
 }
 
 func (c *ControllerServer) ListVolumes(ctx context.Context, request *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	// impl list volumes
+	c.volumesLock.Lock()
+	defer c.volumesLock.Unlock()
+
 	var entries []*csi.ListVolumesResponse_Entry
 	for volumeID, size := range c.volumes {
 		entries = append(entries, &csi.ListVolumesResponse_Entry{
@@ -228,18 +282,16 @@ func (c *ControllerServer) GetCapacity(ctx context.Context, request *csi.GetCapa
 }
 
 func (c *ControllerServer) ControllerGetCapabilities(ctx context.Context, request *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
-
-	return &csi.ControllerGetCapabilitiesResponse{
-		Capabilities: []*csi.ControllerServiceCapability{
-			{
-				Type: &csi.ControllerServiceCapability_Rpc{
-					Rpc: &csi.ControllerServiceCapability_RPC{
-						Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-					},
-				},
+	capabilities := make([]*csi.ControllerServiceCapability, 0, len(controllerCaps))
+	for _, capability := range controllerCaps {
+		capabilities = append(capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{Type: capability},
 			},
-		},
-	}, nil
+		})
+	}
+
+	return &csi.ControllerGetCapabilitiesResponse{Capabilities: capabilities}, nil
 }
 
 func (c *ControllerServer) CreateSnapshot(ctx context.Context, request *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
@@ -254,8 +306,26 @@ func (c *ControllerServer) ListSnapshots(ctx context.Context, request *csi.ListS
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
+// ControllerExpandVolume records the new capacity, the tmpfs is resized by the node.
 func (c *ControllerServer) ControllerExpandVolume(ctx context.Context, request *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	if request.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+	requiredCap := request.GetCapacityRange().GetRequiredBytes()
+	if requiredCap <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "Required bytes of capacity range must be greater than zero")
+	}
+
+	c.volumesLock.Lock()
+	defer c.volumesLock.Unlock()
+	if _, ok := c.volumes[request.GetVolumeId()]; ok {
+		c.volumes[request.GetVolumeId()] = requiredCap
+	}
+
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         requiredCap,
+		NodeExpansionRequired: true,
+	}, nil
 }
 
 func (c *ControllerServer) ControllerGetVolume(ctx context.Context, request *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
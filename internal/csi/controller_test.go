@@ -0,0 +1,261 @@
+package csi
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+const testVolumeID = "pvc-12345678-1234-1234-1234-123456789012"
+
+func newTestControllerServer(t *testing.T, objs ...client.Object) *ControllerServer {
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objs...).Build()
+	return NewControllerServer(c)
+}
+
+func newTestPvc(annotations map[string]string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-pvc",
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+	}
+}
+
+func newTestCreateVolumeRequest() *csi.CreateVolumeRequest {
+	return &csi.CreateVolumeRequest{
+		Name:          testVolumeID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1024},
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			},
+		},
+		Parameters: map[string]string{
+			"csi.storage.k8s.io/pvc/name":      "test-pvc",
+			"csi.storage.k8s.io/pvc/namespace": "default",
+		},
+	}
+}
+
+func TestCreateVolume(t *testing.T) {
+	c := newTestControllerServer(t, newTestPvc(map[string]string{
+		volume.SecretsZncdataClass: "tls",
+		volume.SecretsZncdataScope: "pod,node",
+	}))
+
+	response, err := c.CreateVolume(context.Background(), newTestCreateVolumeRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	volumeContext := response.GetVolume().GetVolumeContext()
+	if volumeContext[volume.SecretsZncdataClass] != "tls" {
+		t.Errorf("unexpected class in volume context: %v", volumeContext)
+	}
+	if volumeContext[volume.SecretsZncdataScope] != "pod,node" {
+		t.Errorf("unexpected scope in volume context: %v", volumeContext)
+	}
+	if response.GetVolume().GetVolumeId() != testVolumeID || response.GetVolume().GetCapacityBytes() != 1024 {
+		t.Errorf("unexpected volume: %v", response.GetVolume())
+	}
+}
+
+func TestCreateVolumeClassFallback(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+	}{
+		{name: "annotation", annotations: map[string]string{volume.SecretsZncdataClass: "tls"}, want: "tls"},
+		{name: "storage class parameter", want: "legacy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestControllerServer(t, newTestPvc(tt.annotations))
+			request := newTestCreateVolumeRequest()
+			request.Parameters[volume.SecretClassName] = "legacy"
+
+			response, err := c.CreateVolume(context.Background(), request)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			volumeContext := response.GetVolume().GetVolumeContext()
+			if volumeContext[volume.SecretsZncdataClass] != tt.want {
+				t.Errorf("unexpected class in volume context: got %v, want %s", volumeContext, tt.want)
+			}
+			if _, ok := volumeContext[volume.SecretClassName]; ok {
+				t.Errorf("the fallback key is passed to the node: %v", volumeContext)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		objs    []client.Object
+		request func(*csi.CreateVolumeRequest)
+		code    codes.Code
+	}{
+		{
+			name:    "missing-pvc-parameters",
+			objs:    []client.Object{newTestPvc(map[string]string{volume.SecretsZncdataClass: "tls"})},
+			request: func(r *csi.CreateVolumeRequest) { r.Parameters = nil },
+			code:    codes.InvalidArgument,
+		},
+		{
+			name: "pvc-not-found",
+			code: codes.NotFound,
+		},
+		{
+			name: "missing-class-annotation",
+			objs: []client.Object{newTestPvc(nil)},
+			code: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestControllerServer(t, tt.objs...)
+			request := newTestCreateVolumeRequest()
+			if tt.request != nil {
+				tt.request(request)
+			}
+
+			_, err := c.CreateVolume(context.Background(), request)
+			if status.Code(err) != tt.code {
+				t.Errorf("unexpected error: got %v, want code %s", err, tt.code)
+			}
+			if _, ok := c.volumes[request.Name]; ok {
+				t.Errorf("volume should not be recorded when creation fails")
+			}
+		})
+	}
+}
+
+func TestDeleteVolume(t *testing.T) {
+	c := newTestControllerServer(t, newTestPvc(map[string]string{volume.SecretsZncdataClass: "tls"}))
+
+	if _, err := c.CreateVolume(context.Background(), newTestCreateVolumeRequest()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request := &csi.DeleteVolumeRequest{VolumeId: testVolumeID}
+	if _, err := c.DeleteVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := c.volumes[testVolumeID]; ok {
+		t.Errorf("volume should be removed after delete")
+	}
+
+	// delete is idempotent
+	if _, err := c.DeleteVolume(context.Background(), request); err != nil {
+		t.Errorf("unexpected error when deleting volume again: %v", err)
+	}
+}
+
+func TestControllerGetCapabilities(t *testing.T) {
+	c := newTestControllerServer(t)
+
+	response, err := c.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var types []csi.ControllerServiceCapability_RPC_Type
+	for _, capability := range response.GetCapabilities() {
+		types = append(types, capability.GetRpc().GetType())
+	}
+	want := []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+	}
+	if !slices.Equal(types, want) {
+		t.Errorf("unexpected capabilities: got %v, want %v", types, want)
+	}
+	// there is nothing to attach, the external-attacher is not required
+	if slices.Contains(types, csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME) {
+		t.Errorf("PUBLISH_UNPUBLISH_VOLUME capability must not be advertised")
+	}
+}
+
+func TestControllerPublishVolume(t *testing.T) {
+	c := newTestControllerServer(t)
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+
+	tests := []struct {
+		name    string
+		request *csi.ControllerPublishVolumeRequest
+		code    codes.Code
+	}{
+		{
+			name:    "no-op",
+			request: &csi.ControllerPublishVolumeRequest{VolumeId: testVolumeID, NodeId: "test-node", VolumeCapability: capability},
+			code:    codes.OK,
+		},
+		{
+			name:    "missing volume id",
+			request: &csi.ControllerPublishVolumeRequest{NodeId: "test-node", VolumeCapability: capability},
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "missing node id",
+			request: &csi.ControllerPublishVolumeRequest{VolumeId: testVolumeID, VolumeCapability: capability},
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "missing volume capability",
+			request: &csi.ControllerPublishVolumeRequest{VolumeId: testVolumeID, NodeId: "test-node"},
+			code:    codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := c.ControllerPublishVolume(context.Background(), tt.request)
+			if status.Code(err) != tt.code {
+				t.Fatalf("unexpected error: got %v, want code %s", err, tt.code)
+			}
+			if tt.code == codes.OK && len(response.GetPublishContext()) != 0 {
+				t.Errorf("unexpected publish context: %v", response.GetPublishContext())
+			}
+		})
+	}
+}
+
+func TestControllerUnpublishVolume(t *testing.T) {
+	c := newTestControllerServer(t)
+
+	if _, err := c.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+		VolumeId: testVolumeID, NodeId: "test-node",
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// unpublishing an unknown volume succeeds too, unpublish is idempotent
+	if _, err := c.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+		VolumeId: "unknown",
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err := c.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("unexpected error: got %v, want code %s", err, codes.InvalidArgument)
+	}
+}
@@ -3,8 +3,12 @@ package csi
 import (
 	"context"
 	"errors"
+	"time"
 
+	secretbackend "github.com/zncdata-labs/secret-operator/internal/csi/backend"
 	"github.com/zncdata-labs/secret-operator/internal/csi/version"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/mount"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
@@ -12,6 +16,12 @@ import (
 
 const (
 	DefaultDriverName = "secrets.zncdata.dev"
+
+	// shutdownTimeout bounds how long the driver waits for the rotation in progress when it stops.
+	shutdownTimeout = 30 * time.Second
+
+	// healthCheckInterval is the delay between the readiness checks until the health service reports SERVING.
+	healthCheckInterval = time.Second
 )
 
 var (
@@ -26,6 +36,147 @@ type Driver struct {
 	server NonBlockingServer
 
 	client client.Client
+
+	// rotationWindow is how long before the secret expires it is rotated, 0 disables rotation.
+	rotationWindow time.Duration
+
+	// maxSecretSize is the max total size in bytes of the secret data of a volume, nil keeps the default.
+	maxSecretSize *int64
+
+	// backendRetry is the retry policy of the transient backend failures, nil keeps the default.
+	backendRetry *secretbackend.RetryPolicy
+	// backendConcurrency are the max concurrent requests per backend type, empty does not limit them.
+	backendConcurrency map[string]int
+
+	// nodeAddressPolicy resolves the addresses of the node scope, the zero value keeps the default.
+	nodeAddressPolicy pod_info.NodeAddressPolicy
+
+	// clusterDomain is the domain of the dns names of the pods and services, empty keeps the default.
+	clusterDomain string
+
+	// classWatcher watches the secret classes to rewrite the volumes when they change, nil disables it.
+	classWatcher client.WithWatch
+
+	// recorder records the result of the publish on the pods, nil disables the events.
+	recorder record.EventRecorder
+
+	// requestTimeout is the max duration of a grpc call, 0 disables the timeout.
+	requestTimeout time.Duration
+
+	// podAnnotationDisabled skips patching the annotations of the pods.
+	podAnnotationDisabled bool
+
+	// apiReader reads the secret classes and the pods not found by the client, nil disables it.
+	apiReader client.Reader
+
+	// fifoEnabled allows the volumes writing keys as named pipes.
+	fifoEnabled bool
+
+	// kubeletDir is the root directory of kubelet, where the orphan reaper looks for the volumes of the driver.
+	kubeletDir string
+	// orphanReapInterval is the interval of the orphan reaper, 0 disables it.
+	orphanReapInterval time.Duration
+}
+
+// DriverOption configures the optional features of the driver.
+type DriverOption func(*Driver)
+
+// WithRotationWindow enables the rotation of mounted secrets which expire within the window.
+func WithRotationWindow(window time.Duration) DriverOption {
+	return func(d *Driver) {
+		d.rotationWindow = window
+	}
+}
+
+// WithMaxSecretSize limits the total size in bytes of the secret data of a volume, 0 disables the limit.
+func WithMaxSecretSize(size int64) DriverOption {
+	return func(d *Driver) {
+		d.maxSecretSize = &size
+	}
+}
+
+// WithBackendRetry retries the transient backend failures with exponential backoff.
+func WithBackendRetry(policy secretbackend.RetryPolicy) DriverOption {
+	return func(d *Driver) {
+		d.backendRetry = &policy
+	}
+}
+
+// WithBackendConcurrency limits the concurrent requests per backend type, e.g. {"vault": 10}.
+func WithBackendConcurrency(limits map[string]int) DriverOption {
+	return func(d *Driver) {
+		d.backendConcurrency = limits
+	}
+}
+
+// WithNodeAddressPolicy replaces how the addresses of the node scope are resolved, e.g. when the node name
+// is not the routable hostname used in the node scoped certificates.
+func WithNodeAddressPolicy(policy pod_info.NodeAddressPolicy) DriverOption {
+	return func(d *Driver) {
+		d.nodeAddressPolicy = policy
+	}
+}
+
+// WithClusterDomain replaces the cluster domain of the dns names of the pods and services, e.g. when the cluster
+// does not use "cluster.local".
+func WithClusterDomain(domain string) DriverOption {
+	return func(d *Driver) {
+		d.clusterDomain = domain
+	}
+}
+
+// WithSecretClassWatch rewrites the mounted volumes of a secret class when it is changed.
+// The watcher is usually a client without cache, the client of the manager can not watch.
+func WithSecretClassWatch(watcher client.WithWatch) DriverOption {
+	return func(d *Driver) {
+		d.classWatcher = watcher
+	}
+}
+
+// WithEventRecorder records the result of the volume publish, e.g. the failure reason, as an event on the pod.
+func WithEventRecorder(recorder record.EventRecorder) DriverOption {
+	return func(d *Driver) {
+		d.recorder = recorder
+	}
+}
+
+// WithRequestTimeout cancels the grpc calls after the timeout, so a hanging backend does not block them forever.
+func WithRequestTimeout(timeout time.Duration) DriverOption {
+	return func(d *Driver) {
+		d.requestTimeout = timeout
+	}
+}
+
+// WithPodAnnotationDisabled skips patching the expiration time and content hash annotations of the pods,
+// so the driver does not need to patch the pods.
+func WithPodAnnotationDisabled(disabled bool) DriverOption {
+	return func(d *Driver) {
+		d.podAnnotationDisabled = disabled
+	}
+}
+
+// WithAPIReader reads the secret classes and the pods from the API server when the client, reading from a cache,
+// does not find them, e.g. before the cache is synced.
+func WithAPIReader(reader client.Reader) DriverOption {
+	return func(d *Driver) {
+		d.apiReader = reader
+	}
+}
+
+// WithFIFOEnabled allows the volumes with the fifo format, the driver serves their named pipes.
+func WithFIFOEnabled(enabled bool) DriverOption {
+	return func(d *Driver) {
+		d.fifoEnabled = enabled
+	}
+}
+
+// WithOrphanReaper unmounts the volumes of the driver under the pods directory of kubelet whose pod is gone,
+// checked every interval, 0 disables it.
+func WithOrphanReaper(kubeletDir string, interval time.Duration) DriverOption {
+	return func(d *Driver) {
+		d.kubeletDir = kubeletDir
+		d.orphanReapInterval = interval
+	}
 }
 
 func NewDriver(
@@ -33,16 +184,21 @@ func NewDriver(
 	nodeID string,
 	endpoint string,
 	client client.Client,
+	opts ...DriverOption,
 ) *Driver {
-	srv := NewNonBlockingServer()
-
-	return &Driver{
+	d := &Driver{
 		name:     name,
 		nodeID:   nodeID,
 		endpoint: endpoint,
-		server:   srv,
 		client:   client,
 	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.server = NewNonBlockingServer(d.requestTimeout)
+
+	return d
 }
 
 func (d *Driver) Run(ctx context.Context, testMode bool) error {
@@ -59,15 +215,52 @@ func (d *Driver) Run(ctx context.Context, testMode bool) error {
 		mount.New(""),
 		d.client,
 	)
+	if d.maxSecretSize != nil {
+		ns.WithMaxSecretSize(*d.maxSecretSize)
+	}
+	if d.backendRetry != nil {
+		ns.WithBackendRetry(*d.backendRetry)
+	}
+	if len(d.backendConcurrency) > 0 {
+		ns.WithBackendConcurrency(d.backendConcurrency)
+	}
+	ns.WithNodeAddressPolicy(d.nodeAddressPolicy)
+	ns.WithClusterDomain(d.clusterDomain)
+	ns.WithEventRecorder(d.recorder)
+	ns.WithPodAnnotationDisabled(d.podAnnotationDisabled)
+	ns.WithAPIReader(d.apiReader)
+	ns.WithFIFOEnabled(d.fifoEnabled)
 
-	is := NewIdentityServer(d.name, version.BuildVersion)
+	is := NewIdentityServer(d.name, version.BuildVersion, d.client)
 	cs := NewControllerServer(d.client)
 
-	d.server.Start(d.endpoint, is, cs, ns, testMode)
+	if err := d.server.Start(d.endpoint, is, cs, ns, testMode); err != nil {
+		return err
+	}
+	go reportHealth(ctx, d.server, is)
 
-	// Gracefully stop the server when the context is done
+	// the rotation and the secret class watch are stopped by the shutdown of the node server, not the context,
+	// so the rotation in progress is not interrupted
+	if d.rotationWindow > 0 {
+		ns.StartRotation(context.WithoutCancel(ctx), d.rotationWindow)
+	}
+	if d.orphanReapInterval > 0 {
+		ns.StartOrphanReaper(context.WithoutCancel(ctx), d.kubeletDir, d.name, d.orphanReapInterval)
+	}
+	if d.classWatcher != nil {
+		ns.StartSecretClassWatch(context.WithoutCancel(ctx), d.classWatcher)
+	}
+
+	// Gracefully stop the server when the context is done, the node server rejects new volumes first,
+	// then the in-flight requests and the rotation in progress finish.
 	go func() {
 		<-ctx.Done()
+		d.server.SetServing(false)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := ns.Shutdown(shutdownCtx); err != nil {
+			logger.Error(err, "Failed to shut down node server gracefully")
+		}
 		d.server.Stop()
 	}()
 
@@ -76,6 +269,32 @@ func (d *Driver) Run(ctx context.Context, testMode bool) error {
 	return nil
 }
 
+// reportHealth reports SERVING once the readiness checks of the Probe pass, i.e. the cache of the client is synced
+// and the backends are validated. The checks are retried every healthCheckInterval until the context is done.
+func reportHealth(ctx context.Context, server NonBlockingServer, is *IdentityServer) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		err := is.checkReady(ctx)
+		// the shutdown has started, the health service keeps reporting NOT_SERVING
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			logger.V(0).Info("Plugin is ready, report the health service serving")
+			server.SetServing(true)
+			return
+		}
+		logger.V(1).Info("Plugin is not ready, report the health service not serving", "reason", err.Error())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func (d *Driver) Stop() {
 	d.server.Stop()
 }
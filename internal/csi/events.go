@@ -0,0 +1,120 @@
+package csi
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// publishEventInterval is the min interval between two events of the same reason on a pod,
+// kubelet retries a failed publish every few seconds at first.
+const publishEventInterval = time.Minute
+
+// Reasons of the events recorded on the pod by NodePublishVolume.
+const (
+	EventReasonSecretPublished     = "SecretPublished"
+	EventReasonSecretNotFound      = "SecretNotFound"
+	EventReasonSecretClassInvalid  = "SecretClassInvalid"
+	EventReasonInvalidVolume       = "InvalidVolume"
+	EventReasonNamespaceNotAllowed = "NamespaceNotAllowed"
+	EventReasonBackendUnavailable  = "BackendUnavailable"
+	EventReasonPermissionDenied    = "PermissionDenied"
+	EventReasonBackendTimeout      = "BackendTimeout"
+	EventReasonSecretTooLarge      = "SecretTooLarge"
+	EventReasonPublishFailed       = "PublishFailed"
+)
+
+type publishEventKey struct {
+	pod    types.NamespacedName
+	reason string
+}
+
+// publishEventReason returns the reason of the event of a publish failed with the grpc status error.
+func publishEventReason(err error) string {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return EventReasonSecretNotFound
+	case codes.FailedPrecondition:
+		return EventReasonSecretClassInvalid
+	case codes.InvalidArgument:
+		return EventReasonInvalidVolume
+	case codes.PermissionDenied:
+		if errors.As(err, new(*namespaceNotAllowedError)) {
+			return EventReasonNamespaceNotAllowed
+		}
+		return EventReasonPermissionDenied
+	case codes.Unavailable:
+		return EventReasonBackendUnavailable
+	case codes.DeadlineExceeded:
+		return EventReasonBackendTimeout
+	case codes.ResourceExhausted:
+		return EventReasonSecretTooLarge
+	default:
+		return EventReasonPublishFailed
+	}
+}
+
+// recordPublishEvent records an event on the pod of the volume with the result and the latency of the publish,
+// so the users see why the volume failed to mount, instead of the generic FailedMount of kubelet.
+// The events of the same reason on a pod are recorded at most once per publishEventInterval.
+func (n *NodeServer) recordPublishEvent(volumeSelector *volume.SecretVolumeSelector, backendType string, latency time.Duration, err error) {
+	if n.recorder == nil || volumeSelector == nil || volumeSelector.Pod == "" || volumeSelector.PodNamespace == "" {
+		return
+	}
+	// the pod being deleted is gone soon, the event would only be noise
+	if errors.As(err, new(*podTerminatingError)) {
+		return
+	}
+
+	eventType, reason := corev1.EventTypeNormal, EventReasonSecretPublished
+	if err != nil {
+		eventType, reason = corev1.EventTypeWarning, publishEventReason(err)
+	}
+	if !n.allowPublishEvent(publishEventKey{
+		pod:    types.NamespacedName{Namespace: volumeSelector.PodNamespace, Name: volumeSelector.Pod},
+		reason: reason,
+	}) {
+		return
+	}
+
+	pod := &corev1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Name:       volumeSelector.Pod,
+		Namespace:  volumeSelector.PodNamespace,
+		UID:        types.UID(volumeSelector.PodUID),
+	}
+	source := fmt.Sprintf("SecretClasses %v (backend %s)", volumeSelector.SecretClasses(), backendType)
+	if err != nil {
+		n.recorder.Eventf(pod, eventType, reason, "Failed to publish the secret of %s after %s: %s",
+			source, latency.Round(time.Millisecond), status.Convert(err).Message())
+		return
+	}
+	n.recorder.Eventf(pod, eventType, reason, "Published the secret of %s in %s", source, latency.Round(time.Millisecond))
+}
+
+// allowPublishEvent returns whether the event can be recorded now, and records its time.
+func (n *NodeServer) allowPublishEvent(key publishEventKey) bool {
+	now := n.clock.Now()
+
+	n.eventsLock.Lock()
+	defer n.eventsLock.Unlock()
+
+	for k, last := range n.events {
+		if now.Sub(last) >= publishEventInterval {
+			delete(n.events, k)
+		}
+	}
+	if _, ok := n.events[key]; ok {
+		return false
+	}
+	n.events[key] = now
+	return true
+}
@@ -0,0 +1,80 @@
+package csi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+
+	secretbackend "github.com/zncdata-labs/secret-operator/internal/csi/backend"
+)
+
+func TestNodePublishVolumeEvents(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakeClock(now)
+	recorder := record.NewFakeRecorder(10)
+	// the secret class is missing
+	n := newTestNodeServer(t, newTestPod()).WithClock(clock).WithEventRecorder(recorder)
+
+	publish := func() {
+		if _, err := n.NodePublishVolume(context.Background(), newTestPublishRequest(t)); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+
+	publish()
+	select {
+	case event := <-recorder.Events:
+		for _, want := range []string{"Warning", EventReasonSecretNotFound, "[tls]"} {
+			if !strings.Contains(event, want) {
+				t.Errorf("unexpected event: got %q, want %q in it", event, want)
+			}
+		}
+	default:
+		t.Fatal("no event recorded on failure")
+	}
+
+	// kubelet retries the failed publish
+	publish()
+	if len(recorder.Events) != 0 {
+		t.Errorf("unexpected event recorded within %s: %q", publishEventInterval, <-recorder.Events)
+	}
+
+	clock.Step(publishEventInterval)
+	publish()
+	if len(recorder.Events) != 1 {
+		t.Errorf("unexpected events after %s: got %d, want 1", publishEventInterval, len(recorder.Events))
+	}
+}
+
+func TestNodePublishVolumeEventPublished(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), newTestSecret()).WithEventRecorder(recorder)
+
+	if _, err := n.NodePublishVolume(context.Background(), newTestPublishRequest(t)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Normal "+EventReasonSecretPublished) {
+			t.Errorf("unexpected event: %q", event)
+		}
+	default:
+		t.Fatal("no event recorded on success")
+	}
+}
+
+func TestPublishEventReasonPermissionDenied(t *testing.T) {
+	namespaceErr := &namespaceNotAllowedError{namespace: "default", secretClass: "tls"}
+	if reason := publishEventReason(namespaceErr); reason != EventReasonNamespaceNotAllowed {
+		t.Errorf("unexpected reason: got %s, want %s", reason, EventReasonNamespaceNotAllowed)
+	}
+	backendErr := backendStatusError(fmt.Errorf("vault: %w", secretbackend.ErrPermissionDenied))
+	if reason := publishEventReason(backendErr); reason != EventReasonPermissionDenied {
+		t.Errorf("unexpected reason: got %s, want %s", reason, EventReasonPermissionDenied)
+	}
+}
@@ -0,0 +1,130 @@
+package csi
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fifo is a named pipe of the volume, the secret value is written once, to the first reader opening it,
+// so it never lands in a file or the page cache. The next readers block until the volume is unpublished,
+// nothing writes to the pipe any more.
+type fifo struct {
+	path  string
+	value []byte
+
+	lock    sync.Mutex
+	file    *os.File
+	stopped bool
+	done    chan struct{}
+}
+
+// createFIFOs creates a named pipe for each key in the directory, with the mode and owner of the secret files,
+// and serves the value of the key in the background. The pipes already created are stopped on failure.
+func createFIFOs(dir string, data map[string][]byte, keys []string, mode fs.FileMode, uid, gid int) (fifos []*fifo, err error) {
+	defer func() {
+		if err != nil {
+			stopFIFOs(fifos)
+		}
+	}()
+
+	for _, key := range keys {
+		value, ok := data[key]
+		if !ok {
+			return fifos, fmt.Errorf("fifo key %q is not in the secret data", key)
+		}
+		path := filepath.Join(dir, key)
+		if err := unix.Mkfifo(path, uint32(mode.Perm())); err != nil {
+			return fifos, fmt.Errorf("failed to create fifo %s: %w", path, err)
+		}
+		// mkfifo applies umask to the mode, so set it explicitly
+		if err := os.Chmod(path, mode); err != nil {
+			return fifos, err
+		}
+		if err := chown(path, uid, gid); err != nil {
+			return fifos, err
+		}
+
+		f := &fifo{path: path, value: value, done: make(chan struct{})}
+		go f.serve()
+		fifos = append(fifos, f)
+		logger.V(5).Info("Fifo created", "file", path)
+	}
+	return fifos, nil
+}
+
+// withoutFIFOKeys returns the data without the keys written as named pipes, the files written by writeData.
+func withoutFIFOKeys(data map[string][]byte, keys []string) map[string][]byte {
+	if len(keys) == 0 {
+		return data
+	}
+	files := make(map[string][]byte, len(data))
+	for name, content := range data {
+		files[name] = content
+	}
+	for _, key := range keys {
+		delete(files, key)
+	}
+	return files
+}
+
+// serve waits for the first reader of the pipe, and writes the value to it.
+func (f *fifo) serve() {
+	defer close(f.done)
+
+	// opening the write end blocks until a reader opens the pipe
+	file, err := os.OpenFile(f.path, os.O_WRONLY, 0)
+	if err != nil {
+		logger.Error(err, "Failed to open fifo", "file", f.path)
+		return
+	}
+	f.lock.Lock()
+	if f.stopped {
+		f.lock.Unlock()
+		_ = file.Close()
+		return
+	}
+	f.file = file
+	f.lock.Unlock()
+
+	_, err = file.Write(f.value)
+	f.lock.Lock()
+	_ = file.Close()
+	f.file = nil
+	f.lock.Unlock()
+	if err != nil {
+		logger.Error(err, "Failed to write fifo", "file", f.path)
+		return
+	}
+	logger.V(1).Info("Fifo read", "file", f.path)
+}
+
+// stop aborts the pending write of the pipe, and waits for serve to return, so the volume can be unmounted.
+func (f *fifo) stop() {
+	f.lock.Lock()
+	f.stopped = true
+	if f.file != nil {
+		// the reader stopped reading a value larger than the pipe buffer
+		_ = f.file.SetWriteDeadline(time.Unix(1, 0))
+	}
+	f.lock.Unlock()
+
+	// a reader unblocks the writer waiting for one, it is kept open until serve returns
+	// in case serve did not start waiting yet
+	reader, err := os.OpenFile(f.path, os.O_RDONLY|unix.O_NONBLOCK, 0)
+	<-f.done
+	if err == nil {
+		_ = reader.Close()
+	}
+}
+
+func stopFIFOs(fifos []*fifo) {
+	for _, f := range fifos {
+		f.stop()
+	}
+}
@@ -2,24 +2,51 @@ package csi
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	secretbackend "github.com/zncdata-labs/secret-operator/internal/csi/backend"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
 )
 
 var _ csi.IdentityServer = &IdentityServer{}
 
+const (
+	// probeCacheTTL is how long the result of the readiness checks is reused,
+	// so frequent probes do not hammer the api server and vault.
+	probeCacheTTL = 5 * time.Second
+
+	// probeTimeout bounds the readiness checks of a probe.
+	probeTimeout = 3 * time.Second
+)
+
 type IdentityServer struct {
 	name    string
 	version string
+
+	client client.Client
+	clock  clock.PassiveClock
+
+	probeLock  sync.Mutex
+	probedAt   time.Time
+	probeError error
 }
 
-func NewIdentityServer(name, version string) *IdentityServer {
+func NewIdentityServer(name, version string, client client.Client) *IdentityServer {
 	return &IdentityServer{
 		name:    name,
 		version: version,
+		client:  client,
+		clock:   clock.RealClock{},
 	}
 
 }
@@ -50,10 +77,64 @@ func (i *IdentityServer) GetPluginCapabilities(ctx context.Context, request *csi
 					},
 				},
 			},
+			{
+				Type: &csi.PluginCapability_VolumeExpansion_{
+					VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+						Type: csi.PluginCapability_VolumeExpansion_ONLINE,
+					},
+				},
+			},
 		},
 	}, nil
 }
 
+// Probe reports the plugin is ready when its dependencies are reachable: the SecretClasses can be listed,
+// which also fails until the cache of the client is synced during startup, and the vault servers of
+// the SecretClasses are healthy. The result is cached for probeCacheTTL.
 func (i *IdentityServer) Probe(ctx context.Context, request *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	if err := i.checkReady(ctx); err != nil {
+		logger.V(1).Info("Plugin is not ready", "reason", err.Error())
+		return &csi.ProbeResponse{Ready: &wrappers.BoolValue{Value: false}}, nil
+	}
 	return &csi.ProbeResponse{Ready: &wrappers.BoolValue{Value: true}}, nil
 }
+
+// checkReady returns the cached result of the readiness checks, and runs them again when it is stale.
+// Concurrent probes wait for the checks in progress instead of running them again.
+func (i *IdentityServer) checkReady(ctx context.Context) error {
+	if i.client == nil {
+		return nil
+	}
+
+	i.probeLock.Lock()
+	defer i.probeLock.Unlock()
+
+	if !i.probedAt.IsZero() && i.clock.Since(i.probedAt) < probeCacheTTL {
+		return i.probeError
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	i.probeError = i.runChecks(ctx)
+	i.probedAt = i.clock.Now()
+	return i.probeError
+}
+
+func (i *IdentityServer) runChecks(ctx context.Context) error {
+	secretClasses := &secretsv1alpha1.SecretClassList{}
+	if err := i.client.List(ctx, secretClasses); err != nil {
+		return fmt.Errorf("failed to list secret classes: %w", err)
+	}
+
+	for idx := range secretClasses.Items {
+		secretClass := &secretClasses.Items[idx]
+		if secretbackend.BackendType(secretClass) != secretbackend.BackendTypeVault {
+			continue
+		}
+		backend := secretbackend.NewBackend(i.client, nil, &volume.SecretVolumeSelector{Class: secretClass.Name}, secretClass)
+		if err := backend.Validate(ctx); err != nil {
+			return fmt.Errorf("secret class %s: %w", secretClass.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package csi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+)
+
+func newTestVaultSecretClass(address string) *secretsv1alpha1.SecretClass {
+	return &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault"},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{
+				Vault: &secretsv1alpha1.VaultSpec{Address: address, Role: "app"},
+			},
+		},
+	}
+}
+
+func probeReady(t *testing.T, i *IdentityServer) bool {
+	response, err := i.Probe(context.Background(), &csi.ProbeRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return response.GetReady().GetValue()
+}
+
+func TestProbe(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"initialized":true,"sealed":false}`))
+	}))
+	defer healthy.Close()
+	sealed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"initialized":true,"sealed":true}`))
+	}))
+	defer sealed.Close()
+
+	tests := []struct {
+		name    string
+		objs    []client.Object
+		listErr error
+		ready   bool
+	}{
+		{
+			name:  "no secret class",
+			ready: true,
+		},
+		{
+			name:  "secret classes without vault",
+			objs:  []client.Object{newTestSecretClass()},
+			ready: true,
+		},
+		{
+			name:  "vault healthy",
+			objs:  []client.Object{newTestSecretClass(), newTestVaultSecretClass(healthy.URL)},
+			ready: true,
+		},
+		{
+			name:  "vault sealed",
+			objs:  []client.Object{newTestSecretClass(), newTestVaultSecretClass(sealed.URL)},
+			ready: false,
+		},
+		{
+			name:    "cache not synced",
+			listErr: errors.New("the cache is not started, can not read objects"),
+			ready:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(tt.objs...).
+				WithInterceptorFuncs(interceptor.Funcs{
+					List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+						if tt.listErr != nil {
+							return tt.listErr
+						}
+						return c.List(ctx, list, opts...)
+					},
+				}).
+				Build()
+			i := NewIdentityServer("test", "v0.0.1", c)
+
+			if ready := probeReady(t, i); ready != tt.ready {
+				t.Errorf("unexpected ready: got %t, want %t", ready, tt.ready)
+			}
+		})
+	}
+}
+
+func TestProbeCached(t *testing.T) {
+	lists := 0
+	c := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				lists++
+				return c.List(ctx, list, opts...)
+			},
+		}).
+		Build()
+	clock := clocktesting.NewFakePassiveClock(time.Now())
+	i := NewIdentityServer("test", "v0.0.1", c)
+	i.clock = clock
+
+	for n := 0; n < 3; n++ {
+		if !probeReady(t, i) {
+			t.Fatalf("expected the plugin to be ready")
+		}
+	}
+	if lists != 1 {
+		t.Errorf("expected the checks to run once, got %d", lists)
+	}
+
+	clock.SetTime(clock.Now().Add(probeCacheTTL))
+	probeReady(t, i)
+	if lists != 2 {
+		t.Errorf("expected the checks to run again after the cache expires, got %d", lists)
+	}
+}
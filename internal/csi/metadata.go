@@ -0,0 +1,142 @@
+package csi
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// metadataFileName is the file describing the secrets of the volume, written when
+// secrets.zncdata.dev/emitMetadata is "true".
+const metadataFileName = "secret-metadata.json"
+
+// secretMetadata describes where the secrets of a volume come from, to audit and debug stale mounts.
+type secretMetadata struct {
+	SecretClasses []string   `json:"secretClasses"`
+	BackendType   string     `json:"backendType"`
+	IssuedAt      time.Time  `json:"issuedAt"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+	PodUID        string     `json:"podUID"`
+	ContentHash   string     `json:"contentHash"`
+}
+
+// contentHash returns the SHA-256 checksum of the secret data and the options converting it to the files,
+// "sha256:<hex>". The data is hashed before the conversion, the keystore formats are encrypted with random
+// salts, so the files converted from the same data differ every time.
+// The keys and the options are hashed in order, each name and value prefixed with its length,
+// so the hash only depends on their content and not on the order of the maps.
+func contentHash(data map[string][]byte, options map[string]string) string {
+	h := sha256.New()
+	writeHashEntries(h, data)
+	converted := make(map[string][]byte, len(options))
+	for name, value := range options {
+		converted[name] = []byte(value)
+	}
+	writeHashEntries(h, converted)
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// writeHashEntries writes the count of the entries and the entries sorted by name, so the entries of the data
+// can not be taken for options.
+func writeHashEntries(w io.Writer, entries map[string][]byte) {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	_ = binary.Write(w, binary.BigEndian, uint64(len(names)))
+	for _, name := range names {
+		for _, b := range [][]byte{[]byte(name), entries[name]} {
+			_ = binary.Write(w, binary.BigEndian, uint64(len(b)))
+			_, _ = w.Write(b)
+		}
+	}
+}
+
+// conversionOptions returns the options of the volume converting the secret data to the files, the volume
+// attributes and the templates, to be hashed with the data.
+func conversionOptions(volumeSelector *volume.SecretVolumeSelector, templates map[string]string) map[string]string {
+	options := volumeSelector.ToMap()
+	for file, template := range templates {
+		options["template:"+file] = template
+	}
+	return options
+}
+
+// podVolumeName returns the name of the pod volume of the target path, kubelet publishes the csi volumes
+// to /var/lib/kubelet/pods/<uid>/volumes/kubernetes.io~csi/<volume>/mount.
+func podVolumeName(targetPath string) string {
+	return filepath.Base(filepath.Dir(filepath.Clean(targetPath)))
+}
+
+// setContentHashAnnotation records the content hash of the volume in the annotations of the pod,
+// and returns whether the annotation changed. The hashes of the other volumes of the pod are kept.
+func setContentHashAnnotation(annotations map[string]string, volumeName, hash string) (bool, error) {
+	hashes := map[string]string{}
+	if existing := annotations[volume.SecretZncdataContentHash]; existing != "" {
+		if err := json.Unmarshal([]byte(existing), &hashes); err != nil {
+			// a malformed annotation is replaced, it is only informative
+			logger.V(1).Info("Ignore malformed content hash annotation", "annotation", existing, "error", err.Error())
+			hashes = map[string]string{}
+		}
+	}
+	if hashes[volumeName] == hash {
+		return false, nil
+	}
+	hashes[volumeName] = hash
+
+	value, err := json.Marshal(hashes)
+	if err != nil {
+		return false, err
+	}
+	annotations[volume.SecretZncdataContentHash] = string(value)
+	return true, nil
+}
+
+// addMetadata adds the metadata file to the secret data of the volume.
+// A secret key with the same name is refused, the metadata must not hide a secret.
+func addMetadata(
+	data map[string][]byte,
+	secretClasses []*secretsv1alpha1.SecretClass,
+	pod *corev1.Pod,
+	issuedAt time.Time,
+	expiresTime *int64,
+	hash string,
+) error {
+	if _, ok := data[metadataFileName]; ok {
+		return fmt.Errorf("key %q of the secret data conflicts with the metadata file", metadataFileName)
+	}
+
+	metadata := secretMetadata{
+		BackendType: volumeBackendType(secretClasses),
+		IssuedAt:    issuedAt.UTC(),
+		PodUID:      string(pod.GetUID()),
+		ContentHash: hash,
+	}
+	for _, secretClass := range secretClasses {
+		metadata.SecretClasses = append(metadata.SecretClasses, secretClass.Name)
+	}
+	if expiresTime != nil {
+		expiresAt := time.Unix(*expiresTime, 0).UTC()
+		metadata.ExpiresAt = &expiresAt
+	}
+
+	content, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	data[metadataFileName] = append(content, '\n')
+	return nil
+}
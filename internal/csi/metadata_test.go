@@ -0,0 +1,91 @@
+package csi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func TestContentHash(t *testing.T) {
+	data := map[string][]byte{
+		"tls.crt": []byte("cert"),
+		"tls.key": []byte("key"),
+		"ca.crt":  []byte("ca"),
+	}
+	options := map[string]string{"secrets.zncdata.dev/format": "tls-pkcs12"}
+	hash := contentHash(data, options)
+
+	// the same files inserted in another order, maps are iterated randomly
+	for i := 0; i < 10; i++ {
+		reordered := map[string][]byte{}
+		for _, name := range []string{"ca.crt", "tls.key", "tls.crt"} {
+			reordered[name] = data[name]
+		}
+		if got := contentHash(reordered, options); got != hash {
+			t.Fatalf("hash is not stable: got %s, want %s", got, hash)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		options map[string]string
+	}{
+		{name: "changed content", data: map[string][]byte{"tls.crt": []byte("cert2"), "tls.key": []byte("key"), "ca.crt": []byte("ca")}},
+		{name: "renamed file", data: map[string][]byte{"tls.pem": []byte("cert"), "tls.key": []byte("key"), "ca.crt": []byte("ca")}},
+		{name: "missing file", data: map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")}},
+		// the boundary between the name and the content is part of the hash
+		{name: "shifted boundary", data: map[string][]byte{"tls.crtc": []byte("ert"), "tls.key": []byte("key"), "ca.crt": []byte("ca")}},
+		{name: "changed option", data: data, options: map[string]string{"secrets.zncdata.dev/format": "tls-jks"}},
+		{name: "missing option", data: data, options: map[string]string{}},
+		// an option can not be taken for a key of the data
+		{name: "option as data", data: map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key"), "ca.crt": []byte("ca"),
+			"secrets.zncdata.dev/format": []byte("tls-pkcs12")}, options: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := tt.options
+			if options == nil {
+				options = map[string]string{"secrets.zncdata.dev/format": "tls-pkcs12"}
+			}
+			if got := contentHash(tt.data, options); got == hash {
+				t.Errorf("hash of different files is the same: %s", got)
+			}
+		})
+	}
+}
+
+func TestSetContentHashAnnotation(t *testing.T) {
+	annotations := map[string]string{
+		volume.SecretZncdataContentHash: `{"tls":"sha256:old","keytab":"sha256:keytab"}`,
+	}
+
+	changed, err := setContentHashAnnotation(annotations, "tls", "sha256:new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("expected the annotation to change")
+	}
+	hashes := map[string]string{}
+	if err := json.Unmarshal([]byte(annotations[volume.SecretZncdataContentHash]), &hashes); err != nil {
+		t.Fatal(err)
+	}
+	if hashes["tls"] != "sha256:new" || hashes["keytab"] != "sha256:keytab" {
+		t.Errorf("unexpected hashes: %v", hashes)
+	}
+
+	if changed, err := setContentHashAnnotation(annotations, "tls", "sha256:new"); err != nil || changed {
+		t.Errorf("expected no change, got changed %t, error %v", changed, err)
+	}
+
+	// a malformed annotation is replaced
+	annotations[volume.SecretZncdataContentHash] = "sha256:new"
+	if changed, err := setContentHashAnnotation(annotations, "tls", "sha256:new"); err != nil || !changed {
+		t.Errorf("expected the malformed annotation to be replaced, got changed %t, error %v", changed, err)
+	}
+	if got := annotations[volume.SecretZncdataContentHash]; got != `{"tls":"sha256:new"}` {
+		t.Errorf("unexpected annotation: %s", got)
+	}
+}
@@ -0,0 +1,92 @@
+package csi
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Metrics of the node server, they are registered to the controller-runtime registry,
+// so they are served by the metrics endpoint of the manager, see --metrics-bind-address.
+const (
+	metricsNamespace = "secret_csi"
+	metricsSubsystem = "node"
+
+	// backendTypeMixed labels the volumes combining secret classes of different backends.
+	backendTypeMixed = "mixed"
+)
+
+var (
+	publishVolumeTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "publish_volume_total",
+			Help:      "Total number of NodePublishVolume calls by backend type and grpc code.",
+		},
+		[]string{"backend", "code"},
+	)
+
+	secretFetchDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "secret_fetch_duration_seconds",
+			Help:      "Latency of getting the secret data from the backend.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"backend"},
+	)
+
+	secretBytesWritten = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "secret_bytes_written_total",
+			Help:      "Total bytes of secret files written to the volumes.",
+		},
+		[]string{"backend"},
+	)
+
+	activeMounts = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "active_mounts",
+			Help:      "Number of secret volumes currently published on the node.",
+		},
+	)
+
+	orphanMountsReaped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "orphan_mounts_reaped_total",
+			Help:      "Total number of volumes unmounted by the orphan reaper after their pod was gone.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		publishVolumeTotal,
+		secretFetchDuration,
+		secretBytesWritten,
+		activeMounts,
+		orphanMountsReaped,
+	)
+}
+
+// recordPublishVolume records the result of NodePublishVolume, err is a grpc status error or nil.
+func recordPublishVolume(backendType string, err error) {
+	publishVolumeTotal.WithLabelValues(backendType, status.Code(err).String()).Inc()
+}
+
+// dataSize returns the total bytes of the secret data.
+func dataSize(data map[string][]byte) int {
+	size := 0
+	for _, content := range data {
+		size += len(content)
+	}
+	return size
+}
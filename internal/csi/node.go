@@ -2,33 +2,132 @@ package csi
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"io/fs"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
 	"k8s.io/utils/mount"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
 	secretbackend "github.com/zncdata-labs/secret-operator/internal/csi/backend"
 
+	"github.com/zncdata-labs/secret-operator/pkg/format"
 	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/util"
 	"github.com/zncdata-labs/secret-operator/pkg/volume"
 )
 
 var _ csi.NodeServer = &NodeServer{}
 
+// defaultTmpfsSizeLimit is the tmpfs size used when the volume context
+// does not specify secrets.zncdata.dev/sizeLimit.
+var defaultTmpfsSizeLimit = resource.MustParse("1Mi")
+
+// The filesystems of the secret volumes.
+const (
+	fsTypeTmpfs = "tmpfs"
+	// fsTypeRamfs is never swapped to disk, it has no size limit.
+	fsTypeRamfs = "ramfs"
+)
+
+// defaultFileMode is the permission of secret files when the volume context
+// does not specify secrets.zncdata.dev/mode.
+const defaultFileMode fs.FileMode = 0644
+
+// defaultMaxSecretSize is the max total size of the secret data of a volume, see NodeServer.WithMaxSecretSize.
+var defaultMaxSecretSize = resource.MustParse("8Mi")
+
+// defaultBackendRetry retries the transient backend failures within the publish, see NodeServer.WithBackendRetry.
+var defaultBackendRetry = secretbackend.RetryPolicy{MaxAttempts: 3, BaseDelay: 200 * time.Millisecond}
+
+// secretCacheTTL is how long the secret data fetched from the backend is reused by the volumes of the same pod.
+const secretCacheTTL = 30 * time.Second
+
 type NodeServer struct {
 	mounter mount.Interface
 	nodeID  string
 	client  client.Client
+
+	// mounts are the published volumes keyed by target path, used to rotate the secrets.
+	mounts     map[string]*mountedVolume
+	mountsLock sync.Mutex
+	// refreshLock serializes the rewrites of the mounted volumes by the rotation and the secret class watch.
+	refreshLock sync.Mutex
+
+	cache *secretbackend.Cache
+	// tokens are the service account tokens used to authenticate to the backends, reused until they are refreshed.
+	tokens *secretbackend.TokenCache
+
+	// staged are the secrets pre-fetched by NodeStageVolume keyed by staging path, used once by the publish.
+	staged     map[string]*stagedVolume
+	stagedLock sync.Mutex
+
+	// maxSecretSize is the max total size in bytes of the secret data of a volume, 0 disables the check.
+	maxSecretSize int64
+
+	// retry is the retry policy of the transient backend failures.
+	retry secretbackend.RetryPolicy
+	// limiter bounds the concurrent requests per backend type, nil does not limit them.
+	limiter *secretbackend.ConcurrencyLimiter
+
+	// nodeAddressPolicy resolves the addresses of the node scope.
+	nodeAddressPolicy pod_info.NodeAddressPolicy
+	// clusterDomain is the domain of the dns names of the pods and services, empty is "cluster.local".
+	clusterDomain string
+
+	// podAnnotationDisabled skips patching the expiration time and content hash annotations of the pods,
+	// the expiration time is only tracked by the mounts.
+	podAnnotationDisabled bool
+
+	// apiReader reads the secret classes and the pods not found by the client, nil disables it.
+	apiReader client.Reader
+
+	// fifoEnabled allows the volumes writing keys as named pipes, each one is served by a goroutine.
+	fifoEnabled bool
+
+	// recorder records the result of the publish on the pod, nil disables the events.
+	// events are the times of the last events per pod and reason, to rate limit them.
+	recorder   record.EventRecorder
+	events     map[publishEventKey]time.Time
+	eventsLock sync.Mutex
+
+	// clock and rand are replaced in tests, to rotate the secrets and issue the certificates deterministically.
+	clock clock.WithTicker
+	rand  io.Reader
+
+	// tracer traces the publish of the volumes, with the global tracer provider by default.
+	tracer trace.Tracer
+
+	// stopCh is closed by Shutdown, to stop the rotation and reject new volumes.
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	// workers are the running rotation and secret class watch loops, Shutdown waits for them.
+	workers sync.WaitGroup
 }
 
 func NewNodeServer(
@@ -37,13 +136,124 @@ func NewNodeServer(
 	client client.Client,
 ) *NodeServer {
 	return &NodeServer{
-		nodeID:  nodeId,
-		mounter: mounter,
-		client:  client,
+		nodeID:        nodeId,
+		mounter:       mounter,
+		client:        client,
+		mounts:        map[string]*mountedVolume{},
+		staged:        map[string]*stagedVolume{},
+		events:        map[publishEventKey]time.Time{},
+		cache:         secretbackend.NewCache(secretCacheTTL),
+		tokens:        secretbackend.NewTokenCache(),
+		maxSecretSize: defaultMaxSecretSize.Value(),
+		retry:         defaultBackendRetry,
+		clock:         clock.RealClock{},
+		rand:          rand.Reader,
+		tracer:        otel.GetTracerProvider().Tracer(tracerName),
+		stopCh:        make(chan struct{}),
 	}
 }
 
-func (n *NodeServer) NodePublishVolume(ctx context.Context, request *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+// WithBackendRetry replaces the retry policy of the transient backend failures, e.g. vault is unavailable.
+// The retries are bounded by the deadline of the request.
+func (n *NodeServer) WithBackendRetry(policy secretbackend.RetryPolicy) *NodeServer {
+	n.retry = policy
+	return n
+}
+
+// WithBackendConcurrency limits the concurrent requests per backend type, e.g. {"vault": 10}.
+// The publishes over the limit wait for a slot until their deadline.
+func (n *NodeServer) WithBackendConcurrency(limits map[string]int) *NodeServer {
+	n.limiter = secretbackend.NewConcurrencyLimiter(limits)
+	return n
+}
+
+// WithNodeAddressPolicy replaces how the addresses of the node scope are resolved, e.g. the SANs of
+// the node scoped certificates, by default the node name and all the addresses of the Node object.
+func (n *NodeServer) WithNodeAddressPolicy(policy pod_info.NodeAddressPolicy) *NodeServer {
+	n.nodeAddressPolicy = policy
+	return n
+}
+
+// WithClusterDomain replaces the cluster domain of the dns names of the pods and services, e.g. the SANs of
+// the pod scoped certificates, by default "cluster.local".
+func (n *NodeServer) WithClusterDomain(domain string) *NodeServer {
+	n.clusterDomain = domain
+	return n
+}
+
+// WithPodAnnotationDisabled skips patching the annotations of the pods when the volumes are published or rotated,
+// e.g. when the audit of the pod patches is too noisy. The rotation still tracks the expiration time of the
+// mounted secrets, but the operator can not restart the pods with expired secrets.
+func (n *NodeServer) WithPodAnnotationDisabled(disabled bool) *NodeServer {
+	n.podAnnotationDisabled = disabled
+	return n
+}
+
+// WithAPIReader reads the secret classes and the pods from the API server when the client does not find them.
+// The client of the manager reads from a cache, which is empty until it is synced, e.g. right after the driver
+// started, so a pod or a secret class created just before is not found yet. The reader is usually not cached.
+func (n *NodeServer) WithAPIReader(reader client.Reader) *NodeServer {
+	n.apiReader = reader
+	return n
+}
+
+// WithFIFOEnabled allows the volumes with the fifo format, which write keys as named pipes served
+// by the driver until the volume is unpublished.
+func (n *NodeServer) WithFIFOEnabled(enabled bool) *NodeServer {
+	n.fifoEnabled = enabled
+	return n
+}
+
+// WithEventRecorder records the result of NodePublishVolume as an event on the pod, e.g. the secret class
+// is not found, so the failure is visible with the events of the pod.
+func (n *NodeServer) WithEventRecorder(recorder record.EventRecorder) *NodeServer {
+	n.recorder = recorder
+	return n
+}
+
+// WithMaxSecretSize limits the total size of the secret data returned by the backends for a volume,
+// independently of the tmpfs size, so a misconfigured backend returning huge data is refused before mounting.
+// 0 disables the check.
+func (n *NodeServer) WithMaxSecretSize(size int64) *NodeServer {
+	n.maxSecretSize = size
+	return n
+}
+
+// WithClock replaces the real clock, used to rotate the secrets, expire the cached secret data and passed to the backends.
+func (n *NodeServer) WithClock(clock clock.WithTicker) *NodeServer {
+	n.clock = clock
+	n.cache.WithClock(clock)
+	return n
+}
+
+// WithRand replaces crypto/rand.Reader passed to the backends and used for the salts of the keystore formats.
+func (n *NodeServer) WithRand(rand io.Reader) *NodeServer {
+	n.rand = rand
+	return n
+}
+
+// WithTracerProvider replaces the global tracer provider, which traces the publish of the volumes.
+func (n *NodeServer) WithTracerProvider(provider trace.TracerProvider) *NodeServer {
+	n.tracer = provider.Tracer(tracerName)
+	return n
+}
+
+func (n *NodeServer) NodePublishVolume(ctx context.Context, request *csi.NodePublishVolumeRequest) (response *csi.NodePublishVolumeResponse, err error) {
+	backendType := secretbackend.BackendTypeUnknown
+	var volumeSelector *volume.SecretVolumeSelector
+	start := n.clock.Now()
+	ctx, span := n.tracer.Start(ctx, spanPublishVolume, trace.WithAttributes(attrTargetPath.String(request.GetTargetPath())))
+	defer func() {
+		recordPublishVolume(backendType, err)
+		n.recordPublishEvent(volumeSelector, backendType, n.clock.Since(start), err)
+		span.SetAttributes(attrBackendType.String(backendType))
+		endSpan(span, err)
+	}()
+
+	if n.isShuttingDown() {
+		return nil, status.Error(codes.Unavailable, "node server is shutting down")
+	}
+
 	if err := n.validateNodePublishVolumeRequest(request); err != nil {
 		return nil, err
 	}
@@ -69,158 +279,861 @@ func (n *NodeServer) NodePublishVolume(ctx context.Context, request *csi.NodePub
 	// because we deliver it from controller to node already.
 	// The following PVC annotations is required:
 	//   - secrets.zncdata.dev/class: <secret-class-name>
-	volumeSelector, err := volume.NewVolumeSelectorFromMap(request.GetVolumeContext())
+	// or secrets.zncdata.dev/classes: <secret-class-name>,<secret-class-name>... to combine the secrets of several classes.
+	// For inline ephemeral volumes, there is no PVC, the keys are read from the volumeAttributes of the csi volume directly.
+	volumeSelector, err = volume.NewVolumeSelectorFromMap(request.GetVolumeContext())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if missing := volumeSelector.MissingAttributes(); len(missing) > 0 {
+		if volumeSelector.IsEphemeral() {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"Required attributes missing in volumeAttributes of ephemeral inline volume: %s", strings.Join(missing, ", "))
+		}
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Required attributes missing in volume context: %s, make sure they are set in the PVC annotations "+
+				"and podInfoOnMount is enabled in the CSIDriver", strings.Join(missing, ", "))
+	}
+
+	secretClasses, err := n.getSecretClasses(ctx, volumeSelector.SecretClasses())
+	if err != nil {
+		return nil, err
+	}
+	backendType = volumeBackendType(secretClasses)
+	volumeSelector, err = applyVolumeDefaults(request.GetVolumeContext(), volumeSelector, secretClasses)
+	if err != nil {
+		return nil, backendStatusError(err)
+	}
+	if err := secretbackend.ValidateVolumeSelector(volumeSelector, secretClasses); err != nil {
+		return nil, backendStatusError(err)
+	}
+
+	for _, secretClass := range secretClasses {
+		if err := n.checkNamespaceAllowed(ctx, secretClass, volumeSelector.PodNamespace); err != nil {
+			return nil, err
+		}
+	}
+
+	options, err := volumeMountOptions(secretClasses)
+	if err != nil {
+		return nil, backendStatusError(err)
+	}
+	fsType, err := volumeFSType(secretClasses)
+	if err != nil {
+		return nil, backendStatusError(err)
+	}
+	// ramfs grows without limit, only the max secret size bounds the data written to it
+	if fsType == fsTypeRamfs && n.maxSecretSize <= 0 {
+		return nil, status.Error(codes.FailedPrecondition, "ramfs volumes require the max secret size of the csi driver")
+	}
+	if len(volumeSelector.FIFOKeys) > 0 && !n.fifoEnabled {
+		return nil, status.Error(codes.FailedPrecondition, "fifo volumes are not enabled in the csi driver")
+	}
+
+	var pod *corev1.Pod
+	var podInfo *pod_info.PodInfo
+	var secretContent *util.SecretContent
+	// the secret pre-fetched when staging may be stale too, noCache drops it
+	if staged := n.takeStaged(request.GetStagingTargetPath(), volumeSelector); staged != nil && !volumeSelector.NoCache {
+		util.LoggerFromContext(ctx, logger).V(1).Info("Use the secret pre-fetched when the volume was staged", "targetPath", targetPath)
+		pod, podInfo, secretContent = staged.pod, staged.podInfo, staged.content
+	} else {
+		pod, podInfo, secretContent, err = n.getSecretContent(ctx, volumeSelector, secretClasses)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sizeLimit := defaultTmpfsSizeLimit.Value()
+	if volumeSelector.SizeLimit != nil {
+		sizeLimit = volumeSelector.SizeLimit.Value()
+	}
+	if fsType == fsTypeRamfs {
+		sizeLimit = 0
+	}
+
+	// mount the volume to the target path
+	mountCtx, mountSpan := n.tracer.Start(ctx, spanMount)
+	err = n.mount(mountCtx, targetPath, fsType, sizeLimit, volumeSelector.DirMode, options)
+	endSpan(mountSpan, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// clean up the half-populated volume when any step after mount fails,
+	// otherwise the retry fails because the target path already exists.
+	defer func() {
+		if err != nil {
+			n.cleanup(ctx, targetPath)
+		}
+	}()
+
+	if err := setVolumeGroup(ctx, targetPath, podInfo, volumeSelector.DirMode); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// write the secret data to the target path
+	fileMode := defaultFileMode
+	if volumeSelector.Mode != 0 {
+		fileMode = volumeSelector.Mode
+	}
+	uid, gid := fileOwner(volumeSelector, podInfo)
+	// the volume root restricted by dirMode is owned by the workload, which can list the files
+	if volumeSelector.DirMode != 0 && uid != -1 {
+		if err := os.Chown(targetPath, uid, -1); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to change owner of %s to %d: %v", targetPath, uid, err)
+		}
+	}
+	dataPath, err := itemDir(targetPath, volumeSelector.ItemPath)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := makeItemDir(targetPath, dataPath, uid, gid); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	written := withoutFIFOKeys(secretContent.Data, volumeSelector.FIFOKeys)
+	_, writeSpan := n.tracer.Start(ctx, spanWriteData, trace.WithAttributes(attrBytesWritten.Int(dataSize(written))))
+	err = n.writeData(dataPath, written, fileMode, uid, gid)
+	endSpan(writeSpan, err)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	secretBytesWritten.WithLabelValues(backendType).Add(float64(dataSize(secretContent.Data)))
+	// the pipes are created before the volume is read-only, and their writers stopped before it is cleaned up
+	fifos, err := createFIFOs(dataPath, secretContent.Data, volumeSelector.FIFOKeys, fileMode, uid, gid)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-	if volumeSelector.Class == "" {
-		return nil, status.Error(codes.InvalidArgument, "Secret class name missing in request")
+	defer func() {
+		if err != nil {
+			stopFIFOs(fifos)
+		}
+	}()
+
+	// remount the volume as read-only after the secret data is written,
+	// so nothing in the pod can tamper with the materialized secrets.
+	if isReadOnly(request) {
+		if err := n.remountReadOnly(ctx, targetPath, fsType, sizeLimit, options); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := n.updatePod(ctx, pod.DeepCopy(), podVolumeName(targetPath), secretContent); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	// track the volume, so the secret can be rotated before it expires
+	n.trackMount(&mountedVolume{
+		targetPath:     targetPath,
+		dataPath:       dataPath,
+		volumeSelector: volumeSelector,
+		fileMode:       fileMode,
+		uid:            uid,
+		gid:            gid,
+		fsType:         fsType,
+		sizeLimit:      sizeLimit,
+		mountOptions:   options,
+		readOnly:       isReadOnly(request),
+		issuedTime:     n.clock.Now(),
+		expiresTime:    secretContent.ExpiresTime,
+		contentHash:    secretContent.ContentHash,
+		fifos:          fifos,
+	})
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// getSecretClass gets the secret class, the returned error is a grpc status error.
+func (n *NodeServer) getSecretClass(ctx context.Context, name string) (*secretsv1alpha1.SecretClass, error) {
 	secretClass := &secretsv1alpha1.SecretClass{}
-	// get the secret class
 	// SecretClass is cluster coped, so we don't need to specify the namespace
-	if err := n.client.Get(ctx, client.ObjectKey{
-		Name: volumeSelector.Class,
+	if err := n.get(ctx, client.ObjectKey{
+		Name: name,
 	}, secretClass); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, backendStatusError(fmt.Errorf("%w: %q", secretbackend.ErrSecretClassNotFound, name))
+		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	return secretClass, nil
+}
+
+// get gets the object with the client, and with the API reader when the client does not find it.
+func (n *NodeServer) get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	err := n.client.Get(ctx, key, obj)
+	if err == nil || n.apiReader == nil || !apierrors.IsNotFound(err) {
+		return err
+	}
+	util.LoggerFromContext(ctx, logger).V(1).Info("Object not found by the client, read it from the API server",
+		"kind", fmt.Sprintf("%T", obj), "key", key)
+	return n.apiReader.Get(ctx, key, obj)
+}
+
+// getSecretClasses gets the secret classes of the volume in order, the returned error is a grpc status error.
+func (n *NodeServer) getSecretClasses(ctx context.Context, names []string) (_ []*secretsv1alpha1.SecretClass, err error) {
+	ctx, span := n.tracer.Start(ctx, spanGetSecretClasses, trace.WithAttributes(attrSecretClass.StringSlice(names)))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	secretClasses := make([]*secretsv1alpha1.SecretClass, 0, len(names))
+	for _, name := range names {
+		secretClass, err := n.getSecretClass(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		secretClasses = append(secretClasses, secretClass)
+	}
+	return secretClasses, nil
+}
+
+// volumeBackendType returns the backend type of the secret classes to label the metrics,
+// backendTypeMixed when the classes of the volume have different backends.
+func volumeBackendType(secretClasses []*secretsv1alpha1.SecretClass) string {
+	backendType := secretbackend.BackendTypeUnknown
+	for i, secretClass := range secretClasses {
+		t := secretbackend.BackendType(secretClass)
+		if i > 0 && t != backendType {
+			return backendTypeMixed
+		}
+		backendType = t
+	}
+	return backendType
+}
+
+// getTemplates returns the templates of the volume keyed by their file, the inline template and the ones of
+// the template ConfigMap in the namespace of the pod. The returned error is a grpc status error.
+func (n *NodeServer) getTemplates(ctx context.Context, volumeSelector *volume.SecretVolumeSelector) (map[string]string, error) {
+	templates := map[string]string{}
+	if volumeSelector.TemplateConfigMap != "" {
+		configMap := &corev1.ConfigMap{}
+		key := client.ObjectKey{Name: volumeSelector.TemplateConfigMap, Namespace: volumeSelector.PodNamespace}
+		if err := n.client.Get(ctx, key, configMap); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, status.Errorf(codes.NotFound, "template ConfigMap %s not found", key)
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		for file, template := range configMap.Data {
+			// the ConfigMap keys can not be in a directory, but can be reserved by the atomic writer, e.g. "..data"
+			if strings.HasPrefix(file, "..") {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid file %q of template ConfigMap %s", file, key)
+			}
+			templates[file] = template
+		}
+	}
+	if volumeSelector.Template != "" {
+		if _, ok := templates[volumeSelector.TemplateFile]; ok {
+			return nil, status.Errorf(codes.InvalidArgument, "template file %q is also in template ConfigMap %s",
+				volumeSelector.TemplateFile, volumeSelector.TemplateConfigMap)
+		}
+		templates[volumeSelector.TemplateFile] = volumeSelector.Template
+	}
+	return templates, nil
+}
+
+// podTerminatingError is the FailedPrecondition status error of a volume published for a pod being deleted.
+type podTerminatingError struct {
+	pod types.NamespacedName
+}
+
+func (e *podTerminatingError) Error() string {
+	return fmt.Sprintf("pod %s is terminating", e.pod)
+}
+
+// GRPCStatus implements the interface of the errors converted by the grpc status package.
+func (e *podTerminatingError) GRPCStatus() *status.Status {
+	return status.New(codes.FailedPrecondition, e.Error())
+}
+
+// backendStatusError converts the error of the backend to a grpc status error,
+// the code is decided by the typed error wrapped in it, and defaults to Internal.
+func backendStatusError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, secretbackend.ErrSecretClassNotFound), errors.Is(err, secretbackend.ErrSecretNotFound):
+		code = codes.NotFound
+	case errors.Is(err, secretbackend.ErrSecretClassInvalid):
+		code = codes.FailedPrecondition
+	case errors.Is(err, secretbackend.ErrInvalidVolumeContext):
+		code = codes.InvalidArgument
+	case errors.Is(err, secretbackend.ErrPermissionDenied):
+		code = codes.PermissionDenied
+	case errors.Is(err, secretbackend.ErrBackendUnavailable):
+		code = codes.Unavailable
+	case errors.Is(err, secretbackend.ErrBackendTimeout), errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, err.Error())
+}
+
+// checkNamespaceAllowed checks the namespace of the pod is allowed to mount the secret class,
+// the returned error is a grpc status error.
+func (n *NodeServer) checkNamespaceAllowed(ctx context.Context, secretClass *secretsv1alpha1.SecretClass, namespace string) error {
+	allowed := secretClass.Spec.AllowedNamespaces
+	if allowed == nil || slices.Contains(allowed.Names, namespace) {
+		return nil
+	}
+
+	if allowed.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(allowed.Selector)
+		if err != nil {
+			return status.Errorf(codes.Internal, "invalid allowedNamespaces selector in SecretClass %q: %v", secretClass.Name, err)
+		}
+
+		ns := &corev1.Namespace{}
+		if err := n.client.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if selector.Matches(labels.Set(ns.GetLabels())) {
+			return nil
+		}
+	}
 
+	return &namespaceNotAllowedError{namespace: namespace, secretClass: secretClass.Name}
+}
+
+// namespaceNotAllowedError is the PermissionDenied status error of a volume of a namespace not allowed by the secret
+// class, told apart from the access denied by the backend.
+type namespaceNotAllowedError struct {
+	namespace   string
+	secretClass string
+}
+
+func (e *namespaceNotAllowedError) Error() string {
+	return fmt.Sprintf("namespace %q is not allowed to mount SecretClass %q", e.namespace, e.secretClass)
+}
+
+// GRPCStatus implements the interface of the errors converted by the grpc status package.
+func (e *namespaceNotAllowedError) GRPCStatus() *status.Status {
+	return status.New(codes.PermissionDenied, e.Error())
+}
+
+// getSecretContent gets the secret data of the volume from the backends of the secret classes,
+// merges it, converts it to the format required by the volume, and selects the items of the volume.
+// The metadata file is added when the volume asks for it.
+// A key provided by more than one class is refused, and the merged secret expires with the first one to expire.
+// The returned error is a grpc status error.
+func (n *NodeServer) getSecretContent(
+	ctx context.Context,
+	volumeSelector *volume.SecretVolumeSelector,
+	secretClasses []*secretsv1alpha1.SecretClass,
+) (*corev1.Pod, *pod_info.PodInfo, *util.SecretContent, error) {
 	pod := &corev1.Pod{}
 	// get the pod
-	if err := n.client.Get(ctx, client.ObjectKey{
+	podCtx, podSpan := n.tracer.Start(ctx, spanGetPod, trace.WithAttributes(
+		attrPod.String(volumeSelector.Pod), attrPodNamespace.String(volumeSelector.PodNamespace)))
+	err := n.get(podCtx, client.ObjectKey{
 		Name:      volumeSelector.Pod,
 		Namespace: volumeSelector.PodNamespace,
-	}, pod); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	}, pod)
+	endSpan(podSpan, err)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil, nil, status.Errorf(codes.NotFound, "Pod %q not found in namespace %q", volumeSelector.Pod, volumeSelector.PodNamespace)
+		}
+		return nil, nil, nil, status.Error(codes.Internal, err.Error())
 	}
+	// kubelet may still publish the volumes of a pod being deleted, e.g. on a fast scale-down,
+	// the secrets would be issued for nothing and the annotations of the pod can not be patched anymore
+	if pod.DeletionTimestamp != nil {
+		return nil, nil, nil, &podTerminatingError{pod: client.ObjectKeyFromObject(pod)}
+	}
+
+	podInfo := pod_info.NewPodInfo(n.client, pod, volumeSelector).
+		WithNodeAddressPolicy(n.nodeAddressPolicy).
+		WithClusterDomain(n.clusterDomain)
 
-	podInfo := pod_info.NewPodInfo(n.client, pod, volumeSelector)
+	merged := &util.SecretContent{Data: map[string][]byte{}}
+	providers := map[string]string{}
+	for _, secretClass := range secretClasses {
+		// each backend sees the volume as if it only requested its own class
+		classSelector := *volumeSelector
+		classSelector.Class = secretClass.Name
+		classSelector.Classes = nil
+
+		// get the secret data
+		backend := secretbackend.NewBackend(n.client, podInfo, &classSelector, secretClass).
+			WithCache(n.cache).
+			WithTokenCache(n.tokens).
+			WithClock(n.clock).
+			WithRand(n.rand).
+			WithRetry(n.retry).
+			WithConcurrencyLimiter(n.limiter)
+		start := n.clock.Now()
+		backendCtx, backendSpan := n.tracer.Start(ctx, spanGetSecretData, trace.WithAttributes(
+			attrBackendType.String(secretbackend.BackendType(secretClass)), attrSecretClass.String(secretClass.Name)))
+		secretContent, err := backend.GetSecretData(backendCtx)
+		endSpan(backendSpan, err)
+		secretFetchDuration.WithLabelValues(secretbackend.BackendType(secretClass)).Observe(n.clock.Since(start).Seconds())
+		if err != nil {
+			return nil, nil, nil, backendStatusError(err)
+		}
+
+		for key, value := range secretContent.Data {
+			if provider, ok := providers[key]; ok {
+				return nil, nil, nil, status.Errorf(codes.InvalidArgument,
+					"key %q is provided by both secret class %q and %q", key, provider, secretClass.Name)
+			}
+			providers[key] = secretClass.Name
+			merged.Data[key] = value
+		}
+		if secretContent.ExpiresTime != nil && (merged.ExpiresTime == nil || *secretContent.ExpiresTime < *merged.ExpiresTime) {
+			merged.ExpiresTime = secretContent.ExpiresTime
+		}
+	}
+	// the ttl of the volume also expires the secrets without lifetime, e.g. of k8sSearch, so they are rotated
+	if volumeSelector.TTL != 0 {
+		ttlExpiresTime := n.clock.Now().Add(volumeSelector.TTL).Unix()
+		if merged.ExpiresTime == nil || ttlExpiresTime < *merged.ExpiresTime {
+			merged.ExpiresTime = &ttlExpiresTime
+		}
+	}
 
-	// get the secret data
-	backend := secretbackend.NewBackend(n.client, podInfo, volumeSelector, secretClass)
-	secretContent, err := backend.GetSecretData(ctx)
+	// the encoded values are decoded first, a compressed value may be encoded too
+	decoded, err := format.DecodeBase64(merged.Data, volumeSelector.DecodeKeys)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, nil, nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	merged.Data = decoded
 
-	// mount the volume to the target path
-	if err := n.mount(targetPath); err != nil {
-		return nil, err
+	// the compressed values are decompressed within the max secret size, a larger value is never fully inflated
+	decompressed, err := format.Gunzip(merged.Data, volumeSelector.GzipKeys, n.maxSecretSize)
+	if err != nil {
+		if errors.Is(err, format.ErrDecompressedTooLarge) {
+			return nil, nil, nil, status.Errorf(codes.ResourceExhausted, "%s, exceeding the max secret size %d bytes", err, n.maxSecretSize)
+		}
+		return nil, nil, nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	merged.Data = decompressed
 
-	// write the secret data to the target path
-	if err := n.writeData(targetPath, secretContent.Data); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if size := dataSize(merged.Data); n.maxSecretSize > 0 && int64(size) > n.maxSecretSize {
+		return nil, nil, nil, status.Errorf(codes.ResourceExhausted,
+			"secret data of volume is %d bytes, exceeding the max secret size %d bytes", size, n.maxSecretSize)
 	}
 
-	if err := n.updatePod(ctx, pod.DeepCopy(), secretContent.ExpiresTime); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	templates, err := n.getTemplates(ctx, volumeSelector)
+	if err != nil {
+		return nil, nil, nil, err
 	}
+	// the data is hashed before the conversion, the keystore formats differ on every conversion of the same data
+	hash := contentHash(merged.Data, conversionOptions(volumeSelector, templates))
 
-	return &csi.NodePublishVolumeResponse{}, nil
+	// convert the secret data to the format required by the volume
+	data, err := format.Convert(merged.Data, volumeSelector, n.clock, n.rand)
+	if err != nil {
+		if errors.Is(err, format.ErrNotText) {
+			return nil, nil, nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, nil, nil, status.Error(codes.Internal, err.Error())
+	}
+	// the templates are rendered with the converted data, a volume with templates has no items
+	data, err = format.RenderTemplates(data, templates)
+	if err != nil {
+		return nil, nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	data, err = format.SelectItems(data, volumeSelector.Items)
+	if err != nil {
+		return nil, nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	data, err = format.NormalizeKeys(data, volumeSelector)
+	if err != nil {
+		return nil, nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	data = format.TrailingNewline(data, volumeSelector.TrailingNewline)
+	if volumeSelector.EmitMetadata {
+		if err := addMetadata(data, secretClasses, pod, n.clock.Now(), merged.ExpiresTime, hash); err != nil {
+			return nil, nil, nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	return pod, podInfo, &util.SecretContent{
+		Data:        data,
+		ExpiresTime: merged.ExpiresTime,
+		ContentHash: hash,
+	}, nil
 }
 
-// updatePod updates the pod annotation with the secret expiration time.
-// If the new expiration time is closer to the current time, update the pod annotation
-// with the new expiration time. Otherwise, do nothing, meaning the pod annotation
-// keeps the old expiration time.
-func (n *NodeServer) updatePod(ctx context.Context, pod *corev1.Pod, expiresTime *int64) error {
+// updatePod updates the pod annotations with the secret expiration time and the content hash of the volume.
+// The expiration annotation holds the soonest expiration time of all the secrets mounted by the pod,
+// so it is only replaced when the new expiration time is earlier, the pod must not outlive
+// its shortest-lived secret. An annotation already in the past is stale, e.g. left by
+// a secret rotated since, so it is replaced.
+// The pod is patched once, only when an annotation changed, and never when the pod annotation is disabled.
+func (n *NodeServer) updatePod(ctx context.Context, pod *corev1.Pod, volumeName string, secretContent *util.SecretContent) error {
+	if n.podAnnotationDisabled {
+		return nil
+	}
 	if pod.Annotations == nil {
 		pod.Annotations = make(map[string]string)
 	}
 	patch := client.MergeFrom(pod.DeepCopy())
-	var err error
-	if expiresTime == nil {
-		logger.V(5).Info("Expiration time is nil, skip update pod annotation", "pod", pod.Name)
-		return nil
+
+	changed := false
+	if secretContent.ContentHash != "" {
+		updated, err := setContentHashAnnotation(pod.Annotations, volumeName, secretContent.ContentHash)
+		if err != nil {
+			return err
+		}
+		changed = updated
 	}
 
-	existExpiresTime := int64(0)
+	expiresTime := secretContent.ExpiresTime
+	if expiresTime == nil {
+		util.LoggerFromContext(ctx, logger).V(5).Info("Expiration time is nil, skip update pod expiration time", "pod", pod.Name)
+	} else if updated, err := n.setExpiresTimeAnnotation(ctx, pod, *expiresTime); err != nil {
+		return err
+	} else if updated {
+		changed = true
+	}
 
-	existExpiresTimeStr, found := pod.Annotations[volume.SecretZncdataExpirationTime]
+	if !changed {
+		return nil
+	}
+	if err := n.client.Patch(ctx, pod, patch); err != nil {
+		return err
+	}
+	util.LoggerFromContext(ctx, logger).V(5).Info("Pod annotations updated",
+		"pod", pod.Name, "expiresTime", expiresTime, "contentHash", secretContent.ContentHash)
+	return nil
+}
 
-	if found && existExpiresTimeStr != "" {
-		existExpiresTime, err = strconv.ParseInt(existExpiresTimeStr, 10, 64)
+// setExpiresTimeAnnotation sets the expiration time annotation of the pod when the secret expires sooner,
+// and returns whether it changed.
+func (n *NodeServer) setExpiresTimeAnnotation(ctx context.Context, pod *corev1.Pod, expiresTime int64) (bool, error) {
+	if existExpiresTimeStr := pod.Annotations[volume.SecretZncdataExpirationTime]; existExpiresTimeStr != "" {
+		existExpiresTime, err := strconv.ParseInt(existExpiresTimeStr, 10, 64)
 		if err != nil {
-			return err
+			return false, err
 		}
-		logger.V(5).Info("Pod annotation found", "pod", pod.Name, "expiresTime", existExpiresTime)
-		// if the new expiration time is closer to the current time, update the pod annotation
-		// with the new expiration time. Otherwise, do nothing, meaning the pod annotation
-		// keeps the old expiration time.
-		if *expiresTime > existExpiresTime {
-			return nil
+		if existExpiresTime <= expiresTime && existExpiresTime > n.clock.Now().Unix() {
+			util.LoggerFromContext(ctx, logger).V(5).Info("Pod expiration time is sooner than the secret, keep it", "pod", pod.Name,
+				"podExpiresTime", existExpiresTime, "secretExpiresTime", expiresTime)
+			return false, nil
 		}
+		util.LoggerFromContext(ctx, logger).V(5).Info("Secret expires sooner than the pod expiration time, replace it", "pod", pod.Name,
+			"podExpiresTime", existExpiresTime, "secretExpiresTime", expiresTime)
+	}
 
-		pod.Annotations[volume.SecretZncdataExpirationTime] = strconv.FormatInt(*expiresTime, 10)
-		logger.V(5).Info("Pod annotation updated", "pod", pod.Name, "expiresTime", expiresTime)
-	} else {
-		pod.Annotations[volume.SecretZncdataExpirationTime] = strconv.FormatInt(*expiresTime, 10)
-		logger.V(5).Info("Pod annotation added", "pod", pod.Name, "expiresTime", expiresTime)
+	pod.Annotations[volume.SecretZncdataExpirationTime] = strconv.FormatInt(expiresTime, 10)
+	return true, nil
+}
+
+// validateFileName checks the key of secret data is a single path element, so the file
+// is always written in the target path. The data may come from a compromised secret or backend.
+// Names starting with ".." are reserved for the data directories of the atomic writer.
+func validateFileName(name string) error {
+	if name == "" || name == "." || strings.HasPrefix(name, "..") ||
+		strings.ContainsAny(name, `/\`) || strings.ContainsRune(name, 0) {
+		return fmt.Errorf("secret data key %q is not a valid file name", name)
 	}
+	return nil
+}
 
-	if err := n.client.Patch(ctx, pod, patch); err != nil {
+// itemDir returns the directory where the secret files are written.
+// When itemPath is set, it is the subdirectory of the target path, and it must not escape the target path.
+func itemDir(targetPath, itemPath string) (string, error) {
+	if itemPath == "" {
+		return targetPath, nil
+	}
+	dir := filepath.Join(targetPath, itemPath)
+	rel, err := filepath.Rel(targetPath, dir)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("item path %q is not a subdirectory of %s", itemPath, targetPath)
+	}
+	return dir, nil
+}
+
+// makeItemDir creates the item directory and its parents under the target path,
+// and changes their owner to the owner of the secret files, so the pod can access them.
+func makeItemDir(targetPath, dir string, uid, gid int) error {
+	if dir == targetPath {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
 		return err
 	}
-	logger.V(5).Info("Pod patched", "pod", pod.Name)
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+	for d := dir; d != targetPath; d = filepath.Dir(d) {
+		if err := os.Chown(d, uid, gid); err != nil {
+			return fmt.Errorf("failed to change owner of %s to %d:%d: %w", d, uid, gid, err)
+		}
+	}
 	return nil
 }
 
-// writeData writes the data to the target path.
-// The data is a map of key-value pairs.
-// The key is the file name, and the value is the file content.
-func (n *NodeServer) writeData(targetPath string, data map[string]string) error {
-	for name, content := range data {
-		fileName := filepath.Join(targetPath, name)
-		if err := os.WriteFile(fileName, []byte(content), fs.FileMode(0644)); err != nil {
-			return err
-		}
-		logger.V(5).Info("File written", "file", fileName)
+// fileOwner returns the uid and gid of the secret files.
+// If gid is not set in the volume context, the fsGroup of the pod is used.
+// The id is -1 when it is not set, meaning the owner is not changed.
+func fileOwner(volumeSelector *volume.SecretVolumeSelector, podInfo *pod_info.PodInfo) (int, int) {
+	uid, gid := -1, -1
+	if volumeSelector.UID != nil {
+		uid = int(*volumeSelector.UID)
+	}
+	if volumeSelector.GID != nil {
+		gid = int(*volumeSelector.GID)
+	} else if fsGroup := podInfo.GetFSGroup(); fsGroup != nil {
+		gid = int(*fsGroup)
+	}
+	return uid, gid
+}
+
+// fsGroupDirMode is the mode of the volume root when the pod has a fsGroup, like the volumes managed by kubelet,
+// the setgid bit makes the files created in the volume inherit the group.
+const fsGroupDirMode uint32 = unix.S_ISGID | 0770
+
+// setVolumeGroup changes the group of the volume root to the fsGroup of the pod, and sets the setgid bit.
+// The permission of the root is the dirMode of the volume when it is set, otherwise fsGroupDirMode.
+// Like fsGroupChangePolicy OnRootMismatch, nothing is changed when the root already has the group and the mode.
+func setVolumeGroup(ctx context.Context, targetPath string, podInfo *pod_info.PodInfo, dirMode fs.FileMode) error {
+	fsGroup := podInfo.GetFSGroup()
+	if fsGroup == nil {
+		return nil
+	}
+	mode := fsGroupDirMode
+	if dirMode != 0 {
+		mode = unix.S_ISGID | uint32(dirMode)
+	}
+
+	var stat unix.Stat_t
+	if err := unix.Stat(targetPath, &stat); err != nil {
+		return err
+	}
+	if int64(stat.Gid) == *fsGroup && stat.Mode&07777 == mode {
+		util.LoggerFromContext(ctx, logger).V(5).Info("Volume root already owned by fsGroup, skip it", "target", targetPath, "fsGroup", *fsGroup)
+		return nil
 	}
-	logger.V(5).Info("Data written", "target", targetPath)
+
+	if err := os.Chown(targetPath, -1, int(*fsGroup)); err != nil {
+		return fmt.Errorf("failed to change group of %s to fsGroup %d: %w", targetPath, *fsGroup, err)
+	}
+	if err := unix.Chmod(targetPath, mode); err != nil {
+		return fmt.Errorf("failed to change mode of %s: %w", targetPath, err)
+	}
+	util.LoggerFromContext(ctx, logger).V(1).Info("Volume root owned by fsGroup", "target", targetPath, "fsGroup", *fsGroup)
 	return nil
 }
 
 // mount mounts the volume to the target path.
-// Mount the volume to the target path with tmpfs.
+// Mount the volume to the target path with tmpfs, or ramfs.
 // The target path is created if it does not exist.
 // The volume is mounted with the following options:
-//   - noexec (no execution)
+//   - noexec (no execution), unless the secret class allows exec
 //   - nosuid (no set user ID)
 //   - nodev (no device)
-func (n *NodeServer) mount(targetPath string) error {
+//   - the extra mount options of the secret class
+//   - size (the size limit of tmpfs in bytes), none for ramfs
+//   - mode (the permission of the root of the volume), when dirMode is set
+//
+// The root of the volume has the permission dirMode, when it is set, otherwise the default of the filesystem.
+// It is set by the mode option, so the root is never readable by others between the mount and a chmod.
+func (n *NodeServer) mount(ctx context.Context, targetPath string, fsType string, sizeLimit int64, dirMode fs.FileMode, options []string) error {
 	// check if the target path exists
 	// if not, create the target path
 	// if exists, return error
 	if exist, err := mount.PathExists(targetPath); err != nil {
-		logger.Error(err, "failed to check if target path exists", "target", targetPath)
+		util.LoggerFromContext(ctx, logger).Error(err, "failed to check if target path exists", "target", targetPath)
 		return status.Error(codes.Internal, err.Error())
 	} else if exist {
 		err := errors.New("target path already exists")
-		logger.Error(err, "failed to create target path", "target", targetPath)
+		util.LoggerFromContext(ctx, logger).Error(err, "failed to create target path", "target", targetPath)
 		return status.Error(codes.Internal, err.Error())
 	} else {
-		if err := os.MkdirAll(targetPath, 0750); err != nil {
-			logger.Error(err, "failed to create target path", "target", targetPath)
+		mkdirMode := fs.FileMode(0750)
+		if dirMode != 0 {
+			mkdirMode = dirMode
+		}
+		if err := os.MkdirAll(targetPath, mkdirMode); err != nil {
+			util.LoggerFromContext(ctx, logger).Error(err, "failed to create target path", "target", targetPath)
 			return status.Error(codes.Internal, err.Error())
 		}
 	}
 
-	opts := []string{
-		"noexec",
-		"nosuid",
-		"nodev",
+	// the root of the mounted filesystem replaces the directory, its mode is set by the filesystem, not the umask.
+	// The read-only remount does not pass it again, the mode of the root is kept.
+	opts := mountOptions(sizeLimit, options)
+	if dirMode != 0 {
+		opts = append(opts, dirModeOption(dirMode))
 	}
 
 	// mount the volume to the target path
-	if err := n.mounter.Mount("tmpfs", targetPath, "tmpfs", opts); err != nil {
+	if err := n.mounter.Mount(fsType, targetPath, fsType, opts); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	util.LoggerFromContext(ctx, logger).V(1).Info("Volume mounted", "source", fsType, "target", targetPath, "fsType", fsType, "options", opts)
+	return nil
+}
+
+// cleanup unmounts the volume and removes the target path, errors are logged only,
+// as it is called when publishing already failed.
+func (n *NodeServer) cleanup(ctx context.Context, targetPath string) {
+	if err := n.mounter.Unmount(targetPath); err != nil {
+		util.LoggerFromContext(ctx, logger).Error(err, "failed to unmount target path during cleanup", "target", targetPath)
+	}
+	if err := os.RemoveAll(targetPath); err != nil {
+		util.LoggerFromContext(ctx, logger).Error(err, "failed to remove target path during cleanup", "target", targetPath)
+		return
+	}
+	util.LoggerFromContext(ctx, logger).V(1).Info("Target path cleaned up", "target", targetPath)
+}
+
+// remountReadOnly remounts the tmpfs, or ramfs, at the target path with the ro option.
+// The options of the first mount are passed again, because remount replaces
+// the per-mount flags of the existing mount.
+func (n *NodeServer) remountReadOnly(ctx context.Context, targetPath string, fsType string, sizeLimit int64, options []string) error {
+	opts := append([]string{"remount", "ro"}, mountOptions(sizeLimit, options)...)
+	if err := n.mounter.Mount(fsType, targetPath, fsType, opts); err != nil {
 		return status.Error(codes.Internal, err.Error())
 	}
-	logger.V(1).Info("Volume mounted", "source", "tmpfs", "target", targetPath, "fsType", "tmpfs", "options", opts)
+	util.LoggerFromContext(ctx, logger).V(1).Info("Volume remounted as read-only", "target", targetPath, "options", opts)
 	return nil
 }
 
+// dirModeOption is the mount option setting the permission of the root of the volume.
+func dirModeOption(dirMode fs.FileMode) string {
+	return fmt.Sprintf("mode=%04o", uint32(dirMode))
+}
+
+// defaultMountOptions are the flags of every secret volume, only noexec can be dropped by the secret class.
+var defaultMountOptions = []string{"noexec", "nosuid", "nodev"}
+
+// mountOptions returns the options used to mount the tmpfs, the options of the volume followed by the size.
+// The options of the volume are the ones returned by classMountOptions.
+// The size is omitted when the size limit is 0, for ramfs which has no size limit.
+func mountOptions(sizeLimit int64, options []string) []string {
+	if options == nil {
+		options = defaultMountOptions
+	}
+	if sizeLimit == 0 {
+		return slices.Clone(options)
+	}
+	return append(slices.Clone(options), fmt.Sprintf("size=%d", sizeLimit))
+}
+
+// volumeFSType returns the filesystem of the volume, ramfs when any secret class of the volume requires it,
+// so the secrets are never swapped to disk, otherwise tmpfs.
+// The returned error is an ErrSecretClassInvalid.
+func volumeFSType(secretClasses []*secretsv1alpha1.SecretClass) (string, error) {
+	fsType := fsTypeTmpfs
+	for _, secretClass := range secretClasses {
+		switch secretClass.Spec.FSType {
+		case "", fsTypeTmpfs:
+		case fsTypeRamfs:
+			fsType = fsTypeRamfs
+		default:
+			return "", fmt.Errorf("%w: unsupported fsType %q of secret class %s, must be %s or %s",
+				secretbackend.ErrSecretClassInvalid, secretClass.Spec.FSType, secretClass.Name, fsTypeTmpfs, fsTypeRamfs)
+		}
+	}
+	return fsType, nil
+}
+
+// applyVolumeDefaults returns the volume selector of the volume context merged with the volume defaults
+// of the secret classes, the volume context wins, then the first class. The selector is returned as is
+// when no class has defaults. The returned error is an ErrSecretClassInvalid for invalid defaults,
+// and an ErrInvalidVolumeContext when the defaults conflict with the volume context.
+func applyVolumeDefaults(
+	volumeContext map[string]string,
+	volumeSelector *volume.SecretVolumeSelector,
+	secretClasses []*secretsv1alpha1.SecretClass,
+) (*volume.SecretVolumeSelector, error) {
+	var defaults []map[string]string
+	for _, secretClass := range secretClasses {
+		if len(secretClass.Spec.VolumeDefaults) == 0 {
+			continue
+		}
+		if err := volume.ValidateDefaults(secretClass.Spec.VolumeDefaults); err != nil {
+			return nil, fmt.Errorf("%w: secret class %s: %v", secretbackend.ErrSecretClassInvalid, secretClass.Name, err)
+		}
+		defaults = append(defaults, secretClass.Spec.VolumeDefaults)
+	}
+	if len(defaults) == 0 {
+		return volumeSelector, nil
+	}
+
+	merged, err := volume.NewVolumeSelectorFromMap(volume.MergeDefaults(volumeContext, defaults...))
+	if err != nil {
+		return nil, fmt.Errorf("%w: the volume defaults of the secret classes conflict with the volume: %v",
+			secretbackend.ErrInvalidVolumeContext, err)
+	}
+	return merged, nil
+}
+
+// volumeMountOptions merges the mount options of all the secret classes of the volume,
+// so the volume is mounted with the strictest options, e.g. noexec unless every class allows exec.
+func volumeMountOptions(secretClasses []*secretsv1alpha1.SecretClass) ([]string, error) {
+	var options []string
+	for _, secretClass := range secretClasses {
+		classOptions, err := classMountOptions(secretClass)
+		if err != nil {
+			return nil, err
+		}
+		for _, option := range classOptions {
+			if !slices.Contains(options, option) {
+				options = append(options, option)
+			}
+		}
+	}
+	return options, nil
+}
+
+// classMountOptions merges the mount options of the secret class with the defaults.
+// The options weakening the isolation of the volume are refused, except exec when allowExec is set,
+// and the options managed by the driver, e.g. size and ro, can not be set.
+// The returned error is an ErrSecretClassInvalid.
+func classMountOptions(secretClass *secretsv1alpha1.SecretClass) ([]string, error) {
+	spec := secretClass.Spec
+	invalid := func(option, reason string) error {
+		return fmt.Errorf("%w: mount option %q of secret class %s %s", secretbackend.ErrSecretClassInvalid, option, secretClass.Name, reason)
+	}
+
+	options := slices.Clone(defaultMountOptions)
+	if spec.AllowExec {
+		options = slices.DeleteFunc(options, func(o string) bool { return o == "noexec" })
+	}
+
+	for _, option := range spec.MountOptions {
+		option = strings.TrimSpace(option)
+		name, _, _ := strings.Cut(option, "=")
+		switch {
+		case option == "" || strings.Contains(option, ","):
+			return nil, invalid(option, "is not a single option")
+		case name == "size" || name == "ro" || name == "rw" || name == "remount":
+			return nil, invalid(option, "is managed by the csi driver")
+		case name == "suid" || name == "dev":
+			return nil, invalid(option, "is not allowed")
+		case name == "exec" && !spec.AllowExec:
+			return nil, invalid(option, "requires allowExec")
+		case name == "noexec" && spec.AllowExec:
+			return nil, invalid(option, "conflicts with allowExec")
+		case slices.Contains(options, option) || name == "exec":
+			continue
+		}
+		options = append(options, option)
+	}
+	return options, nil
+}
+
+// isReadOnly checks whether the volume should be published as read-only,
+// either by the readonly flag of the request or by a read-only access mode.
+func isReadOnly(request *csi.NodePublishVolumeRequest) bool {
+	if request.GetReadonly() {
+		return true
+	}
+
+	switch request.GetVolumeCapability().GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return true
+	}
+	return false
+}
+
 // NodeUnpublishVolume unpublishes the volume from the node.
 // unmount the volume from the target path, and remove the target path
 func (n *NodeServer) NodeUnpublishVolume(ctx context.Context, request *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
@@ -234,11 +1147,37 @@ func (n *NodeServer) NodeUnpublishVolume(ctx context.Context, request *csi.NodeU
 
 	targetPath := request.GetTargetPath()
 
-	// unmount the volume from the target path
-	if err := n.mounter.Unmount(targetPath); err != nil {
-		// FIXME: use status.Error to return error
-		// return nil, status.Error(codes.Internal, err.Error())
-		logger.V(0).Info("Volume not found, skip delete volume")
+	// drop the cached secret data of the volume, so it is fetched again when the volume is published again
+	if m := n.untrackMount(targetPath); m != nil {
+		// the pending writes of the pipes keep the volume busy
+		stopFIFOs(m.fifos)
+		for _, class := range m.volumeSelector.SecretClasses() {
+			classSelector := *m.volumeSelector
+			classSelector.Class = class
+			n.cache.Invalidate(secretbackend.NewCacheKey(&classSelector))
+		}
+	}
+
+	// unpublish is idempotent, the volume is already unpublished if the target path does not exist
+	if exist, err := mount.PathExists(targetPath); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	} else if !exist {
+		util.LoggerFromContext(ctx, logger).V(1).Info("Target path not found, volume is already unpublished", "target", targetPath)
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+
+	// unmount the volume from the target path, only if it is mounted
+	notMnt, err := n.mounter.IsLikelyNotMountPoint(targetPath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !notMnt {
+		if err := n.mounter.Unmount(targetPath); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		util.LoggerFromContext(ctx, logger).V(1).Info("Volume unmounted", "target", targetPath)
+	} else {
+		util.LoggerFromContext(ctx, logger).V(1).Info("Target path is not a mount point, skip unmount", "target", targetPath)
 	}
 
 	// remove the target path
@@ -280,6 +1219,30 @@ func (n *NodeServer) NodeStageVolume(ctx context.Context, request *csi.NodeStage
 		return nil, status.Error(codes.InvalidArgument, "Volume capability missing in request")
 	}
 
+	volumeSelector, err := volume.NewVolumeSelectorFromMap(request.GetVolumeContext())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(volumeSelector.SecretClasses()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Required attributes missing in volume context: %s", volume.SecretsZncdataClass)
+	}
+
+	// fail fast, the pod does not wait for the publish to report a missing or misconfigured secret class
+	secretClasses, err := n.getSecretClasses(ctx, volumeSelector.SecretClasses())
+	if err != nil {
+		return nil, err
+	}
+	volumeSelector, err = applyVolumeDefaults(request.GetVolumeContext(), volumeSelector, secretClasses)
+	if err != nil {
+		return nil, backendStatusError(err)
+	}
+	if err := secretbackend.ValidateVolumeSelector(volumeSelector, secretClasses); err != nil {
+		return nil, backendStatusError(err)
+	}
+	if err := n.stage(ctx, request.GetStagingTargetPath(), volumeSelector, secretClasses); err != nil {
+		return nil, err
+	}
+
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
@@ -294,15 +1257,208 @@ func (n *NodeServer) NodeUnstageVolume(ctx context.Context, request *csi.NodeUns
 
 	}
 
+	n.forgetStaged(request.GetStagingTargetPath())
+
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
+// NodeGetVolumeStats returns the usage of the tmpfs mounted at the volume path.
+// Bytes and inodes are reported from statfs, so kubelet can expose them as
+// kubelet_volume_stats_* metrics. The volume condition reports the volumes whose
+// tmpfs is gone or whose secret expired, see volumeCondition.
 func (n *NodeServer) NodeGetVolumeStats(ctx context.Context, request *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	if request.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+
+	volumePath := request.GetVolumePath()
+	if volumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume path missing in request")
+	}
+
+	exist, err := mount.PathExists(volumePath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "Volume path %q not found", volumePath)
+	}
+
+	stats := &unix.Statfs_t{}
+	if err := unix.Statfs(volumePath, stats); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	blockSize := int64(stats.Bsize)
+	total := int64(stats.Blocks) * blockSize
+	available := int64(stats.Bavail) * blockSize
+	used := (int64(stats.Blocks) - int64(stats.Bfree)) * blockSize
+
+	inodes := int64(stats.Files)
+	inodesFree := int64(stats.Ffree)
+
+	condition, err := n.volumeCondition(volumePath)
+	if err != nil {
+		return nil, err
+	}
+
+	util.LoggerFromContext(ctx, logger).V(5).Info("Volume stats", "volumePath", volumePath, "total", total, "used", used, "available", available,
+		"inodes", inodes, "inodesFree", inodesFree, "abnormal", condition.Abnormal)
+
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				Unit:      csi.VolumeUsage_BYTES,
+				Total:     total,
+				Used:      used,
+				Available: available,
+			},
+			{
+				Unit:      csi.VolumeUsage_INODES,
+				Total:     inodes,
+				Used:      inodes - inodesFree,
+				Available: inodesFree,
+			},
+		},
+		VolumeCondition: condition,
+	}, nil
 }
 
+// volumeCondition returns the health of the volume published at the path, kubelet surfaces the abnormal ones
+// as events of the pod. A volume is abnormal when nothing is mounted at the path any more, e.g. the tmpfs
+// was unmounted behind kubelet, or when its secret expired, e.g. the rotation keeps failing.
+// The volumes published before the driver restarted are not tracked, only their mount is checked.
+func (n *NodeServer) volumeCondition(volumePath string) (*csi.VolumeCondition, error) {
+	mountPoints, err := n.mounter.List()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !slices.ContainsFunc(mountPoints, func(mountPoint mount.MountPoint) bool { return mountPoint.Path == volumePath }) {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("volume path %q is not mounted, the secret files are gone", volumePath),
+		}, nil
+	}
+
+	n.mountsLock.Lock()
+	defer n.mountsLock.Unlock()
+	if m, ok := n.mounts[volumePath]; ok && m.expiresTime != nil {
+		expiresTime := time.Unix(*m.expiresTime, 0)
+		if !n.clock.Now().Before(expiresTime) {
+			message := fmt.Sprintf("secret expired at %s", expiresTime.UTC().Format(time.RFC3339))
+			if m.rotationErr != nil {
+				message = fmt.Sprintf("%s, the last %d rotations failed: %v", message, m.failures, m.rotationErr)
+			}
+			return &csi.VolumeCondition{Abnormal: true, Message: message}, nil
+		}
+	}
+	return &csi.VolumeCondition{Message: "volume is healthy"}, nil
+}
+
+// NodeExpandVolume resizes the tmpfs of the volume by remounting it with the new size, the files are kept.
 func (n *NodeServer) NodeExpandVolume(ctx context.Context, request *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	if request.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	volumePath := request.GetVolumePath()
+	if volumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume path missing in request")
+	}
+	mountPoint, err := n.getMountPoint(volumePath)
+	if err != nil {
+		return nil, err
+	}
+	sizeLimit := request.GetCapacityRange().GetRequiredBytes()
+	if sizeLimit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Required bytes of capacity range must not be negative")
+	}
+
+	// the rotation remounts the volume too
+	n.refreshLock.Lock()
+	defer n.refreshLock.Unlock()
+
+	n.mountsLock.Lock()
+	m := n.mounts[volumePath]
+	n.mountsLock.Unlock()
+
+	fsType := mountPoint.Type
+	currentSize := mountSizeLimit(mountPoint.Opts)
+	if m != nil {
+		fsType = m.fsType
+		currentSize = m.sizeLimit
+	}
+	// ramfs has no size limit, and the capacity range is optional
+	if fsType == fsTypeRamfs || sizeLimit == 0 {
+		return &csi.NodeExpandVolumeResponse{CapacityBytes: currentSize}, nil
+	}
+
+	// remount replaces the flags of the mount, so pass the options of the mount again with the new size.
+	// The volume may be published before the driver restarted and not tracked, the options of the mount point are used.
+	opts := []string{"remount"}
+	if m != nil {
+		if m.readOnly {
+			opts = append(opts, "ro")
+		}
+		opts = append(opts, mountOptions(sizeLimit, m.mountOptions)...)
+		if m.volumeSelector.DirMode != 0 {
+			opts = append(opts, dirModeOption(m.volumeSelector.DirMode))
+		}
+	} else {
+		for _, opt := range mountPoint.Opts {
+			if opt != "remount" && !strings.HasPrefix(opt, "size=") {
+				opts = append(opts, opt)
+			}
+		}
+		opts = append(opts, fmt.Sprintf("size=%d", sizeLimit))
+	}
+	if err := n.mounter.Mount(fsType, volumePath, fsType, opts); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	util.LoggerFromContext(ctx, logger).V(1).Info("Volume expanded", "target", volumePath, "options", opts)
+
+	// the rotation remounts the volume with the size, so keep it up to date
+	if m != nil {
+		n.mountsLock.Lock()
+		m.sizeLimit = sizeLimit
+		n.mountsLock.Unlock()
+	}
+
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: sizeLimit}, nil
+}
+
+// getMountPoint returns the mount point of the volume path, it must be a mounted tmpfs or ramfs.
+// The last mount point of the path is the visible one, when there are stacked mounts.
+func (n *NodeServer) getMountPoint(volumePath string) (*mount.MountPoint, error) {
+	mountPoints, err := n.mounter.List()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	for i := len(mountPoints) - 1; i >= 0; i-- {
+		if mountPoints[i].Path != volumePath {
+			continue
+		}
+		if mountPoints[i].Type != fsTypeTmpfs && mountPoints[i].Type != fsTypeRamfs {
+			return nil, status.Errorf(codes.FailedPrecondition, "Volume path %q is mounted as %s, not %s or %s",
+				volumePath, mountPoints[i].Type, fsTypeTmpfs, fsTypeRamfs)
+		}
+		return &mountPoints[i], nil
+	}
+	return nil, status.Errorf(codes.NotFound, "Volume path %q is not mounted", volumePath)
+}
+
+// mountSizeLimit returns the size option of the mount in bytes, 0 when it has none or it is not in bytes,
+// e.g. 64k in /proc/mounts.
+func mountSizeLimit(opts []string) int64 {
+	for _, opt := range opts {
+		if value, ok := strings.CutPrefix(opt, "size="); ok {
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0
+			}
+			return size
+		}
+	}
+	return 0
 }
 
 func (n *NodeServer) NodeGetCapabilities(ctx context.Context, request *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
@@ -320,6 +1476,9 @@ func (n *NodeServer) NodeGetCapabilities(ctx context.Context, request *csi.NodeG
 
 	for _, capability := range []csi.NodeServiceCapability_RPC_Type{
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 	} {
 		capabilities = append(capabilities, newCapabilities(capability))
 	}
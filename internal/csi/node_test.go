@@ -0,0 +1,1820 @@
+package csi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/mount"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	secretbackend "github.com/zncdata-labs/secret-operator/internal/csi/backend"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/util"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := secretsv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func newTestSecretClass() *secretsv1alpha1.SecretClass {
+	return &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "tls",
+		},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{
+				K8sSearch: &secretsv1alpha1.K8sSearchSpec{
+					SearchNamespace: &secretsv1alpha1.SearchNamespaceSpec{
+						Pod: &secretsv1alpha1.PodSpec{},
+					},
+				},
+			},
+		},
+	}
+}
+
+func newTestPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "default",
+		},
+	}
+}
+
+func newTestNodeServer(t *testing.T, objs ...client.Object) *NodeServer {
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objs...).Build()
+	return NewNodeServer("test-node", mount.NewFakeMounter(nil), c)
+}
+
+func newTestPublishRequest(t *testing.T) *csi.NodePublishVolumeRequest {
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: filepath.Join(t.TempDir(), "target"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+		VolumeContext: map[string]string{
+			volume.SecretsZncdataClass:    "tls",
+			volume.CSIStoragePodName:      "test-pod",
+			volume.CSIStoragePodNamespace: "default",
+		},
+	}
+}
+
+func TestNodePublishVolumeNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		objs []client.Object
+	}{
+		{
+			name: "secret class not found",
+			objs: []client.Object{newTestPod()},
+		},
+		{
+			name: "pod not found",
+			objs: []client.Object{newTestSecretClass()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newTestNodeServer(t, tt.objs...)
+
+			_, err := n.NodePublishVolume(context.Background(), newTestPublishRequest(t))
+			if status.Code(err) != codes.NotFound {
+				t.Errorf("unexpected error: got %v, want code %s", err, codes.NotFound)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumePodTerminating(t *testing.T) {
+	pod := newTestPod()
+	pod.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	// the fake client refuses the objects being deleted without finalizer
+	pod.Finalizers = []string{"test/finalizer"}
+	patched := false
+	c := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(newTestSecretClass(), pod, newTestSecret()).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				patched = true
+				return c.Patch(ctx, obj, patch, opts...)
+			},
+		}).
+		Build()
+	recorder := record.NewFakeRecorder(10)
+	n := NewNodeServer("test-node", mount.NewFakeMounter(nil), c).WithEventRecorder(recorder)
+	request := newTestPublishRequest(t)
+
+	_, err := n.NodePublishVolume(context.Background(), request)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("unexpected error: got %v, want code %s", err, codes.FailedPrecondition)
+	}
+	if _, err := os.Stat(filepath.Join(request.GetTargetPath(), "username")); !os.IsNotExist(err) {
+		t.Errorf("expected no secret written for a terminating pod, got %v", err)
+	}
+	if patched {
+		t.Error("unexpected patch of the terminating pod")
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("unexpected event on the terminating pod: %q", <-recorder.Events)
+	}
+}
+
+func newTestSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "default",
+			Labels: map[string]string{
+				volume.SecretsZncdataClass: "tls",
+			},
+		},
+		Data: map[string][]byte{
+			"username": []byte("admin"),
+		},
+	}
+}
+
+func TestNodePublishVolume(t *testing.T) {
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), newTestSecret())
+	request := newTestPublishRequest(t)
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), "username"))
+	if err != nil {
+		t.Fatalf("failed to read secret file: %v", err)
+	}
+	if string(data) != "admin" {
+		t.Errorf("unexpected secret file content: got %q, want %q", data, "admin")
+	}
+}
+
+func TestNodePublishVolumeBinaryData(t *testing.T) {
+	// a keytab like blob, not valid utf-8
+	keytab := []byte{0x05, 0x02, 0x00, 0x00, 0x00, 0x3c, 0xff, 0xfe, 0x80, 0xc3, 0x28, 0x00, 0x0a}
+	secret := newTestSecret()
+	secret.Data["keytab"] = keytab
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), secret)
+	request := newTestPublishRequest(t)
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), "keytab"))
+	if err != nil {
+		t.Fatalf("failed to read secret file: %v", err)
+	}
+	if !bytes.Equal(data, keytab) {
+		t.Errorf("unexpected secret file content: got %x, want %x", data, keytab)
+	}
+}
+
+func TestNodePublishVolumeMaxSecretSize(t *testing.T) {
+	secret := newTestSecret()
+	secret.Data["blob"] = bytes.Repeat([]byte("x"), 1024)
+	mounter := mount.NewFakeMounter(nil)
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).
+		WithObjects(newTestSecretClass(), newTestPod(), secret).
+		Build()
+	n := NewNodeServer("test-node", mounter, c).WithMaxSecretSize(1024)
+	request := newTestPublishRequest(t)
+
+	_, err := n.NodePublishVolume(context.Background(), request)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("unexpected error: got %v, want code %s", err, codes.ResourceExhausted)
+	}
+	if mountPoints, _ := mounter.List(); len(mountPoints) != 0 {
+		t.Errorf("expected nothing to be mounted, got %v", mountPoints)
+	}
+
+	// the limit is on the total size of all the keys
+	n.WithMaxSecretSize(1024 + int64(len("admin")))
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestNodePublishVolumeGzipKeys(t *testing.T) {
+	config := bytes.Repeat([]byte("key=value\n"), 200)
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, _ = w.Write(config)
+	_ = w.Close()
+
+	secret := newTestSecret()
+	secret.Data["config.properties"] = compressed.Bytes()
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), secret)
+	request := newTestPublishRequest(t)
+	request.VolumeContext[volume.GzipKeys] = "config.properties"
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), "config.properties"))
+	if err != nil {
+		t.Fatalf("failed to read secret file: %v", err)
+	}
+	if !bytes.Equal(data, config) {
+		t.Errorf("unexpected secret file content: got %q", data)
+	}
+
+	// the decompressed data is limited by the max secret size
+	n.WithMaxSecretSize(int64(len(config)))
+	request = newTestPublishRequest(t)
+	request.VolumeContext[volume.GzipKeys] = "config.properties"
+	if _, err := n.NodePublishVolume(context.Background(), request); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("unexpected error: got %v, want code %s", err, codes.ResourceExhausted)
+	}
+}
+
+func TestNodePublishVolumeDecodeBase64(t *testing.T) {
+	secret := newTestSecret()
+	secret.Data["keystore"] = []byte(base64.StdEncoding.EncodeToString([]byte{0x00, 0x01, 0xfe, 0xff}))
+	secret.Data["password"] = []byte("not base64!")
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), secret)
+	request := newTestPublishRequest(t)
+	request.VolumeContext[volume.Decode] = string(volume.DecodeBase64)
+	request.VolumeContext[volume.DecodeKeys] = "keystore"
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), "keystore"))
+	if err != nil {
+		t.Fatalf("failed to read secret file: %v", err)
+	}
+	if !bytes.Equal(data, []byte{0x00, 0x01, 0xfe, 0xff}) {
+		t.Errorf("unexpected secret file content: got %q", data)
+	}
+
+	// an invalid value fails the publish, naming the key
+	request = newTestPublishRequest(t)
+	request.VolumeContext[volume.Decode] = string(volume.DecodeBase64)
+	request.VolumeContext[volume.DecodeKeys] = "password"
+	_, err = n.NodePublishVolume(context.Background(), request)
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), `"password"`) {
+		t.Errorf("unexpected error: got %v, want code %s naming the key", err, codes.InvalidArgument)
+	}
+}
+
+func TestNodePublishVolumeEmitMetadata(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pod := newTestPod()
+	pod.UID = "6f2b6c1e-4f2b-4c55-9d57-7b8f3c1b0d0a"
+	n := newTestNodeServer(t, newTestAutoTlsSecretClass("tls"), pod).WithClock(clocktesting.NewFakeClock(now))
+	request := newTestPublishRequest(t)
+	request.VolumeContext[volume.EmitMetadata] = "true"
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), metadataFileName))
+	if err != nil {
+		t.Fatalf("failed to read metadata file: %v", err)
+	}
+	metadata := secretMetadata{}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("invalid metadata file %s: %v", data, err)
+	}
+	if !slices.Equal(metadata.SecretClasses, []string{"tls"}) || metadata.BackendType != secretbackend.BackendTypeAutoTls {
+		t.Errorf("unexpected secret classes %v or backend %s", metadata.SecretClasses, metadata.BackendType)
+	}
+	if !metadata.IssuedAt.Equal(now) {
+		t.Errorf("unexpected issued time: got %s, want %s", metadata.IssuedAt, now)
+	}
+	if metadata.ExpiresAt == nil || !metadata.ExpiresAt.Equal(now.Add(24*time.Hour)) {
+		t.Errorf("unexpected expiration time: got %v, want %s", metadata.ExpiresAt, now.Add(24*time.Hour))
+	}
+	if metadata.PodUID != string(pod.UID) {
+		t.Errorf("unexpected pod uid: got %s, want %s", metadata.PodUID, pod.UID)
+	}
+}
+
+func TestNodePublishVolumeContentHash(t *testing.T) {
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), newTestSecret())
+	request := newTestPublishRequest(t)
+	// the target path of kubelet ends with the name of the pod volume
+	request.TargetPath = filepath.Join(t.TempDir(), "credentials", "mount")
+	request.VolumeContext[volume.EmitMetadata] = "true"
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := contentHash(map[string][]byte{"username": []byte("admin")},
+		conversionOptions(n.mounts[request.GetTargetPath()].volumeSelector, map[string]string{}))
+	pod := &corev1.Pod{}
+	if err := n.client.Get(context.Background(), client.ObjectKey{Name: "test-pod", Namespace: "default"}, pod); err != nil {
+		t.Fatal(err)
+	}
+	hashes := map[string]string{}
+	if err := json.Unmarshal([]byte(pod.Annotations[volume.SecretZncdataContentHash]), &hashes); err != nil {
+		t.Fatalf("invalid content hash annotation %q: %v", pod.Annotations[volume.SecretZncdataContentHash], err)
+	}
+	if hashes["credentials"] != want {
+		t.Errorf("unexpected content hash annotation: got %v, want credentials: %s", hashes, want)
+	}
+
+	data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), metadataFileName))
+	if err != nil {
+		t.Fatalf("failed to read metadata file: %v", err)
+	}
+	metadata := secretMetadata{}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("invalid metadata file %s: %v", data, err)
+	}
+	if metadata.ContentHash != want {
+		t.Errorf("unexpected content hash in metadata: got %s, want %s", metadata.ContentHash, want)
+	}
+}
+
+func TestNodePublishVolumeEmitMetadataConflict(t *testing.T) {
+	secret := newTestSecret()
+	secret.Data[metadataFileName] = []byte("{}")
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), secret)
+	request := newTestPublishRequest(t)
+	request.VolumeContext[volume.EmitMetadata] = "true"
+
+	_, err := n.NodePublishVolume(context.Background(), request)
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), metadataFileName) {
+		t.Fatalf("unexpected error: got %v, want code %s for the key %s", err, codes.InvalidArgument, metadataFileName)
+	}
+}
+
+func TestNodePublishVolumeItems(t *testing.T) {
+	secret := newTestSecret()
+	secret.Data["password"] = []byte("secret")
+	secret.Data["host"] = []byte("db.example.com")
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), secret)
+	request := newTestPublishRequest(t)
+	request.VolumeContext[volume.Items] = "username:user,host"
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, want := range map[string]string{"user": "admin", "host": "db.example.com"} {
+		data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), name))
+		if err != nil {
+			t.Fatalf("failed to read secret file %s: %v", name, err)
+		}
+		if string(data) != want {
+			t.Errorf("unexpected content of %s: got %q, want %q", name, data, want)
+		}
+	}
+	for _, name := range []string{"username", "password"} {
+		if _, err := os.Lstat(filepath.Join(request.GetTargetPath(), name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be written, got %v", name, err)
+		}
+	}
+}
+
+func TestNodePublishVolumeTrailingNewline(t *testing.T) {
+	tests := []struct {
+		mode     string
+		username string
+		password string
+	}{
+		{mode: "strip", username: "admin", password: "secret"},
+		{mode: "ensure", username: "admin\n", password: "secret\n"},
+		{mode: "preserve", username: "admin", password: "secret\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			secret := newTestSecret()
+			secret.Data["password"] = []byte("secret\n")
+			n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), secret)
+			request := newTestPublishRequest(t)
+			request.VolumeContext[volume.TrailingNewline] = tt.mode
+
+			if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for name, want := range map[string]string{"username": tt.username, "password": tt.password} {
+				data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), name))
+				if err != nil {
+					t.Fatalf("failed to read secret file %s: %v", name, err)
+				}
+				if string(data) != want {
+					t.Errorf("unexpected content of %s: got %q, want %q", name, data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeJSONBinary(t *testing.T) {
+	secret := newTestSecret()
+	secret.Data["keytab"] = []byte{0x05, 0x02, 0xff, 0xfe}
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), secret)
+	request := newTestPublishRequest(t)
+	request.VolumeContext[volume.SecretsZncdataFormat] = string(volume.SecretFormatJSON)
+
+	if _, err := n.NodePublishVolume(context.Background(), request); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("unexpected error: got %v, want code %s", err, codes.InvalidArgument)
+	}
+}
+
+func TestNodePublishVolumeTemplates(t *testing.T) {
+	secret := newTestSecret()
+	secret.Data["password"] = []byte("secret")
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "templates", Namespace: "default"},
+		Data:       map[string]string{"user.txt": "user={{ .username }}"},
+	}
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), secret, configMap)
+	request := newTestPublishRequest(t)
+	request.VolumeContext[volume.Template] = "postgres://{{ .username }}:{{ .password }}@db:5432/app"
+	request.VolumeContext[volume.TemplateFile] = "dsn"
+	request.VolumeContext[volume.TemplateConfigMap] = "templates"
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"dsn":      "postgres://admin:secret@db:5432/app",
+		"user.txt": "user=admin",
+		"username": "admin",
+	} {
+		data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), name))
+		if err != nil {
+			t.Fatalf("failed to read secret file %s: %v", name, err)
+		}
+		if string(data) != want {
+			t.Errorf("unexpected content of %s: got %q, want %q", name, data, want)
+		}
+	}
+}
+
+func TestNodePublishVolumeTemplateMissingKey(t *testing.T) {
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), newTestSecret())
+	request := newTestPublishRequest(t)
+	request.VolumeContext[volume.Template] = "{{ .username }}:{{ .password }}"
+	request.VolumeContext[volume.TemplateFile] = "credentials"
+
+	_, err := n.NodePublishVolume(context.Background(), request)
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "password") {
+		t.Errorf("unexpected error: got %v, want code %s about the missing key", err, codes.InvalidArgument)
+	}
+	if _, err := os.Stat(request.GetTargetPath()); !os.IsNotExist(err) {
+		t.Errorf("expected nothing mounted, got %v", err)
+	}
+
+	request = newTestPublishRequest(t)
+	request.VolumeContext[volume.TemplateConfigMap] = "missing"
+	if _, err := n.NodePublishVolume(context.Background(), request); status.Code(err) != codes.NotFound {
+		t.Errorf("unexpected error: got %v, want code %s", err, codes.NotFound)
+	}
+}
+
+func TestNodePublishVolumeItemsMissingKey(t *testing.T) {
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), newTestSecret())
+	request := newTestPublishRequest(t)
+	request.VolumeContext[volume.Items] = "username,password"
+
+	_, err := n.NodePublishVolume(context.Background(), request)
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), `"password"`) {
+		t.Fatalf("unexpected error: got %v, want code %s for the key password", err, codes.InvalidArgument)
+	}
+}
+
+// newTestAutoTlsSecretClass returns an autoTls secret class generating its CA.
+func newTestAutoTlsSecretClass(name string) *secretsv1alpha1.SecretClass {
+	return &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{
+				AutoTls: &secretsv1alpha1.AutoTlsSpec{
+					CA: &secretsv1alpha1.CASpec{
+						AutoGenerated:         true,
+						CACertificateLifeTime: "8760h",
+						Secret:                &secretsv1alpha1.SecretSpec{Name: name + "-ca", Namespace: "default"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestNodePublishVolumeSoonestExpiration(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	n := newTestNodeServer(t, newTestAutoTlsSecretClass("tls"), newTestPod()).WithClock(clocktesting.NewFakeClock(now))
+
+	// the volumes of the same pod are published one by one, the soonest expiration must be kept
+	for _, lifetime := range []string{"48h", "24h", "72h"} {
+		request := newTestPublishRequest(t)
+		request.VolumeContext[volume.CertLifeTime] = lifetime
+		if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+			t.Fatalf("unexpected error publishing volume with lifetime %s: %v", lifetime, err)
+		}
+	}
+
+	pod := &corev1.Pod{}
+	if err := n.client.Get(context.Background(), client.ObjectKeyFromObject(newTestPod()), pod); err != nil {
+		t.Fatal(err)
+	}
+	want := strconv.FormatInt(now.Add(24*time.Hour).Unix(), 10)
+	if got := pod.Annotations[volume.SecretZncdataExpirationTime]; got != want {
+		t.Errorf("unexpected expiration time annotation: got %s, want %s", got, want)
+	}
+}
+
+func TestNodePublishVolumePodAnnotationDisabled(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	patches := 0
+	c := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(newTestAutoTlsSecretClass("tls"), newTestPod()).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				patches++
+				return c.Patch(ctx, obj, patch, opts...)
+			},
+		}).
+		Build()
+	n := NewNodeServer("test-node", mount.NewFakeMounter(nil), c).
+		WithClock(clocktesting.NewFakeClock(now)).
+		WithPodAnnotationDisabled(true)
+	request := newTestPublishRequest(t)
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if patches != 0 {
+		t.Errorf("unexpected pod patches: got %d, want 0", patches)
+	}
+
+	// the expiration time is still tracked for the rotation
+	m := n.mounts[request.GetTargetPath()]
+	if m == nil || m.expiresTime == nil || *m.expiresTime != now.Add(24*time.Hour).Unix() {
+		t.Errorf("unexpected tracked mount: %+v", m)
+	}
+}
+
+func TestNodePublishVolumeAPIReader(t *testing.T) {
+	// the cache is not synced yet, the secret class and the pod are not found, the writes go to the API server
+	scheme := newTestScheme(t)
+	cached := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(newTestSecretClass(), newTestPod(), newTestSecret()).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				switch obj.(type) {
+				case *secretsv1alpha1.SecretClass, *corev1.Pod:
+					return apierrors.NewNotFound(corev1.Resource("unsynced"), key.Name)
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+		}).
+		Build()
+	direct := fake.NewClientBuilder().WithScheme(scheme).WithObjects(newTestSecretClass(), newTestPod()).Build()
+
+	request := newTestPublishRequest(t)
+	n := NewNodeServer("test-node", mount.NewFakeMounter(nil), cached)
+	_, err := n.NodePublishVolume(context.Background(), request)
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound without the API reader, got: %v", err)
+	}
+
+	n = NewNodeServer("test-node", mount.NewFakeMounter(nil), cached).WithAPIReader(direct)
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), "username"))
+	if err != nil || string(data) != "admin" {
+		t.Errorf("unexpected secret file: %q, error %v", data, err)
+	}
+}
+
+func TestNodePublishVolumeFIFO(t *testing.T) {
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), newTestSecret()).WithFIFOEnabled(true)
+	request := newTestPublishRequest(t)
+	request.VolumeContext[volume.SecretsZncdataFormat] = string(volume.SecretFormatFIFO)
+	request.VolumeContext[volume.FIFOKeys] = "username"
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fileName := filepath.Join(request.GetTargetPath(), "username")
+	info, err := os.Lstat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Type() != fs.ModeNamedPipe {
+		t.Fatalf("unexpected file type: %s", info.Mode())
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil || string(data) != "admin" {
+		t.Errorf("unexpected fifo content: %q, error %v", data, err)
+	}
+
+	unpublish := func() {
+		done := make(chan error)
+		go func() {
+			_, err := n.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   request.GetVolumeId(),
+				TargetPath: request.GetTargetPath(),
+			})
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("unpublish blocked by the fifo")
+		}
+	}
+	unpublish()
+
+	// the writer still waiting for a reader does not block the unpublish
+	request = newTestPublishRequest(t)
+	request.VolumeContext[volume.SecretsZncdataFormat] = string(volume.SecretFormatFIFO)
+	request.VolumeContext[volume.FIFOKeys] = "username"
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unpublish()
+}
+
+func TestNodePublishVolumeFIFOInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		key     string
+		code    codes.Code
+	}{
+		{name: "not enabled", key: "username", code: codes.FailedPrecondition},
+		{name: "missing key", enabled: true, key: "token", code: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), newTestSecret()).WithFIFOEnabled(tt.enabled)
+			request := newTestPublishRequest(t)
+			request.VolumeContext[volume.SecretsZncdataFormat] = string(volume.SecretFormatFIFO)
+			request.VolumeContext[volume.FIFOKeys] = tt.key
+
+			_, err := n.NodePublishVolume(context.Background(), request)
+			if status.Code(err) != tt.code {
+				t.Errorf("unexpected error: got %v, want code %s", err, tt.code)
+			}
+			if _, err := os.Stat(request.GetTargetPath()); !os.IsNotExist(err) {
+				t.Errorf("target path not cleaned up: %v", err)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeTTL(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// the secrets of k8sSearch have no lifetime, the ttl expires them
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), newTestSecret()).WithClock(clocktesting.NewFakeClock(now))
+	request := newTestPublishRequest(t)
+	request.VolumeContext[volume.TTL] = "1h"
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pod := &corev1.Pod{}
+	if err := n.client.Get(context.Background(), client.ObjectKeyFromObject(newTestPod()), pod); err != nil {
+		t.Fatal(err)
+	}
+	want := strconv.FormatInt(now.Add(time.Hour).Unix(), 10)
+	if got := pod.Annotations[volume.SecretZncdataExpirationTime]; got != want {
+		t.Errorf("unexpected expiration time annotation: got %s, want %s", got, want)
+	}
+
+	// the ttl can not exceed the max certificate lifetime of the secret class
+	n = newTestNodeServer(t, newTestAutoTlsSecretClass("tls"), newTestPod()).WithClock(clocktesting.NewFakeClock(now))
+	request = newTestPublishRequest(t)
+	request.VolumeContext[volume.TTL] = "1000h"
+	if _, err := n.NodePublishVolume(context.Background(), request); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("unexpected error: got %v, want code %s", err, codes.InvalidArgument)
+	}
+}
+
+// newTestSharedSecretClass returns a k8sSearch secret class named shared, with a secret of the given data.
+func newTestSharedSecretClass(data map[string][]byte) (*secretsv1alpha1.SecretClass, *corev1.Secret) {
+	secretClass := newTestSecretClass()
+	secretClass.Name = "shared"
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "shared-secret",
+			Namespace: "default",
+			Labels:    map[string]string{volume.SecretsZncdataClass: "shared"},
+		},
+		Data: data,
+	}
+	return secretClass, secret
+}
+
+func TestNodePublishVolumeMultipleClasses(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sharedClass, sharedSecret := newTestSharedSecretClass(map[string][]byte{"config.yaml": []byte("debug: true")})
+	n := newTestNodeServer(t, newTestAutoTlsSecretClass("tls"), sharedClass, sharedSecret, newTestPod()).
+		WithClock(clocktesting.NewFakeClock(now))
+	request := newTestPublishRequest(t)
+	delete(request.VolumeContext, volume.SecretsZncdataClass)
+	request.VolumeContext[volume.SecretsZncdataClasses] = "tls,shared"
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"tls.crt", "tls.key", "ca.crt", "config.yaml"} {
+		if _, err := os.Stat(filepath.Join(request.GetTargetPath(), name)); err != nil {
+			t.Errorf("expected %s in the volume: %v", name, err)
+		}
+	}
+
+	// the shared class does not expire, the volume expires with the certificate
+	m := n.mounts[request.GetTargetPath()]
+	if m == nil || m.expiresTime == nil || *m.expiresTime != now.Add(24*time.Hour).Unix() {
+		t.Errorf("expected the volume to expire with the certificate, got %v", m)
+	}
+}
+
+func TestNodePublishVolumeMultipleClassesCollision(t *testing.T) {
+	sharedClass, sharedSecret := newTestSharedSecretClass(map[string][]byte{"username": []byte("shared")})
+	mounter := mount.NewFakeMounter(nil)
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).
+		WithObjects(newTestSecretClass(), newTestSecret(), sharedClass, sharedSecret, newTestPod()).
+		Build()
+	n := NewNodeServer("test-node", mounter, c)
+	request := newTestPublishRequest(t)
+	delete(request.VolumeContext, volume.SecretsZncdataClass)
+	request.VolumeContext[volume.SecretsZncdataClasses] = "tls,shared"
+
+	_, err := n.NodePublishVolume(context.Background(), request)
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), `"username"`) {
+		t.Fatalf("unexpected error: got %v, want code %s for the key username", err, codes.InvalidArgument)
+	}
+	if mountPoints, _ := mounter.List(); len(mountPoints) != 0 {
+		t.Errorf("expected nothing to be mounted, got %v", mountPoints)
+	}
+}
+
+func TestNodePublishVolumeAllowedNamespaces(t *testing.T) {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "default",
+			Labels: map[string]string{"tenant": "a"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		allowed  *secretsv1alpha1.AllowedNamespacesSpec
+		wantCode codes.Code
+	}{
+		{
+			name:     "not restricted",
+			wantCode: codes.OK,
+		},
+		{
+			name:     "allowed by name",
+			allowed:  &secretsv1alpha1.AllowedNamespacesSpec{Names: []string{"kube-system", "default"}},
+			wantCode: codes.OK,
+		},
+		{
+			name:     "denied by name",
+			allowed:  &secretsv1alpha1.AllowedNamespacesSpec{Names: []string{"kube-system"}},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "allowed by selector",
+			allowed: &secretsv1alpha1.AllowedNamespacesSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "a"}},
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "denied by selector",
+			allowed: &secretsv1alpha1.AllowedNamespacesSpec{
+				Names:    []string{"kube-system"},
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "b"}},
+			},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "empty",
+			allowed:  &secretsv1alpha1.AllowedNamespacesSpec{},
+			wantCode: codes.PermissionDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secretClass := newTestSecretClass()
+			secretClass.Spec.AllowedNamespaces = tt.allowed
+			n := newTestNodeServer(t, secretClass, newTestPod(), newTestSecret(), namespace)
+			request := newTestPublishRequest(t)
+
+			_, err := n.NodePublishVolume(context.Background(), request)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("unexpected error: got %v, want code %s", err, tt.wantCode)
+			}
+
+			// a denied volume must not be mounted
+			if tt.wantCode != codes.OK {
+				if _, err := os.Stat(request.GetTargetPath()); !os.IsNotExist(err) {
+					t.Errorf("expected target path not to be created, got: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeCleanupOnWriteFailure(t *testing.T) {
+	secret := newTestSecret()
+	// the key is not a valid file name, so writing it fails
+	secret.Data["missing/username"] = []byte("admin")
+
+	mounter := mount.NewFakeMounter(nil)
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(newTestSecretClass(), newTestPod(), secret).Build()
+	n := NewNodeServer("test-node", mounter, c)
+	request := newTestPublishRequest(t)
+
+	_, err := n.NodePublishVolume(context.Background(), request)
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("unexpected error: got %v, want code %s", err, codes.Internal)
+	}
+
+	if mountPoints, _ := mounter.List(); len(mountPoints) != 0 {
+		t.Errorf("expected target path to be unmounted, got mount points %v", mountPoints)
+	}
+	if _, err := os.Stat(request.GetTargetPath()); !os.IsNotExist(err) {
+		t.Errorf("expected target path to be removed, got %v", err)
+	}
+}
+
+func TestBackendStatusError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want codes.Code
+	}{
+		{err: fmt.Errorf("wrapped: %w", secretbackend.ErrSecretClassNotFound), want: codes.NotFound},
+		{err: fmt.Errorf("wrapped: %w", secretbackend.ErrSecretNotFound), want: codes.NotFound},
+		{err: fmt.Errorf("wrapped: %w", secretbackend.ErrSecretClassInvalid), want: codes.FailedPrecondition},
+		{err: fmt.Errorf("wrapped: %w", secretbackend.ErrInvalidVolumeContext), want: codes.InvalidArgument},
+		{err: fmt.Errorf("wrapped: %w", secretbackend.ErrPermissionDenied), want: codes.PermissionDenied},
+		{err: fmt.Errorf("wrapped: %w", secretbackend.ErrBackendUnavailable), want: codes.Unavailable},
+		{err: fmt.Errorf("wrapped: %w", secretbackend.ErrBackendTimeout), want: codes.DeadlineExceeded},
+		{err: errors.New("unknown"), want: codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			err := backendStatusError(tt.err)
+			if status.Code(err) != tt.want {
+				t.Errorf("unexpected code: got %s, want %s", status.Code(err), tt.want)
+			}
+			if status.Convert(err).Message() != tt.err.Error() {
+				t.Errorf("unexpected message: got %q, want %q", status.Convert(err).Message(), tt.err.Error())
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeVaultAuthFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+	}))
+	defer server.Close()
+
+	secretClass := &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "tls"},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{
+				Vault: &secretsv1alpha1.VaultSpec{Address: server.URL, Role: "app"},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(secretClass, newTestPod()).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				subResource.(*authenticationv1.TokenRequest).Status.Token = "test-jwt"
+				return nil
+			},
+		}).
+		Build()
+	n := NewNodeServer("test-node", mount.NewFakeMounter(nil), c)
+
+	_, err := n.NodePublishVolume(context.Background(), newTestPublishRequest(t))
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("unexpected error: got %v, want code %s", err, codes.Unavailable)
+	}
+	for _, want := range []string{"vault backend", "secret class tls", "pod default/test-pod", "permission denied"} {
+		if !strings.Contains(status.Convert(err).Message(), want) {
+			t.Errorf("expected %q in error message, got: %s", want, status.Convert(err).Message())
+		}
+	}
+}
+
+func TestNodeUnpublishVolume(t *testing.T) {
+	mounter := mount.NewFakeMounter(nil)
+	n := NewNodeServer("test-node", mounter, nil)
+
+	targetPath := filepath.Join(t.TempDir(), "target")
+	if err := os.MkdirAll(targetPath, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := mounter.Mount("tmpfs", targetPath, "tmpfs", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	request := &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: targetPath,
+	}
+
+	if _, err := n.NodeUnpublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mountPoints, _ := mounter.List(); len(mountPoints) != 0 {
+		t.Errorf("expected target path to be unmounted, got mount points %v", mountPoints)
+	}
+	if _, err := os.Stat(targetPath); !os.IsNotExist(err) {
+		t.Errorf("expected target path to be removed, got %v", err)
+	}
+
+	// unpublish again, the target path does not exist
+	if _, err := n.NodeUnpublishVolume(context.Background(), request); err != nil {
+		t.Errorf("unexpected error when unpublishing again: %v", err)
+	}
+}
+
+func TestNodeUnpublishVolumeNotMounted(t *testing.T) {
+	mounter := mount.NewFakeMounter(nil)
+	n := NewNodeServer("test-node", mounter, nil)
+
+	targetPath := filepath.Join(t.TempDir(), "target")
+	if err := os.MkdirAll(targetPath, 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	request := &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: targetPath,
+	}
+
+	if _, err := n.NodeUnpublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, action := range mounter.GetLog() {
+		if action.Action == mount.FakeActionUnmount {
+			t.Errorf("expected no unmount for a path which is not mounted")
+		}
+	}
+	if _, err := os.Stat(targetPath); !os.IsNotExist(err) {
+		t.Errorf("expected target path to be removed, got %v", err)
+	}
+}
+
+func TestNodePublishVolumeClassFallback(t *testing.T) {
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), newTestSecret())
+	request := newTestPublishRequest(t)
+	// a volume provisioned with the class in the StorageClass parameters
+	delete(request.VolumeContext, volume.SecretsZncdataClass)
+	request.VolumeContext[volume.SecretClassName] = "tls"
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), "username")); err != nil || string(data) != "admin" {
+		t.Errorf("unexpected secret file content: got %q, %v", data, err)
+	}
+}
+
+func TestNodePublishVolumeMissingAttributes(t *testing.T) {
+	tests := []struct {
+		name      string
+		ephemeral string
+		contains  string
+	}{
+		{
+			name:     "persistent",
+			contains: "PVC annotations",
+		},
+		{
+			name:      "ephemeral",
+			ephemeral: "true",
+			contains:  "ephemeral inline volume",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newTestNodeServer(t)
+			request := newTestPublishRequest(t)
+			delete(request.VolumeContext, volume.SecretsZncdataClass)
+			if tt.ephemeral != "" {
+				request.VolumeContext[volume.CSIStorageEphemeral] = tt.ephemeral
+			}
+
+			_, err := n.NodePublishVolume(context.Background(), request)
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("unexpected error: got %v, want code %s", err, codes.InvalidArgument)
+			}
+			message := status.Convert(err).Message()
+			if !strings.Contains(message, tt.contains) || !strings.Contains(message, volume.SecretsZncdataClass) {
+				t.Errorf("unexpected error message: %s", message)
+			}
+		})
+	}
+}
+
+func TestClassMountOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		mountOptions []string
+		allowExec    bool
+		expected     []string
+		wantErr      bool
+	}{
+		{
+			name:     "default",
+			expected: []string{"noexec", "nosuid", "nodev"},
+		},
+		{
+			name:         "extra options",
+			mountOptions: []string{"noatime", " nr_inodes=64 ", "nodev"},
+			expected:     []string{"noexec", "nosuid", "nodev", "noatime", "nr_inodes=64"},
+		},
+		{
+			name:      "allow exec",
+			allowExec: true,
+			expected:  []string{"nosuid", "nodev"},
+		},
+		{
+			name:         "exec with allow exec",
+			mountOptions: []string{"exec"},
+			allowExec:    true,
+			expected:     []string{"nosuid", "nodev"},
+		},
+		{
+			name:         "exec without allow exec",
+			mountOptions: []string{"exec"},
+			wantErr:      true,
+		},
+		{
+			name:         "noexec with allow exec",
+			mountOptions: []string{"noexec"},
+			allowExec:    true,
+			wantErr:      true,
+		},
+		{
+			name:         "suid",
+			mountOptions: []string{"suid"},
+			allowExec:    true,
+			wantErr:      true,
+		},
+		{
+			name:         "dev",
+			mountOptions: []string{"dev"},
+			wantErr:      true,
+		},
+		{
+			name:         "size",
+			mountOptions: []string{"size=1G"},
+			wantErr:      true,
+		},
+		{
+			name:         "multiple options",
+			mountOptions: []string{"noatime,suid"},
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secretClass := newTestSecretClass()
+			secretClass.Spec.MountOptions = tt.mountOptions
+			secretClass.Spec.AllowExec = tt.allowExec
+
+			options, err := classMountOptions(secretClass)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, secretbackend.ErrSecretClassInvalid) {
+					t.Errorf("expected ErrSecretClassInvalid, got %v", err)
+				}
+				return
+			}
+			if !slices.Equal(options, tt.expected) {
+				t.Errorf("unexpected options: got %v, want %v", options, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeFSGroup(t *testing.T) {
+	fsGroup := int64(1234)
+
+	tests := []struct {
+		name    string
+		fsGroup *int64
+	}{
+		{name: "no fsGroup"},
+		{name: "fsGroup", fsGroup: &fsGroup},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestPod()
+			pod.Spec.SecurityContext = &corev1.PodSecurityContext{FSGroup: tt.fsGroup}
+			n := newTestNodeServer(t, newTestSecretClass(), pod, newTestSecret())
+			request := newTestPublishRequest(t)
+
+			if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var stat unix.Stat_t
+			if err := unix.Stat(request.GetTargetPath(), &stat); err != nil {
+				t.Fatal(err)
+			}
+			if tt.fsGroup == nil {
+				if stat.Mode&unix.S_ISGID != 0 {
+					t.Errorf("expected no setgid bit without fsGroup, got mode %o", stat.Mode&07777)
+				}
+				return
+			}
+			if int64(stat.Gid) != *tt.fsGroup || stat.Mode&07777 != fsGroupDirMode {
+				t.Errorf("unexpected volume root: got gid %d mode %o, want gid %d mode %o", stat.Gid, stat.Mode&07777, *tt.fsGroup, fsGroupDirMode)
+			}
+
+			// already owned by the fsGroup, nothing to change
+			podInfo := pod_info.NewPodInfo(n.client, pod, &volume.SecretVolumeSelector{})
+			if err := setVolumeGroup(context.Background(), request.GetTargetPath(), podInfo, 0); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeDirMode(t *testing.T) {
+	fsGroup := int64(1234)
+	uid := int64(os.Getuid())
+
+	tests := []struct {
+		name    string
+		fsGroup *int64
+		want    uint32
+	}{
+		{name: "no fsGroup", want: 0700},
+		{name: "fsGroup", fsGroup: &fsGroup, want: unix.S_ISGID | 0700},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestPod()
+			pod.Spec.SecurityContext = &corev1.PodSecurityContext{FSGroup: tt.fsGroup}
+			n := newTestNodeServer(t, newTestSecretClass(), pod, newTestSecret())
+			request := newTestPublishRequest(t)
+			request.VolumeContext[volume.DirMode] = "0700"
+			request.VolumeContext[volume.UID] = strconv.FormatInt(uid, 10)
+
+			if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var stat unix.Stat_t
+			if err := unix.Stat(request.GetTargetPath(), &stat); err != nil {
+				t.Fatal(err)
+			}
+			if stat.Mode&07777 != tt.want || int64(stat.Uid) != uid {
+				t.Errorf("unexpected volume root: got mode %o uid %d, want mode %o uid %d", stat.Mode&07777, stat.Uid, tt.want, uid)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeDirModeMountOption(t *testing.T) {
+	tests := []struct {
+		name    string
+		dirMode string
+		want    string
+	}{
+		{name: "dirMode", dirMode: "0750", want: "mode=0750"},
+		{name: "no dirMode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounter := mount.NewFakeMounter(nil)
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(newTestSecretClass(), newTestPod(), newTestSecret()).Build()
+			n := NewNodeServer("test-node", mounter, c)
+			request := newTestPublishRequest(t)
+			if tt.dirMode != "" {
+				request.VolumeContext[volume.DirMode] = tt.dirMode
+			}
+
+			if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// the mode of the root is set by the mount itself
+			mountPoints, _ := mounter.List()
+			if len(mountPoints) != 1 {
+				t.Fatalf("unexpected mount points: %v", mountPoints)
+			}
+			var mode string
+			for _, opt := range mountPoints[0].Opts {
+				if strings.HasPrefix(opt, "mode=") {
+					mode = opt
+				}
+			}
+			if mode != tt.want {
+				t.Errorf("unexpected mode option: got %q, want %q in %v", mode, tt.want, mountPoints[0].Opts)
+			}
+		})
+	}
+}
+
+// storeBackend is a custom backend registered at build time, it returns the parameters of the secret class.
+type storeBackend struct {
+	parameters map[string]string
+}
+
+func (s *storeBackend) GetSecretData(ctx context.Context) (*util.SecretContent, error) {
+	data := map[string][]byte{}
+	for key, value := range s.parameters {
+		data[key] = []byte(value)
+	}
+	return &util.SecretContent{Data: data}, nil
+}
+
+func (s *storeBackend) Validate(ctx context.Context) error {
+	return nil
+}
+
+func init() {
+	secretbackend.RegisterBackend("test-store", func(config *secretbackend.BackendConfig) (secretbackend.IBackend, error) {
+		return &storeBackend{parameters: config.SecretClass.Spec.Backend.Custom.Parameters}, nil
+	})
+}
+
+func TestNodePublishVolumeCustomBackend(t *testing.T) {
+	secretClass := newTestSecretClass()
+	secretClass.Spec.Backend = &secretsv1alpha1.BackendSpec{
+		Custom: &secretsv1alpha1.CustomSpec{Type: "test-store", Parameters: map[string]string{"token": "secret"}},
+	}
+	n := newTestNodeServer(t, secretClass, newTestPod())
+	request := newTestPublishRequest(t)
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), "token"))
+	if err != nil {
+		t.Fatalf("failed to read secret file: %v", err)
+	}
+	if string(data) != "secret" {
+		t.Errorf("unexpected content of token: got %q, want %q", data, "secret")
+	}
+
+	// a backend not registered in the csi driver is a misconfigured secret class
+	secretClass.Spec.Backend.Custom.Type = "other-store"
+	n = newTestNodeServer(t, secretClass, newTestPod())
+	if _, err := n.NodePublishVolume(context.Background(), newTestPublishRequest(t)); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("unexpected error: got %v, want code %s", err, codes.FailedPrecondition)
+	}
+}
+
+func TestNodePublishVolumeMountOptions(t *testing.T) {
+	secretClass := newTestSecretClass()
+	secretClass.Spec.AllowExec = true
+	secretClass.Spec.MountOptions = []string{"noatime"}
+
+	mounter := mount.NewFakeMounter(nil)
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(secretClass, newTestPod(), newTestSecret()).Build()
+	n := NewNodeServer("test-node", mounter, c)
+	request := newTestPublishRequest(t)
+	request.Readonly = true
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the read-only remount must keep the options of the secret class
+	mountPoints, _ := mounter.List()
+	for _, mountPoint := range mountPoints {
+		if slices.Contains(mountPoint.Opts, "noexec") {
+			t.Errorf("expected no noexec option with allowExec, got %v", mountPoint.Opts)
+		}
+		if !slices.Contains(mountPoint.Opts, "noatime") {
+			t.Errorf("expected noatime option of secret class, got %v", mountPoint.Opts)
+		}
+	}
+
+	secretClass.Spec.MountOptions = []string{"suid"}
+	n = newTestNodeServer(t, secretClass, newTestPod(), newTestSecret())
+	if _, err := n.NodePublishVolume(context.Background(), newTestPublishRequest(t)); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("unexpected error: got %v, want code %s", err, codes.FailedPrecondition)
+	}
+}
+
+func TestVolumeFSType(t *testing.T) {
+	tests := []struct {
+		name     string
+		fsTypes  []string
+		expected string
+		wantErr  bool
+	}{
+		{name: "default", fsTypes: []string{""}, expected: "tmpfs"},
+		{name: "tmpfs", fsTypes: []string{"tmpfs"}, expected: "tmpfs"},
+		{name: "ramfs", fsTypes: []string{"ramfs"}, expected: "ramfs"},
+		{name: "ramfs required by one class", fsTypes: []string{"tmpfs", "ramfs", ""}, expected: "ramfs"},
+		{name: "unsupported", fsTypes: []string{"ext4"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var secretClasses []*secretsv1alpha1.SecretClass
+			for _, fsType := range tt.fsTypes {
+				secretClass := newTestSecretClass()
+				secretClass.Spec.FSType = fsType
+				secretClasses = append(secretClasses, secretClass)
+			}
+
+			fsType, err := volumeFSType(secretClasses)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, secretbackend.ErrSecretClassInvalid) {
+					t.Errorf("expected ErrSecretClassInvalid, got %v", err)
+				}
+				return
+			}
+			if fsType != tt.expected {
+				t.Errorf("unexpected fsType: got %s, want %s", fsType, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeRamfs(t *testing.T) {
+	secretClass := newTestSecretClass()
+	secretClass.Spec.FSType = "ramfs"
+
+	mounter := mount.NewFakeMounter(nil)
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(secretClass, newTestPod(), newTestSecret()).Build()
+	n := NewNodeServer("test-node", mounter, c)
+	request := newTestPublishRequest(t)
+	request.Readonly = true
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// ramfs has no size option, the read-only remount keeps it
+	mountPoints, _ := mounter.List()
+	for _, mountPoint := range mountPoints {
+		if mountPoint.Type != "ramfs" {
+			t.Errorf("unexpected fsType: got %s, want ramfs", mountPoint.Type)
+		}
+		for _, option := range mountPoint.Opts {
+			if strings.HasPrefix(option, "size=") {
+				t.Errorf("unexpected size option of ramfs: %v", mountPoint.Opts)
+			}
+		}
+	}
+
+	// ramfs is only bounded by the max secret size
+	n = newTestNodeServer(t, secretClass, newTestPod(), newTestSecret()).WithMaxSecretSize(0)
+	if _, err := n.NodePublishVolume(context.Background(), newTestPublishRequest(t)); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("unexpected error: got %v, want code %s", err, codes.FailedPrecondition)
+	}
+}
+
+func TestWriteDataUnsafeKeys(t *testing.T) {
+	n := NewNodeServer("test-node", mount.NewFakeMounter(nil), nil)
+
+	for _, key := range []string{"../../etc/passwd", "..", ".", "", "dir/file", `dir\file`, "file\x00"} {
+		t.Run(key, func(t *testing.T) {
+			targetPath := t.TempDir()
+			data := map[string][]byte{
+				"a-safe-file": []byte("content"),
+				key:           []byte("malicious"),
+			}
+
+			if err := n.writeData(targetPath, data, 0640, -1, -1); err == nil {
+				t.Fatalf("expected error for key %q", key)
+			}
+			entries, err := os.ReadDir(targetPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("expected no file written, got %v", entries)
+			}
+		})
+	}
+}
+
+func TestNodeUnpublishVolumeInvalidatesCache(t *testing.T) {
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), newTestSecret())
+	request := newTestPublishRequest(t)
+
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	volumeSelector, err := volume.NewVolumeSelectorFromMap(request.GetVolumeContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := secretbackend.NewCacheKey(volumeSelector)
+	if _, ok := n.cache.Get(key); !ok {
+		t.Fatalf("expected the secret data to be cached after publish")
+	}
+
+	if _, err := n.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   request.GetVolumeId(),
+		TargetPath: request.GetTargetPath(),
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := n.cache.Get(key); ok {
+		t.Errorf("expected the cached secret data to be invalidated after unpublish")
+	}
+}
+
+func TestNodeGetCapabilitiesVolumeCondition(t *testing.T) {
+	n := newTestNodeServer(t)
+	response, err := n.NodeGetCapabilities(context.Background(), &csi.NodeGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.ContainsFunc(response.GetCapabilities(), func(capability *csi.NodeServiceCapability) bool {
+		return capability.GetRpc().GetType() == csi.NodeServiceCapability_RPC_VOLUME_CONDITION
+	}) {
+		t.Errorf("VOLUME_CONDITION is not advertised: %v", response.GetCapabilities())
+	}
+}
+
+func TestNodeGetVolumeStatsVolumeCondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   func(t *testing.T, n *NodeServer, targetPath string)
+		abnormal bool
+		message  string
+	}{
+		{
+			name:    "healthy",
+			update:  func(t *testing.T, n *NodeServer, targetPath string) {},
+			message: "healthy",
+		},
+		{
+			name: "expired with failing rotation",
+			update: func(t *testing.T, n *NodeServer, targetPath string) {
+				expiresTime := time.Now().Add(-time.Hour).Unix()
+				m := n.mounts[targetPath]
+				m.expiresTime = &expiresTime
+				m.failures = 3
+				m.rotationErr = errors.New("vault unavailable")
+			},
+			abnormal: true,
+			message:  "the last 3 rotations failed: vault unavailable",
+		},
+		{
+			name: "tmpfs missing",
+			update: func(t *testing.T, n *NodeServer, targetPath string) {
+				if err := n.mounter.Unmount(targetPath); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			},
+			abnormal: true,
+			message:  "is not mounted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), newTestSecret())
+			request := newTestPublishRequest(t)
+			if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.update(t, n, request.GetTargetPath())
+
+			response, err := n.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+				VolumeId:   request.GetVolumeId(),
+				VolumePath: request.GetTargetPath(),
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			condition := response.GetVolumeCondition()
+			if condition.GetAbnormal() != tt.abnormal || !strings.Contains(condition.GetMessage(), tt.message) {
+				t.Errorf("unexpected volume condition: got %v, want abnormal %t with %q", condition, tt.abnormal, tt.message)
+			}
+		})
+	}
+}
+
+func TestNodeExpandVolume(t *testing.T) {
+	mounter := mount.NewFakeMounter(nil)
+	n := NewNodeServer("test-node", mounter, nil)
+
+	volumePath := filepath.Join(t.TempDir(), "target")
+	if err := os.MkdirAll(volumePath, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := mounter.Mount("tmpfs", volumePath, "tmpfs", append([]string{"ro"}, mountOptions(1024, nil)...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(volumePath, "username"), []byte("admin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := n.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+		VolumeId:      "test-volume",
+		VolumePath:    volumePath,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 4096},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if response.GetCapacityBytes() != 4096 {
+		t.Errorf("unexpected capacity: got %d, want 4096", response.GetCapacityBytes())
+	}
+
+	// the fake mounter records the remount as another mount point
+	mountPoints, _ := mounter.List()
+	opts := mountPoints[len(mountPoints)-1].Opts
+	for _, opt := range []string{"remount", "ro", "noexec", "nosuid", "nodev", "size=4096"} {
+		if !slices.Contains(opts, opt) {
+			t.Errorf("expected option %q in remount options %v", opt, opts)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(volumePath, "username")); err != nil || string(data) != "admin" {
+		t.Errorf("expected files to be kept after expand, got %q, %v", data, err)
+	}
+}
+
+func TestNodeExpandVolumeTracked(t *testing.T) {
+	secretClass := newTestSecretClass()
+	secretClass.Spec.MountOptions = []string{"noatime"}
+	mounter := mount.NewFakeMounter(nil)
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(secretClass, newTestPod(), newTestSecret()).Build()
+	n := NewNodeServer("test-node", mounter, c)
+	request := newTestPublishRequest(t)
+	request.Readonly = true
+	request.VolumeContext[volume.DirMode] = "0750"
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the capacity range is optional, nothing is changed without it
+	response, err := n.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+		VolumeId:   request.GetVolumeId(),
+		VolumePath: request.GetTargetPath(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if response.GetCapacityBytes() != defaultTmpfsSizeLimit.Value() {
+		t.Errorf("unexpected capacity: got %d, want %d", response.GetCapacityBytes(), defaultTmpfsSizeLimit.Value())
+	}
+	mountPoints, _ := mounter.List()
+	mounts := len(mountPoints)
+
+	if _, err := n.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+		VolumeId:      request.GetVolumeId(),
+		VolumePath:    request.GetTargetPath(),
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 4096},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the options of the secret class and the dirMode are kept, only the size is replaced
+	mountPoints, _ = mounter.List()
+	if len(mountPoints) != mounts+1 {
+		t.Fatalf("expected one remount, got mount points %v", mountPoints)
+	}
+	remount := mountPoints[len(mountPoints)-1]
+	if remount.Type != fsTypeTmpfs {
+		t.Errorf("unexpected fsType of the remount: %s", remount.Type)
+	}
+	for _, opt := range []string{"remount", "ro", "noexec", "nosuid", "nodev", "noatime", "mode=0750", "size=4096"} {
+		if !slices.Contains(remount.Opts, opt) {
+			t.Errorf("expected option %q in remount options %v", opt, remount.Opts)
+		}
+	}
+	if slices.Contains(remount.Opts, fmt.Sprintf("size=%d", defaultTmpfsSizeLimit.Value())) {
+		t.Errorf("expected the previous size to be replaced, got %v", remount.Opts)
+	}
+}
+
+func TestNodeExpandVolumeRamfs(t *testing.T) {
+	secretClass := newTestSecretClass()
+	secretClass.Spec.FSType = fsTypeRamfs
+	mounter := mount.NewFakeMounter(nil)
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(secretClass, newTestPod(), newTestSecret()).Build()
+	n := NewNodeServer("test-node", mounter, c)
+	request := newTestPublishRequest(t)
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// ramfs has no size limit, there is nothing to remount
+	if _, err := n.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+		VolumeId:      request.GetVolumeId(),
+		VolumePath:    request.GetTargetPath(),
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 4096},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mountPoints, _ := mounter.List(); len(mountPoints) != 1 {
+		t.Errorf("expected no remount, got mount points %v", mountPoints)
+	}
+}
+
+func TestNodeExpandVolumeInvalid(t *testing.T) {
+	mounter := mount.NewFakeMounter(nil)
+	n := NewNodeServer("test-node", mounter, nil)
+	if err := mounter.Mount("/dev/sda1", "/ext4", "ext4", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		request *csi.NodeExpandVolumeRequest
+		code    codes.Code
+	}{
+		{
+			// the capacity range is optional, the volume is looked up first
+			name:    "not-mounted-without-capacity",
+			request: &csi.NodeExpandVolumeRequest{VolumeId: "test-volume", VolumePath: "/not-mounted"},
+			code:    codes.NotFound,
+		},
+		{
+			name: "not-mounted",
+			request: &csi.NodeExpandVolumeRequest{
+				VolumeId:      "test-volume",
+				VolumePath:    "/not-mounted",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 4096},
+			},
+			code: codes.NotFound,
+		},
+		{
+			name: "not-tmpfs",
+			request: &csi.NodeExpandVolumeRequest{
+				VolumeId:      "test-volume",
+				VolumePath:    "/ext4",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 4096},
+			},
+			code: codes.FailedPrecondition,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := n.NodeExpandVolume(context.Background(), tt.request); status.Code(err) != tt.code {
+				t.Errorf("unexpected error: got %v, want code %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeVolumeDefaults(t *testing.T) {
+	secretClass := newTestSecretClass()
+	secretClass.Spec.VolumeDefaults = map[string]string{
+		volume.SecretsZncdataFormat: string(volume.SecretFormatEnv),
+		volume.Mode:                 "0400",
+	}
+	n := newTestNodeServer(t, secretClass, newTestPod(), newTestSecret())
+
+	tests := []struct {
+		name          string
+		volumeContext map[string]string
+		wantMode      os.FileMode
+	}{
+		{name: "default only", wantMode: 0400},
+		{name: "volume overrides default", volumeContext: map[string]string{volume.Mode: "0440"}, wantMode: 0440},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newTestPublishRequest(t)
+			for key, value := range tt.volumeContext {
+				request.VolumeContext[key] = value
+			}
+			if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			info, err := os.Stat(filepath.Join(request.GetTargetPath(), "secrets.env"))
+			if err != nil {
+				t.Fatalf("the default format is not applied: %v", err)
+			}
+			if info.Mode().Perm() != tt.wantMode {
+				t.Errorf("unexpected file mode: got %04o, want %04o", info.Mode().Perm(), tt.wantMode)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeInvalidVolumeDefaults(t *testing.T) {
+	tests := []struct {
+		name          string
+		defaults      map[string]string
+		volumeContext map[string]string
+		code          codes.Code
+	}{
+		{
+			name:     "class default",
+			defaults: map[string]string{volume.SecretsZncdataClass: "other"},
+			code:     codes.FailedPrecondition,
+		},
+		{
+			name:     "invalid default",
+			defaults: map[string]string{volume.Mode: "0999"},
+			code:     codes.FailedPrecondition,
+		},
+		{
+			name:          "default conflicting with the volume",
+			defaults:      map[string]string{volume.SecretsZncdataFormat: string(volume.SecretFormatEnv)},
+			volumeContext: map[string]string{volume.TLSPEMFiles: "tls.crt"},
+			code:          codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secretClass := newTestSecretClass()
+			secretClass.Spec.VolumeDefaults = tt.defaults
+			n := newTestNodeServer(t, secretClass, newTestPod(), newTestSecret())
+			request := newTestPublishRequest(t)
+			for key, value := range tt.volumeContext {
+				request.VolumeContext[key] = value
+			}
+
+			_, err := n.NodePublishVolume(context.Background(), request)
+			if status.Code(err) != tt.code {
+				t.Errorf("unexpected error: got %v, want code %s", err, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package csi
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DefaultKubeletDir is the root directory of kubelet, the csi volumes of the pods are published under its pods directory.
+const DefaultKubeletDir = "/var/lib/kubelet"
+
+// csiVolumeData is the part of the vol_data.json file written by kubelet next to the mount of a csi volume
+// we need, to tell the volumes of this driver from the volumes of the other csi drivers.
+type csiVolumeData struct {
+	DriverName string `json:"driverName"`
+}
+
+// orphanMount is a volume of the driver mounted under the kubelet pods directory.
+type orphanMount struct {
+	targetPath string
+	podUID     types.UID
+}
+
+// StartOrphanReaper runs the orphan reaper in the background until the context is done
+// or the node server is shut down.
+func (n *NodeServer) StartOrphanReaper(ctx context.Context, kubeletDir, driverName string, interval time.Duration) {
+	n.workers.Add(1)
+	go func() {
+		defer n.workers.Done()
+		n.RunOrphanReaper(ctx, kubeletDir, driverName, interval)
+	}()
+}
+
+// RunOrphanReaper unmounts the volumes of the driver left behind by their pods every interval, e.g. when kubelet
+// missed the unpublish, or the driver crashed while publishing, so their tmpfs does not hold memory forever.
+// A volume is an orphan when it is not published by this driver and its pod does not exist any more.
+// It is unmounted when it is still an orphan at the next check, so a pod missing from a stale cache is not reaped.
+func (n *NodeServer) RunOrphanReaper(ctx context.Context, kubeletDir, driverName string, interval time.Duration) {
+	logger.Info("Orphan mount reaper started", "kubeletDir", kubeletDir, "interval", interval)
+
+	ticker := n.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	suspects := map[string]bool{}
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Orphan mount reaper stopped")
+			return
+		case <-n.stopCh:
+			logger.Info("Orphan mount reaper stopped")
+			return
+		case <-ticker.C():
+			suspects = n.reapOrphanMounts(ctx, kubeletDir, driverName, suspects)
+		}
+	}
+}
+
+// reapOrphanMounts unmounts the orphan volumes which were already suspects at the previous check,
+// and returns the orphan volumes found by this check, the suspects of the next one.
+func (n *NodeServer) reapOrphanMounts(ctx context.Context, kubeletDir, driverName string, suspects map[string]bool) map[string]bool {
+	orphans, err := n.findOrphanMounts(ctx, kubeletDir, driverName)
+	if err != nil {
+		logger.Error(err, "Failed to find orphan mounts")
+		return suspects
+	}
+
+	next := map[string]bool{}
+	for _, orphan := range orphans {
+		if !suspects[orphan.targetPath] {
+			logger.V(1).Info("Orphan mount found, unmounted at the next check if still orphan",
+				"target", orphan.targetPath, "podUID", orphan.podUID)
+			next[orphan.targetPath] = true
+			continue
+		}
+		if err := n.mounter.Unmount(orphan.targetPath); err != nil {
+			logger.Error(err, "Failed to unmount orphan mount", "target", orphan.targetPath)
+			next[orphan.targetPath] = true
+			continue
+		}
+		// the directory is only removed when it is empty, it is not if the unmount silently left the mount
+		if err := os.Remove(orphan.targetPath); err != nil && !os.IsNotExist(err) {
+			logger.Error(err, "Failed to remove orphan mount target", "target", orphan.targetPath)
+		}
+		orphanMountsReaped.Inc()
+		logger.Info("Orphan mount unmounted", "target", orphan.targetPath, "podUID", orphan.podUID)
+	}
+	return next
+}
+
+// findOrphanMounts returns the volumes of the driver mounted under the kubelet pods directory, which are not
+// published by this driver, and whose pod does not exist.
+func (n *NodeServer) findOrphanMounts(ctx context.Context, kubeletDir, driverName string) ([]orphanMount, error) {
+	mountPoints, err := n.mounter.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []orphanMount
+	for _, mountPoint := range mountPoints {
+		podUID, ok := csiVolumePodUID(kubeletDir, mountPoint.Path)
+		if !ok || !isDriverVolume(mountPoint.Path, driverName) {
+			continue
+		}
+		// the volumes published by this driver are unpublished by kubelet
+		if n.isTracked(mountPoint.Path) {
+			continue
+		}
+		candidates = append(candidates, orphanMount{targetPath: mountPoint.Path, podUID: podUID})
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	pods := &corev1.PodList{}
+	if err := n.client.List(ctx, pods); err != nil {
+		return nil, err
+	}
+	podUIDs := make(map[types.UID]bool, len(pods.Items))
+	for _, pod := range pods.Items {
+		podUIDs[pod.UID] = true
+	}
+
+	var orphans []orphanMount
+	for _, candidate := range candidates {
+		if !podUIDs[candidate.podUID] {
+			orphans = append(orphans, candidate)
+		}
+	}
+	return orphans, nil
+}
+
+// csiVolumePodUID returns the pod UID of a csi volume published by kubelet
+// to <kubelet dir>/pods/<uid>/volumes/kubernetes.io~csi/<volume>/mount, false for the other paths.
+func csiVolumePodUID(kubeletDir, path string) (types.UID, bool) {
+	rel, err := filepath.Rel(filepath.Join(kubeletDir, "pods"), path)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	if len(parts) != 5 || parts[0] == ".." || parts[1] != "volumes" || parts[2] != "kubernetes.io~csi" || parts[4] != "mount" {
+		return "", false
+	}
+	return types.UID(parts[0]), true
+}
+
+// isDriverVolume returns whether the csi volume mounted to the path belongs to the driver,
+// according to the vol_data.json file of kubelet. A volume without the file is not.
+func isDriverVolume(path, driverName string) bool {
+	content, err := os.ReadFile(filepath.Join(filepath.Dir(path), "vol_data.json"))
+	if err != nil {
+		return false
+	}
+	data := csiVolumeData{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return false
+	}
+	return data.DriverName == driverName
+}
+
+func (n *NodeServer) isTracked(targetPath string) bool {
+	n.mountsLock.Lock()
+	defer n.mountsLock.Unlock()
+	_, ok := n.mounts[targetPath]
+	return ok
+}
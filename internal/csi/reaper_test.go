@@ -0,0 +1,128 @@
+package csi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/mount"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestCSIVolume creates the directories of a csi volume published by kubelet, and returns its target path.
+func newTestCSIVolume(t *testing.T, kubeletDir, podUID, volumeName, driverName string) string {
+	dir := filepath.Join(kubeletDir, "pods", podUID, "volumes", "kubernetes.io~csi", volumeName)
+	target := filepath.Join(dir, "mount")
+	if err := os.MkdirAll(target, 0750); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := `{"driverName":"` + driverName + `","specVolID":"` + volumeName + `"}`
+	if err := os.WriteFile(filepath.Join(dir, "vol_data.json"), []byte(data), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return target
+}
+
+func TestReapOrphanMounts(t *testing.T) {
+	kubeletDir := t.TempDir()
+	driverName := DefaultDriverName
+	livePod := newTestPod()
+	livePod.UID = "live-uid"
+
+	orphan := newTestCSIVolume(t, kubeletDir, "deleted-uid", "tls", driverName)
+	published := newTestCSIVolume(t, kubeletDir, "deleted-uid", "published", driverName)
+	live := newTestCSIVolume(t, kubeletDir, "live-uid", "tls", driverName)
+	otherDriver := newTestCSIVolume(t, kubeletDir, "deleted-uid", "other", "other.csi.k8s.io")
+	outside := filepath.Join(t.TempDir(), "target")
+
+	mounter := mount.NewFakeMounter([]mount.MountPoint{
+		{Device: "tmpfs", Path: orphan, Type: "tmpfs"},
+		{Device: "tmpfs", Path: published, Type: "tmpfs"},
+		{Device: "tmpfs", Path: live, Type: "tmpfs"},
+		{Device: "tmpfs", Path: otherDriver, Type: "tmpfs"},
+		{Device: "tmpfs", Path: outside, Type: "tmpfs"},
+	})
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(livePod).Build()
+	n := NewNodeServer("test-node", mounter, c)
+	// the volume is published by this driver, kubelet unpublishes it
+	n.trackMount(&mountedVolume{targetPath: published})
+
+	// the orphan is only a suspect at the first check
+	suspects := n.reapOrphanMounts(context.Background(), kubeletDir, driverName, map[string]bool{})
+	if len(suspects) != 1 || !suspects[orphan] {
+		t.Fatalf("unexpected suspects: got %v, want %s", suspects, orphan)
+	}
+	if mountPoints, _ := mounter.List(); len(mountPoints) != 5 {
+		t.Fatalf("unexpected unmount at the first check: %v", mountPoints)
+	}
+
+	suspects = n.reapOrphanMounts(context.Background(), kubeletDir, driverName, suspects)
+	if len(suspects) != 0 {
+		t.Errorf("unexpected suspects after the reap: %v", suspects)
+	}
+	mountPoints, _ := mounter.List()
+	for _, mountPoint := range mountPoints {
+		if mountPoint.Path == orphan {
+			t.Errorf("orphan mount %s is not unmounted", orphan)
+		}
+	}
+	if len(mountPoints) != 4 {
+		t.Errorf("unexpected mounts: got %v, want the active mounts kept", mountPoints)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("orphan target path is not removed: %v", err)
+	}
+}
+
+func TestReapOrphanMountsPodRecreated(t *testing.T) {
+	kubeletDir := t.TempDir()
+	target := newTestCSIVolume(t, kubeletDir, "recreated-uid", "tls", DefaultDriverName)
+	mounter := mount.NewFakeMounter([]mount.MountPoint{{Device: "tmpfs", Path: target, Type: "tmpfs"}})
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	n := NewNodeServer("test-node", mounter, c)
+
+	suspects := n.reapOrphanMounts(context.Background(), kubeletDir, DefaultDriverName, map[string]bool{})
+	if !suspects[target] {
+		t.Fatalf("unexpected suspects: got %v, want %s", suspects, target)
+	}
+
+	// the pod shows up in the cache before the next check, e.g. the cache was stale
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default", UID: types.UID("recreated-uid")}}
+	if err := c.Create(context.Background(), pod); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	suspects = n.reapOrphanMounts(context.Background(), kubeletDir, DefaultDriverName, suspects)
+	if len(suspects) != 0 {
+		t.Errorf("unexpected suspects: %v", suspects)
+	}
+	if mountPoints, _ := mounter.List(); len(mountPoints) != 1 {
+		t.Errorf("the mount of the live pod is unmounted: %v", mountPoints)
+	}
+}
+
+func TestCSIVolumePodUID(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		uid  types.UID
+		ok   bool
+	}{
+		{name: "csi volume", path: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/tls/mount", uid: "uid", ok: true},
+		{name: "projected volume", path: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~projected/token"},
+		{name: "volume subdirectory", path: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/tls/mount/conf"},
+		{name: "outside kubelet", path: "/var/lib/other/pods/uid/volumes/kubernetes.io~csi/tls/mount"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid, ok := csiVolumePodUID(DefaultKubeletDir, tt.path)
+			if uid != tt.uid || ok != tt.ok {
+				t.Errorf("unexpected result: got %q, %t, want %q, %t", uid, ok, tt.uid, tt.ok)
+			}
+		})
+	}
+}
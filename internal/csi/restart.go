@@ -0,0 +1,114 @@
+package csi
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// restartOnRotation returns whether one of the secret classes of the volume restarts the workload on rotation.
+func restartOnRotation(secretClasses []*secretsv1alpha1.SecretClass) bool {
+	for _, secretClass := range secretClasses {
+		if secretClass.Spec.RestartOnRotation {
+			return true
+		}
+	}
+	return false
+}
+
+// restartWorkload restarts the workload owning the pod, like kubectl rollout restart, so its controller replaces
+// the pods with a rolling update. The owner references are followed up to the top controller, e.g. the Deployment
+// of the ReplicaSet of the pod, the restartedAt annotation of its pod template is set to now.
+// A workload already restarted after the pod was created is not restarted again, the pod is replaced by
+// the restart in progress, so the rotations of all its pods restart it once.
+func (n *NodeServer) restartWorkload(ctx context.Context, pod *corev1.Pod) error {
+	workload, template, err := n.getTopController(ctx, pod)
+	if err != nil {
+		return err
+	}
+	if template == nil {
+		logger.V(1).Info("Pod is not owned by a workload with a pod template, skip restarting it", "pod", pod.Name,
+			"namespace", pod.Namespace)
+		return nil
+	}
+
+	if value, ok := template.Annotations[volume.SecretZncdataRestartedAt]; ok {
+		if restartedAt, err := time.Parse(time.RFC3339, value); err == nil && !restartedAt.Before(pod.CreationTimestamp.Time) {
+			logger.V(1).Info("Workload already restarted since the pod was created", "pod", pod.Name,
+				"namespace", pod.Namespace, "workload", workload.GetName(), "restartedAt", value)
+			return nil
+		}
+	}
+
+	patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[volume.SecretZncdataRestartedAt] = n.clock.Now().UTC().Format(time.RFC3339)
+	if err := n.client.Patch(ctx, workload, patch); err != nil {
+		return fmt.Errorf("failed to restart %T %s/%s of pod %s: %w", workload, workload.GetNamespace(), workload.GetName(), pod.Name, err)
+	}
+	logger.Info("Workload restarted after the secret rotation", "pod", pod.Name, "namespace", pod.Namespace,
+		"kind", fmt.Sprintf("%T", workload), "workload", workload.GetName())
+	return nil
+}
+
+// getTopController follows the controller owner references of the pod to the top controller, and returns it with
+// its pod template. The template is nil when the top controller has none, e.g. the pod has no owner,
+// or it is a kind the driver does not know, e.g. a Job.
+func (n *NodeServer) getTopController(ctx context.Context, pod *corev1.Pod) (client.Object, *corev1.PodTemplateSpec, error) {
+	var obj client.Object = pod
+	var template *corev1.PodTemplateSpec
+	for {
+		ref := metav1.GetControllerOf(obj)
+		if ref == nil {
+			// the pods of a ReplicaSet are not replaced when its template changes
+			if _, ok := obj.(*appsv1.ReplicaSet); ok {
+				return obj, nil, nil
+			}
+			return obj, template, nil
+		}
+
+		var owner client.Object
+		var ownerTemplate *corev1.PodTemplateSpec
+		switch ref.APIVersion + "/" + ref.Kind {
+		case "apps/v1/ReplicaSet":
+			replicaSet := &appsv1.ReplicaSet{}
+			owner, ownerTemplate = replicaSet, &replicaSet.Spec.Template
+		case "apps/v1/Deployment":
+			deployment := &appsv1.Deployment{}
+			owner, ownerTemplate = deployment, &deployment.Spec.Template
+		case "apps/v1/StatefulSet":
+			statefulSet := &appsv1.StatefulSet{}
+			owner, ownerTemplate = statefulSet, &statefulSet.Spec.Template
+		case "apps/v1/DaemonSet":
+			daemonSet := &appsv1.DaemonSet{}
+			owner, ownerTemplate = daemonSet, &daemonSet.Spec.Template
+		default:
+			logger.V(1).Info("Unknown owner kind, stop looking for the top controller", "pod", pod.Name,
+				"namespace", pod.Namespace, "owner", ref)
+			return obj, nil, nil
+		}
+
+		if err := n.client.Get(ctx, client.ObjectKey{Name: ref.Name, Namespace: pod.Namespace}, owner); err != nil {
+			if apierrors.IsNotFound(err) {
+				return obj, nil, nil
+			}
+			return nil, nil, err
+		}
+		// the owner was recreated with the same name
+		if owner.GetUID() != ref.UID {
+			return obj, nil, nil
+		}
+		obj, template = owner, ownerTemplate
+	}
+}
@@ -0,0 +1,199 @@
+package csi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/mount"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func newTestOwnerReference(kind, name string) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       kind,
+		Name:       name,
+		UID:        types.UID(name + "-uid"),
+		Controller: &controller,
+	}
+}
+
+func newTestOwnedPod(owner metav1.OwnerReference, created time.Time) *corev1.Pod {
+	pod := newTestPod()
+	pod.CreationTimestamp = metav1.NewTime(created)
+	pod.OwnerReferences = []metav1.OwnerReference{owner}
+	return pod
+}
+
+func TestRestartWorkload(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	created := now.Add(-24 * time.Hour)
+	restartedAt := now.Format(time.RFC3339)
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "web-uid"},
+	}
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web-5d8f",
+			Namespace:       "default",
+			UID:             "web-5d8f-uid",
+			OwnerReferences: []metav1.OwnerReference{newTestOwnerReference("Deployment", "web")},
+		},
+	}
+	bareReplicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "bare", Namespace: "default", UID: "bare-uid"},
+	}
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", UID: "db-uid"},
+	}
+	restartedStatefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "restarted", Namespace: "default", UID: "restarted-uid"},
+		Spec: appsv1.StatefulSetSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{volume.SecretZncdataRestartedAt: created.Add(time.Hour).Format(time.RFC3339)},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		workload client.Object
+		// want is the restartedAt annotation of the workload, empty when it is not restarted
+		want string
+	}{
+		{
+			name:     "deployment through replica set",
+			pod:      newTestOwnedPod(newTestOwnerReference("ReplicaSet", "web-5d8f"), created),
+			workload: &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}},
+			want:     restartedAt,
+		},
+		{
+			name:     "statefulset",
+			pod:      newTestOwnedPod(newTestOwnerReference("StatefulSet", "db"), created),
+			workload: &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"}},
+			want:     restartedAt,
+		},
+		{
+			name:     "bare replica set",
+			pod:      newTestOwnedPod(newTestOwnerReference("ReplicaSet", "bare"), created),
+			workload: &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "bare", Namespace: "default"}},
+		},
+		{
+			name:     "already restarted since the pod was created",
+			pod:      newTestOwnedPod(newTestOwnerReference("StatefulSet", "restarted"), created),
+			workload: &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "restarted", Namespace: "default"}},
+			want:     created.Add(time.Hour).Format(time.RFC3339),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().
+				WithScheme(newTestScheme(t)).
+				WithObjects(tt.pod, deployment.DeepCopy(), replicaSet.DeepCopy(), bareReplicaSet.DeepCopy(),
+					statefulSet.DeepCopy(), restartedStatefulSet.DeepCopy()).
+				Build()
+			n := NewNodeServer("test-node", mount.NewFakeMounter(nil), c).WithClock(clocktesting.NewFakeClock(now))
+
+			if err := n.restartWorkload(context.Background(), tt.pod); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(tt.workload), tt.workload); err != nil {
+				t.Fatal(err)
+			}
+			var annotations map[string]string
+			switch workload := tt.workload.(type) {
+			case *appsv1.Deployment:
+				annotations = workload.Spec.Template.Annotations
+			case *appsv1.StatefulSet:
+				annotations = workload.Spec.Template.Annotations
+			case *appsv1.ReplicaSet:
+				annotations = workload.Spec.Template.Annotations
+			}
+			if got := annotations[volume.SecretZncdataRestartedAt]; got != tt.want {
+				t.Errorf("unexpected restartedAt: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestartWorkloadWithoutOwner(t *testing.T) {
+	n := newTestNodeServer(t, newTestPod())
+	if err := n.restartWorkload(context.Background(), newTestPod()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRestartWorkloadRecreatedOwner(t *testing.T) {
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", UID: "db-uid"},
+	}
+	// the statefulset was recreated since the pod was created
+	owner := newTestOwnerReference("StatefulSet", "db")
+	owner.UID = "old-uid"
+	pod := newTestOwnedPod(owner, time.Now().Add(-time.Hour))
+	n := newTestNodeServer(t, pod, statefulSet)
+
+	if err := n.restartWorkload(context.Background(), pod); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := n.client.Get(context.Background(), client.ObjectKeyFromObject(statefulSet), statefulSet); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := statefulSet.Spec.Template.Annotations[volume.SecretZncdataRestartedAt]; ok {
+		t.Error("recreated workload restarted")
+	}
+}
+
+func TestRefreshRestartOnRotation(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	secretClass := newTestSecretClass()
+	secretClass.Spec.RestartOnRotation = true
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", UID: "db-uid"},
+	}
+	pod := newTestOwnedPod(newTestOwnerReference("StatefulSet", "db"), now.Add(-time.Hour))
+	n := newTestNodeServer(t, secretClass, pod, statefulSet, newTestSecret()).WithClock(clocktesting.NewFakeClock(now))
+	request := newTestPublishRequest(t)
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := n.mounts[request.GetTargetPath()]
+
+	// the unchanged secret does not restart the workload
+	if err := n.refresh(context.Background(), m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := n.client.Get(context.Background(), client.ObjectKeyFromObject(statefulSet), statefulSet); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := statefulSet.Spec.Template.Annotations[volume.SecretZncdataRestartedAt]; ok {
+		t.Error("workload restarted with unchanged content")
+	}
+
+	m.contentHash = "sha256:stale"
+	if err := n.refresh(context.Background(), m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := n.client.Get(context.Background(), client.ObjectKeyFromObject(statefulSet), statefulSet); err != nil {
+		t.Fatal(err)
+	}
+	if got := statefulSet.Spec.Template.Annotations[volume.SecretZncdataRestartedAt]; got != now.Format(time.RFC3339) {
+		t.Errorf("unexpected restartedAt: got %q", got)
+	}
+}
@@ -0,0 +1,252 @@
+package csi
+
+import (
+	"context"
+	"io/fs"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+const (
+	// rotationCheckInterval is the interval to check whether the mounted secrets need to be rotated.
+	rotationCheckInterval = time.Minute
+
+	// rotationBaseBackoff and rotationMaxBackoff bound the delay before retrying a failed rotation.
+	rotationBaseBackoff = 10 * time.Second
+	rotationMaxBackoff  = 5 * time.Minute
+)
+
+// mountedVolume is a published volume, it keeps everything needed to rewrite the secret files in place.
+type mountedVolume struct {
+	targetPath     string
+	dataPath       string
+	volumeSelector *volume.SecretVolumeSelector
+	fileMode       fs.FileMode
+	uid            int
+	gid            int
+	fsType         string
+	sizeLimit      int64
+	mountOptions   []string
+	readOnly       bool
+
+	issuedTime  time.Time
+	expiresTime *int64
+	// contentHash is the hash of the secret data written to the volume, see contentHash.
+	contentHash string
+
+	// fifos are the named pipes of the volume, their value is not rotated.
+	fifos []*fifo
+
+	// failures is the count of consecutive failed rotations, nextAttempt is when to retry.
+	// rotationErr is the error of the last failed rotation, reported by the volume condition.
+	failures    int
+	nextAttempt time.Time
+	rotationErr error
+}
+
+func (n *NodeServer) trackMount(m *mountedVolume) {
+	n.mountsLock.Lock()
+	defer n.mountsLock.Unlock()
+	n.mounts[m.targetPath] = m
+	activeMounts.Set(float64(len(n.mounts)))
+}
+
+// untrackMount stops tracking the volume, and returns it, or nil if it is not tracked.
+func (n *NodeServer) untrackMount(targetPath string) *mountedVolume {
+	n.mountsLock.Lock()
+	defer n.mountsLock.Unlock()
+	m := n.mounts[targetPath]
+	delete(n.mounts, targetPath)
+	activeMounts.Set(float64(len(n.mounts)))
+	return m
+}
+
+// StartRotation runs the rotation in the background until the context is done or the node server is shut down.
+func (n *NodeServer) StartRotation(ctx context.Context, window time.Duration) {
+	n.workers.Add(1)
+	go func() {
+		defer n.workers.Done()
+		n.RunRotation(ctx, window)
+	}()
+}
+
+// RunRotation rotates the secrets of the mounted volumes until the context is done or the node server is shut down.
+// A secret is rotated when it is within the window of its expiration time. The window is
+// capped to half of the secret lifetime, so a short-lived secret is not rotated continuously.
+func (n *NodeServer) RunRotation(ctx context.Context, window time.Duration) {
+	logger.Info("Secret rotation started", "window", window, "interval", rotationCheckInterval)
+
+	ticker := n.clock.NewTicker(rotationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Secret rotation stopped")
+			return
+		case <-n.stopCh:
+			logger.Info("Secret rotation stopped")
+			return
+		case now := <-ticker.C():
+			for _, m := range n.dueMounts(now, window) {
+				// finish the rotation in progress only, the rest are rotated by the next driver
+				if n.isShuttingDown() {
+					break
+				}
+				n.rotate(ctx, m, now)
+			}
+		}
+	}
+}
+
+// dueMounts returns the mounted volumes whose secret should be rotated now.
+func (n *NodeServer) dueMounts(now time.Time, window time.Duration) []*mountedVolume {
+	n.mountsLock.Lock()
+	defer n.mountsLock.Unlock()
+
+	var due []*mountedVolume
+	for _, m := range n.mounts {
+		if m.expiresTime == nil || now.Before(m.nextAttempt) {
+			continue
+		}
+		expiresTime := time.Unix(*m.expiresTime, 0)
+		w := window
+		if half := expiresTime.Sub(m.issuedTime) / 2; half < w {
+			w = half
+		}
+		if !now.Before(expiresTime.Add(-w)) {
+			due = append(due, m)
+		}
+	}
+	return due
+}
+
+// rotate refreshes the secret of the mounted volume, failed rotations are retried with exponential backoff.
+func (n *NodeServer) rotate(ctx context.Context, m *mountedVolume, now time.Time) {
+	n.refreshLock.Lock()
+	defer n.refreshLock.Unlock()
+
+	err := n.refresh(ctx, m)
+
+	n.mountsLock.Lock()
+	defer n.mountsLock.Unlock()
+
+	// the volume may be unpublished during the refresh
+	if _, ok := n.mounts[m.targetPath]; !ok {
+		return
+	}
+
+	if err != nil {
+		backoff := rotationBaseBackoff << m.failures
+		if backoff > rotationMaxBackoff || backoff <= 0 {
+			backoff = rotationMaxBackoff
+		} else {
+			m.failures++
+		}
+		m.nextAttempt = now.Add(backoff)
+		m.rotationErr = err
+		logger.Error(err, "Failed to rotate secret, retry later", "target", m.targetPath, "backoff", backoff)
+		return
+	}
+
+	m.failures = 0
+	m.nextAttempt = time.Time{}
+	m.rotationErr = nil
+	logger.Info("Secret rotated", "target", m.targetPath, "expiresTime", m.expiresTime)
+}
+
+// refresh gets the secret from the backend again, and rewrites the files in place.
+// The read-only volume is remounted as read-write while the files are written.
+// The files are not rewritten when their content hash is unchanged.
+func (n *NodeServer) refresh(ctx context.Context, m *mountedVolume) error {
+	secretClasses, err := n.getSecretClasses(ctx, m.volumeSelector.SecretClasses())
+	if err != nil {
+		return err
+	}
+
+	pod, _, secretContent, err := n.getSecretContent(ctx, m.volumeSelector, secretClasses)
+	if err != nil {
+		return err
+	}
+
+	if secretContent.ContentHash == m.contentHash {
+		logger.V(1).Info("Secret content unchanged, skip rewriting the volume", "target", m.targetPath, "contentHash", m.contentHash)
+		n.mountsLock.Lock()
+		m.issuedTime = n.clock.Now()
+		m.expiresTime = secretContent.ExpiresTime
+		n.mountsLock.Unlock()
+		return nil
+	}
+
+	if m.readOnly {
+		opts := append([]string{"remount", "rw"}, mountOptions(m.sizeLimit, m.mountOptions)...)
+		if err := n.mounter.Mount(m.fsType, m.targetPath, m.fsType, opts); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	writeErr := n.writeData(m.dataPath, withoutFIFOKeys(secretContent.Data, m.volumeSelector.FIFOKeys), m.fileMode, m.uid, m.gid)
+
+	if m.readOnly {
+		if err := n.remountReadOnly(ctx, m.targetPath, m.fsType, m.sizeLimit, m.mountOptions); err != nil {
+			return err
+		}
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	secretBytesWritten.WithLabelValues(volumeBackendType(secretClasses)).Add(float64(dataSize(secretContent.Data)))
+
+	n.mountsLock.Lock()
+	m.issuedTime = n.clock.Now()
+	m.expiresTime = secretContent.ExpiresTime
+	m.contentHash = secretContent.ContentHash
+	n.mountsLock.Unlock()
+
+	if err := n.updatePodExpiresTime(ctx, pod, podVolumeName(m.targetPath), secretContent.ContentHash); err != nil {
+		return err
+	}
+	if restartOnRotation(secretClasses) {
+		return n.restartWorkload(ctx, pod)
+	}
+	return nil
+}
+
+// updatePodExpiresTime sets the expiration time annotation of the pod to the earliest expiration time
+// of the volumes mounted by the pod, as the rotated secret expires later than the recorded one.
+// The content hash of the rotated volume is updated too. Nothing is patched when the pod annotation is disabled.
+func (n *NodeServer) updatePodExpiresTime(ctx context.Context, pod *corev1.Pod, volumeName, hash string) error {
+	if n.podAnnotationDisabled {
+		return nil
+	}
+	var earliest *int64
+	n.mountsLock.Lock()
+	for _, m := range n.mounts {
+		if m.volumeSelector.Pod != pod.GetName() || m.volumeSelector.PodNamespace != pod.GetNamespace() || m.expiresTime == nil {
+			continue
+		}
+		if earliest == nil || *m.expiresTime < *earliest {
+			earliest = m.expiresTime
+		}
+	}
+	n.mountsLock.Unlock()
+
+	patch := client.MergeFrom(pod.DeepCopy())
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	if _, err := setContentHashAnnotation(pod.Annotations, volumeName, hash); err != nil {
+		return err
+	}
+	if earliest != nil {
+		pod.Annotations[volume.SecretZncdataExpirationTime] = strconv.FormatInt(*earliest, 10)
+	}
+	return n.client.Patch(ctx, pod, patch)
+}
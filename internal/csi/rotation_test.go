@@ -0,0 +1,258 @@
+package csi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/mount"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/zncdata-labs/secret-operator/pkg/util"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func newTestMountedVolume(targetPath string, issuedTime time.Time, lifetime time.Duration) *mountedVolume {
+	expiresTime := issuedTime.Add(lifetime).Unix()
+	return &mountedVolume{
+		targetPath:     targetPath,
+		dataPath:       targetPath,
+		volumeSelector: &volume.SecretVolumeSelector{Class: "tls", Pod: "test-pod", PodNamespace: "default"},
+		uid:            -1,
+		gid:            -1,
+		issuedTime:     issuedTime,
+		expiresTime:    &expiresTime,
+	}
+}
+
+func TestDueMounts(t *testing.T) {
+	now := time.Now()
+	n := NewNodeServer("test-node", mount.NewFakeMounter(nil), nil)
+
+	// expires in 30m, within the 1h window
+	n.trackMount(newTestMountedVolume("/due", now.Add(-23*time.Hour-30*time.Minute), 24*time.Hour))
+	// expires in 10h
+	n.trackMount(newTestMountedVolume("/not-due", now.Add(-14*time.Hour), 24*time.Hour))
+	// lifetime is 1h, the window is capped to 30m, expires in 40m
+	n.trackMount(newTestMountedVolume("/short-lived", now.Add(-20*time.Minute), time.Hour))
+	// no expiration time
+	n.trackMount(&mountedVolume{targetPath: "/no-expiry"})
+
+	due := n.dueMounts(now, time.Hour)
+	if len(due) != 1 || due[0].targetPath != "/due" {
+		t.Errorf("unexpected due mounts: %v", due)
+	}
+
+	n.untrackMount("/due")
+	if due := n.dueMounts(now, time.Hour); len(due) != 0 {
+		t.Errorf("expected no due mounts after untrack, got %v", due)
+	}
+}
+
+func TestRotateBackoff(t *testing.T) {
+	now := time.Now()
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	n := NewNodeServer("test-node", mount.NewFakeMounter(nil), c)
+
+	m := newTestMountedVolume("/due", now.Add(-23*time.Hour-30*time.Minute), 24*time.Hour)
+	n.trackMount(m)
+
+	// the secret class does not exist, so the refresh fails
+	n.rotate(context.Background(), m, now)
+	if m.failures != 1 || !m.nextAttempt.Equal(now.Add(rotationBaseBackoff)) {
+		t.Errorf("unexpected backoff after first failure: failures %d, next attempt %v", m.failures, m.nextAttempt)
+	}
+	if due := n.dueMounts(now, time.Hour); len(due) != 0 {
+		t.Errorf("expected no due mounts during backoff, got %v", due)
+	}
+
+	n.rotate(context.Background(), m, now)
+	if m.failures != 2 || !m.nextAttempt.Equal(now.Add(2*rotationBaseBackoff)) {
+		t.Errorf("unexpected backoff after second failure: failures %d, next attempt %v", m.failures, m.nextAttempt)
+	}
+}
+
+func TestRefreshUnchangedContent(t *testing.T) {
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), newTestSecret())
+	request := newTestPublishRequest(t)
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := n.mounts[request.GetTargetPath()]
+	dataDir, err := os.Readlink(filepath.Join(request.GetTargetPath(), dataDirName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the shared secret did not change, the files are not rewritten
+	if err := n.refresh(context.Background(), m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := os.Readlink(filepath.Join(request.GetTargetPath(), dataDirName)); got != dataDir {
+		t.Errorf("volume rewritten with unchanged content: data dir %s, was %s", got, dataDir)
+	}
+
+	// a changed secret is written
+	m.contentHash = "sha256:stale"
+	if err := n.refresh(context.Background(), m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := os.Readlink(filepath.Join(request.GetTargetPath(), dataDirName)); got == dataDir {
+		t.Error("volume not rewritten with changed content")
+	}
+	if m.contentHash != contentHash(map[string][]byte{"username": []byte("admin")}, conversionOptions(m.volumeSelector, nil)) {
+		t.Errorf("unexpected content hash of the mount: %s", m.contentHash)
+	}
+}
+
+func TestRefreshUnchangedKeystore(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := newTestSecret()
+	secret.Data = map[string][]byte{
+		"tls.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		"tls.key": pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+	}
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), secret)
+	request := newTestPublishRequest(t)
+	request.VolumeContext[volume.SecretsZncdataFormat] = string(volume.SecretFormatTLSPKCS12)
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := n.mounts[request.GetTargetPath()]
+	dataDir, err := os.Readlink(filepath.Join(request.GetTargetPath(), dataDirName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the keystore is encrypted with a new salt, but it is converted from the same data
+	if err := n.refresh(context.Background(), m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := os.Readlink(filepath.Join(request.GetTargetPath(), dataDirName)); got != dataDir {
+		t.Errorf("volume rewritten with unchanged content: data dir %s, was %s", got, dataDir)
+	}
+}
+
+func TestRunRotationFakeClock(t *testing.T) {
+	clock := clocktesting.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	n := NewNodeServer("test-node", mount.NewFakeMounter(nil), c).WithClock(clock)
+
+	// expires in 30m, within the 1h window
+	m := newTestMountedVolume("/due", clock.Now().Add(-23*time.Hour-30*time.Minute), 24*time.Hour)
+	n.trackMount(m)
+
+	n.StartRotation(context.Background(), time.Hour)
+	defer func() {
+		if err := n.Shutdown(context.Background()); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	failures := func() int {
+		n.mountsLock.Lock()
+		defer n.mountsLock.Unlock()
+		return m.failures
+	}
+
+	// nothing is rotated before the ticker fires
+	for !clock.HasWaiters() {
+		time.Sleep(time.Millisecond)
+	}
+	if failures() != 0 {
+		t.Fatalf("expected no rotation before the check interval")
+	}
+
+	// the secret class does not exist, so the rotation fails and is retried after the backoff
+	clock.Step(rotationCheckInterval)
+	deadline := time.Now().Add(5 * time.Second)
+	for failures() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if failures() != 1 {
+		t.Fatalf("expected the rotation to run once, got %d failures", failures())
+	}
+	n.mountsLock.Lock()
+	nextAttempt := m.nextAttempt
+	n.mountsLock.Unlock()
+	if want := clock.Now().Add(rotationBaseBackoff); !nextAttempt.Equal(want) {
+		t.Errorf("unexpected next attempt: got %s, want %s", nextAttempt, want)
+	}
+}
+
+func TestUpdatePodStaleExpiresTime(t *testing.T) {
+	clock := clocktesting.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name     string
+		existing time.Time
+		expires  time.Time
+		want     time.Time
+	}{
+		{
+			name:     "earlier",
+			existing: clock.Now().Add(2 * time.Hour),
+			expires:  clock.Now().Add(time.Hour),
+			want:     clock.Now().Add(time.Hour),
+		},
+		{
+			name:     "later",
+			existing: clock.Now().Add(time.Hour),
+			expires:  clock.Now().Add(2 * time.Hour),
+			want:     clock.Now().Add(time.Hour),
+		},
+		{
+			name:     "stale",
+			existing: clock.Now().Add(-time.Hour),
+			expires:  clock.Now().Add(2 * time.Hour),
+			want:     clock.Now().Add(2 * time.Hour),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestPod()
+			pod.Annotations = map[string]string{
+				volume.SecretZncdataExpirationTime: strconv.FormatInt(tt.existing.Unix(), 10),
+			}
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(pod).Build()
+			n := NewNodeServer("test-node", mount.NewFakeMounter(nil), c).WithClock(clock)
+
+			expiresTime := tt.expires.Unix()
+			if err := n.updatePod(context.Background(), pod.DeepCopy(), "tls", &util.SecretContent{ExpiresTime: &expiresTime}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(pod), pod); err != nil {
+				t.Fatal(err)
+			}
+			if got := pod.Annotations[volume.SecretZncdataExpirationTime]; got != strconv.FormatInt(tt.want.Unix(), 10) {
+				t.Errorf("unexpected expiration time annotation: got %s, want %d", got, tt.want.Unix())
+			}
+		})
+	}
+}
@@ -1,12 +1,22 @@
 package csi
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc"
@@ -16,71 +26,147 @@ import (
 
 // NonBlockingServer Defines Non blocking GRPC server interfaces
 type NonBlockingServer interface {
-	// Start services at the endpoint
-	Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer, testMode bool)
+	// Start listens at the endpoint and serves the services in the background
+	Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer, testMode bool) error
 	// Wait Waits for the service to stop
 	Wait()
 	// Stop Stops the service gracefully
 	Stop()
 	// ForceStop Stops the service forcefully
 	ForceStop()
+	// SetServing reports the status of the grpc.health.v1 Health service, the server starts NOT_SERVING
+	SetServing(serving bool)
 }
 
-func NewNonBlockingServer() NonBlockingServer {
+// NewNonBlockingServer creates the server, each call is cancelled after the request timeout, 0 disables the timeout.
+func NewNonBlockingServer(requestTimeout time.Duration) NonBlockingServer {
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(util.LogGRPC),
+		grpc.ChainUnaryInterceptor(util.LogGRPC, timeoutInterceptor(requestTimeout)),
 	}
 
 	server := grpc.NewServer(opts...)
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
 	return &nonBlockingServer{
-		grpcSrv: server,
+		grpcSrv:   server,
+		healthSrv: healthSrv,
+	}
+}
+
+// timeoutInterceptor cancels the context of each call after the timeout, so a hanging backend, e.g. vault behind
+// a blackhole, fails the call with DeadlineExceeded instead of blocking it while kubelet piles up the retries.
+// The deadline of the caller is kept when it is sooner.
+func timeoutInterceptor(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if timeout <= 0 {
+			return handler(ctx, req)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		resp, err := handler(ctx, req)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && status.Code(err) != codes.DeadlineExceeded {
+			return nil, status.Errorf(codes.DeadlineExceeded, "%s timed out after %s: %s", info.FullMethod, timeout, status.Convert(err).Message())
+		}
+		return resp, err
 	}
 }
 
 // NonBlocking server
 type nonBlockingServer struct {
-	wg      sync.WaitGroup
-	grpcSrv *grpc.Server
+	wg        sync.WaitGroup
+	grpcSrv   *grpc.Server
+	healthSrv *health.Server
 }
 
-func (s *nonBlockingServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer, testMode bool) {
+// Start returns an error when the endpoint is invalid or can not be listened on.
+func (s *nonBlockingServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer, testMode bool) error {
+	listener, err := listen(endpoint)
+	if err != nil {
+		return err
+	}
 
 	s.wg.Add(1)
 
-	go s.serveGrpc(endpoint, ids, cs, ns, testMode)
+	go s.serveGrpc(listener, ids, cs, ns, testMode)
+	return nil
 }
 
 func (s *nonBlockingServer) Wait() {
 	s.wg.Wait()
 }
 
+// Stop reports NOT_SERVING to the health watchers before the server stops, and ignores the later statuses.
 func (s *nonBlockingServer) Stop() {
+	s.healthSrv.Shutdown()
 	s.grpcSrv.GracefulStop()
 }
 
 func (s *nonBlockingServer) ForceStop() {
+	s.healthSrv.Shutdown()
 	s.grpcSrv.Stop()
 }
 
-func (s *nonBlockingServer) serveGrpc(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer, testMode bool) {
+func (s *nonBlockingServer) SetServing(serving bool) {
+	servingStatus := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		servingStatus = healthpb.HealthCheckResponse_SERVING
+	}
+	s.healthSrv.SetServingStatus("", servingStatus)
+}
 
+// listen creates the listener of the endpoint, unix://<path> or tcp://<host>:<port>.
+// unix://@<name> is an abstract socket, which has no file. Otherwise the parent directories of the socket are created,
+// and the socket left by a previous run is removed, the path of another kind of file is rejected.
+func listen(endpoint string) (net.Listener, error) {
 	proto, addr, err := util.ParseEndpoint(endpoint)
 	if err != nil {
-		logger.Error(err, "Failed to parse endpoint")
+		return nil, err
 	}
 
-	if proto == "unix" {
-		addr = "/" + addr
-		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
-			logger.V(0).Info("Failed to remove", "addr", addr, "error", err.Error())
+	switch proto {
+	case "unix":
+		if strings.HasPrefix(addr, "@") {
+			break
+		}
+		// the path is absolute whether it starts with a slash or not, e.g. unix://tmp/csi.sock
+		addr = filepath.Join("/", addr)
+		if err := os.MkdirAll(filepath.Dir(addr), 0750); err != nil {
+			return nil, fmt.Errorf("failed to create the directory of the socket %s: %w", addr, err)
+		}
+		if err := removeStaleSocket(addr); err != nil {
+			return nil, err
+		}
+	case "tcp":
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid endpoint: %s: %w", endpoint, err)
 		}
 	}
 
 	listener, err := net.Listen(proto, addr)
 	if err != nil {
-		logger.Error(err, "Failed to listen")
+		return nil, fmt.Errorf("failed to listen on %s: %w", endpoint, err)
 	}
+	return listener, nil
+}
 
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("failed to listen on %s: the file exists and is not a socket", path)
+	}
+	logger.V(1).Info("Removing stale socket", "path", path)
+	return os.Remove(path)
+}
+
+func (s *nonBlockingServer) serveGrpc(listener net.Listener, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer, testMode bool) {
 	if ids != nil {
 		csi.RegisterIdentityServer(s.grpcSrv, ids)
 	}
@@ -90,6 +176,8 @@ func (s *nonBlockingServer) serveGrpc(endpoint string, ids csi.IdentityServer, c
 	if ns != nil {
 		csi.RegisterNodeServer(s.grpcSrv, ns)
 	}
+	// the deployment tools check the health of the plugin with the standard protocol, instead of the csi Probe
+	healthpb.RegisterHealthServer(s.grpcSrv, s.healthSrv)
 
 	// Used to stop the server while running tests
 	if testMode {
@@ -106,8 +194,7 @@ func (s *nonBlockingServer) serveGrpc(endpoint string, ids csi.IdentityServer, c
 	logger.V(0).Info("Listening for connections on address", "address", listener.Addr())
 
 	reflection.Register(s.grpcSrv)
-	err = s.grpcSrv.Serve(listener)
-	if err != nil {
+	if err := s.grpcSrv.Serve(listener); err != nil {
 		logger.Error(err, "Failed to serve grpc server")
 	}
 }
@@ -0,0 +1,285 @@
+package csi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/go-logr/logr/funcr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/mount"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/zncdata-labs/secret-operator/pkg/util"
+)
+
+func TestTimeoutInterceptor(t *testing.T) {
+	// the k8sSearch backend hangs listing the secrets until the call is cancelled
+	c := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(newTestSecretClass(), newTestPod(), newTestSecret()).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}).
+		Build()
+	n := NewNodeServer("test-node", mount.NewFakeMounter(nil), c)
+	request := newTestPublishRequest(t)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodePublishVolume"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return n.NodePublishVolume(ctx, req.(*csi.NodePublishVolumeRequest))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := timeoutInterceptor(100*time.Millisecond)(context.Background(), request, info, handler)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Errorf("unexpected error: got %v, want code %s", err, codes.DeadlineExceeded)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the call was not cancelled after the timeout")
+	}
+}
+
+func TestTimeoutInterceptorDisabled(t *testing.T) {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if _, ok := ctx.Deadline(); ok {
+			t.Error("unexpected deadline when the timeout is disabled")
+		}
+		return "ok", nil
+	}
+
+	resp, err := timeoutInterceptor(0)(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	if err != nil || resp != "ok" {
+		t.Errorf("unexpected result: got %v, %v", resp, err)
+	}
+}
+
+func TestLogGRPCRequestID(t *testing.T) {
+	// the loggers of the packages can only be set once, the entries of the other tests are told apart by the request ID
+	var lock sync.Mutex
+	var entries []string
+	ctrl.SetLogger(funcr.NewJSON(func(obj string) {
+		lock.Lock()
+		defer lock.Unlock()
+		entries = append(entries, obj)
+	}, funcr.Options{Verbosity: 8}))
+
+	n := newTestNodeServer(t, newTestSecretClass(), newTestPod(), newTestSecret())
+	request := newTestPublishRequest(t)
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodePublishVolume"}
+	var requestID string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		requestID = util.RequestIDFromContext(ctx)
+		return n.NodePublishVolume(ctx, req.(*csi.NodePublishVolumeRequest))
+	}
+
+	if _, err := util.LogGRPC(context.Background(), request, info, handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requestID == "" {
+		t.Fatal("no request ID in the context of the call")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	loggers := map[string]bool{}
+	for _, obj := range entries {
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(obj), &entry); err != nil {
+			t.Fatalf("invalid JSON log entry %s: %v", obj, err)
+		}
+		if entry["requestID"] != requestID {
+			continue
+		}
+		if entry["volumeID"] != request.GetVolumeId() {
+			t.Errorf("unexpected volumeID of entry %q: got %v, want %s", entry["msg"], entry["volumeID"], request.GetVolumeId())
+		}
+		loggers[entry["logger"].(string)] = true
+	}
+	// the entries of the call itself, the node server and the backend it calls
+	for _, name := range []string{"csi-grpc", "csi-driver", "csi-backend"} {
+		if !loggers[name] {
+			t.Errorf("no entry of %s with the request ID %s, got entries of %v", name, requestID, loggers)
+		}
+	}
+}
+
+func TestHealthService(t *testing.T) {
+	endpoint := "unix://" + filepath.Join(t.TempDir(), "csi.sock")
+	server := NewNonBlockingServer(0)
+	if err := server.Start(endpoint, NewIdentityServer("test", "v0.0.1", nil), nil, nil, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer server.ForceStop()
+
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	check := func(want healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.GetStatus() != want {
+			t.Errorf("unexpected status: got %s, want %s", resp.GetStatus(), want)
+		}
+	}
+	watch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	recv := func(want healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		resp, err := watch.Recv()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.GetStatus() != want {
+			t.Errorf("unexpected watched status: got %s, want %s", resp.GetStatus(), want)
+		}
+	}
+
+	// not serving until the plugin is ready
+	check(healthpb.HealthCheckResponse_NOT_SERVING)
+	recv(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	reportHealth(ctx, server, NewIdentityServer("test", "v0.0.1", newTestNodeServer(t, newTestSecretClass()).client))
+	check(healthpb.HealthCheckResponse_SERVING)
+	recv(healthpb.HealthCheckResponse_SERVING)
+
+	// the shutdown
+	server.SetServing(false)
+	check(healthpb.HealthCheckResponse_NOT_SERVING)
+	recv(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+func TestServerUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	// a previous run left its socket
+	staleSocket := filepath.Join(dir, "csi.sock")
+	stale, err := net.Listen("unix", staleSocket)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	tests := []struct {
+		name   string
+		socket string
+	}{
+		{name: "stale socket", socket: staleSocket},
+		{name: "missing directory", socket: filepath.Join(dir, "plugins", "secrets.zncdata.dev", "csi.sock")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := "unix://" + tt.socket
+			server := NewNonBlockingServer(0)
+			if err := server.Start(endpoint, NewIdentityServer("test", "v0.0.1", nil), nil, nil, false); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer server.ForceStop()
+
+			conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer conn.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			resp, err := csi.NewIdentityClient(conn).GetPluginInfo(ctx, &csi.GetPluginInfoRequest{}, grpc.WaitForReady(true))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.GetName() != "test" {
+				t.Errorf("unexpected plugin name: got %s, want test", resp.GetName())
+			}
+		})
+	}
+}
+
+func TestServerInvalidEndpoint(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "csi.sock")
+	if err := os.WriteFile(file, []byte("data"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		endpoint string
+	}{
+		{name: "no scheme", endpoint: "/tmp/csi.sock"},
+		{name: "unsupported scheme", endpoint: "http://localhost:8080"},
+		{name: "empty address", endpoint: "unix://"},
+		{name: "tcp without port", endpoint: "tcp://localhost"},
+		{name: "not a socket", endpoint: "unix://" + file},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewNonBlockingServer(0).Start(tt.endpoint, nil, nil, nil, false); err == nil {
+				t.Errorf("expected an error for the endpoint %s", tt.endpoint)
+			}
+		})
+	}
+
+	// the regular file is not removed
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReportHealthNotReady(t *testing.T) {
+	c := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				return errors.New("cache not synced")
+			},
+		}).
+		Build()
+	server := NewNonBlockingServer(0).(*nonBlockingServer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*healthCheckInterval)
+	defer cancel()
+	reportHealth(ctx, server, NewIdentityServer("test", "v0.0.1", c))
+
+	resp, err := server.healthSrv.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("unexpected status: got %s, want %s", resp.GetStatus(), healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
@@ -0,0 +1,41 @@
+package csi
+
+import (
+	"context"
+)
+
+// Shutdown stops the node server gracefully, new volumes are rejected with Unavailable,
+// and the rotation stops after the rotation in progress finishes, or the context is done.
+// The mounted volumes are kept, unmounting them would break the running pods,
+// they are unpublished by kubelet as usual when the pods are deleted.
+func (n *NodeServer) Shutdown(ctx context.Context) error {
+	n.stopOnce.Do(func() {
+		close(n.stopCh)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		n.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		n.mountsLock.Lock()
+		mounts := len(n.mounts)
+		n.mountsLock.Unlock()
+		logger.Info("Node server shut down, mounted volumes are kept", "volumes", mounts)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *NodeServer) isShuttingDown() bool {
+	select {
+	case <-n.stopCh:
+		return true
+	default:
+		return false
+	}
+}
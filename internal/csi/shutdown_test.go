@@ -0,0 +1,48 @@
+package csi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/mount"
+)
+
+func TestNodeServerShutdown(t *testing.T) {
+	mounter := mount.NewFakeMounter(nil)
+	n := NewNodeServer("test-node", mounter, nil)
+
+	targetPath := filepath.Join(t.TempDir(), "target")
+	if err := os.MkdirAll(targetPath, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := mounter.Mount("tmpfs", targetPath, "tmpfs", nil); err != nil {
+		t.Fatal(err)
+	}
+	n.trackMount(newTestMountedVolume(targetPath, time.Now(), time.Hour))
+
+	n.StartRotation(context.Background(), time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// shutting down again is a no-op
+	if err := n.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := n.NodePublishVolume(context.Background(), newTestPublishRequest(t)); status.Code(err) != codes.Unavailable {
+		t.Errorf("unexpected error: got %v, want code %s", err, codes.Unavailable)
+	}
+
+	// the mounted volumes are kept for the running pods
+	if mountPoints, _ := mounter.List(); len(mountPoints) != 1 {
+		t.Errorf("expected the volume to stay mounted, got %v", mountPoints)
+	}
+}
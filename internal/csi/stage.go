@@ -0,0 +1,147 @@
+package csi
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	secretbackend "github.com/zncdata-labs/secret-operator/internal/csi/backend"
+	"github.com/zncdata-labs/secret-operator/pkg/pod_info"
+	"github.com/zncdata-labs/secret-operator/pkg/util"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// stagedVolume is the secret content pre-fetched by NodeStageVolume for a pod.
+// It is used once, by the next NodePublishVolume of the same pod, like the secrets fetched by the publish.
+type stagedVolume struct {
+	pod      *corev1.Pod
+	podInfo  *pod_info.PodInfo
+	content  *util.SecretContent
+	stagedAt time.Time
+}
+
+// stage pre-fetches the secret content of the volume, so the publish is faster and a failure is reported
+// before the pod waits for the volume.
+// kubelet passes the pod to NodePublishVolume only, so the pod is found by the owner of the PVC,
+// which is the pod of a generic ephemeral volume. Otherwise, or when the volume sets noCache,
+// the backends of the secret classes are only validated.
+// The returned error is a grpc status error.
+func (n *NodeServer) stage(
+	ctx context.Context,
+	stagingPath string,
+	volumeSelector *volume.SecretVolumeSelector,
+	secretClasses []*secretsv1alpha1.SecretClass,
+) error {
+	owner, err := n.getPVCOwnerPod(ctx, volumeSelector)
+	if err != nil {
+		return err
+	}
+	if owner == nil || volumeSelector.NoCache {
+		for _, secretClass := range secretClasses {
+			backend := secretbackend.NewBackend(n.client, nil, &volume.SecretVolumeSelector{Class: secretClass.Name}, secretClass)
+			if err := backend.Validate(ctx); err != nil {
+				return backendStatusError(err)
+			}
+		}
+		return nil
+	}
+
+	for _, secretClass := range secretClasses {
+		if err := n.checkNamespaceAllowed(ctx, secretClass, owner.Namespace); err != nil {
+			return err
+		}
+	}
+
+	podSelector := *volumeSelector
+	podSelector.Pod = owner.Name
+	podSelector.PodNamespace = owner.Namespace
+	podSelector.PodUID = string(owner.UID)
+	podSelector.ServiceAccountName = owner.Spec.ServiceAccountName
+	pod, podInfo, content, err := n.getSecretContent(ctx, &podSelector, secretClasses)
+	if err != nil {
+		return err
+	}
+
+	n.stagedLock.Lock()
+	defer n.stagedLock.Unlock()
+	n.staged[stagingPath] = &stagedVolume{
+		pod:      pod,
+		podInfo:  podInfo,
+		content:  content,
+		stagedAt: n.clock.Now(),
+	}
+	logger.V(1).Info("Secret pre-fetched for the volume", "stagingPath", stagingPath, "pod", pod.Name, "namespace", pod.Namespace)
+	return nil
+}
+
+// getPVCOwnerPod returns the pod controlling the PVC of the volume, nil when the PVC is unknown
+// or is not owned by a pod. The returned error is a grpc status error.
+func (n *NodeServer) getPVCOwnerPod(ctx context.Context, volumeSelector *volume.SecretVolumeSelector) (*corev1.Pod, error) {
+	if volumeSelector.PVCName == "" || volumeSelector.PVCNamespace == "" {
+		return nil, nil
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := n.client.Get(ctx, client.ObjectKey{Name: volumeSelector.PVCName, Namespace: volumeSelector.PVCNamespace}, pvc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	owner := metav1.GetControllerOf(pvc)
+	if owner == nil || owner.APIVersion != "v1" || owner.Kind != "Pod" {
+		return nil, nil
+	}
+
+	pod := &corev1.Pod{}
+	if err := n.client.Get(ctx, client.ObjectKey{Name: owner.Name, Namespace: pvc.Namespace}, pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// the pod was recreated with the same name, the PVC is about to be deleted, or the pod is being deleted
+	if pod.UID != owner.UID || pod.DeletionTimestamp != nil {
+		return nil, nil
+	}
+	return pod, nil
+}
+
+// takeStaged returns the secret content pre-fetched for the pod of the volume, and forgets it.
+// nil is returned when nothing was pre-fetched, or it is stale, or it was fetched for another pod.
+func (n *NodeServer) takeStaged(stagingPath string, volumeSelector *volume.SecretVolumeSelector) *stagedVolume {
+	if stagingPath == "" {
+		return nil
+	}
+
+	n.stagedLock.Lock()
+	staged := n.staged[stagingPath]
+	delete(n.staged, stagingPath)
+	n.stagedLock.Unlock()
+
+	if staged == nil {
+		return nil
+	}
+	if staged.pod.Name != volumeSelector.Pod || staged.pod.Namespace != volumeSelector.PodNamespace ||
+		(volumeSelector.PodUID != "" && string(staged.pod.UID) != volumeSelector.PodUID) {
+		return nil
+	}
+	if n.clock.Since(staged.stagedAt) >= secretCacheTTL {
+		return nil
+	}
+	return staged
+}
+
+// forgetStaged drops the secret content pre-fetched for the volume, if it was not published.
+func (n *NodeServer) forgetStaged(stagingPath string) {
+	n.stagedLock.Lock()
+	defer n.stagedLock.Unlock()
+	delete(n.staged, stagingPath)
+}
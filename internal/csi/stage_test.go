@@ -0,0 +1,253 @@
+package csi
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/pkg/format"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+const testPodUID = "6f2b6c1e-4f2b-4c55-9d57-7b8f3c1b0d0a"
+
+// newTestEphemeralPVC returns the PVC of a generic ephemeral volume of the test pod, owned by the pod.
+func newTestEphemeralPVC() (*corev1.Pod, *corev1.PersistentVolumeClaim) {
+	pod := newTestPod()
+	pod.UID = testPodUID
+	controller := true
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod-tls",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "v1", Kind: "Pod", Name: pod.Name, UID: pod.UID, Controller: &controller},
+			},
+		},
+	}
+	return pod, pvc
+}
+
+func newTestStageRequest(t *testing.T, volumeContext map[string]string) *csi.NodeStageVolumeRequest {
+	return &csi.NodeStageVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+		VolumeContext: volumeContext,
+	}
+}
+
+// newTestStagedPublishRequest returns the publish request of the staged volume, with the pod info added by kubelet.
+func newTestStagedPublishRequest(t *testing.T, stage *csi.NodeStageVolumeRequest) *csi.NodePublishVolumeRequest {
+	request := newTestPublishRequest(t)
+	request.StagingTargetPath = stage.GetStagingTargetPath()
+	for key, value := range stage.GetVolumeContext() {
+		request.VolumeContext[key] = value
+	}
+	request.VolumeContext[volume.CSIStoragePodUid] = testPodUID
+	return request
+}
+
+func readTestCertificate(t *testing.T, request *csi.NodePublishVolumeRequest) []byte {
+	data, err := os.ReadFile(filepath.Join(request.GetTargetPath(), format.PEMTlsCertFileName))
+	if err != nil {
+		t.Fatalf("failed to read certificate: %v", err)
+	}
+	return data
+}
+
+func TestNodeStageVolumePrefetch(t *testing.T) {
+	pod, pvc := newTestEphemeralPVC()
+	n := newTestNodeServer(t, newTestAutoTlsSecretClass("tls"), pod, pvc)
+	stage := newTestStageRequest(t, map[string]string{
+		volume.SecretsZncdataClass:    "tls",
+		volume.CSIStoragePVCName:      pvc.Name,
+		volume.CSIStoragePVCNamespace: pvc.Namespace,
+	})
+
+	if _, err := n.NodeStageVolume(context.Background(), stage); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	staged, ok := n.staged[stage.GetStagingTargetPath()]
+	if !ok {
+		t.Fatalf("expected the secret pre-fetched when the volume is staged")
+	}
+	if staged.pod.UID != testPodUID {
+		t.Errorf("expected the secret fetched for the pod owning the PVC, got pod %s", staged.pod.UID)
+	}
+
+	// the autoTls backend issues a new certificate for each fetch, the staged one must be published
+	request := newTestStagedPublishRequest(t, stage)
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cert := readTestCertificate(t, request); !bytes.Equal(cert, staged.content.Data[format.PEMTlsCertFileName]) {
+		t.Errorf("expected the certificate pre-fetched by stage to be published")
+	}
+	if len(n.staged) != 0 {
+		t.Errorf("expected the pre-fetched secret to be used once, got %d staged", len(n.staged))
+	}
+
+	// the pre-fetched secret is not published twice
+	again := newTestStagedPublishRequest(t, stage)
+	if _, err := n.NodePublishVolume(context.Background(), again); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Equal(readTestCertificate(t, again), staged.content.Data[format.PEMTlsCertFileName]) {
+		t.Errorf("expected a new certificate for the second publish")
+	}
+}
+
+func TestNodeStageVolumeNoCache(t *testing.T) {
+	pod, pvc := newTestEphemeralPVC()
+	n := newTestNodeServer(t, newTestAutoTlsSecretClass("tls"), pod, pvc)
+	volumeContext := map[string]string{
+		volume.SecretsZncdataClass:    "tls",
+		volume.CSIStoragePVCName:      pvc.Name,
+		volume.CSIStoragePVCNamespace: pvc.Namespace,
+	}
+
+	// the secret pre-fetched by stage is not published with noCache
+	stage := newTestStageRequest(t, volumeContext)
+	if _, err := n.NodeStageVolume(context.Background(), stage); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	staged := n.staged[stage.GetStagingTargetPath()]
+	request := newTestStagedPublishRequest(t, stage)
+	request.VolumeContext[volume.NoCache] = "true"
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Equal(readTestCertificate(t, request), staged.content.Data[format.PEMTlsCertFileName]) {
+		t.Errorf("expected the secret pre-fetched by stage not to be published with noCache")
+	}
+	if len(n.staged) != 0 {
+		t.Errorf("expected the pre-fetched secret to be dropped, got %d staged", len(n.staged))
+	}
+
+	// nothing is pre-fetched with noCache
+	volumeContext[volume.NoCache] = "true"
+	stage = newTestStageRequest(t, volumeContext)
+	if _, err := n.NodeStageVolume(context.Background(), stage); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(n.staged) != 0 {
+		t.Errorf("expected nothing pre-fetched with noCache, got %d staged", len(n.staged))
+	}
+}
+
+func TestNodeStageVolumePrefetchOtherPod(t *testing.T) {
+	pod, pvc := newTestEphemeralPVC()
+	n := newTestNodeServer(t, newTestAutoTlsSecretClass("tls"), pod, pvc)
+	stage := newTestStageRequest(t, map[string]string{
+		volume.SecretsZncdataClass:    "tls",
+		volume.CSIStoragePVCName:      pvc.Name,
+		volume.CSIStoragePVCNamespace: pvc.Namespace,
+	})
+	if _, err := n.NodeStageVolume(context.Background(), stage); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	staged := n.staged[stage.GetStagingTargetPath()]
+
+	request := newTestStagedPublishRequest(t, stage)
+	request.VolumeContext[volume.CSIStoragePodUid] = "another-uid"
+	if _, err := n.NodePublishVolume(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Equal(readTestCertificate(t, request), staged.content.Data[format.PEMTlsCertFileName]) {
+		t.Errorf("expected the secret pre-fetched for another pod not to be published")
+	}
+}
+
+func TestNodeStageVolumeFailFast(t *testing.T) {
+	pki := "pki"
+	pkiClass := &secretsv1alpha1.SecretClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "pki"},
+		Spec: secretsv1alpha1.SecretClassSpec{
+			Backend: &secretsv1alpha1.BackendSpec{
+				K8sSearch: &secretsv1alpha1.K8sSearchSpec{
+					SearchNamespace: &secretsv1alpha1.SearchNamespaceSpec{Name: &pki},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		objs          []client.Object
+		volumeContext map[string]string
+		code          codes.Code
+	}{
+		{
+			name:          "class missing",
+			volumeContext: map[string]string{volume.SecretsZncdataScope: "pod"},
+			code:          codes.InvalidArgument,
+		},
+		{
+			name:          "secret class not found",
+			volumeContext: map[string]string{volume.SecretsZncdataClass: "tls"},
+			code:          codes.NotFound,
+		},
+		{
+			name:          "secret not found",
+			objs:          []client.Object{pkiClass},
+			volumeContext: map[string]string{volume.SecretsZncdataClass: "pki"},
+			code:          codes.NotFound,
+		},
+		{
+			name:          "valid",
+			objs:          []client.Object{newTestSecretClass()},
+			volumeContext: map[string]string{volume.SecretsZncdataClass: "tls"},
+			code:          codes.OK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newTestNodeServer(t, tt.objs...)
+			_, err := n.NodeStageVolume(context.Background(), newTestStageRequest(t, tt.volumeContext))
+			if status.Code(err) != tt.code {
+				t.Errorf("unexpected error: got %v, want code %s", err, tt.code)
+			}
+			if len(n.staged) != 0 {
+				t.Errorf("expected nothing pre-fetched without the PVC, got %d staged", len(n.staged))
+			}
+		})
+	}
+}
+
+func TestNodeUnstageVolumeForgetsPrefetch(t *testing.T) {
+	pod, pvc := newTestEphemeralPVC()
+	n := newTestNodeServer(t, newTestAutoTlsSecretClass("tls"), pod, pvc)
+	stage := newTestStageRequest(t, map[string]string{
+		volume.SecretsZncdataClass:    "tls",
+		volume.CSIStoragePVCName:      pvc.Name,
+		volume.CSIStoragePVCNamespace: pvc.Namespace,
+	})
+	if _, err := n.NodeStageVolume(context.Background(), stage); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := n.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+		VolumeId:          stage.GetVolumeId(),
+		StagingTargetPath: stage.GetStagingTargetPath(),
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(n.staged) != 0 {
+		t.Errorf("expected the pre-fetched secret dropped by unstage, got %d staged", len(n.staged))
+	}
+}
@@ -0,0 +1,39 @@
+package csi
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans of the node server, they are exported by the tracer provider of the node server,
+// the global one by default, see --otlp-endpoint.
+const (
+	tracerName = "github.com/zncdata-labs/secret-operator/internal/csi"
+
+	spanPublishVolume    = "NodePublishVolume"
+	spanGetSecretClasses = "getSecretClasses"
+	spanGetPod           = "getPod"
+	spanGetSecretData    = "GetSecretData"
+	spanMount            = "mount"
+	spanWriteData        = "writeData"
+)
+
+// Attributes of the spans.
+const (
+	attrBackendType  = attribute.Key("secret.backend.type")
+	attrSecretClass  = attribute.Key("secret.class")
+	attrPod          = attribute.Key("k8s.pod.name")
+	attrPodNamespace = attribute.Key("k8s.namespace.name")
+	attrTargetPath   = attribute.Key("csi.target_path")
+	attrBytesWritten = attribute.Key("secret.bytes_written")
+)
+
+// endSpan records the error on the span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,103 @@
+package csi
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretbackend "github.com/zncdata-labs/secret-operator/internal/csi/backend"
+)
+
+func newTestTracedNodeServer(t *testing.T, exporter *tracetest.InMemoryExporter, objs ...client.Object) *NodeServer {
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+	})
+	return newTestNodeServer(t, objs...).WithTracerProvider(provider)
+}
+
+// spanAttribute returns the value of the attribute of the span, and whether it is set.
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestNodePublishVolumeTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	n := newTestTracedNodeServer(t, exporter, newTestSecretClass(), newTestPod(), newTestSecret())
+
+	if _, err := n.NodePublishVolume(context.Background(), newTestPublishRequest(t)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	root, ok := spans[spanPublishVolume]
+	if !ok {
+		t.Fatalf("span %q not exported, got %v", spanPublishVolume, exporter.GetSpans())
+	}
+	if v, _ := spanAttribute(root, attrBackendType); v.AsString() != secretbackend.BackendTypeK8sSearch {
+		t.Errorf("unexpected backend type of span %q: %q", root.Name, v.AsString())
+	}
+	for _, name := range []string{spanGetSecretClasses, spanGetPod, spanGetSecretData, spanMount, spanWriteData} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("span %q not exported", name)
+			continue
+		}
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("span %q is not a child of span %q", name, root.Name)
+		}
+		if span.Status.Code == otelcodes.Error {
+			t.Errorf("unexpected error status of span %q: %s", name, span.Status.Description)
+		}
+	}
+
+	if v, _ := spanAttribute(spans[spanGetSecretData], attrBackendType); v.AsString() != secretbackend.BackendTypeK8sSearch {
+		t.Errorf("unexpected backend type of span %q: %q", spanGetSecretData, v.AsString())
+	}
+	if v, _ := spanAttribute(spans[spanGetSecretData], attrSecretClass); v.AsString() != "tls" {
+		t.Errorf("unexpected secret class of span %q: %q", spanGetSecretData, v.AsString())
+	}
+	// the secret has a single key, username, with the value admin
+	if v, ok := spanAttribute(spans[spanWriteData], attrBytesWritten); !ok || v.AsInt64() != int64(len("admin")) {
+		t.Errorf("unexpected bytes written of span %q: %v", spanWriteData, v.Emit())
+	}
+}
+
+func TestNodePublishVolumeTracingError(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	n := newTestTracedNodeServer(t, exporter, newTestSecretClass())
+
+	_, err := n.NodePublishVolume(context.Background(), newTestPublishRequest(t))
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("unexpected error: got %v, want code %s", err, codes.NotFound)
+	}
+
+	// the pod is not found, the failed span and the publish record the error, the volume is never mounted
+	failed := map[string]bool{}
+	for _, span := range exporter.GetSpans() {
+		if span.Name == spanMount || span.Name == spanWriteData {
+			t.Errorf("unexpected span %q", span.Name)
+		}
+		failed[span.Name] = span.Status.Code == otelcodes.Error && len(span.Events) > 0
+	}
+	for name, want := range map[string]bool{spanGetSecretClasses: false, spanGetPod: true, spanPublishVolume: true} {
+		if got, ok := failed[name]; !ok || got != want {
+			t.Errorf("unexpected error of span %q: got %v, want %v (exported %v)", name, got, want, ok)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Package jks implements a minimal encoder of the Java KeyStore (JKS) format.
+//
+// Only writing is supported, it is enough to provide keystores and truststores
+// to the java components which do not support PKCS12.
+package jks
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"k8s.io/utils/clock"
+)
+
+const (
+	magic   uint32 = 0xFEEDFEED
+	version uint32 = 2
+
+	privateKeyTag         uint32 = 1
+	trustedCertificateTag uint32 = 2
+
+	certificateType = "X.509"
+
+	// whitener is mixed into the integrity digest of the keystore.
+	whitener = "Mighty Aphrodite"
+
+	saltLength = sha1.Size
+)
+
+// keyProtectorOID is the OID of the Sun proprietary key protection algorithm.
+var keyProtectorOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+type PrivateKeyEntry struct {
+	Alias        string
+	CreationTime time.Time
+	PrivateKey   crypto.PrivateKey
+	// CertificateChain starts with the certificate of the private key.
+	CertificateChain []*x509.Certificate
+}
+
+type TrustedCertificateEntry struct {
+	Alias        string
+	CreationTime time.Time
+	Certificate  *x509.Certificate
+}
+
+type KeyStore struct {
+	PrivateKeys         []PrivateKeyEntry
+	TrustedCertificates []TrustedCertificateEntry
+
+	// Clock sets the creation time of the entries without one, and Rand generates the salts of the private keys.
+	// They default to the real clock and crypto/rand.Reader, tests replace them to encode deterministically.
+	Clock clock.PassiveClock
+	Rand  io.Reader
+}
+
+// Encode encodes the keystore to JKS format, the password is used to protect the private keys
+// and to compute the integrity digest of the keystore.
+func (k *KeyStore) Encode(password string) ([]byte, error) {
+	passwordBytes := passwordToBytes(password)
+	now := k.now()
+
+	buf := &bytes.Buffer{}
+	writeUint32(buf, magic)
+	writeUint32(buf, version)
+	writeUint32(buf, uint32(len(k.PrivateKeys)+len(k.TrustedCertificates)))
+
+	for _, entry := range k.PrivateKeys {
+		if len(entry.CertificateChain) == 0 {
+			return nil, fmt.Errorf("private key entry %s has no certificate", entry.Alias)
+		}
+
+		protectedKey, err := protectKey(entry.PrivateKey, passwordBytes, k.rand())
+		if err != nil {
+			return nil, fmt.Errorf("failed to protect private key %s: %w", entry.Alias, err)
+		}
+
+		writeUint32(buf, privateKeyTag)
+		if err := writeEntryHeader(buf, entry.Alias, entry.CreationTime, now); err != nil {
+			return nil, err
+		}
+		writeBytes(buf, protectedKey)
+		writeUint32(buf, uint32(len(entry.CertificateChain)))
+		for _, cert := range entry.CertificateChain {
+			if err := writeCertificate(buf, cert); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, entry := range k.TrustedCertificates {
+		writeUint32(buf, trustedCertificateTag)
+		if err := writeEntryHeader(buf, entry.Alias, entry.CreationTime, now); err != nil {
+			return nil, err
+		}
+		if err := writeCertificate(buf, entry.Certificate); err != nil {
+			return nil, err
+		}
+	}
+
+	digest := sha1.New()
+	digest.Write(passwordBytes)
+	digest.Write([]byte(whitener))
+	digest.Write(buf.Bytes())
+	buf.Write(digest.Sum(nil))
+
+	return buf.Bytes(), nil
+}
+
+func (k *KeyStore) now() time.Time {
+	if k.Clock == nil {
+		return time.Now()
+	}
+	return k.Clock.Now()
+}
+
+func (k *KeyStore) rand() io.Reader {
+	if k.Rand == nil {
+		return rand.Reader
+	}
+	return k.Rand
+}
+
+// passwordToBytes converts the password to UTF-16 big endian bytes, as java does.
+func passwordToBytes(password string) []byte {
+	chars := utf16.Encode([]rune(password))
+	data := make([]byte, 0, len(chars)*2)
+	for _, c := range chars {
+		data = append(data, byte(c>>8), byte(c))
+	}
+	return data
+}
+
+// protectKey encrypts the private key with the Sun key protector, and returns the
+// DER encoded EncryptedPrivateKeyInfo.
+//
+// The key is xored with a key stream of chained SHA-1 digests of the password and a random salt,
+// the protected key is the salt, the encrypted key and the SHA-1 digest of the password and the plain key.
+func protectKey(privateKey crypto.PrivateKey, passwordBytes []byte, random io.Reader) ([]byte, error) {
+	plainKey, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(random, salt); err != nil {
+		return nil, err
+	}
+
+	encryptedKey := make([]byte, len(plainKey))
+	digest := salt
+	for i := 0; i < len(plainKey); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, passwordBytes...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(plainKey); j++ {
+			encryptedKey[i+j] = plainKey[i+j] ^ digest[j]
+		}
+	}
+
+	check := sha1.Sum(append(append([]byte{}, passwordBytes...), plainKey...))
+
+	protectedKey := make([]byte, 0, saltLength+len(encryptedKey)+sha1.Size)
+	protectedKey = append(protectedKey, salt...)
+	protectedKey = append(protectedKey, encryptedKey...)
+	protectedKey = append(protectedKey, check[:]...)
+
+	return asn1.Marshal(struct {
+		Algorithm     pkix.AlgorithmIdentifier
+		EncryptedData []byte
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  keyProtectorOID,
+			Parameters: asn1.NullRawValue,
+		},
+		EncryptedData: protectedKey,
+	})
+}
+
+func writeEntryHeader(buf *bytes.Buffer, alias string, creationTime, now time.Time) error {
+	if alias == "" {
+		return errors.New("alias of keystore entry is empty")
+	}
+	if creationTime.IsZero() {
+		creationTime = now
+	}
+	// keytool treats aliases case-insensitively and stores them in lower case
+	if err := writeUTF(buf, strings.ToLower(alias)); err != nil {
+		return err
+	}
+	writeUint64(buf, uint64(creationTime.UnixMilli()))
+	return nil
+}
+
+func writeCertificate(buf *bytes.Buffer, cert *x509.Certificate) error {
+	if cert == nil {
+		return errors.New("certificate is nil")
+	}
+	if err := writeUTF(buf, certificateType); err != nil {
+		return err
+	}
+	writeBytes(buf, cert.Raw)
+	return nil
+}
+
+// writeUTF writes the string as java DataOutput.writeUTF does, the string must be shorter than 64KiB.
+// Modified UTF-8 only differs from UTF-8 for the NUL character and supplementary characters,
+// which are not expected in aliases.
+func writeUTF(buf *bytes.Buffer, s string) error {
+	if len(s) > 0xFFFF {
+		return fmt.Errorf("string is too long: %d bytes", len(s))
+	}
+	_ = binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+	return nil
+}
+
+func writeBytes(buf *bytes.Buffer, data []byte) {
+	writeUint32(buf, uint32(len(data)))
+	buf.Write(data)
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	_ = binary.Write(buf, binary.BigEndian, v)
+}
+
+func writeUint64(buf *bytes.Buffer, v uint64) {
+	_ = binary.Write(buf, binary.BigEndian, v)
+}
@@ -0,0 +1,182 @@
+package jks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func newTestKeyStore(t *testing.T) (*KeyStore, *rsa.PrivateKey) {
+	cert, key := newTestCertificate(t)
+	return &KeyStore{
+		PrivateKeys: []PrivateKeyEntry{
+			{Alias: "Server", PrivateKey: key, CertificateChain: []*x509.Certificate{cert}},
+		},
+		TrustedCertificates: []TrustedCertificateEntry{
+			{Alias: "ca", Certificate: cert},
+		},
+	}, key
+}
+
+// unprotectKey reverses protectKey, to check the key can be recovered with the password.
+func unprotectKey(t *testing.T, der []byte, passwordBytes []byte) []byte {
+	var info struct {
+		Algorithm     pkix.AlgorithmIdentifier
+		EncryptedData []byte
+	}
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		t.Fatal(err)
+	}
+	if !info.Algorithm.Algorithm.Equal(keyProtectorOID) {
+		t.Fatalf("unexpected key protection algorithm: %v", info.Algorithm.Algorithm)
+	}
+
+	data := info.EncryptedData
+	salt := data[:saltLength]
+	encryptedKey := data[saltLength : len(data)-sha1.Size]
+	check := data[len(data)-sha1.Size:]
+
+	plainKey := make([]byte, len(encryptedKey))
+	digest := salt
+	for i := 0; i < len(encryptedKey); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, passwordBytes...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(encryptedKey); j++ {
+			plainKey[i+j] = encryptedKey[i+j] ^ digest[j]
+		}
+	}
+
+	expectedCheck := sha1.Sum(append(append([]byte{}, passwordBytes...), plainKey...))
+	if !bytes.Equal(check, expectedCheck[:]) {
+		t.Fatalf("key check digest mismatch")
+	}
+	return plainKey
+}
+
+func TestEncode(t *testing.T) {
+	keyStore, key := newTestKeyStore(t)
+
+	data, err := keyStore.Encode("changeit")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := binary.BigEndian.Uint32(data[0:4]); got != magic {
+		t.Errorf("unexpected magic: got %x, want %x", got, magic)
+	}
+	if got := binary.BigEndian.Uint32(data[4:8]); got != version {
+		t.Errorf("unexpected version: got %d, want %d", got, version)
+	}
+	if got := binary.BigEndian.Uint32(data[8:12]); got != 2 {
+		t.Errorf("unexpected entry count: got %d, want %d", got, 2)
+	}
+
+	passwordBytes := passwordToBytes("changeit")
+	body := data[:len(data)-sha1.Size]
+	expectedDigest := sha1.Sum(append(append(append([]byte{}, passwordBytes...), whitener...), body...))
+	if !bytes.Equal(data[len(data)-sha1.Size:], expectedDigest[:]) {
+		t.Errorf("integrity digest mismatch")
+	}
+
+	// the first entry is the private key entry
+	r := bytes.NewReader(data[12:])
+	var tag uint32
+	_ = binary.Read(r, binary.BigEndian, &tag)
+	if tag != privateKeyTag {
+		t.Fatalf("unexpected entry tag: got %d, want %d", tag, privateKeyTag)
+	}
+	var aliasLength uint16
+	_ = binary.Read(r, binary.BigEndian, &aliasLength)
+	alias := make([]byte, aliasLength)
+	_, _ = r.Read(alias)
+	if string(alias) != "server" {
+		t.Errorf("unexpected alias: got %s, want %s", alias, "server")
+	}
+	var timestamp uint64
+	_ = binary.Read(r, binary.BigEndian, &timestamp)
+	var keyLength uint32
+	_ = binary.Read(r, binary.BigEndian, &keyLength)
+	protectedKey := make([]byte, keyLength)
+	_, _ = r.Read(protectedKey)
+
+	plainKey := unprotectKey(t, protectedKey, passwordBytes)
+	expectedKey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plainKey, expectedKey) {
+		t.Errorf("recovered private key mismatch")
+	}
+}
+
+func TestEncodeWithoutCertificate(t *testing.T) {
+	_, key := newTestCertificate(t)
+	keyStore := &KeyStore{
+		PrivateKeys: []PrivateKeyEntry{{Alias: "server", PrivateKey: key}},
+	}
+	if _, err := keyStore.Encode("changeit"); err == nil {
+		t.Errorf("expected error when private key entry has no certificate")
+	}
+}
+
+func TestKeytoolList(t *testing.T) {
+	keytool, err := exec.LookPath("keytool")
+	if err != nil {
+		t.Skip("keytool not found in PATH")
+	}
+
+	keyStore, _ := newTestKeyStore(t)
+	data, err := keyStore.Encode("changeit")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keystore.jks")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := exec.Command(keytool, "-list", "-keystore", path, "-storetype", "JKS", "-storepass", "changeit").CombinedOutput()
+	if err != nil {
+		t.Fatalf("keytool failed: %v\n%s", err, output)
+	}
+	for _, expected := range []string{"server, ", "PrivateKeyEntry", "ca, ", "trustedCertEntry"} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("keytool output does not contain %q:\n%s", expected, output)
+		}
+	}
+}
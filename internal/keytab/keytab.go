@@ -0,0 +1,250 @@
+// Package keytab implements a minimal encoder and decoder of the MIT Kerberos keytab format, version 0x0502.
+//
+// It is enough to merge the keytabs exported for several principals into the single keytab of a volume,
+// no cryptographic operation is done on the keys.
+package keytab
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	formatMarker  byte = 0x05
+	formatVersion byte = 0x02
+)
+
+// NameTypePrincipal is the KRB5_NT_PRINCIPAL name type of the user principals.
+const NameTypePrincipal uint32 = 1
+
+// NameTypeSrvHost is the KRB5_NT_SRV_HST name type of the service principals, e.g. "HTTP/host@REALM".
+const NameTypeSrvHost uint32 = 3
+
+// Principal is a Kerberos principal name, e.g. "HTTP/host.example.com@EXAMPLE.COM".
+type Principal struct {
+	Components []string
+	Realm      string
+}
+
+// ParsePrincipal parses "<component>[/<component>...]@<realm>".
+func ParsePrincipal(name string) (Principal, error) {
+	components, realm, ok := strings.Cut(name, "@")
+	if !ok || components == "" || realm == "" {
+		return Principal{}, fmt.Errorf("invalid principal %q: must be <name>@<realm>", name)
+	}
+	p := Principal{Components: strings.Split(components, "/"), Realm: realm}
+	if slices.Contains(p.Components, "") {
+		return Principal{}, fmt.Errorf("invalid principal %q: empty component", name)
+	}
+	return p, nil
+}
+
+func (p Principal) String() string {
+	return strings.Join(p.Components, "/") + "@" + p.Realm
+}
+
+// Entry is a key of a principal.
+type Entry struct {
+	Principal Principal
+	NameType  uint32
+	Timestamp time.Time
+	// KVNO is the key version number, a principal has an entry per key version and encryption type.
+	KVNO    uint32
+	KeyType uint16
+	Key     []byte
+}
+
+type Keytab struct {
+	Entries []Entry
+}
+
+// Principals returns the sorted names of the principals in the keytab, each listed once.
+func (k *Keytab) Principals() []string {
+	var principals []string
+	for _, entry := range k.Entries {
+		name := entry.Principal.String()
+		if !slices.Contains(principals, name) {
+			principals = append(principals, name)
+		}
+	}
+	slices.Sort(principals)
+	return principals
+}
+
+// Merge returns a keytab with the entries of all the keytabs.
+// The entries of every key version are kept, an entry with the same principal, key version
+// and encryption type as a previous one is dropped.
+func Merge(keytabs ...*Keytab) *Keytab {
+	type entryKey struct {
+		principal string
+		kvno      uint32
+		keyType   uint16
+	}
+	seen := map[entryKey]bool{}
+
+	merged := &Keytab{}
+	for _, k := range keytabs {
+		for _, entry := range k.Entries {
+			key := entryKey{principal: entry.Principal.String(), kvno: entry.KVNO, keyType: entry.KeyType}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged.Entries = append(merged.Entries, entry)
+		}
+	}
+	return merged
+}
+
+// Encode encodes the keytab, the key version is written in both the 8 bit and the 32 bit fields.
+func (k *Keytab) Encode() ([]byte, error) {
+	var b bytes.Buffer
+	b.Write([]byte{formatMarker, formatVersion})
+
+	for _, entry := range k.Entries {
+		data, err := encodeEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the entry of principal %s: %w", entry.Principal, err)
+		}
+		_ = binary.Write(&b, binary.BigEndian, int32(len(data)))
+		b.Write(data)
+	}
+	return b.Bytes(), nil
+}
+
+func encodeEntry(entry Entry) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeUint16Len(&b, len(entry.Principal.Components)); err != nil {
+		return nil, err
+	}
+	if err := writeCountedString(&b, []byte(entry.Principal.Realm)); err != nil {
+		return nil, err
+	}
+	for _, component := range entry.Principal.Components {
+		if err := writeCountedString(&b, []byte(component)); err != nil {
+			return nil, err
+		}
+	}
+	_ = binary.Write(&b, binary.BigEndian, entry.NameType)
+	_ = binary.Write(&b, binary.BigEndian, uint32(entry.Timestamp.Unix()))
+	b.WriteByte(byte(entry.KVNO))
+	_ = binary.Write(&b, binary.BigEndian, entry.KeyType)
+	if err := writeCountedString(&b, entry.Key); err != nil {
+		return nil, err
+	}
+	_ = binary.Write(&b, binary.BigEndian, entry.KVNO)
+	return b.Bytes(), nil
+}
+
+func writeUint16Len(b *bytes.Buffer, n int) error {
+	if n > 0xFFFF {
+		return fmt.Errorf("length %d is too long", n)
+	}
+	return binary.Write(b, binary.BigEndian, uint16(n))
+}
+
+func writeCountedString(b *bytes.Buffer, data []byte) error {
+	if err := writeUint16Len(b, len(data)); err != nil {
+		return err
+	}
+	b.Write(data)
+	return nil
+}
+
+// Parse decodes a keytab, the deleted entries are skipped.
+func Parse(data []byte) (*Keytab, error) {
+	if len(data) < 2 || data[0] != formatMarker || data[1] != formatVersion {
+		return nil, errors.New("unsupported keytab format, only version 0x0502 is supported")
+	}
+
+	k := &Keytab{}
+	r := bytes.NewReader(data[2:])
+	for r.Len() > 0 {
+		var size int32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, fmt.Errorf("failed to read the entry size: %w", err)
+		}
+		if size < 0 {
+			// a hole left by a deleted entry
+			if _, err := r.Seek(-int64(size), io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if int64(size) > int64(r.Len()) {
+			return nil, fmt.Errorf("entry size %d exceeds the keytab", size)
+		}
+		buf := make([]byte, size)
+		_, _ = r.Read(buf)
+		entry, err := parseEntry(buf)
+		if err != nil {
+			return nil, err
+		}
+		k.Entries = append(k.Entries, entry)
+	}
+	return k, nil
+}
+
+func parseEntry(data []byte) (Entry, error) {
+	r := bytes.NewReader(data)
+	var entry Entry
+
+	var count uint16
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return entry, fmt.Errorf("failed to read the principal: %w", err)
+	}
+	realm, err := readCountedString(r)
+	if err != nil {
+		return entry, fmt.Errorf("failed to read the realm: %w", err)
+	}
+	entry.Principal.Realm = string(realm)
+	for i := 0; i < int(count); i++ {
+		component, err := readCountedString(r)
+		if err != nil {
+			return entry, fmt.Errorf("failed to read the principal: %w", err)
+		}
+		entry.Principal.Components = append(entry.Principal.Components, string(component))
+	}
+
+	var timestamp uint32
+	var kvno8 uint8
+	for _, field := range []any{&entry.NameType, &timestamp, &kvno8, &entry.KeyType} {
+		if err := binary.Read(r, binary.BigEndian, field); err != nil {
+			return entry, fmt.Errorf("failed to read the entry of principal %s: %w", entry.Principal, err)
+		}
+	}
+	entry.Timestamp = time.Unix(int64(timestamp), 0)
+	if entry.Key, err = readCountedString(r); err != nil {
+		return entry, fmt.Errorf("failed to read the key of principal %s: %w", entry.Principal, err)
+	}
+
+	// the 32 bit key version is optional, it overrides the 8 bit one which wraps around
+	entry.KVNO = uint32(kvno8)
+	if r.Len() >= 4 {
+		var kvno uint32
+		_ = binary.Read(r, binary.BigEndian, &kvno)
+		if kvno != 0 {
+			entry.KVNO = kvno
+		}
+	}
+	return entry, nil
+}
+
+func readCountedString(r *bytes.Reader) ([]byte, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	if int(n) > r.Len() {
+		return nil, errors.New("unexpected end of entry")
+	}
+	data := make([]byte, n)
+	_, _ = r.Read(data)
+	return data, nil
+}
@@ -0,0 +1,106 @@
+package keytab
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestEntry(t *testing.T, name string, kvno uint32, keyType uint16) Entry {
+	principal, err := ParsePrincipal(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Entry{
+		Principal: principal,
+		NameType:  NameTypeSrvHost,
+		Timestamp: time.Unix(1700000000, 0),
+		KVNO:      kvno,
+		KeyType:   keyType,
+		Key:       bytes.Repeat([]byte{byte(kvno)}, 16),
+	}
+}
+
+func TestEncodeParse(t *testing.T) {
+	k := &Keytab{Entries: []Entry{
+		newTestEntry(t, "HTTP/web.default.svc.cluster.local@EXAMPLE.COM", 1, 17),
+		newTestEntry(t, "HTTP/web.default.svc.cluster.local@EXAMPLE.COM", 300, 18),
+		newTestEntry(t, "admin@EXAMPLE.COM", 2, 18),
+	}}
+
+	data, err := k.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, k) {
+		t.Errorf("unexpected keytab: got %+v, want %+v", parsed, k)
+	}
+}
+
+func TestParseSkipsHoles(t *testing.T) {
+	k := &Keytab{Entries: []Entry{newTestEntry(t, "HTTP/host@EXAMPLE.COM", 1, 18)}}
+	data, err := k.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a deleted entry of 4 bytes before the entry
+	data = append([]byte{0x05, 0x02, 0xFF, 0xFF, 0xFF, 0xFC, 0, 0, 0, 0}, data[2:]...)
+
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, k) {
+		t.Errorf("unexpected keytab: got %+v, want %+v", parsed, k)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":           nil,
+		"version 0x0501":  {0x05, 0x01},
+		"truncated size":  {0x05, 0x02, 0x00},
+		"truncated entry": {0x05, 0x02, 0x00, 0x00, 0x00, 0x10, 0x00},
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse(data); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	http := &Keytab{Entries: []Entry{
+		newTestEntry(t, "HTTP/host@EXAMPLE.COM", 1, 18),
+		newTestEntry(t, "HTTP/host@EXAMPLE.COM", 2, 18),
+	}}
+	hdfs := &Keytab{Entries: []Entry{
+		newTestEntry(t, "hdfs/host@EXAMPLE.COM", 1, 18),
+		newTestEntry(t, "HTTP/host@EXAMPLE.COM", 2, 18),
+		newTestEntry(t, "HTTP/host@EXAMPLE.COM", 2, 17),
+	}}
+
+	merged := Merge(http, hdfs)
+	if len(merged.Entries) != 4 {
+		t.Errorf("expected 4 entries, got %+v", merged.Entries)
+	}
+	expected := []string{"HTTP/host@EXAMPLE.COM", "hdfs/host@EXAMPLE.COM"}
+	if principals := merged.Principals(); !reflect.DeepEqual(principals, expected) {
+		t.Errorf("unexpected principals: got %v, want %v", principals, expected)
+	}
+}
+
+func TestParsePrincipalInvalid(t *testing.T) {
+	for _, name := range []string{"", "HTTP/host", "@EXAMPLE.COM", "HTTP//host@EXAMPLE.COM", "HTTP/host@"} {
+		if _, err := ParsePrincipal(name); err == nil {
+			t.Errorf("expected error for principal %q", name)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2024 zncdata-labs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+	"github.com/zncdata-labs/secret-operator/internal/csi/backend"
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+var logger = ctrl.Log.WithName("secretclass-webhook")
+
+// SetupSecretClassWebhookWithManager registers the validating webhook of SecretClass in the manager.
+func SetupSecretClassWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&secretsv1alpha1.SecretClass{}).
+		WithValidator(&SecretClassValidator{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-secrets-zncdata-dev-v1alpha1-secretclass,mutating=false,failurePolicy=fail,sideEffects=None,groups=secrets.zncdata.dev,resources=secretclasses,verbs=create;update,versions=v1alpha1,name=vsecretclass.kb.io,admissionReviewVersions=v1
+
+// SecretClassValidator rejects the SecretClasses whose backend is misconfigured, e.g. an autoTls backend
+// without CA secret or several backends, which otherwise only fail when a volume is published on a node.
+// The backend is checked like by the csi driver, but the resources it depends on, e.g. the CA secret or vault,
+// are not, they may be created after the SecretClass. The controller reports them in the Ready condition.
+type SecretClassValidator struct{}
+
+var _ webhook.CustomValidator = &SecretClassValidator{}
+
+// ValidateCreate implements webhook.CustomValidator.
+func (v *SecretClassValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(obj)
+}
+
+// ValidateUpdate implements webhook.CustomValidator.
+func (v *SecretClassValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(newObj)
+}
+
+// ValidateDelete implements webhook.CustomValidator, a SecretClass can always be deleted.
+func (v *SecretClassValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *SecretClassValidator) validate(obj runtime.Object) error {
+	secretClass, ok := obj.(*secretsv1alpha1.SecretClass)
+	if !ok {
+		return fmt.Errorf("expected a SecretClass but got a %T", obj)
+	}
+
+	if err := backend.ValidateSecretClass(secretClass); err != nil {
+		logger.V(1).Info("SecretClass rejected", "name", secretClass.Name, "error", err.Error())
+		return apierrors.NewInvalid(
+			secretsv1alpha1.GroupVersion.WithKind("SecretClass").GroupKind(),
+			secretClass.Name,
+			field.ErrorList{field.Invalid(field.NewPath("spec", "backend"), secretClass.Spec.Backend, err.Error())},
+		)
+	}
+	if err := volume.ValidateDefaults(secretClass.Spec.VolumeDefaults); err != nil {
+		logger.V(1).Info("SecretClass rejected", "name", secretClass.Name, "error", err.Error())
+		return apierrors.NewInvalid(
+			secretsv1alpha1.GroupVersion.WithKind("SecretClass").GroupKind(),
+			secretClass.Name,
+			field.ErrorList{field.Invalid(field.NewPath("spec", "volumeDefaults"), secretClass.Spec.VolumeDefaults, err.Error())},
+		)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2024 zncdata-labs.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	secretsv1alpha1 "github.com/zncdata-labs/secret-operator/api/v1alpha1"
+)
+
+func newTestAutoTlsSpec() *secretsv1alpha1.AutoTlsSpec {
+	return &secretsv1alpha1.AutoTlsSpec{
+		CA: &secretsv1alpha1.CASpec{
+			AutoGenerated:         true,
+			CACertificateLifeTime: "8760h",
+			Secret:                &secretsv1alpha1.SecretSpec{Name: "tls-ca", Namespace: "default"},
+		},
+	}
+}
+
+func TestSecretClassValidator(t *testing.T) {
+	pki := "pki"
+	tests := []struct {
+		name     string
+		backend  *secretsv1alpha1.BackendSpec
+		defaults map[string]string
+		message  string
+	}{
+		{
+			name:    "valid autoTls",
+			backend: &secretsv1alpha1.BackendSpec{AutoTls: newTestAutoTlsSpec()},
+		},
+		{
+			name:    "no backend",
+			message: "backend is not configured",
+		},
+		{
+			name:    "empty backend",
+			backend: &secretsv1alpha1.BackendSpec{},
+			message: "can not find backend",
+		},
+		{
+			name: "several backends",
+			backend: &secretsv1alpha1.BackendSpec{
+				AutoTls: newTestAutoTlsSpec(),
+				Vault:   &secretsv1alpha1.VaultSpec{Address: "https://vault:8200", Role: "app"},
+			},
+			message: "mutually exclusive",
+		},
+		{
+			name:    "autoTls without ca",
+			backend: &secretsv1alpha1.BackendSpec{AutoTls: &secretsv1alpha1.AutoTlsSpec{}},
+			message: "ca secret is not set",
+		},
+		{
+			name: "autoTls invalid max certificate lifetime",
+			backend: &secretsv1alpha1.BackendSpec{AutoTls: func() *secretsv1alpha1.AutoTlsSpec {
+				spec := newTestAutoTlsSpec()
+				spec.MaxCertificateLifeTime = "15 days"
+				return spec
+			}()},
+			message: "invalid maxCertificateLifeTime",
+		},
+		{
+			name: "k8sSearch with both namespaces",
+			backend: &secretsv1alpha1.BackendSpec{K8sSearch: &secretsv1alpha1.K8sSearchSpec{
+				SearchNamespace: &secretsv1alpha1.SearchNamespaceSpec{Name: &pki, Pod: &secretsv1alpha1.PodSpec{}},
+			}},
+			message: "can not be used together",
+		},
+		{
+			name:    "vault without role",
+			backend: &secretsv1alpha1.BackendSpec{Vault: &secretsv1alpha1.VaultSpec{Address: "https://vault:8200"}},
+			message: "vault role is empty",
+		},
+		{
+			name:     "valid volume defaults",
+			backend:  &secretsv1alpha1.BackendSpec{AutoTls: newTestAutoTlsSpec()},
+			defaults: map[string]string{"secrets.zncdata.dev/format": "tls-pem", "secrets.zncdata.dev/mode": "0400"},
+		},
+		{
+			name:     "class volume default",
+			backend:  &secretsv1alpha1.BackendSpec{AutoTls: newTestAutoTlsSpec()},
+			defaults: map[string]string{"secrets.zncdata.dev/class": "other"},
+			message:  "can not be set by a secret class",
+		},
+		{
+			name:     "invalid volume default",
+			backend:  &secretsv1alpha1.BackendSpec{AutoTls: newTestAutoTlsSpec()},
+			defaults: map[string]string{"secrets.zncdata.dev/mode": "0999"},
+			message:  "invalid volume defaults",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secretClass := &secretsv1alpha1.SecretClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "tls"},
+				Spec:       secretsv1alpha1.SecretClassSpec{Backend: tt.backend, VolumeDefaults: tt.defaults},
+			}
+			validator := &SecretClassValidator{}
+
+			_, createErr := validator.ValidateCreate(context.Background(), secretClass)
+			_, updateErr := validator.ValidateUpdate(context.Background(), &secretsv1alpha1.SecretClass{}, secretClass)
+			for _, err := range []error{createErr, updateErr} {
+				if tt.message == "" {
+					if err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+					continue
+				}
+				if !apierrors.IsInvalid(err) {
+					t.Fatalf("expected an invalid error, got: %v", err)
+				}
+				if !strings.Contains(err.Error(), tt.message) {
+					t.Errorf("expected %q in error, got: %v", tt.message, err)
+				}
+			}
+
+			if _, err := validator.ValidateDelete(context.Background(), secretClass); err != nil {
+				t.Errorf("unexpected error on delete: %v", err)
+			}
+		})
+	}
+}
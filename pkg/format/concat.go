@@ -0,0 +1,49 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+const (
+	// ConcatFileName is the file written by the concat format when the volume does not set concatFile.
+	ConcatFileName = "authorized_keys"
+
+	defaultConcatSeparator = "\n"
+)
+
+// ConvertToConcat writes the values of the concat keys, in their order, or of all the keys ordered by name,
+// to a single file, e.g. the public keys of an authorized_keys or known_hosts file.
+// The trailing newlines of each value are replaced by the separator, so every entry ends with exactly one,
+// and the empty values are skipped.
+func ConvertToConcat(data map[string][]byte, selector *volume.SecretVolumeSelector) (map[string][]byte, error) {
+	keys := selector.ConcatKeys
+	if len(keys) == 0 {
+		keys = sortedKeys(data)
+	}
+	fileName := selector.ConcatFile
+	if fileName == "" {
+		fileName = ConcatFileName
+	}
+	separator := selector.ConcatSeparator
+	if separator == "" {
+		separator = defaultConcatSeparator
+	}
+
+	var b bytes.Buffer
+	for _, key := range keys {
+		value, ok := data[key]
+		if !ok {
+			return nil, fmt.Errorf("key %q of %s is not in the secret data, available keys: %v", key, volume.ConcatKeys, sortedKeys(data))
+		}
+		value = bytes.TrimRight(value, "\r\n")
+		if len(value) == 0 {
+			continue
+		}
+		b.Write(value)
+		b.WriteString(separator)
+	}
+	return map[string][]byte{fileName: b.Bytes()}, nil
+}
@@ -0,0 +1,59 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func TestConvertToConcat(t *testing.T) {
+	data := map[string][]byte{
+		"bob.pub":   []byte("ssh-ed25519 AAAAC3b bob@example.com\n"),
+		"alice.pub": []byte("ssh-ed25519 AAAAC3a alice@example.com"),
+		"carol.pub": []byte("ssh-rsa AAAAB3c carol@example.com\r\n\n"),
+		"empty.pub": []byte("\n"),
+	}
+
+	tests := []struct {
+		name     string
+		selector volume.SecretVolumeSelector
+		file     string
+		want     string
+	}{
+		{
+			name: "all keys ordered by name",
+			file: ConcatFileName,
+			want: "ssh-ed25519 AAAAC3a alice@example.com\n" +
+				"ssh-ed25519 AAAAC3b bob@example.com\n" +
+				"ssh-rsa AAAAB3c carol@example.com\n",
+		},
+		{
+			name:     "listed keys in order",
+			selector: volume.SecretVolumeSelector{ConcatKeys: []string{"carol.pub", "alice.pub"}, ConcatFile: "known_hosts"},
+			file:     "known_hosts",
+			want:     "ssh-rsa AAAAB3c carol@example.com\nssh-ed25519 AAAAC3a alice@example.com\n",
+		},
+		{
+			name:     "separator",
+			selector: volume.SecretVolumeSelector{ConcatKeys: []string{"alice.pub", "bob.pub"}, ConcatSeparator: "\n\n"},
+			file:     ConcatFileName,
+			want:     "ssh-ed25519 AAAAC3a alice@example.com\n\nssh-ed25519 AAAAC3b bob@example.com\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ConvertToConcat(data, &tt.selector)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result) != 1 || string(result[tt.file]) != tt.want {
+				t.Errorf("unexpected result:\n got: %q\nwant: %s=%q", result, tt.file, tt.want)
+			}
+		})
+	}
+
+	if _, err := ConvertToConcat(data, &volume.SecretVolumeSelector{ConcatKeys: []string{"dave.pub"}}); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
@@ -0,0 +1,37 @@
+package format
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// DecodeBase64 returns the data with the values of the keys base64 decoded, the keys keep their name.
+// The leading and trailing white spaces of the values are ignored, e.g. the newline added by base64 tools.
+func DecodeBase64(data map[string][]byte, keys []string) (map[string][]byte, error) {
+	if len(keys) == 0 {
+		return data, nil
+	}
+
+	result := make(map[string][]byte, len(data))
+	for key, value := range data {
+		result[key] = value
+	}
+
+	for _, key := range keys {
+		encoded, ok := data[key]
+		if !ok {
+			return nil, fmt.Errorf("key %q of %s is not in the secret data, available keys: %v", key, volume.DecodeKeys, sortedKeys(data))
+		}
+		encoded = bytes.TrimSpace(encoded)
+		value := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+		n, err := base64.StdEncoding.Decode(value, encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %q as base64: %w", key, err)
+		}
+		result[key] = value[:n]
+	}
+	return result, nil
+}
@@ -0,0 +1,64 @@
+package format
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeBase64(t *testing.T) {
+	data := map[string][]byte{
+		"keystore": []byte("AAEC/w==\n"),
+		"password": []byte("c2VjcmV0"),
+		"username": []byte("admin"),
+	}
+
+	result, err := DecodeBase64(data, []string{"keystore", "password"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(result["keystore"]) != "\x00\x01\x02\xff" {
+		t.Errorf("unexpected keystore: got %q", result["keystore"])
+	}
+	if string(result["password"]) != "secret" {
+		t.Errorf("unexpected password: got %q", result["password"])
+	}
+	if string(result["username"]) != "admin" {
+		t.Errorf("unexpected username: got %q", result["username"])
+	}
+	// the input is not changed
+	if string(data["password"]) != "c2VjcmV0" {
+		t.Error("input data was modified")
+	}
+
+	if result, _ := DecodeBase64(data, nil); string(result["password"]) != "c2VjcmV0" {
+		t.Error("expected the data unchanged without decode keys")
+	}
+}
+
+func TestDecodeBase64Invalid(t *testing.T) {
+	data := map[string][]byte{
+		"password": []byte("not base64!"),
+		"username": []byte("admin"),
+	}
+
+	tests := []struct {
+		name string
+		keys []string
+		want string
+	}{
+		{name: "invalid base64", keys: []string{"password"}, want: `key "password"`},
+		{name: "missing key", keys: []string{"token"}, want: `key "token"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeBase64(data, tt.keys)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("unexpected error: got %v, want it to name %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package format
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	EnvFileName  = "secrets.env"
+	JSONFileName = "secrets.json"
+)
+
+// ErrNotText means a value converted to a text format is not valid UTF-8, e.g. a keytab or a DER certificate.
+var ErrNotText = errors.New("value is not UTF-8 text")
+
+// ConvertToEnv serializes all the data to secrets.env, one KEY="VALUE" line per key, ordered by key.
+// Characters not allowed in an environment variable name are replaced by "_", e.g. "tls.crt" is "tls_crt".
+// Values are double quoted, and backslashes, quotes, dollar signs and newlines are escaped,
+// so multi-line values like PEM certificates can be loaded by dotenv parsers and shells.
+func ConvertToEnv(data map[string][]byte) (map[string][]byte, error) {
+	names := make(map[string]string, len(data))
+	for _, key := range sortedKeys(data) {
+		name := envName(key)
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("keys %q and %q are both converted to environment variable %q", other, key, name)
+		}
+		names[name] = key
+	}
+
+	var b strings.Builder
+	for _, name := range sortedKeys(names) {
+		b.WriteString(name)
+		b.WriteString("=\"")
+		b.WriteString(envEscaper.Replace(string(data[names[name]])))
+		b.WriteString("\"\n")
+	}
+	return map[string][]byte{EnvFileName: []byte(b.String())}, nil
+}
+
+// ConvertToJSON serializes all the data to secrets.json as a JSON object of strings, ordered by key.
+// The values must be text, a binary value fails with ErrNotText instead of being corrupted by encoding/json,
+// which replaces invalid UTF-8.
+func ConvertToJSON(data map[string][]byte) (map[string][]byte, error) {
+	values := make(map[string]string, len(data))
+	for _, key := range sortedKeys(data) {
+		if !utf8.Valid(data[key]) {
+			return nil, fmt.Errorf("%w: key %q of the json format", ErrNotText, key)
+		}
+		values[key] = string(data[key])
+	}
+	// encoding/json sorts the map keys, so the output is deterministic
+	content, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{JSONFileName: append(content, '\n')}, nil
+}
+
+var envEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	`$`, `\$`,
+	"\n", `\n`,
+	"\r", `\r`,
+)
+
+// envName converts the key to a valid environment variable name.
+func envName(key string) string {
+	name := []byte(key)
+	for i, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' && i > 0) {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
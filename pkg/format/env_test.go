@@ -0,0 +1,78 @@
+package format
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestConvertToEnv(t *testing.T) {
+	data := map[string][]byte{
+		"username": []byte("admin"),
+		"tls.crt":  []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"),
+		"password": []byte(`p"a$s\s`),
+	}
+
+	result, err := ConvertToEnv(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `password="p\"a\$s\\s"` + "\n" +
+		`tls_crt="-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"` + "\n" +
+		`username="admin"` + "\n"
+	if string(result[EnvFileName]) != expected {
+		t.Errorf("unexpected env file:\n got: %s\nwant: %s", result[EnvFileName], expected)
+	}
+
+	// the output is deterministic
+	for i := 0; i < 10; i++ {
+		again, _ := ConvertToEnv(data)
+		if string(again[EnvFileName]) != expected {
+			t.Fatalf("env file is not deterministic")
+		}
+	}
+
+	if _, err := ConvertToEnv(map[string][]byte{"tls.crt": []byte("a"), "tls-crt": []byte("b")}); err == nil {
+		t.Errorf("expected error when keys are converted to the same name")
+	}
+}
+
+func TestConvertToJSONBinary(t *testing.T) {
+	data := map[string][]byte{
+		"username": []byte("admin"),
+		// a keytab starts with the version bytes 0x05 0x02
+		"keytab": {0x05, 0x02, 0x00, 0x00, 0x00, 0x3c, 0xff, 0xfe},
+	}
+
+	if _, err := ConvertToJSON(data); !errors.Is(err, ErrNotText) {
+		t.Errorf("unexpected error: got %v, want %v", err, ErrNotText)
+	}
+}
+
+func TestConvertToJSON(t *testing.T) {
+	data := map[string][]byte{
+		"username": []byte("admin"),
+		"tls.crt":  []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"),
+	}
+
+	result, err := ConvertToJSON(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "{\n  \"tls.crt\": \"-----BEGIN CERTIFICATE-----\\nMIIB\\n-----END CERTIFICATE-----\\n\",\n  \"username\": \"admin\"\n}\n"
+	if string(result[JSONFileName]) != expected {
+		t.Errorf("unexpected json file:\n got: %s\nwant: %s", result[JSONFileName], expected)
+	}
+
+	decoded := map[string]string{}
+	if err := json.Unmarshal(result[JSONFileName], &decoded); err != nil {
+		t.Fatalf("failed to decode json file: %v", err)
+	}
+	for key, value := range data {
+		if decoded[key] != string(value) {
+			t.Errorf("unexpected value of %s: got %q, want %q", key, decoded[key], value)
+		}
+	}
+}
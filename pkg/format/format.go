@@ -0,0 +1,170 @@
+package format
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+	"k8s.io/utils/clock"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var (
+	logger = ctrl.Log.WithName("format")
+)
+
+const (
+	PEMTlsCertFileName = "tls.crt"
+	PEMTlsKeyFileName  = "tls.key"
+	PEMCaCertFileName  = "ca.crt"
+)
+
+// Convert converts the secret data returned by backend to the formats required by the volume.
+// The files of every format are written, they are produced from the same secret data,
+// so a file written by two formats must have the same content.
+// The fifo format converts nothing, the csi driver writes its keys as named pipes.
+// The clock and rand are used by the keystore formats, for the creation time of the entries and the salts.
+func Convert(data map[string][]byte, selector *volume.SecretVolumeSelector, clock clock.PassiveClock, rand io.Reader) (map[string][]byte, error) {
+	var formats []volume.SecretFormat
+	for _, format := range selector.Formats() {
+		if format != volume.SecretFormatFIFO {
+			formats = append(formats, format)
+		}
+	}
+	if len(formats) == 0 {
+		return convert(data, "", selector, clock, rand)
+	}
+
+	result := map[string][]byte{}
+	for _, format := range formats {
+		converted, err := convert(data, format, selector, clock, rand)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert to format %s: %w", format, err)
+		}
+		for name, content := range converted {
+			if existing, ok := result[name]; ok && !bytes.Equal(existing, content) {
+				return nil, fmt.Errorf("file %q is written by more than one of the formats %v", name, formats)
+			}
+			result[name] = content
+		}
+	}
+	return result, nil
+}
+
+// convert converts the secret data to a single format.
+// The env and json formats serialize any data to a single file.
+// Backends return tls material in PEM format, so only the PEM data needs to be converted to the tls formats.
+// If the data does not contain PEM tls material, it is returned as is.
+func convert(data map[string][]byte, format volume.SecretFormat, selector *volume.SecretVolumeSelector,
+	clock clock.PassiveClock, rand io.Reader) (map[string][]byte, error) {
+	switch format {
+	case volume.SecretFormatEnv:
+		return ConvertToEnv(data)
+	case volume.SecretFormatJSON:
+		return ConvertToJSON(data)
+	case volume.SecretFormatConcat:
+		return ConvertToConcat(data, selector)
+	}
+
+	if !hasPEMData(data) {
+		return data, nil
+	}
+
+	switch format {
+	case volume.SecretFormatTLSP12, volume.SecretFormatTLSPKCS12:
+		logger.V(1).Info("convert PEM data to PKCS12 format", "format", format)
+		return ConvertToPKCS12(data, storePassword(selector), rand)
+	case volume.SecretFormatTLSJKS:
+		logger.V(1).Info("convert PEM data to JKS format", "format", format)
+		return ConvertToJKS(data, storePassword(selector), clock, rand)
+	case volume.SecretFormatTLSPEM, "":
+		return ConvertToPEM(data, selector.TLSPEMFiles)
+	default:
+		return data, nil
+	}
+}
+
+func hasPEMData(data map[string][]byte) bool {
+	_, hasCert := data[PEMTlsCertFileName]
+	_, hasKey := data[PEMTlsKeyFileName]
+	return hasCert && hasKey
+}
+
+// pemData is the parsed tls material in PEM format.
+type pemData struct {
+	privateKey   crypto.PrivateKey
+	certificate  *x509.Certificate
+	intermediate []*x509.Certificate
+	caCerts      []*x509.Certificate
+}
+
+func parsePEMData(data map[string][]byte) (*pemData, error) {
+	certs, err := parseCertificates(data[PEMTlsCertFileName])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", PEMTlsCertFileName, err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in %s", PEMTlsCertFileName)
+	}
+
+	privateKey, err := parsePrivateKey(data[PEMTlsKeyFileName])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", PEMTlsKeyFileName, err)
+	}
+
+	caCerts, err := parseCertificates(data[PEMCaCertFileName])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", PEMCaCertFileName, err)
+	}
+
+	return &pemData{
+		privateKey:   privateKey,
+		certificate:  certs[0],
+		intermediate: certs[1:],
+		caCerts:      caCerts,
+	}, nil
+}
+
+// parseCertificates parses all certificates in the PEM data, ca.crt may contain multiple certificates.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func parsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %s", block.Type)
+	}
+}
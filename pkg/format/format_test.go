@@ -0,0 +1,289 @@
+package format
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	mathrand "math/rand"
+	"testing"
+	"time"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+func newTestCertificate(t *testing.T, cn string, isCA bool, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func certificatePEM(certs ...*x509.Certificate) string {
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return string(data)
+}
+
+func newTestPEMData(t *testing.T) (map[string][]byte, *x509.Certificate, []*x509.Certificate) {
+	caCert, caKey := newTestCertificate(t, "ca", true, nil, nil)
+	otherCACert, _ := newTestCertificate(t, "other-ca", true, nil, nil)
+	cert, key := newTestCertificate(t, "server", false, caCert, caKey)
+
+	data := map[string][]byte{
+		PEMTlsCertFileName: []byte(certificatePEM(cert)),
+		PEMTlsKeyFileName: pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}),
+		PEMCaCertFileName: []byte(certificatePEM(caCert, otherCACert)),
+	}
+	return data, cert, []*x509.Certificate{caCert, otherCACert}
+}
+
+func TestConvertToPKCS12(t *testing.T) {
+	data, cert, caCerts := newTestPEMData(t)
+
+	result, err := ConvertToPKCS12(data, "secret", rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, keystoreCert, chain, err := pkcs12.DecodeChain(result[KeystoreP12FileName], "secret")
+	if err != nil {
+		t.Fatalf("failed to decode keystore: %v", err)
+	}
+	if !keystoreCert.Equal(cert) {
+		t.Errorf("unexpected certificate in keystore: got %s, want %s", keystoreCert.Subject, cert.Subject)
+	}
+	if len(chain) != len(caCerts) {
+		t.Errorf("unexpected chain length in keystore: got %d, want %d", len(chain), len(caCerts))
+	}
+
+	trusted, err := pkcs12.DecodeTrustStore(result[TruststoreP12FileName], "secret")
+	if err != nil {
+		t.Fatalf("failed to decode truststore: %v", err)
+	}
+	if len(trusted) != len(caCerts) {
+		t.Fatalf("unexpected truststore length: got %d, want %d", len(trusted), len(caCerts))
+	}
+	for i := range caCerts {
+		if !trusted[i].Equal(caCerts[i]) {
+			t.Errorf("unexpected certificate in truststore: got %s, want %s", trusted[i].Subject, caCerts[i].Subject)
+		}
+	}
+
+	if _, _, _, err := pkcs12.DecodeChain(result[KeystoreP12FileName], "wrong"); err == nil {
+		t.Errorf("expected error when decoding keystore with wrong password")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	data, _, _ := newTestPEMData(t)
+
+	tests := []struct {
+		name     string
+		data     map[string][]byte
+		selector *volume.SecretVolumeSelector
+		password string
+		files    []string
+	}{
+		{
+			name:     "pem",
+			data:     data,
+			selector: &volume.SecretVolumeSelector{Format: volume.SecretFormatTLSPEM},
+			files:    []string{PEMTlsCertFileName, PEMTlsKeyFileName, PEMCaCertFileName},
+		},
+		{
+			name: "pem with selected files",
+			data: data,
+			selector: &volume.SecretVolumeSelector{
+				Format:      volume.SecretFormatTLSPEM,
+				TLSPEMFiles: []string{PEMFullChainFileName, PEMPrivKeyFileName},
+			},
+			files: []string{PEMFullChainFileName, PEMPrivKeyFileName},
+		},
+		{
+			name:     "p12 with default password",
+			data:     data,
+			selector: &volume.SecretVolumeSelector{Format: volume.SecretFormatTLSP12},
+			password: DefaultPKCS12Password,
+			files:    []string{KeystoreP12FileName, TruststoreP12FileName},
+		},
+		{
+			name: "pkcs12 with password",
+			data: data,
+			selector: &volume.SecretVolumeSelector{
+				Format:            volume.SecretFormatTLSPKCS12,
+				TlsPKCS12Password: "secret",
+			},
+			password: "secret",
+			files:    []string{KeystoreP12FileName, TruststoreP12FileName},
+		},
+		{
+			name:     "jks",
+			data:     data,
+			selector: &volume.SecretVolumeSelector{Format: volume.SecretFormatTLSJKS},
+			files:    []string{KeystoreJKSFileName, TruststoreJKSFileName},
+		},
+		{
+			name:     "env",
+			data:     data,
+			selector: &volume.SecretVolumeSelector{Format: volume.SecretFormatEnv},
+			files:    []string{EnvFileName},
+		},
+		{
+			name:     "json",
+			data:     map[string][]byte{"username": []byte("admin")},
+			selector: &volume.SecretVolumeSelector{Format: volume.SecretFormatJSON},
+			files:    []string{JSONFileName},
+		},
+		{
+			name:     "pem and pkcs12",
+			data:     data,
+			selector: &volume.SecretVolumeSelector{Format: "tls-pem,tls-pkcs12"},
+			password: DefaultPKCS12Password,
+			files:    []string{PEMTlsCertFileName, PEMTlsKeyFileName, PEMCaCertFileName, KeystoreP12FileName, TruststoreP12FileName},
+		},
+		{
+			name:     "pem, jks and env",
+			data:     data,
+			selector: &volume.SecretVolumeSelector{Format: "tls-pem, tls-jks, env"},
+			files:    []string{PEMTlsCertFileName, PEMTlsKeyFileName, PEMCaCertFileName, KeystoreJKSFileName, TruststoreJKSFileName, EnvFileName},
+		},
+		{
+			name:     "non tls data with multiple formats",
+			data:     map[string][]byte{"username": []byte("admin")},
+			selector: &volume.SecretVolumeSelector{Format: "tls-pem,tls-p12,json"},
+			files:    []string{"username", JSONFileName},
+		},
+		{
+			name:     "fifo",
+			data:     map[string][]byte{"token": []byte("secret")},
+			selector: &volume.SecretVolumeSelector{Format: volume.SecretFormatFIFO, FIFOKeys: []string{"token"}},
+			files:    []string{"token"},
+		},
+		{
+			name:     "pem and fifo",
+			data:     data,
+			selector: &volume.SecretVolumeSelector{Format: "tls-pem,fifo", FIFOKeys: []string{PEMTlsKeyFileName}},
+			files:    []string{PEMTlsCertFileName, PEMTlsKeyFileName, PEMCaCertFileName},
+		},
+		{
+			name:     "non tls data",
+			data:     map[string][]byte{"username": []byte("admin")},
+			selector: &volume.SecretVolumeSelector{Format: volume.SecretFormatTLSPKCS12},
+			files:    []string{"username"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Convert(tt.data, tt.selector, clock.RealClock{}, rand.Reader)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result) != len(tt.files) {
+				t.Errorf("unexpected files: got %d files, want %v", len(result), tt.files)
+			}
+			for _, name := range tt.files {
+				if _, ok := result[name]; !ok {
+					t.Errorf("missing %s in result", name)
+				}
+			}
+			if tt.password != "" {
+				if _, _, _, err := pkcs12.DecodeChain(result[KeystoreP12FileName], tt.password); err != nil {
+					t.Errorf("failed to decode keystore with password %q: %v", tt.password, err)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertKeystoresDeterministic(t *testing.T) {
+	data, _, _ := newTestPEMData(t)
+	selector := &volume.SecretVolumeSelector{Format: "tls-pkcs12,tls-jks"}
+	fakeClock := clocktesting.NewFakePassiveClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	first, err := Convert(data, selector, fakeClock, mathrand.New(mathrand.NewSource(1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := Convert(data, selector, fakeClock, mathrand.New(mathrand.NewSource(1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{KeystoreP12FileName, TruststoreP12FileName, KeystoreJKSFileName, TruststoreJKSFileName} {
+		if !bytes.Equal(first[name], second[name]) {
+			t.Errorf("expected %s to be the same with the same clock and rand", name)
+		}
+	}
+}
+
+func TestConvertToPEMFullChain(t *testing.T) {
+	data, cert, caCerts := newTestPEMData(t)
+
+	result, err := ConvertToPEM(data, []string{PEMTlsCertFileName, PEMFullChainFileName, PEMPrivKeyFileName})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	chain, err := parseCertificates(result[PEMFullChainFileName])
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", PEMFullChainFileName, err)
+	}
+	expected := append([]*x509.Certificate{cert}, caCerts...)
+	if len(chain) != len(expected) {
+		t.Fatalf("unexpected chain length: got %d, want %d", len(chain), len(expected))
+	}
+	for i := range expected {
+		if !chain[i].Equal(expected[i]) {
+			t.Errorf("unexpected certificate at %d: got %s, want %s", i, chain[i].Subject, expected[i].Subject)
+		}
+	}
+
+	if !bytes.Equal(result[PEMPrivKeyFileName], data[PEMTlsKeyFileName]) {
+		t.Errorf("%s is not the same as %s", PEMPrivKeyFileName, PEMTlsKeyFileName)
+	}
+	if _, ok := result[PEMCaCertFileName]; ok {
+		t.Errorf("unexpected %s in result, it is not selected", PEMCaCertFileName)
+	}
+
+	if _, err := ConvertToPEM(data, []string{"unknown.pem"}); err == nil {
+		t.Errorf("expected error for unsupported file")
+	}
+}
@@ -0,0 +1,74 @@
+package format
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// ErrDecompressedTooLarge is returned when the decompressed secret data exceeds the max size,
+// e.g. a decompression bomb.
+var ErrDecompressedTooLarge = errors.New("decompressed secret data is too large")
+
+// Gunzip returns the data with the values of the keys decompressed, the keys keep their name.
+// The total size of the data after the decompression is limited to maxSize bytes, 0 disables the limit,
+// a value is never decompressed beyond the limit.
+func Gunzip(data map[string][]byte, keys []string, maxSize int64) (map[string][]byte, error) {
+	if len(keys) == 0 {
+		return data, nil
+	}
+
+	result := make(map[string][]byte, len(data))
+	var size int64
+	for key, value := range data {
+		result[key] = value
+		size += int64(len(value))
+	}
+
+	for _, key := range keys {
+		compressed, ok := data[key]
+		if !ok {
+			return nil, fmt.Errorf("key %q of %s is not in the secret data, available keys: %v", key, volume.GzipKeys, sortedKeys(data))
+		}
+		size -= int64(len(compressed))
+
+		var limit int64 = -1
+		if maxSize > 0 {
+			limit = maxSize - size
+		}
+		value, err := gunzip(compressed, limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress key %q: %w", key, err)
+		}
+		result[key] = value
+		size += int64(len(value))
+	}
+	return result, nil
+}
+
+// gunzip decompresses the value, reading at most limit bytes, a negative limit is no limit.
+func gunzip(compressed []byte, limit int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var reader io.Reader = r
+	if limit >= 0 {
+		// read one more byte to tell a value of exactly the limit from a larger one
+		reader = io.LimitReader(r, limit+1)
+	}
+	value, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if limit >= 0 && int64(len(value)) > limit {
+		return nil, ErrDecompressedTooLarge
+	}
+	return value, nil
+}
@@ -0,0 +1,89 @@
+package format
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+)
+
+func gzipValue(t *testing.T, value []byte) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(value); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestGunzip(t *testing.T) {
+	config := bytes.Repeat([]byte(`{"key":"value"}`), 100)
+	data := map[string][]byte{
+		"config.json": gzipValue(t, config),
+		"username":    []byte("admin"),
+	}
+
+	result, err := Gunzip(data, []string{"config.json"}, 8*1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(result["config.json"], config) {
+		t.Errorf("unexpected config.json: got %q", result["config.json"])
+	}
+	if string(result["username"]) != "admin" {
+		t.Errorf("unexpected username: got %q", result["username"])
+	}
+	// the input is not changed
+	if bytes.Equal(data["config.json"], config) {
+		t.Error("input data was modified")
+	}
+
+	if result, _ := Gunzip(data, nil, 0); !bytes.Equal(result["config.json"], data["config.json"]) {
+		t.Error("expected the data unchanged without gzip keys")
+	}
+
+	// no limit
+	if result, err := Gunzip(data, []string{"config.json"}, 0); err != nil || !bytes.Equal(result["config.json"], config) {
+		t.Errorf("unexpected result without limit: %v", err)
+	}
+}
+
+func TestGunzipMaxSize(t *testing.T) {
+	// a bomb: 16MiB of zeros compressed to a few KiB
+	bomb := gzipValue(t, make([]byte, 16*1024*1024))
+	data := map[string][]byte{
+		"bomb":     bomb,
+		"username": []byte("admin"),
+	}
+
+	if _, err := Gunzip(data, []string{"bomb"}, 1024*1024); !errors.Is(err, ErrDecompressedTooLarge) {
+		t.Errorf("unexpected error: got %v, want %v", err, ErrDecompressedTooLarge)
+	}
+
+	// the other keys count in the total size
+	value := gzipValue(t, make([]byte, 100))
+	data = map[string][]byte{
+		"value":    value,
+		"username": []byte("admin"),
+	}
+	if _, err := Gunzip(data, []string{"value"}, 105); err != nil {
+		t.Errorf("unexpected error at the max size: %v", err)
+	}
+	if _, err := Gunzip(data, []string{"value"}, 104); !errors.Is(err, ErrDecompressedTooLarge) {
+		t.Errorf("unexpected error above the max size: got %v, want %v", err, ErrDecompressedTooLarge)
+	}
+}
+
+func TestGunzipInvalid(t *testing.T) {
+	data := map[string][]byte{"config.json": []byte("not gzip")}
+
+	if _, err := Gunzip(data, []string{"config.json"}, 0); err == nil {
+		t.Error("expected error for a value which is not gzip compressed")
+	}
+	if _, err := Gunzip(data, []string{"missing"}, 0); err == nil {
+		t.Error("expected error for a missing key")
+	}
+}
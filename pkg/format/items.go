@@ -0,0 +1,58 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// SelectItems returns only the keys listed in the items, renamed to their paths.
+// All the data is returned when no item is listed. A listed key missing in the data is an error,
+// so a typo does not silently mount an empty volume.
+func SelectItems(data map[string][]byte, items []volume.SecretItem) (map[string][]byte, error) {
+	if len(items) == 0 {
+		return data, nil
+	}
+
+	selected := make(map[string][]byte, len(items))
+	for _, item := range items {
+		value, ok := data[item.Key]
+		if !ok {
+			return nil, fmt.Errorf("key %q of %s is not in the secret data, available keys: %v", item.Key, volume.Items, sortedKeys(data))
+		}
+		selected[item.Path] = value
+	}
+	return selected, nil
+}
+
+// NormalizeKeys converts the keys of the data to the case of the volume, and adds its prefix and suffix.
+// It is applied after SelectItems, so the items are selected by the original keys. Two keys normalized to
+// the same file name is an error, e.g. "tls.crt" and "TLS.crt" in lower case.
+func NormalizeKeys(data map[string][]byte, volumeSelector *volume.SecretVolumeSelector) (map[string][]byte, error) {
+	if volumeSelector.KeyCase == "" && volumeSelector.KeyPrefix == "" && volumeSelector.KeySuffix == "" {
+		return data, nil
+	}
+
+	normalized := make(map[string][]byte, len(data))
+	sources := make(map[string]string, len(data))
+	for _, key := range sortedKeys(data) {
+		name := key
+		switch volumeSelector.KeyCase {
+		case volume.KeyCaseLower:
+			name = strings.ToLower(name)
+		case volume.KeyCaseUpper:
+			name = strings.ToUpper(name)
+		}
+		name = volumeSelector.KeyPrefix + name + volumeSelector.KeySuffix
+		if strings.HasPrefix(name, "..") {
+			return nil, fmt.Errorf("key %q is normalized to %q, the file names starting with \"..\" are reserved", key, name)
+		}
+		if source, ok := sources[name]; ok {
+			return nil, fmt.Errorf("keys %q and %q are both normalized to %q", source, key, name)
+		}
+		sources[name] = key
+		normalized[name] = data[key]
+	}
+	return normalized, nil
+}
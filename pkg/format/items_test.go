@@ -0,0 +1,123 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func TestSelectItems(t *testing.T) {
+	data := map[string][]byte{
+		"tls.crt":  []byte("cert"),
+		"tls.key":  []byte("key"),
+		"ca.crt":   []byte("ca"),
+		"username": []byte("admin"),
+	}
+
+	result, err := SelectItems(data, []volume.SecretItem{
+		{Key: "tls.crt", Path: "cert.pem"},
+		{Key: "tls.key", Path: "key.pem"},
+		{Key: "ca.crt", Path: "ca.crt"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{"cert.pem": "cert", "key.pem": "key", "ca.crt": "ca"}
+	if len(result) != len(expected) {
+		t.Errorf("unexpected files: got %v, want %v", result, expected)
+	}
+	for name, value := range expected {
+		if string(result[name]) != value {
+			t.Errorf("unexpected %s: got %q, want %q", name, result[name], value)
+		}
+	}
+
+	if result, _ := SelectItems(data, nil); len(result) != len(data) {
+		t.Errorf("expected all the data without items, got %v", result)
+	}
+
+	_, err = SelectItems(data, []volume.SecretItem{{Key: "password", Path: "password"}})
+	if err == nil || !strings.Contains(err.Error(), `"password"`) {
+		t.Errorf("expected error naming the missing key, got: %v", err)
+	}
+}
+
+func TestNormalizeKeys(t *testing.T) {
+	data := map[string][]byte{
+		"tls.crt":  []byte("cert"),
+		"tls.key":  []byte("key"),
+		"Username": []byte("admin"),
+	}
+
+	tests := []struct {
+		name           string
+		items          []volume.SecretItem
+		volumeSelector volume.SecretVolumeSelector
+		expected       map[string]string
+	}{
+		{
+			name:     "none",
+			expected: map[string]string{"tls.crt": "cert", "tls.key": "key", "Username": "admin"},
+		},
+		{
+			name:           "lower",
+			volumeSelector: volume.SecretVolumeSelector{KeyCase: volume.KeyCaseLower},
+			expected:       map[string]string{"tls.crt": "cert", "tls.key": "key", "username": "admin"},
+		},
+		{
+			name:           "upper with prefix",
+			volumeSelector: volume.SecretVolumeSelector{KeyCase: volume.KeyCaseUpper, KeyPrefix: "APP_"},
+			expected:       map[string]string{"APP_TLS.CRT": "cert", "APP_TLS.KEY": "key", "APP_USERNAME": "admin"},
+		},
+		{
+			name:           "items renamed then normalized",
+			items:          []volume.SecretItem{{Key: "tls.crt", Path: "Server"}, {Key: "tls.key", Path: "server-key"}},
+			volumeSelector: volume.SecretVolumeSelector{KeyCase: volume.KeyCaseLower, KeySuffix: ".pem"},
+			expected:       map[string]string{"server.pem": "cert", "server-key.pem": "key"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := SelectItems(data, tt.items)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			result, err := NormalizeKeys(selected, &tt.volumeSelector)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result) != len(tt.expected) {
+				t.Errorf("unexpected files: got %v, want %v", result, tt.expected)
+			}
+			for name, value := range tt.expected {
+				if string(result[name]) != value {
+					t.Errorf("unexpected %s: got %q, want %q", name, result[name], value)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeKeysCollision(t *testing.T) {
+	data := map[string][]byte{"tls.crt": []byte("cert"), "TLS.crt": []byte("other")}
+	_, err := NormalizeKeys(data, &volume.SecretVolumeSelector{KeyCase: volume.KeyCaseLower})
+	if err == nil || !strings.Contains(err.Error(), `"tls.crt"`) || !strings.Contains(err.Error(), `"TLS.crt"`) {
+		t.Errorf("expected error naming both keys, got: %v", err)
+	}
+
+	// the items may rename two keys to names which only collide once normalized
+	selected, err := SelectItems(map[string][]byte{"a": []byte("a"), "b": []byte("b")},
+		[]volume.SecretItem{{Key: "a", Path: "Cert"}, {Key: "b", Path: "cert"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := NormalizeKeys(selected, &volume.SecretVolumeSelector{KeyCase: volume.KeyCaseUpper}); err == nil {
+		t.Error("expected error for the renamed keys normalized to the same name")
+	}
+
+	if _, err := NormalizeKeys(data, &volume.SecretVolumeSelector{KeyPrefix: ".."}); err == nil {
+		t.Error("expected error for a reserved file name")
+	}
+}
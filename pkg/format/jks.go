@@ -0,0 +1,67 @@
+package format
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+
+	"github.com/zncdata-labs/secret-operator/internal/jks"
+	"k8s.io/utils/clock"
+)
+
+const (
+	KeystoreJKSFileName   = "keystore.jks"
+	TruststoreJKSFileName = "truststore.jks"
+
+	jksPrivateKeyAlias = "tls"
+)
+
+// ConvertToJKS converts the PEM data to keystore.jks and truststore.jks.
+// The keystore contains the private key, the certificate and its chain,
+// the truststore contains the certificates in ca.crt.
+// The entries are created at the time of the clock, and the private key is protected with a salt read from rand.
+func ConvertToJKS(data map[string][]byte, password string, clock clock.PassiveClock, rand io.Reader) (map[string][]byte, error) {
+	parsed, err := parsePEMData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	now := clock.Now()
+
+	chain := append(parsed.intermediate, parsed.caCerts...)
+	keystore := &jks.KeyStore{
+		PrivateKeys: []jks.PrivateKeyEntry{
+			{
+				Alias:            jksPrivateKeyAlias,
+				CreationTime:     now,
+				PrivateKey:       parsed.privateKey,
+				CertificateChain: append([]*x509.Certificate{parsed.certificate}, chain...),
+			},
+		},
+		Clock: clock,
+		Rand:  rand,
+	}
+
+	truststore := &jks.KeyStore{Clock: clock, Rand: rand}
+	for i, cert := range parsed.caCerts {
+		truststore.TrustedCertificates = append(truststore.TrustedCertificates, jks.TrustedCertificateEntry{
+			Alias:        fmt.Sprintf("ca-%d", i),
+			CreationTime: now,
+			Certificate:  cert,
+		})
+	}
+
+	keystoreData, err := keystore.Encode(password)
+	if err != nil {
+		return nil, err
+	}
+	truststoreData, err := truststore.Encode(password)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		KeystoreJKSFileName:   keystoreData,
+		TruststoreJKSFileName: truststoreData,
+	}, nil
+}
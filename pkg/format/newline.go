@@ -0,0 +1,29 @@
+package format
+
+import (
+	"bytes"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+// TrailingNewline returns the data with the trailing newline of each value stripped or ensured by the mode.
+// The data is returned unchanged for the preserve mode, the default.
+func TrailingNewline(data map[string][]byte, mode volume.TrailingNewlineMode) map[string][]byte {
+	if mode != volume.TrailingNewlineStrip && mode != volume.TrailingNewlineEnsure {
+		return data
+	}
+
+	result := make(map[string][]byte, len(data))
+	for key, value := range data {
+		switch mode {
+		case volume.TrailingNewlineStrip:
+			value = bytes.TrimRight(value, "\r\n")
+		case volume.TrailingNewlineEnsure:
+			if len(value) > 0 && value[len(value)-1] != '\n' {
+				value = append(bytes.Clone(value), '\n')
+			}
+		}
+		result[key] = value
+	}
+	return result
+}
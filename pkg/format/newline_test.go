@@ -0,0 +1,61 @@
+package format
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func TestTrailingNewline(t *testing.T) {
+	data := map[string][]byte{
+		"password": []byte("secret"),
+		"username": []byte("admin\n"),
+		"token":    []byte("abc\r\n\n"),
+		"empty":    {},
+	}
+
+	tests := []struct {
+		name string
+		mode volume.TrailingNewlineMode
+		want map[string]string
+	}{
+		{
+			name: "strip",
+			mode: volume.TrailingNewlineStrip,
+			want: map[string]string{"password": "secret", "username": "admin", "token": "abc", "empty": ""},
+		},
+		{
+			name: "ensure",
+			mode: volume.TrailingNewlineEnsure,
+			want: map[string]string{"password": "secret\n", "username": "admin\n", "token": "abc\r\n\n", "empty": ""},
+		},
+		{
+			name: "preserve",
+			mode: volume.TrailingNewlinePreserve,
+			want: map[string]string{"password": "secret", "username": "admin\n", "token": "abc\r\n\n", "empty": ""},
+		},
+		{
+			name: "default",
+			want: map[string]string{"password": "secret", "username": "admin\n", "token": "abc\r\n\n", "empty": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := TrailingNewline(data, tt.mode)
+			got := make(map[string]string, len(result))
+			for key, value := range result {
+				got[key] = string(value)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected result: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// the input is not changed
+	if string(data["password"]) != "secret" || string(data["username"]) != "admin\n" {
+		t.Error("input data was modified")
+	}
+}
@@ -0,0 +1,51 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	// PEMFullChainFileName is the certificate followed by the CA certificates, required by some proxies.
+	PEMFullChainFileName = "fullchain.pem"
+	// PEMPrivKeyFileName is the private key, the same as tls.key.
+	PEMPrivKeyFileName = "privkey.pem"
+)
+
+// ConvertToPEM selects the PEM files written to the volume.
+// When no file is selected, the data is returned as is, that is tls.crt, tls.key and ca.crt.
+func ConvertToPEM(data map[string][]byte, files []string) (map[string][]byte, error) {
+	if len(files) == 0 {
+		return data, nil
+	}
+
+	result := make(map[string][]byte, len(files))
+	for _, file := range files {
+		switch file {
+		case PEMTlsCertFileName, PEMTlsKeyFileName, PEMCaCertFileName:
+			result[file] = data[file]
+		case PEMFullChainFileName:
+			result[file] = joinPEM(data[PEMTlsCertFileName], data[PEMCaCertFileName])
+		case PEMPrivKeyFileName:
+			result[file] = data[PEMTlsKeyFileName]
+		default:
+			return nil, fmt.Errorf("unsupported PEM file %q", file)
+		}
+	}
+	return result, nil
+}
+
+// joinPEM concatenates the PEM data, making sure every block starts on a new line.
+func joinPEM(data ...[]byte) []byte {
+	var b bytes.Buffer
+	for _, d := range data {
+		if len(d) == 0 {
+			continue
+		}
+		b.Write(d)
+		if !bytes.HasSuffix(d, []byte("\n")) {
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,52 @@
+package format
+
+import (
+	"io"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	KeystoreP12FileName   = "keystore.p12"
+	TruststoreP12FileName = "truststore.p12"
+
+	// DefaultPKCS12Password is the default password of the keystore and truststore,
+	// it is the same as the default password of the JDK keystore.
+	DefaultPKCS12Password = "changeit"
+)
+
+// storePassword returns the password of keystore and truststore, it is shared by PKCS12 and JKS format.
+func storePassword(selector *volume.SecretVolumeSelector) string {
+	if selector == nil || selector.TlsPKCS12Password == "" {
+		return DefaultPKCS12Password
+	}
+	return selector.TlsPKCS12Password
+}
+
+// ConvertToPKCS12 converts the PEM data to keystore.p12 and truststore.p12.
+// The keystore contains the private key, the certificate and its chain,
+// the truststore contains the certificates in ca.crt. The salts are read from rand.
+func ConvertToPKCS12(data map[string][]byte, password string, rand io.Reader) (map[string][]byte, error) {
+	parsed, err := parsePEMData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := append(parsed.intermediate, parsed.caCerts...)
+	encoder := pkcs12.Modern.WithRand(rand)
+	keystore, err := encoder.Encode(parsed.privateKey, parsed.certificate, chain, password)
+	if err != nil {
+		return nil, err
+	}
+
+	truststore, err := encoder.EncodeTrustStore(parsed.caCerts, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		KeystoreP12FileName:   keystore,
+		TruststoreP12FileName: truststore,
+	}, nil
+}
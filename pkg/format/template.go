@@ -0,0 +1,42 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// RenderTemplates renders the templates keyed by their file name with the secret data, and returns the data
+// with the rendered files added. The context of the templates is the data with the values as strings,
+// e.g. {{ .username }}, or {{ index . "tls.crt" }} for the keys which are not identifiers.
+// A key missing in the data fails the rendering, like a file overwriting a key of the data.
+func RenderTemplates(data map[string][]byte, templates map[string]string) (map[string][]byte, error) {
+	if len(templates) == 0 {
+		return data, nil
+	}
+
+	values := make(map[string]string, len(data))
+	for key, value := range data {
+		values[key] = string(value)
+	}
+
+	rendered := make(map[string][]byte, len(data)+len(templates))
+	for key, value := range data {
+		rendered[key] = value
+	}
+	for _, name := range sortedKeys(templates) {
+		if _, ok := data[name]; ok {
+			return nil, fmt.Errorf("template file %q overwrites the key of the secret data", name)
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(templates[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid template of file %q: %w", name, err)
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, values); err != nil {
+			return nil, fmt.Errorf("failed to render template of file %q: %w", name, err)
+		}
+		rendered[name] = b.Bytes()
+	}
+	return rendered, nil
+}
@@ -0,0 +1,55 @@
+package format
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	data := map[string][]byte{
+		"username": []byte("admin"),
+		"password": []byte("s3cret"),
+		"tls.crt":  []byte("cert"),
+	}
+
+	result, err := RenderTemplates(data, map[string]string{
+		"dsn":      "postgres://{{ .username }}:{{ .password }}@db:5432/app",
+		"cert.txt": `{{ index . "tls.crt" }}`,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := string(result["dsn"]); got != "postgres://admin:s3cret@db:5432/app" {
+		t.Errorf("unexpected dsn: got %q", got)
+	}
+	if got := string(result["cert.txt"]); got != "cert" {
+		t.Errorf("unexpected cert.txt: got %q", got)
+	}
+	// the secret data is kept
+	if len(result) != len(data)+2 || string(result["password"]) != "s3cret" {
+		t.Errorf("unexpected files: got %v", result)
+	}
+}
+
+func TestRenderTemplatesInvalid(t *testing.T) {
+	data := map[string][]byte{"username": []byte("admin")}
+
+	tests := []struct {
+		name      string
+		templates map[string]string
+		want      string
+	}{
+		{name: "missing key", templates: map[string]string{"dsn": "{{ .username }}:{{ .password }}"}, want: `"password"`},
+		{name: "syntax", templates: map[string]string{"dsn": "{{ .username "}, want: "invalid template"},
+		{name: "overwrite key", templates: map[string]string{"username": "{{ .username }}"}, want: "overwrites"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RenderTemplates(data, tt.templates)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("unexpected error: got %v, want %q in it", err, tt.want)
+			}
+		})
+	}
+}
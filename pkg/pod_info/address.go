@@ -1,8 +1,87 @@
 package pod_info
 
-import "net"
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
 
 type Address struct {
 	IP       net.IP `json:"ip"`
 	Hostname string `json:"hostname"`
 }
+
+// NodeAddressPolicy decides the addresses of the node scope, e.g. the SANs of a node scoped certificate.
+// The zero value uses the node name and all the addresses of the Node object.
+type NodeAddressPolicy struct {
+	// Types are the types of the addresses of the Node object used, in order. The node name is not used
+	// when types are set, it is not always routable.
+	Types []corev1.NodeAddressType
+	// Addresses replace the addresses of the Node object, the Node object is not read.
+	// The csi driver runs on the node of the pod, so they are the addresses of the local node.
+	Addresses []Address
+}
+
+var nodeAddressTypes = []corev1.NodeAddressType{
+	corev1.NodeHostName,
+	corev1.NodeInternalDNS,
+	corev1.NodeExternalDNS,
+	corev1.NodeInternalIP,
+	corev1.NodeExternalIP,
+}
+
+// ParseNodeAddressTypes parses a comma separated list of node address types, e.g. "InternalDNS,InternalIP".
+func ParseNodeAddressTypes(s string) ([]corev1.NodeAddressType, error) {
+	var types []corev1.NodeAddressType
+	for _, item := range strings.Split(s, ",") {
+		addressType := corev1.NodeAddressType(strings.TrimSpace(item))
+		if addressType == "" {
+			continue
+		}
+		if !slices.Contains(nodeAddressTypes, addressType) {
+			return nil, fmt.Errorf("invalid node address type %q, must be one of %v", addressType, nodeAddressTypes)
+		}
+		if !slices.Contains(types, addressType) {
+			types = append(types, addressType)
+		}
+	}
+	return types, nil
+}
+
+// ParseAddresses parses a comma separated list of hostnames and ips, e.g. "node-1.example.com,192.168.0.10".
+func ParseAddresses(s string) []Address {
+	var addresses []Address
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if ip := net.ParseIP(item); ip != nil {
+			addresses = append(addresses, Address{IP: ip})
+		} else {
+			addresses = append(addresses, Address{Hostname: item})
+		}
+	}
+	return addresses
+}
+
+// deduplicateAddresses removes the duplicated addresses, and keeps the order.
+func deduplicateAddresses(addresses []Address) []Address {
+	seen := map[string]bool{}
+	result := []Address{}
+	for _, address := range addresses {
+		key := address.Hostname
+		if address.IP != nil {
+			key = address.IP.String()
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, address)
+	}
+	return result
+}
@@ -0,0 +1,39 @@
+package pod_info
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// DefaultClusterDomain is the cluster domain of the dns names of the pods and services, unless it is configured.
+const DefaultClusterDomain = "cluster.local"
+
+// ResolvConfPath is the resolv.conf read to detect the cluster domain.
+const ResolvConfPath = "/etc/resolv.conf"
+
+// ParseClusterDomain returns the cluster domain from the search domains of a resolv.conf written by kubelet,
+// e.g. "k8s.internal" from "search default.svc.k8s.internal svc.k8s.internal k8s.internal".
+// It is empty when no search domain starts with "svc.", e.g. the pod does not use the cluster dns.
+func ParseClusterDomain(resolvConf io.Reader) (string, error) {
+	domain := ""
+	scanner := bufio.NewScanner(resolvConf)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "search" {
+			continue
+		}
+		// the last search line wins, like the resolver
+		domain = ""
+		for _, search := range fields[1:] {
+			if suffix, ok := strings.CutPrefix(strings.TrimSuffix(search, "."), "svc."); ok && suffix != "" {
+				domain = suffix
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return domain, nil
+}
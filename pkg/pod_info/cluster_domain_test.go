@@ -0,0 +1,93 @@
+package pod_info
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func TestParseClusterDomain(t *testing.T) {
+	tests := []struct {
+		name       string
+		resolvConf string
+		expected   string
+	}{
+		{
+			name:       "cluster first",
+			resolvConf: "nameserver 10.96.0.10\nsearch kube-system.svc.k8s.internal svc.k8s.internal k8s.internal\noptions ndots:5\n",
+			expected:   "k8s.internal",
+		},
+		{
+			name:       "trailing dot",
+			resolvConf: "search svc.cluster.local.\n",
+			expected:   "cluster.local",
+		},
+		{
+			name:       "host dns",
+			resolvConf: "nameserver 192.168.0.1\nsearch example.com\n",
+		},
+		{
+			name:       "last search line",
+			resolvConf: "search svc.cluster.local\nsearch svc.k8s.internal\n",
+			expected:   "k8s.internal",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain, err := ParseClusterDomain(strings.NewReader(tt.resolvConf))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if domain != tt.expected {
+				t.Errorf("unexpected cluster domain: got %q, want %q", domain, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetScopedAddressesClusterDomain(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "web"},
+		},
+		Spec: corev1.PodSpec{
+			Subdomain: "web-headless",
+		},
+		Status: corev1.PodStatus{
+			PodIPs: []corev1.PodIP{{IP: "10.0.0.10"}},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithObjects(pod, newTestService("web", map[string]string{"app": "web"}, "10.96.0.10")).Build()
+	podInfo := NewPodInfo(c, pod, &volume.SecretVolumeSelector{
+		Scope: volume.SecretScope{Pod: volume.ScopePod, Services: []string{"db"}},
+	}).WithClusterDomain("k8s.internal")
+
+	addresses, err := podInfo.GetScopedAddresses(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Address{
+		{Hostname: "web-headless.default.svc.k8s.internal"},
+		{Hostname: "web-0.web-headless.default.svc.k8s.internal"},
+		{IP: net.ParseIP("10.0.0.10")},
+		{Hostname: "10-0-0-10.default.pod.k8s.internal"},
+		{Hostname: "web.default.svc.k8s.internal"},
+		{Hostname: "db.default.svc.k8s.internal"},
+	}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("unexpected addresses: got %v, want %v", addresses, expected)
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strings"
 
 	listenersv1alpha1 "github.com/zncdata-labs/listener-operator/api/v1alpha1"
 	listenerUtil "github.com/zncdata-labs/listener-operator/pkg/util"
 	"github.com/zncdata-labs/secret-operator/pkg/volume"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	ctrl "sigs.k8s.io/controller-runtime"
 	client "sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -21,6 +24,9 @@ type PodInfo struct {
 	client         client.Client
 	Pod            *corev1.Pod
 	VolumeSelector *volume.SecretVolumeSelector
+
+	nodeAddressPolicy NodeAddressPolicy
+	clusterDomain     string
 }
 
 func NewPodInfo(
@@ -35,6 +41,27 @@ func NewPodInfo(
 	}
 }
 
+// WithNodeAddressPolicy replaces how the addresses of the node scope are resolved, see NodeAddressPolicy.
+func (p *PodInfo) WithNodeAddressPolicy(policy NodeAddressPolicy) *PodInfo {
+	p.nodeAddressPolicy = policy
+	return p
+}
+
+// WithClusterDomain replaces the cluster domain of the dns names of the pods and services, DefaultClusterDomain
+// when empty.
+func (p *PodInfo) WithClusterDomain(domain string) *PodInfo {
+	p.clusterDomain = domain
+	return p
+}
+
+// GetClusterDomain returns the cluster domain of the dns names of the pods and services, e.g. "cluster.local".
+func (p *PodInfo) GetClusterDomain() string {
+	if p.clusterDomain == "" {
+		return DefaultClusterDomain
+	}
+	return p.clusterDomain
+}
+
 func (p *PodInfo) GetPodName() string {
 	return p.Pod.GetName()
 }
@@ -43,6 +70,20 @@ func (p *PodInfo) GetPodNamespace() string {
 	return p.Pod.GetNamespace()
 }
 
+// GetPodLabelValues returns the values of the given label keys of the pod.
+// It returns an error naming the first missing label.
+func (p *PodInfo) GetPodLabelValues(keys []string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, ok := p.Pod.GetLabels()[key]
+		if !ok {
+			return nil, fmt.Errorf("label %s not found in pod %s/%s", key, p.GetPodNamespace(), p.GetPodName())
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
 func (p *PodInfo) GetPodIP() string {
 	return p.Pod.Status.PodIP
 }
@@ -58,6 +99,22 @@ func (p *PodInfo) GetPodIPs() []string {
 	return ips
 }
 
+// GetServiceAccountName returns the service account of the pod, "default" when not set.
+func (p *PodInfo) GetServiceAccountName() string {
+	if p.Pod.Spec.ServiceAccountName == "" {
+		return "default"
+	}
+	return p.Pod.Spec.ServiceAccountName
+}
+
+// GetFSGroup returns the fsGroup of the pod security context, or nil if not set.
+func (p *PodInfo) GetFSGroup() *int64 {
+	if p.Pod.Spec.SecurityContext == nil {
+		return nil
+	}
+	return p.Pod.Spec.SecurityContext.FSGroup
+}
+
 func (p *PodInfo) GetNodeName() string {
 	return p.Pod.Spec.NodeName
 }
@@ -84,9 +141,13 @@ func (p *PodInfo) GetNodeIPs(ctx context.Context) ([]Address, error) {
 
 	node, err := p.GetNode(ctx)
 	if err != nil {
-		return nil, nil
+		return nil, fmt.Errorf("failed to get node %s of pod %s: %w", p.GetNodeName(), p.GetPodName(), err)
 	}
 
+	return p.nodeIPs(node)
+}
+
+func (p *PodInfo) nodeIPs(node *corev1.Node) ([]Address, error) {
 	addresses := []Address{}
 
 	for _, address := range node.Status.Addresses {
@@ -108,16 +169,131 @@ func (p *PodInfo) GetNodeIPs(ctx context.Context) ([]Address, error) {
 	return addresses, nil
 }
 
+// GetNodeAddresses returns the addresses of the node where the pod is running.
+// By default, it includes the node name, the hostname and dns names reported by the node,
+// and the internal and external ips. The NodeAddressPolicy of the pod info may restrict the address types,
+// or replace the addresses of the Node object.
+func (p *PodInfo) GetNodeAddresses(ctx context.Context) ([]Address, error) {
+	policy := p.nodeAddressPolicy
+	if len(policy.Addresses) > 0 {
+		logger.V(5).Info("use the configured node addresses", "pod", p.GetPodName(), "namespace", p.GetPodNamespace(),
+			"node", p.GetNodeName(), "addresses", policy.Addresses)
+		return deduplicateAddresses(policy.Addresses), nil
+	}
+
+	node, err := p.GetNode(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s of pod %s: %w", p.GetNodeName(), p.GetPodName(), err)
+	}
+
+	if len(policy.Types) == 0 {
+		return p.defaultNodeAddresses(node)
+	}
+
+	addresses := []Address{}
+	for _, addressType := range policy.Types {
+		for _, address := range node.Status.Addresses {
+			if address.Type != addressType {
+				continue
+			}
+			switch addressType {
+			case corev1.NodeInternalIP, corev1.NodeExternalIP:
+				ip := net.ParseIP(address.Address)
+				if ip == nil {
+					return nil, fmt.Errorf("invalid node ip: %s from node %s", address.Address, p.GetNodeName())
+				}
+				addresses = append(addresses, Address{IP: ip})
+			default:
+				addresses = append(addresses, Address{Hostname: address.Address})
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("node %s of pod %s has no address of types %v", p.GetNodeName(), p.GetPodName(), policy.Types)
+	}
+
+	return deduplicateAddresses(addresses), nil
+}
+
+func (p *PodInfo) defaultNodeAddresses(node *corev1.Node) ([]Address, error) {
+	addresses := []Address{
+		{
+			Hostname: node.GetName(),
+		},
+	}
+
+	for _, address := range node.Status.Addresses {
+		switch address.Type {
+		case corev1.NodeHostName, corev1.NodeInternalDNS, corev1.NodeExternalDNS:
+			addresses = append(addresses, Address{
+				Hostname: address.Address,
+			})
+		}
+	}
+
+	ips, err := p.nodeIPs(node)
+	if err != nil {
+		return nil, err
+	}
+	addresses = append(addresses, ips...)
+
+	return deduplicateAddresses(addresses), nil
+}
+
 func (p *PodInfo) GetServiceIPsByName(name string) []Address {
 	addresses := []Address{
 		{
-			Hostname: fmt.Sprintf("%s.%s.svc.cluster.local", name, p.GetPodNamespace()),
+			Hostname: fmt.Sprintf("%s.%s.svc.%s", name, p.GetPodNamespace(), p.GetClusterDomain()),
 		},
 	}
 
 	return addresses
 }
 
+// GetServiceScopedDNSNames returns the DNS names of the services whose selector matches the pod labels.
+// For each matched service, "<svc>.<ns>.svc.<cluster domain>" is returned. If the service is headless,
+// the per-pod hostname "<pod>.<svc>.<ns>.svc.<cluster domain>" is returned too.
+// The returned names are deduplicated.
+func (p *PodInfo) GetServiceScopedDNSNames(ctx context.Context) ([]string, error) {
+	services := &corev1.ServiceList{}
+	if err := p.client.List(ctx, services, client.InNamespace(p.GetPodNamespace())); err != nil {
+		return nil, err
+	}
+
+	podLabels := labels.Set(p.Pod.GetLabels())
+	hostname := p.Pod.Spec.Hostname
+	if hostname == "" {
+		hostname = p.GetPodName()
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, svc := range services.Items {
+		// a service without selector does not select any pod
+		if len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		if !labels.SelectorFromSet(svc.Spec.Selector).Matches(podLabels) {
+			continue
+		}
+		add(fmt.Sprintf("%s.%s.svc.%s", svc.GetName(), p.GetPodNamespace(), p.GetClusterDomain()))
+		if svc.Spec.ClusterIP == corev1.ClusterIPNone {
+			add(fmt.Sprintf("%s.%s.%s.svc.%s", hostname, svc.GetName(), p.GetPodNamespace(), p.GetClusterDomain()))
+		}
+	}
+
+	logger.V(1).Info("get service scoped dns names", "pod", p.GetPodName(), "namespace", p.GetPodNamespace(), "names", names)
+
+	return names, nil
+}
+
 // Get the address information of the pod.
 // In statusfulset, the spec.serviceName field is required, so the pod will come with pod.spec.subdomain.
 // In deployment, the pod does not have pod.spec.subdomain by default. If needed, you can first create a Service, and then
@@ -128,10 +304,10 @@ func (p *PodInfo) GetPodAddresses() ([]Address, error) {
 	if svcName != "" {
 		// https://kubernetes.io/docs/concepts/services-networking/dns-pod-service/
 		addresses = append(addresses, Address{
-			Hostname: fmt.Sprintf("%s.%s.svc.cluster.local", svcName, p.GetPodNamespace()),
+			Hostname: fmt.Sprintf("%s.%s.svc.%s", svcName, p.GetPodNamespace(), p.GetClusterDomain()),
 		})
 		addresses = append(addresses, Address{
-			Hostname: fmt.Sprintf("%s.%s.%s.svc.cluster.local", p.GetPodName(), svcName, p.GetPodNamespace()),
+			Hostname: fmt.Sprintf("%s.%s.%s.svc.%s", p.GetPodName(), svcName, p.GetPodNamespace(), p.GetClusterDomain()),
 		})
 	}
 
@@ -144,6 +320,9 @@ func (p *PodInfo) GetPodAddresses() ([]Address, error) {
 		addresses = append(addresses, Address{
 			IP: ip,
 		})
+		addresses = append(addresses, Address{
+			Hostname: p.podFQDN(ip),
+		})
 	}
 
 	logger.V(1).Info("get pod addresses", "pod", p.GetPodName(), "namespace", p.GetPodNamespace(), "addresses", addresses)
@@ -151,18 +330,25 @@ func (p *PodInfo) GetPodAddresses() ([]Address, error) {
 	return addresses, nil
 }
 
+// podFQDN returns the dns name of the pod ip, e.g. "10-0-0-10.default.pod.cluster.local".
+// https://kubernetes.io/docs/concepts/services-networking/dns-pod-service/#a-aaaa-records-1
+func (p *PodInfo) podFQDN(ip net.IP) string {
+	dashed := strings.NewReplacer(".", "-", ":", "-").Replace(ip.String())
+	return fmt.Sprintf("%s.%s.pod.%s", dashed, p.GetPodNamespace(), p.GetClusterDomain())
+}
+
 func (p *PodInfo) GetScopedAddresses(ctx context.Context) ([]Address, error) {
 	addresses := []Address{}
 
 	scoped := p.VolumeSelector.Scope
 
 	if scoped.Node == volume.ScopeNode {
-		nodeIps, err := p.GetNodeIPs(ctx)
+		nodeAddresses, err := p.GetNodeAddresses(ctx)
 		if err != nil {
 			return nil, err
 		}
-		addresses = append(addresses, nodeIps...)
-		logger.V(1).Info("get node ip", "pod", p.GetPodName(), "namespace", p.GetPodNamespace(), "node", p.GetNodeName())
+		addresses = append(addresses, nodeAddresses...)
+		logger.V(1).Info("get node addresses", "pod", p.GetPodName(), "namespace", p.GetPodNamespace(), "node", p.GetNodeName())
 	}
 
 	if scoped.Pod == volume.ScopePod {
@@ -172,6 +358,15 @@ func (p *PodInfo) GetScopedAddresses(ctx context.Context) ([]Address, error) {
 		}
 		addresses = append(addresses, podAddresses...)
 		logger.V(1).Info("get pod addresses", "pod", p.GetPodName(), "namespace", p.GetPodNamespace())
+
+		// clients usually connect to the pod through the services selecting it
+		svcNames, err := p.GetServiceScopedDNSNames(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range svcNames {
+			addresses = append(addresses, Address{Hostname: name})
+		}
 	}
 
 	if scoped.Services != nil {
@@ -192,6 +387,16 @@ func (p *PodInfo) GetScopedAddresses(ctx context.Context) ([]Address, error) {
 		addresses = append(addresses, listenerAddresses...)
 	}
 
+	if scoped.Listeners != nil {
+		listenerAddresses, err := p.GetListenerServiceAddresses(ctx)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, listenerAddresses...)
+	}
+
+	addresses = deduplicateAddresses(addresses)
+
 	logger.V(1).Info("get scoped addresses", "pod", p.GetPodName(), "namespace", p.GetPodNamespace(),
 		"scope", scoped, "addresses", addresses,
 	)
@@ -337,6 +542,58 @@ func (p *PodInfo) GetListenerAddresses(ctx context.Context) ([]Address, error) {
 	return addresses, nil
 }
 
+// GetListenerServiceAddresses returns the external addresses of the services of the listener scope,
+// i.e. the ips and hostnames of their load balancer ingress and their external ips.
+// The address of a service is not known while its load balancer is pending, or the service is not created yet,
+// the internal dns name of the service is returned instead, e.g. "foo.default.svc.cluster.local".
+func (p *PodInfo) GetListenerServiceAddresses(ctx context.Context) ([]Address, error) {
+	var addresses []Address
+	for _, name := range p.VolumeSelector.Scope.Listeners {
+		svc := &corev1.Service{}
+		if err := p.client.Get(ctx, client.ObjectKey{Name: name, Namespace: p.GetPodNamespace()}, svc); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return nil, err
+			}
+			svc = nil
+		}
+
+		var svcAddresses []Address
+		if svc != nil {
+			for _, ingress := range svc.Status.LoadBalancer.Ingress {
+				if ingress.IP != "" {
+					ip := net.ParseIP(ingress.IP)
+					if ip == nil {
+						return nil, fmt.Errorf("invalid load balancer ip: %s from service %s", ingress.IP, name)
+					}
+					svcAddresses = append(svcAddresses, Address{IP: ip})
+				}
+				if ingress.Hostname != "" {
+					svcAddresses = append(svcAddresses, Address{Hostname: ingress.Hostname})
+				}
+			}
+			for _, externalIP := range svc.Spec.ExternalIPs {
+				ip := net.ParseIP(externalIP)
+				if ip == nil {
+					return nil, fmt.Errorf("invalid external ip: %s from service %s", externalIP, name)
+				}
+				svcAddresses = append(svcAddresses, Address{IP: ip})
+			}
+		}
+
+		if len(svcAddresses) == 0 {
+			logger.V(1).Info("listener service has no external address yet, use its internal name", "pod", p.GetPodName(),
+				"namespace", p.GetPodNamespace(), "service", name, "found", svc != nil)
+			svcAddresses = p.GetServiceIPsByName(name)
+		}
+		addresses = append(addresses, svcAddresses...)
+	}
+
+	logger.V(1).Info("get listener service addresses", "pod", p.GetPodName(), "namespace", p.GetPodNamespace(),
+		"listeners", p.VolumeSelector.Scope.Listeners, "addresses", addresses)
+
+	return addresses, nil
+}
+
 func (p *PodInfo) GetListener(ctx context.Context, name string) (*listenersv1alpha1.Listener, error) {
 	listener := &listenersv1alpha1.Listener{}
 
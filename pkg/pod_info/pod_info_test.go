@@ -0,0 +1,299 @@
+package pod_info
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/zncdata-labs/secret-operator/pkg/volume"
+)
+
+func newTestService(name string, selector map[string]string, clusterIP string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Selector:  selector,
+			ClusterIP: clusterIP,
+		},
+	}
+}
+
+func TestGetServiceScopedDNSNames(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "web", "tier": "frontend"},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithObjects(
+		pod,
+		newTestService("web", map[string]string{"app": "web"}, "10.96.0.10"),
+		newTestService("web-headless", map[string]string{"app": "web", "tier": "frontend"}, corev1.ClusterIPNone),
+		newTestService("db", map[string]string{"app": "db"}, "10.96.0.11"),
+		newTestService("external", nil, "10.96.0.12"),
+	).Build()
+
+	podInfo := NewPodInfo(c, pod, nil)
+
+	names, err := podInfo.GetServiceScopedDNSNames(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"web.default.svc.cluster.local",
+		"web-headless.default.svc.cluster.local",
+		"web-0.web-headless.default.svc.cluster.local",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("unexpected names: got %v, want %v", names, expected)
+	}
+}
+
+func TestDeduplicateAddresses(t *testing.T) {
+	addresses := []Address{
+		{Hostname: "web.default.svc.cluster.local"},
+		{Hostname: "web.default.svc.cluster.local"},
+	}
+
+	result := deduplicateAddresses(addresses)
+	if len(result) != 1 {
+		t.Errorf("unexpected addresses: got %v, want 1 address", result)
+	}
+}
+
+func TestGetScopedAddressesNodeScope(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "node-1",
+		},
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+		},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeHostName, Address: "node-1.example.com"},
+				{Type: corev1.NodeInternalIP, Address: "192.168.0.10"},
+			},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithObjects(pod, node).Build()
+	podInfo := NewPodInfo(c, pod, &volume.SecretVolumeSelector{
+		Scope: volume.SecretScope{Node: volume.ScopeNode},
+	})
+
+	addresses, err := podInfo.GetScopedAddresses(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Address{
+		{Hostname: "node-1"},
+		{Hostname: "node-1.example.com"},
+		{IP: net.ParseIP("192.168.0.10")},
+	}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("unexpected addresses: got %v, want %v", addresses, expected)
+	}
+}
+
+func TestGetNodeAddressesPolicy(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "i-0a1b2c3d",
+		},
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "i-0a1b2c3d",
+		},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeHostName, Address: "ip-10-0-0-5"},
+				{Type: corev1.NodeInternalDNS, Address: "ip-10-0-0-5.ec2.internal"},
+				{Type: corev1.NodeExternalDNS, Address: "node.example.com"},
+				{Type: corev1.NodeInternalIP, Address: "10.0.0.5"},
+				{Type: corev1.NodeExternalIP, Address: "203.0.113.5"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		policy   NodeAddressPolicy
+		expected []Address
+	}{
+		{
+			name:   "default",
+			policy: NodeAddressPolicy{},
+			expected: []Address{
+				{Hostname: "i-0a1b2c3d"},
+				{Hostname: "ip-10-0-0-5"},
+				{Hostname: "ip-10-0-0-5.ec2.internal"},
+				{Hostname: "node.example.com"},
+				{IP: net.ParseIP("10.0.0.5")},
+				{IP: net.ParseIP("203.0.113.5")},
+			},
+		},
+		{
+			name:   "types in order",
+			policy: NodeAddressPolicy{Types: []corev1.NodeAddressType{corev1.NodeInternalIP, corev1.NodeInternalDNS}},
+			expected: []Address{
+				{IP: net.ParseIP("10.0.0.5")},
+				{Hostname: "ip-10-0-0-5.ec2.internal"},
+			},
+		},
+		{
+			name:   "external only",
+			policy: NodeAddressPolicy{Types: []corev1.NodeAddressType{corev1.NodeExternalDNS, corev1.NodeExternalIP}},
+			expected: []Address{
+				{Hostname: "node.example.com"},
+				{IP: net.ParseIP("203.0.113.5")},
+			},
+		},
+		{
+			name:   "configured addresses",
+			policy: NodeAddressPolicy{Addresses: ParseAddresses("node-1.example.com, 192.168.0.10")},
+			expected: []Address{
+				{Hostname: "node-1.example.com"},
+				{IP: net.ParseIP("192.168.0.10")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithObjects(pod, node).Build()
+			podInfo := NewPodInfo(c, pod, &volume.SecretVolumeSelector{
+				Scope: volume.SecretScope{Node: volume.ScopeNode},
+			}).WithNodeAddressPolicy(tt.policy)
+
+			addresses, err := podInfo.GetScopedAddresses(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(addresses, tt.expected) {
+				t.Errorf("unexpected addresses: got %v, want %v", addresses, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetNodeAddressesPolicyNoAddress(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.5"}},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithObjects(pod, node).Build()
+	podInfo := NewPodInfo(c, pod, &volume.SecretVolumeSelector{
+		Scope: volume.SecretScope{Node: volume.ScopeNode},
+	}).WithNodeAddressPolicy(NodeAddressPolicy{Types: []corev1.NodeAddressType{corev1.NodeExternalDNS}})
+
+	if _, err := podInfo.GetScopedAddresses(context.Background()); err == nil {
+		t.Error("expected error when the node has no address of the types")
+	}
+}
+
+func TestParseNodeAddressTypes(t *testing.T) {
+	types, err := ParseNodeAddressTypes(" InternalDNS,InternalIP,,InternalDNS")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []corev1.NodeAddressType{corev1.NodeInternalDNS, corev1.NodeInternalIP}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("unexpected types: got %v, want %v", types, expected)
+	}
+
+	if _, err := ParseNodeAddressTypes("InternalIP,PodIP"); err == nil {
+		t.Error("expected error for an unknown address type")
+	}
+}
+
+func TestGetScopedAddressesNodeNotFound(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "node-1",
+		},
+	}
+
+	c := fake.NewClientBuilder().WithObjects(pod).Build()
+	podInfo := NewPodInfo(c, pod, &volume.SecretVolumeSelector{
+		Scope: volume.SecretScope{Node: volume.ScopeNode},
+	})
+
+	if _, err := podInfo.GetScopedAddresses(context.Background()); err == nil {
+		t.Errorf("expected error when node does not exist")
+	}
+}
+
+func TestGetScopedAddressesListenerScope(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "default",
+		},
+	}
+
+	lb := newTestService("web-lb", map[string]string{"app": "web"}, "10.96.0.10")
+	lb.Spec.Type = corev1.ServiceTypeLoadBalancer
+	lb.Spec.ExternalIPs = []string{"192.168.0.20"}
+	lb.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{
+		{IP: "203.0.113.10"},
+		{Hostname: "web.example.com"},
+	}
+	pending := newTestService("web-pending", map[string]string{"app": "web"}, "10.96.0.11")
+	pending.Spec.Type = corev1.ServiceTypeLoadBalancer
+
+	c := fake.NewClientBuilder().WithObjects(pod, lb, pending).Build()
+	podInfo := NewPodInfo(c, pod, &volume.SecretVolumeSelector{
+		Scope: volume.SecretScope{Listeners: []string{"web-lb", "web-pending", "web-missing"}},
+	})
+
+	addresses, err := podInfo.GetScopedAddresses(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Address{
+		{IP: net.ParseIP("203.0.113.10")},
+		{Hostname: "web.example.com"},
+		{IP: net.ParseIP("192.168.0.20")},
+		{Hostname: "web-pending.default.svc.cluster.local"},
+		{Hostname: "web-missing.default.svc.cluster.local"},
+	}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("unexpected addresses: got %v, want %v", addresses, expected)
+	}
+}
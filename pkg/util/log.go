@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"runtime/debug"
 
+	"github.com/go-logr/logr"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
@@ -23,20 +25,63 @@ func GetLogLevel(method string) int {
 	return 2
 }
 
+type requestKey struct{}
+
+// request identifies the grpc call the context belongs to in the log entries.
+type request struct {
+	requestID string
+	volumeID  string
+}
+
+// WithRequestID returns a context of the call with the request ID, and the volume ID when it is not empty.
+// The loggers returned by LoggerFromContext for the context add them to each entry.
+func WithRequestID(ctx context.Context, requestID, volumeID string) context.Context {
+	return context.WithValue(ctx, requestKey{}, request{requestID: requestID, volumeID: volumeID})
+}
+
+// RequestIDFromContext returns the request ID of the call the context belongs to, empty when it has none.
+func RequestIDFromContext(ctx context.Context) string {
+	req, _ := ctx.Value(requestKey{}).(request)
+	return req.requestID
+}
+
+// LoggerFromContext returns the logger with the request ID and the volume ID of the call the context belongs to,
+// so the entries logged while serving a call, e.g. by the backends, can be correlated.
+// The logger is returned as is when the context has no request ID.
+func LoggerFromContext(ctx context.Context, logger logr.Logger) logr.Logger {
+	req, ok := ctx.Value(requestKey{}).(request)
+	if !ok {
+		return logger
+	}
+	if req.volumeID == "" {
+		return logger.WithValues("requestID", req.requestID)
+	}
+	return logger.WithValues("requestID", req.requestID, "volumeID", req.volumeID)
+}
+
+// LogGRPC logs the grpc calls. A request ID is generated for each call and put in its context with the volume ID
+// of the request, if any, see LoggerFromContext.
 func LogGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var volumeID string
+	if r, ok := req.(interface{ GetVolumeId() string }); ok {
+		volumeID = r.GetVolumeId()
+	}
+	ctx = WithRequestID(ctx, string(uuid.NewUUID()), volumeID)
+	reqLog := LoggerFromContext(ctx, log)
+
 	level := GetLogLevel(info.FullMethod)
-	log.V(level).Info("GRPC calling", "method", info.FullMethod, "request", protosanitizer.StripSecrets(req))
+	reqLog.V(level).Info("GRPC calling", "method", info.FullMethod, "request", protosanitizer.StripSecrets(req))
 
 	resp, err := handler(ctx, req)
 	if err != nil {
-		log.Error(err, "GRPC called error", "method", info.FullMethod)
+		reqLog.Error(err, "GRPC called error", "method", info.FullMethod)
 		if level >= 5 {
 			stack := debug.Stack()
 			errStack := fmt.Errorf("\n%s", stack)
-			log.Error(err, "GRPC called error", errStack.Error())
+			reqLog.Error(err, "GRPC called error", errStack.Error())
 		}
 	} else {
-		log.V(level).Info("GRPC called", "method", info.FullMethod, "response", protosanitizer.StripSecrets(resp))
+		reqLog.V(level).Info("GRPC called", "method", info.FullMethod, "response", protosanitizer.StripSecrets(resp))
 	}
 	return resp, err
 }
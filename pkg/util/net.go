@@ -5,12 +5,13 @@ import (
 	"strings"
 )
 
+// ParseEndpoint returns the lower case protocol, unix or tcp, and the address of the endpoint.
 func ParseEndpoint(ep string) (string, string, error) {
 	if strings.HasPrefix(strings.ToLower(ep), "unix://") || strings.HasPrefix(strings.ToLower(ep), "tcp://") {
 		s := strings.SplitN(ep, "://", 2)
 		if s[1] != "" {
-			return s[0], s[1], nil
+			return strings.ToLower(s[0]), s[1], nil
 		}
 	}
-	return "", "", fmt.Errorf("invalid endpoint: %v", ep)
+	return "", "", fmt.Errorf("invalid endpoint: %v, expected unix://<path> or tcp://<host>:<port>", ep)
 }
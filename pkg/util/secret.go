@@ -1,6 +1,10 @@
 package util
 
+// SecretContent is the secret data returned by a backend, the key is the file name and the value is the raw content,
+// it may be binary, e.g. a keytab.
 type SecretContent struct {
-	Data        map[string]string
+	Data        map[string][]byte
 	ExpiresTime *int64
+	// ContentHash is the checksum of the secret data and the options of the volume, set by the csi driver.
+	ContentHash string
 }
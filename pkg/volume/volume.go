@@ -2,10 +2,16 @@ package volume
 
 import (
 	"fmt"
+	"io/fs"
+	"net"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
@@ -20,11 +26,61 @@ const (
 type SecretFormat string
 
 const (
-	SecretFormatTLSPEM   SecretFormat = "tls-pem"
-	SecretFormatTLSP12   SecretFormat = "tls-p12"
-	SecretFormatKerberos SecretFormat = "kerberos"
+	SecretFormatTLSPEM SecretFormat = "tls-pem"
+	SecretFormatTLSP12 SecretFormat = "tls-p12"
+	// SecretFormatTLSPKCS12 is an alias of SecretFormatTLSP12
+	SecretFormatTLSPKCS12 SecretFormat = "tls-pkcs12"
+	SecretFormatTLSJKS    SecretFormat = "tls-jks"
+	SecretFormatKerberos  SecretFormat = "kerberos"
+	// SecretFormatEnv and SecretFormatJSON write all the secret data to a single file.
+	SecretFormatEnv  SecretFormat = "env"
+	SecretFormatJSON SecretFormat = "json"
+	// SecretFormatFIFO writes the keys of FIFOKeys as named pipes instead of files.
+	SecretFormatFIFO SecretFormat = "fifo"
+	// SecretFormatConcat writes the values of ConcatKeys to the single file ConcatFile, each one followed by
+	// ConcatSeparator, e.g. the public keys of an authorized_keys or known_hosts file.
+	SecretFormatConcat SecretFormat = "concat"
 )
 
+// AutoTlsMode selects what the autoTls backend issues for the volume.
+type AutoTlsMode string
+
+const (
+	// AutoTlsModeCAOnly only returns the trusted CA certificates in ca.crt, no certificate is issued.
+	// It is for pods which are only clients and need to trust the servers.
+	AutoTlsModeCAOnly AutoTlsMode = "caOnly"
+)
+
+// KeyCaseMode is the case the keys of the secret data are converted to before they are written to the volume.
+type KeyCaseMode string
+
+const (
+	KeyCaseLower KeyCaseMode = "lower"
+	KeyCaseUpper KeyCaseMode = "upper"
+)
+
+// DecodeMode is the encoding of the values of DecodeKeys, decoded before they are written to the volume.
+type DecodeMode string
+
+const (
+	DecodeBase64 DecodeMode = "base64"
+)
+
+// TrailingNewlineMode is what happens to the trailing newline of each file before it is written to the volume.
+type TrailingNewlineMode string
+
+const (
+	// TrailingNewlineStrip removes all the trailing newlines, "\n" or "\r\n", e.g. for a password file.
+	TrailingNewlineStrip TrailingNewlineMode = "strip"
+	// TrailingNewlineEnsure adds a newline to the files not ending with one, the empty files are kept empty.
+	TrailingNewlineEnsure TrailingNewlineMode = "ensure"
+	// TrailingNewlinePreserve writes the files as they are, the default.
+	TrailingNewlinePreserve TrailingNewlineMode = "preserve"
+)
+
+// TLSPEMFileNames are the files which can be selected by TLSPEMFiles for the tls-pem format.
+var TLSPEMFileNames = []string{"tls.crt", "tls.key", "ca.crt", "fullchain.pem", "privkey.pem"}
+
 const (
 	// kubernetes and sig defained annotations for PVC
 	CSIStoragePodName                       string = "csi.storage.k8s.io/pod.name"
@@ -34,6 +90,11 @@ const (
 	CSIStorageEphemeral                     string = "csi.storage.k8s.io/ephemeral"
 	StorageKubernetesCSIProvisionerIdentity string = "storage.kubernetes.io/csiProvisionerIdentity"
 	VolumeKubernetesStorageProvisioner      string = "volume.kubernetes.io/storage-provisioner"
+
+	// CSIStoragePVCName and CSIStoragePVCNamespace identify the PVC of the volume, they are passed to CreateVolume
+	// by the csi-provisioner with --extra-create-metadata, and delivered to the node in the volume context.
+	CSIStoragePVCName      string = "csi.storage.k8s.io/pvc/name"
+	CSIStoragePVCNamespace string = "csi.storage.k8s.io/pvc/namespace"
 )
 
 // Annotation for expiration time of zncdata secret for pod.
@@ -43,6 +104,15 @@ const (
 	SecretZncdataExpirationTime string = "secrets.zncdata.dev/expirationTime"
 )
 
+// SecretZncdataRestartedAt is the annotation of the pod template of a workload set by the csi driver to restart it
+// when a secret is rotated, like kubectl rollout restart. The value is the restart time in RFC 3339.
+const SecretZncdataRestartedAt string = "secrets.zncdata.dev/restartedAt"
+
+// SecretZncdataContentHash is the pod annotation with the content hash of the secret files of each volume,
+// a JSON object keyed by the volume name, e.g. {"tls":"sha256:..."}.
+// Tools compare it with the files of the volume to detect a stale mount.
+const SecretZncdataContentHash string = "secrets.zncdata.dev/content-hash"
+
 // Labels for k8s search secret
 const (
 	SecretsZncdataNodeName string = "secrets.zncdata.dev/node"
@@ -55,12 +125,23 @@ const (
 const (
 	SecretsZncdataClass string = "secrets.zncdata.dev/class"
 
+	// SecretClassName is the secret class of the volumes provisioned before the class annotation was used,
+	// a parameter of their StorageClass. It is only used when neither class nor classes is set.
+	SecretClassName string = "secretClassName"
+
+	// Classes is a comma separated list of secret classes, e.g. "tls,shared".
+	// The secret data of all the classes is written to the same volume, a key must not be provided by
+	// more than one class. It can not be used together with class.
+	SecretsZncdataClasses string = "secrets.zncdata.dev/classes"
+
 	// Scope is the scope of the secret.
-	// It can be one of the following values:
+	// It is a comma separated list of the following values:
 	// - pod
 	// - node
-	// - service
-	// - listener-volume
+	// - service=<name>[,<name>...]
+	// - listener-volume=<name>[,<name>...]
+	// - listener=<service>[,<service>...]
+	// For example: "pod,node,service=foo,bar"
 	SecretsZncdataScope string = "secrets.zncdata.dev/scope"
 
 	// Format is mounted format of the secret.
@@ -68,16 +149,130 @@ const (
 	// - tls-pem  A PEM-encoded TLS certificate, include "tls.crt", "tls.key", "ca.crt".
 	// - tls-p12 A PKCS#12 archive, include "keystore.p12", "truststore.p12".
 	// - kerberos A Kerberos keytab, include "keytab", "krb5.conf".
+	// - env All the secret data in "secrets.env", one KEY="VALUE" line per key.
+	// - json All the secret data in "secrets.json", a JSON object.
+	// - fifo The keys of fifoKeys as named pipes, see FIFOKeys.
+	// - concat The values of concatKeys in a single file, e.g. "authorized_keys", see ConcatKeys.
+	// A comma separated list writes the files of every format, e.g. "tls-pem,tls-p12".
 	SecretsZncdataFormat string = "secrets.zncdata.dev/format"
 	// KerberosRealms is the list of Kerberos realms.
 	// It is a comma separated list of Kerberos realms.
 	// For example: "realm1,realm2"
 	SecretsZncdataKerberosRealms string = "secrets.zncdata.dev/kerberosRealms"
-	PKCS12Password               string = "secrets.zncdata.dev/tlsPKCS12Password"
-	CertLifeTime                 string = "secrets.zncdata.dev/autoTlsCertLifetime"
-	CertJitterFactor             string = "secrets.zncdata.dev/autoTlsCertJitterFactor"
+	// KerberosServiceNames is the comma separated list of the service names of the pod, e.g. "HTTP,hdfs".
+	// The kerberos backend writes a principal "<service>/<fqdn>@<realm>" for each service and each
+	// scoped address of the pod to the keytab.
+	SecretsZncdataKerberosServiceNames string = "secrets.zncdata.dev/kerberosServiceNames"
+	PKCS12Password                     string = "secrets.zncdata.dev/tlsPKCS12Password"
+	CertLifeTime                       string = "secrets.zncdata.dev/autoTlsCertLifetime"
+	CertJitterFactor                   string = "secrets.zncdata.dev/autoTlsCertJitterFactor"
+
+	// AutoTls is the mode of the autoTls backend, e.g. "caOnly". By default a certificate is issued.
+	AutoTls string = "secrets.zncdata.dev/autoTls"
+
+	// AutoTlsSpiffe adds the SPIFFE ID of the pod, "spiffe://<trust domain>/ns/<namespace>/sa/<service account>",
+	// as URI SAN to the autoTls certificate when it is "true", and the pod ips as IP SANs whatever the scope.
+	AutoTlsSpiffe string = "secrets.zncdata.dev/autoTlsSpiffe"
+
+	// AutoTlsSANs is a comma separated list of the DNS names and IP addresses added as SANs to the autoTls
+	// certificate, besides the addresses of the scope, e.g. "a.example.com,10.0.0.5".
+	AutoTlsSANs string = "secrets.zncdata.dev/autoTlsSANs"
+
+	// TTL is the max lifetime of the secret of the volume, e.g. "1h", parsed by time.ParseDuration.
+	// It only shortens the lifetime given by the secret class, the secret expires at most TTL after it is issued.
+	TTL string = "secrets.zncdata.dev/ttl"
+
+	// SizeLimit is the size limit of the tmpfs mounted for the volume.
+	// It is parsed as a resource.Quantity, e.g. "16Mi".
+	SizeLimit string = "secrets.zncdata.dev/sizeLimit"
+
+	// Mode is the permission of the secret files written to the volume.
+	// It is an octal string, e.g. "0400". Default is "0644".
+	Mode string = "secrets.zncdata.dev/mode"
+
+	// DirMode is the permission of the volume root, e.g. "0700", an octal string like Mode.
+	// The root is then owned by UID, when set, so only the workload can list the files.
+	DirMode string = "secrets.zncdata.dev/dirMode"
+
+	// UID and GID are the owner of the secret files written to the volume.
+	// When GID is not set, the fsGroup of the pod security context is used.
+	UID string = "secrets.zncdata.dev/uid"
+	GID string = "secrets.zncdata.dev/gid"
+
+	// ItemPath is the subdirectory of the volume where the secret files are written, e.g. "certs".
+	// It must be a relative path inside the volume.
+	ItemPath string = "secrets.zncdata.dev/itemPath"
+
+	// TLSPEMFiles is a comma separated list of the files written for the tls-pem format.
+	// Besides "tls.crt", "tls.key" and "ca.crt", it can select "fullchain.pem", the certificate followed by
+	// the CA certificates, and "privkey.pem", the same as "tls.key".
+	// Default is "tls.crt,tls.key,ca.crt".
+	TLSPEMFiles string = "secrets.zncdata.dev/tlsPEMFiles"
+
+	// Items is a comma separated list of the keys written to the volume, each one "<key>[:<path>]",
+	// e.g. "tls.crt:cert.pem,tls.key:key.pem". Like the items of the kubernetes secret volumes,
+	// only the listed keys are written, renamed to the path when it is set.
+	// The keys are the files after the format conversion, e.g. "keystore.p12" for the tls-p12 format.
+	Items string = "secrets.zncdata.dev/items"
+
+	// EmitMetadata writes "secret-metadata.json" to the volume when it is "true", describing the secret classes,
+	// the backend, the issue and expiration time of the secrets and the pod, to debug stale mounts.
+	EmitMetadata string = "secrets.zncdata.dev/emitMetadata"
+
+	// GzipKeys is a comma separated list of the keys of the secret data stored gzip compressed in the backend,
+	// e.g. "config.json". They are decompressed before the format conversion, and written with the same name.
+	GzipKeys string = "secrets.zncdata.dev/gzipKeys"
+
+	// Decode is the encoding of the keys of DecodeKeys in the backend, only "base64", e.g. for the values
+	// encoded twice when they were stored in the stringData of a kubernetes secret.
+	// They are decoded before the gzip keys and the format conversion, and written with the same name.
+	Decode     string = "secrets.zncdata.dev/decode"
+	DecodeKeys string = "secrets.zncdata.dev/decodeKeys"
+
+	// NoCache fetches the secret from the backend when it is "true", instead of the secret cached by the node,
+	// e.g. right after the secret is updated in vault.
+	NoCache string = "secrets.zncdata.dev/noCache"
+
+	// FIFOKeys is a comma separated list of the keys written as named pipes with the fifo format, e.g. "token".
+	// The value is written once, to the first reader opening the pipe, so it never lands in a file.
+	FIFOKeys string = "secrets.zncdata.dev/fifoKeys"
+
+	// ConcatKeys is a comma separated list of the keys concatenated in that order by the concat format,
+	// default is all the keys ordered by name. ConcatFile is the file written, default is "authorized_keys".
+	// ConcatSeparator follows each value, instead of its trailing newlines, default is a newline.
+	// It is unquoted like a Go string, e.g. "\n\n" is an empty line between the values.
+	ConcatKeys      string = "secrets.zncdata.dev/concatKeys"
+	ConcatFile      string = "secrets.zncdata.dev/concatFile"
+	ConcatSeparator string = "secrets.zncdata.dev/concatSeparator"
+
+	// Template is a text/template rendered with the secret data into the file TemplateFile of the volume,
+	// e.g. "postgres://{{ .username }}:{{ .password }}@db:5432/app". The keys which are not identifiers
+	// are read with index, e.g. {{ index . "tls.crt" }}. A key missing in the secret data fails the publish.
+	Template     string = "secrets.zncdata.dev/template"
+	TemplateFile string = "secrets.zncdata.dev/templateFile"
+
+	// TemplateConfigMap is the name of a ConfigMap in the namespace of the pod, each of its keys is a file
+	// of the volume rendered from the template in the value, like Template.
+	TemplateConfigMap string = "secrets.zncdata.dev/templateConfigMap"
+
+	// KeyCase converts the file names written to the volume to "lower" or "upper" case.
+	// KeyPrefix and KeySuffix are added to the file names, e.g. "app-" and ".pem".
+	// They apply to the files selected and renamed by Items, and to the rendered templates.
+	KeyCase   string = "secrets.zncdata.dev/keyCase"
+	KeyPrefix string = "secrets.zncdata.dev/keyPrefix"
+	KeySuffix string = "secrets.zncdata.dev/keySuffix"
+
+	// TrailingNewline is "strip", "ensure" or "preserve", whether the trailing newline of each file written
+	// to the volume is removed, added or left as-is. Default is "preserve".
+	TrailingNewline string = "secrets.zncdata.dev/trailingNewline"
 )
 
+// SecretItem maps a key of the secret data to the file written to the volume.
+type SecretItem struct {
+	Key  string `json:"key"`
+	Path string `json:"path"`
+}
+
 type SecretVolumeSelector struct {
 	// Default values for volume context
 	Pod                string `json:"csi.storage.k8s.io/pod.name"`
@@ -86,15 +281,54 @@ type SecretVolumeSelector struct {
 	ServiceAccountName string `json:"csi.storage.k8s.io/serviceAccount.name"`
 	Ephemeral          string `json:"csi.storage.k8s.io/ephemeral"`
 	Provisioner        string `json:"storage.kubernetes.io/csiProvisionerIdentity"`
+	PVCName            string `json:"csi.storage.k8s.io/pvc/name"`
+	PVCNamespace       string `json:"csi.storage.k8s.io/pvc/namespace"`
 
-	Class  string       `json:"secrets.zncdata.dev/class"`
-	Scope  SecretScope  `json:"secrets.zncdata.dev/scope"`
-	Format SecretFormat `json:"secrets.zncdata.dev/format"`
+	Class   string       `json:"secrets.zncdata.dev/class"`
+	Classes []string     `json:"secrets.zncdata.dev/classes"`
+	Scope   SecretScope  `json:"secrets.zncdata.dev/scope"`
+	Format  SecretFormat `json:"secrets.zncdata.dev/format"`
 
 	TlsPKCS12Password       string        `json:"secrets.zncdata.dev/tlsPKCS12Password"`
 	KerberosRealms          []string      `json:"secrets.zncdata.dev/kerberosRealms"`
+	KerberosServiceNames    []string      `json:"secrets.zncdata.dev/kerberosServiceNames"`
 	AutoTlsCertLifetime     time.Duration `json:"secrets.zncdata.dev/autoTlsCertLifetime"`
 	AutoTlsCertJitterFactor float64       `json:"secrets.zncdata.dev/autoTlsCertJitterFactor"`
+	AutoTls                 AutoTlsMode   `json:"secrets.zncdata.dev/autoTls"`
+	AutoTlsSpiffe           bool          `json:"secrets.zncdata.dev/autoTlsSpiffe"`
+	AutoTlsSANs             []string      `json:"secrets.zncdata.dev/autoTlsSANs"`
+	TTL                     time.Duration `json:"secrets.zncdata.dev/ttl"`
+
+	SizeLimit *resource.Quantity `json:"secrets.zncdata.dev/sizeLimit"`
+	Mode      fs.FileMode        `json:"secrets.zncdata.dev/mode"`
+	DirMode   fs.FileMode        `json:"secrets.zncdata.dev/dirMode"`
+	UID       *int64             `json:"secrets.zncdata.dev/uid"`
+	GID       *int64             `json:"secrets.zncdata.dev/gid"`
+	ItemPath  string             `json:"secrets.zncdata.dev/itemPath"`
+
+	TLSPEMFiles []string     `json:"secrets.zncdata.dev/tlsPEMFiles"`
+	Items       []SecretItem `json:"secrets.zncdata.dev/items"`
+
+	EmitMetadata bool       `json:"secrets.zncdata.dev/emitMetadata"`
+	GzipKeys     []string   `json:"secrets.zncdata.dev/gzipKeys"`
+	NoCache      bool       `json:"secrets.zncdata.dev/noCache"`
+	Decode       DecodeMode `json:"secrets.zncdata.dev/decode"`
+	DecodeKeys   []string   `json:"secrets.zncdata.dev/decodeKeys"`
+	FIFOKeys     []string   `json:"secrets.zncdata.dev/fifoKeys"`
+
+	ConcatKeys      []string `json:"secrets.zncdata.dev/concatKeys"`
+	ConcatFile      string   `json:"secrets.zncdata.dev/concatFile"`
+	ConcatSeparator string   `json:"secrets.zncdata.dev/concatSeparator"`
+
+	Template          string `json:"secrets.zncdata.dev/template"`
+	TemplateFile      string `json:"secrets.zncdata.dev/templateFile"`
+	TemplateConfigMap string `json:"secrets.zncdata.dev/templateConfigMap"`
+
+	KeyCase   KeyCaseMode `json:"secrets.zncdata.dev/keyCase"`
+	KeyPrefix string      `json:"secrets.zncdata.dev/keyPrefix"`
+	KeySuffix string      `json:"secrets.zncdata.dev/keySuffix"`
+
+	TrailingNewline TrailingNewlineMode `json:"secrets.zncdata.dev/trailingNewline"`
 }
 
 type ListScope string
@@ -104,6 +338,7 @@ const (
 	ScopeNode           ListScope = "node"
 	ScopeService        string    = "service"
 	ScopeListenerVolume string    = "listener-volume"
+	ScopeListener       string    = "listener"
 )
 
 type SecretScope struct {
@@ -115,6 +350,9 @@ type SecretScope struct {
 	Services []string `json:"service"`
 	// this field is k-v pair, key is listener volume name, value is listener volume type
 	ListenerVolumes []string `json:"listener-volume"`
+	// Listeners are the services exposing the pod outside the cluster, e.g. of type LoadBalancer,
+	// their external addresses are read from the service status.
+	Listeners []string `json:"listener"`
 }
 
 func (v SecretVolumeSelector) ToMap() map[string]string {
@@ -137,9 +375,18 @@ func (v SecretVolumeSelector) ToMap() map[string]string {
 	if v.Provisioner != "" {
 		out[StorageKubernetesCSIProvisionerIdentity] = v.Provisioner
 	}
+	if v.PVCName != "" {
+		out[CSIStoragePVCName] = v.PVCName
+	}
+	if v.PVCNamespace != "" {
+		out[CSIStoragePVCNamespace] = v.PVCNamespace
+	}
 	if v.Class != "" {
 		out[SecretsZncdataClass] = v.Class
 	}
+	if len(v.Classes) > 0 {
+		out[SecretsZncdataClasses] = strings.Join(v.Classes, ",")
+	}
 	if v.encodeScope() != "" {
 		out[SecretsZncdataScope] = v.encodeScope()
 	}
@@ -149,6 +396,9 @@ func (v SecretVolumeSelector) ToMap() map[string]string {
 	if len(v.KerberosRealms) > 0 {
 		out[SecretsZncdataKerberosRealms] = strings.Join(v.KerberosRealms, KerberosRealmsSplitter)
 	}
+	if len(v.KerberosServiceNames) > 0 {
+		out[SecretsZncdataKerberosServiceNames] = strings.Join(v.KerberosServiceNames, ",")
+	}
 	if v.TlsPKCS12Password != "" {
 		out[PKCS12Password] = v.TlsPKCS12Password
 	}
@@ -156,7 +406,92 @@ func (v SecretVolumeSelector) ToMap() map[string]string {
 		out[CertLifeTime] = v.AutoTlsCertLifetime.String()
 	}
 	if v.AutoTlsCertJitterFactor != 0 {
-		out[CertJitterFactor] = fmt.Sprintf("%f", v.AutoTlsCertJitterFactor)
+		out[CertJitterFactor] = strconv.FormatFloat(v.AutoTlsCertJitterFactor, 'f', -1, 64)
+	}
+	if v.AutoTls != "" {
+		out[AutoTls] = string(v.AutoTls)
+	}
+	if v.AutoTlsSpiffe {
+		out[AutoTlsSpiffe] = strconv.FormatBool(v.AutoTlsSpiffe)
+	}
+	if len(v.AutoTlsSANs) > 0 {
+		out[AutoTlsSANs] = strings.Join(v.AutoTlsSANs, ",")
+	}
+	if v.TTL != 0 {
+		out[TTL] = v.TTL.String()
+	}
+	if v.SizeLimit != nil {
+		out[SizeLimit] = v.SizeLimit.String()
+	}
+	if v.Mode != 0 {
+		out[Mode] = fmt.Sprintf("%04o", uint32(v.Mode))
+	}
+	if v.DirMode != 0 {
+		out[DirMode] = fmt.Sprintf("%04o", uint32(v.DirMode))
+	}
+	if v.UID != nil {
+		out[UID] = strconv.FormatInt(*v.UID, 10)
+	}
+	if v.GID != nil {
+		out[GID] = strconv.FormatInt(*v.GID, 10)
+	}
+	if v.ItemPath != "" {
+		out[ItemPath] = v.ItemPath
+	}
+	if len(v.TLSPEMFiles) > 0 {
+		out[TLSPEMFiles] = strings.Join(v.TLSPEMFiles, ",")
+	}
+	if len(v.Items) > 0 {
+		out[Items] = encodeItems(v.Items)
+	}
+	if v.EmitMetadata {
+		out[EmitMetadata] = strconv.FormatBool(v.EmitMetadata)
+	}
+	if len(v.GzipKeys) > 0 {
+		out[GzipKeys] = strings.Join(v.GzipKeys, ",")
+	}
+	if v.NoCache {
+		out[NoCache] = strconv.FormatBool(v.NoCache)
+	}
+	if v.Decode != "" {
+		out[Decode] = string(v.Decode)
+	}
+	if len(v.DecodeKeys) > 0 {
+		out[DecodeKeys] = strings.Join(v.DecodeKeys, ",")
+	}
+	if len(v.FIFOKeys) > 0 {
+		out[FIFOKeys] = strings.Join(v.FIFOKeys, ",")
+	}
+	if len(v.ConcatKeys) > 0 {
+		out[ConcatKeys] = strings.Join(v.ConcatKeys, ",")
+	}
+	if v.ConcatFile != "" {
+		out[ConcatFile] = v.ConcatFile
+	}
+	if v.ConcatSeparator != "" {
+		quoted := strconv.Quote(v.ConcatSeparator)
+		out[ConcatSeparator] = quoted[1 : len(quoted)-1]
+	}
+	if v.Template != "" {
+		out[Template] = v.Template
+	}
+	if v.TemplateFile != "" {
+		out[TemplateFile] = v.TemplateFile
+	}
+	if v.TemplateConfigMap != "" {
+		out[TemplateConfigMap] = v.TemplateConfigMap
+	}
+	if v.KeyCase != "" {
+		out[KeyCase] = string(v.KeyCase)
+	}
+	if v.KeyPrefix != "" {
+		out[KeyPrefix] = v.KeyPrefix
+	}
+	if v.KeySuffix != "" {
+		out[KeySuffix] = v.KeySuffix
+	}
+	if v.TrailingNewline != "" {
+		out[TrailingNewline] = string(v.TrailingNewline)
 	}
 	return out
 }
@@ -179,32 +514,62 @@ func (v SecretVolumeSelector) encodeScope() string {
 			scopes = append(scopes, fmt.Sprintf("%s=%s", ScopeListenerVolume, listenerVolume))
 		}
 	}
+	for _, listener := range v.Scope.Listeners {
+		scopes = append(scopes, fmt.Sprintf("%s=%s", ScopeListener, listener))
+	}
 	return strings.Join(scopes, ",")
 }
 
-func (v SecretVolumeSelector) decodeScope(scope string) SecretScope {
+// decodeScope parses the compound scope string, e.g. "pod,node,service=foo,bar".
+// Scopes are separated by comma. A token without "=" following a service, listener-volume or listener scope
+// continues the list of the previous scope, so "service=foo,bar" means the services foo and bar.
+// Unknown scopes are skipped.
+func (v SecretVolumeSelector) decodeScope(scope string) (SecretScope, error) {
 	secretScope := SecretScope{}
 
-	for _, scopes := range strings.Split(scope, ",") {
-		kv := strings.Split(scopes, "=")
-		switch kv[0] {
+	lastKey := ""
+	for _, token := range strings.Split(scope, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+
+		key, value, hasValue := strings.Cut(token, "=")
+		if !hasValue && key != string(ScopePod) && key != string(ScopeNode) &&
+			(lastKey == ScopeService || lastKey == ScopeListenerVolume || lastKey == ScopeListener) {
+			key, value, hasValue = lastKey, token, true
+		}
+
+		switch key {
 		case string(ScopePod):
 			secretScope.Pod = ScopePod
 		case string(ScopeNode):
 			secretScope.Node = ScopeNode
-		case ScopeService:
-			secretScope.Services = append(secretScope.Services, kv[1])
-		case ScopeListenerVolume:
-			secretScope.ListenerVolumes = append(secretScope.ListenerVolumes, kv[1])
+		case ScopeService, ScopeListenerVolume, ScopeListener:
+			if !hasValue || value == "" {
+				return SecretScope{}, fmt.Errorf("invalid %s %q: %s scope requires a name, e.g. %s=foo", SecretsZncdataScope, scope, key, key)
+			}
+			switch key {
+			case ScopeService:
+				secretScope.Services = append(secretScope.Services, value)
+			case ScopeListenerVolume:
+				secretScope.ListenerVolumes = append(secretScope.ListenerVolumes, value)
+			default:
+				secretScope.Listeners = append(secretScope.Listeners, value)
+			}
 		default:
-			logger.V(0).Info("Unknown scope, skip it", "scope name", kv[0], "scope value", kv[1])
+			logger.V(0).Info("Unknown scope, skip it", "scope name", key, "scope value", value)
 		}
+		lastKey = key
 	}
-	return secretScope
+	return secretScope, nil
 }
 
+// NewVolumeSelectorFromMap parses the volume context, the unknown keys are skipped.
+// The class falls back to SecretClassName when neither class nor classes is set.
 func NewVolumeSelectorFromMap(parameters map[string]string) (*SecretVolumeSelector, error) {
 	v := &SecretVolumeSelector{}
+	var fallbackClass string
 	for key, value := range parameters {
 		switch key {
 		case CSIStoragePodName:
@@ -219,14 +584,36 @@ func NewVolumeSelectorFromMap(parameters map[string]string) (*SecretVolumeSelect
 			v.Ephemeral = value
 		case StorageKubernetesCSIProvisionerIdentity:
 			v.Provisioner = value
+		case CSIStoragePVCName:
+			v.PVCName = value
+		case CSIStoragePVCNamespace:
+			v.PVCNamespace = value
 		case SecretsZncdataClass:
 			v.Class = value
+		case SecretClassName:
+			fallbackClass = value
+		case SecretsZncdataClasses:
+			classes, err := parseClasses(value)
+			if err != nil {
+				return nil, err
+			}
+			v.Classes = classes
 		case SecretsZncdataScope:
-			v.Scope = v.decodeScope(value)
+			scope, err := v.decodeScope(value)
+			if err != nil {
+				return nil, err
+			}
+			v.Scope = scope
 		case SecretsZncdataFormat:
 			v.Format = SecretFormat(value)
 		case SecretsZncdataKerberosRealms:
 			v.KerberosRealms = strings.Split(value, KerberosRealmsSplitter)
+		case SecretsZncdataKerberosServiceNames:
+			names, err := parseKerberosServiceNames(value)
+			if err != nil {
+				return nil, err
+			}
+			v.KerberosServiceNames = names
 		case PKCS12Password:
 			v.TlsPKCS12Password = value
 		case CertLifeTime:
@@ -235,15 +622,507 @@ func NewVolumeSelectorFromMap(parameters map[string]string) (*SecretVolumeSelect
 				return nil, err
 			}
 			v.AutoTlsCertLifetime = d
+		case TTL:
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", TTL, value, err)
+			}
+			if d <= 0 {
+				return nil, fmt.Errorf("invalid %s %q: must be greater than zero", TTL, value)
+			}
+			v.TTL = d
 		case CertJitterFactor:
-			i, err := strconv.ParseInt(value, 10, 64)
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", CertJitterFactor, value, err)
+			}
+			if f < 0 || f >= 1 {
+				return nil, fmt.Errorf("invalid %s %q: must be in [0, 1)", CertJitterFactor, value)
+			}
+			v.AutoTlsCertJitterFactor = f
+		case AutoTls:
+			if AutoTlsMode(value) != AutoTlsModeCAOnly {
+				return nil, fmt.Errorf("invalid %s %q: must be %q", AutoTls, value, AutoTlsModeCAOnly)
+			}
+			v.AutoTls = AutoTlsMode(value)
+		case AutoTlsSpiffe:
+			spiffe, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", AutoTlsSpiffe, value, err)
+			}
+			v.AutoTlsSpiffe = spiffe
+		case AutoTlsSANs:
+			sans, err := parseSANs(value)
+			if err != nil {
+				return nil, err
+			}
+			v.AutoTlsSANs = sans
+		case SizeLimit:
+			q, err := resource.ParseQuantity(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", SizeLimit, value, err)
+			}
+			if q.Sign() <= 0 {
+				return nil, fmt.Errorf("invalid %s %q: must be greater than zero", SizeLimit, value)
+			}
+			v.SizeLimit = &q
+		case Mode:
+			mode, err := parseFileMode(Mode, value)
+			if err != nil {
+				return nil, err
+			}
+			v.Mode = mode
+		case DirMode:
+			mode, err := parseFileMode(DirMode, value)
+			if err != nil {
+				return nil, err
+			}
+			v.DirMode = mode
+		case UID:
+			id, err := parseID(UID, value)
+			if err != nil {
+				return nil, err
+			}
+			v.UID = &id
+		case GID:
+			id, err := parseID(GID, value)
 			if err != nil {
 				return nil, err
 			}
-			v.AutoTlsCertJitterFactor = float64(i)
+			v.GID = &id
+		case ItemPath:
+			if err := validateItemPath(value); err != nil {
+				return nil, err
+			}
+			v.ItemPath = value
+		case TLSPEMFiles:
+			files, err := parseTLSPEMFiles(value)
+			if err != nil {
+				return nil, err
+			}
+			v.TLSPEMFiles = files
+		case Items:
+			items, err := parseItems(value)
+			if err != nil {
+				return nil, err
+			}
+			v.Items = items
+		case EmitMetadata:
+			emit, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", EmitMetadata, value, err)
+			}
+			v.EmitMetadata = emit
+		case NoCache:
+			noCache, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", NoCache, value, err)
+			}
+			v.NoCache = noCache
+		case GzipKeys:
+			keys, err := parseKeys(GzipKeys, value)
+			if err != nil {
+				return nil, err
+			}
+			v.GzipKeys = keys
+		case Decode:
+			if DecodeMode(value) != DecodeBase64 {
+				return nil, fmt.Errorf("invalid %s %q: must be %q", Decode, value, DecodeBase64)
+			}
+			v.Decode = DecodeMode(value)
+		case DecodeKeys:
+			keys, err := parseKeys(DecodeKeys, value)
+			if err != nil {
+				return nil, err
+			}
+			v.DecodeKeys = keys
+		case FIFOKeys:
+			keys, err := parseKeys(FIFOKeys, value)
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range keys {
+				if !isFileName(key) {
+					return nil, fmt.Errorf("invalid %s %q: key %q must be a file name in the volume", FIFOKeys, value, key)
+				}
+			}
+			v.FIFOKeys = keys
+		case ConcatKeys:
+			keys, err := parseKeys(ConcatKeys, value)
+			if err != nil {
+				return nil, err
+			}
+			v.ConcatKeys = keys
+		case ConcatFile:
+			if !isFileName(value) {
+				return nil, fmt.Errorf("invalid %s %q: must be a file name in the volume", ConcatFile, value)
+			}
+			v.ConcatFile = value
+		case ConcatSeparator:
+			separator, err := strconv.Unquote(`"` + value + `"`)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", ConcatSeparator, value, err)
+			}
+			if separator == "" {
+				return nil, fmt.Errorf("invalid %s %q: must not be empty", ConcatSeparator, value)
+			}
+			v.ConcatSeparator = separator
+		case Template:
+			v.Template = value
+		case TemplateFile:
+			if !isFileName(value) {
+				return nil, fmt.Errorf("invalid %s %q: must be a file name in the volume", TemplateFile, value)
+			}
+			v.TemplateFile = value
+		case TemplateConfigMap:
+			v.TemplateConfigMap = value
+		case KeyCase:
+			if KeyCaseMode(value) != KeyCaseLower && KeyCaseMode(value) != KeyCaseUpper {
+				return nil, fmt.Errorf("invalid %s %q: must be %q or %q", KeyCase, value, KeyCaseLower, KeyCaseUpper)
+			}
+			v.KeyCase = KeyCaseMode(value)
+		case KeyPrefix, KeySuffix:
+			if strings.ContainsRune(value, filepath.Separator) {
+				return nil, fmt.Errorf("invalid %s %q: must not contain %q", key, value, filepath.Separator)
+			}
+			if key == KeyPrefix {
+				v.KeyPrefix = value
+			} else {
+				v.KeySuffix = value
+			}
+		case TrailingNewline:
+			switch mode := TrailingNewlineMode(value); mode {
+			case TrailingNewlineStrip, TrailingNewlineEnsure, TrailingNewlinePreserve:
+				v.TrailingNewline = mode
+			default:
+				return nil, fmt.Errorf("invalid %s %q: must be %q, %q or %q",
+					TrailingNewline, value, TrailingNewlineStrip, TrailingNewlineEnsure, TrailingNewlinePreserve)
+			}
 		default:
 			logger.V(0).Info("Unknown key, skip it", "key", key, "value", value)
 		}
 	}
+	if fallbackClass != "" && v.Class == "" && len(v.Classes) == 0 {
+		logger.V(1).Info("Secret class is not annotated, use the fallback", "key", SecretClassName, "class", fallbackClass)
+		v.Class = fallbackClass
+	}
+	if err := v.Validate(); err != nil {
+		return nil, err
+	}
 	return v, nil
 }
+
+// knownFormats are the formats the csi driver can write, tls-pkcs12 is normalized to tls-p12 by Formats.
+var knownFormats = []SecretFormat{
+	SecretFormatTLSPEM, SecretFormatTLSP12, SecretFormatTLSJKS, SecretFormatKerberos,
+	SecretFormatEnv, SecretFormatJSON, SecretFormatFIFO, SecretFormatConcat,
+}
+
+// Validate rejects the options of the volume which contradict each other, instead of ignoring one of them
+// or failing later with a confusing error:
+//   - class and classes
+//   - an unknown format
+//   - template without templateFile, and the reverse
+//   - items with template or templateConfigMap, the items would select among the rendered files and the data
+//   - decode without decodeKeys, and the reverse
+//   - the fifo format without fifoKeys, and the reverse
+//   - concatKeys, concatFile or concatSeparator without the concat format
+//   - tlsPEMFiles without the tls-pem format, when other formats are set
+//   - tlsPKCS12Password without the tls-p12 or tls-jks format
+//   - autoTls caOnly with the options of the issued certificate, autoTlsCertLifetime, autoTlsCertJitterFactor
+//     and autoTlsSpiffe
+//
+// The options which depend on the backend of the secret classes, e.g. the tls formats, are checked by the csi
+// driver once the secret classes are known.
+func (v *SecretVolumeSelector) Validate() error {
+	if v.Class != "" && len(v.Classes) > 0 {
+		return fmt.Errorf("%s and %s can not be used together", SecretsZncdataClass, SecretsZncdataClasses)
+	}
+	formats := v.Formats()
+	for _, format := range formats {
+		if !slices.Contains(knownFormats, format) {
+			return fmt.Errorf("invalid %s %q: unknown format %q, must be one of %v", SecretsZncdataFormat, v.Format, format, knownFormats)
+		}
+	}
+	if (v.Template == "") != (v.TemplateFile == "") {
+		return fmt.Errorf("%s and %s must be used together", Template, TemplateFile)
+	}
+	if len(v.Items) > 0 && (v.Template != "" || v.TemplateConfigMap != "") {
+		return fmt.Errorf("%s can not be used with %s or %s", Items, Template, TemplateConfigMap)
+	}
+	if (v.Decode == "") != (len(v.DecodeKeys) == 0) {
+		return fmt.Errorf("%s and %s must be used together", Decode, DecodeKeys)
+	}
+	if slices.Contains(formats, SecretFormatFIFO) != (len(v.FIFOKeys) > 0) {
+		return fmt.Errorf("%s %s and %s must be used together", SecretsZncdataFormat, SecretFormatFIFO, FIFOKeys)
+	}
+	if (len(v.ConcatKeys) > 0 || v.ConcatFile != "" || v.ConcatSeparator != "") && !slices.Contains(formats, SecretFormatConcat) {
+		return fmt.Errorf("%s, %s and %s require the %s format", ConcatKeys, ConcatFile, ConcatSeparator, SecretFormatConcat)
+	}
+	// the volume without format converts the tls material to PEM too
+	converted := slices.DeleteFunc(slices.Clone(formats), func(format SecretFormat) bool { return format == SecretFormatFIFO })
+	if len(v.TLSPEMFiles) > 0 && len(converted) > 0 && !slices.Contains(converted, SecretFormatTLSPEM) {
+		return fmt.Errorf("%s requires the %s format, got %s %q", TLSPEMFiles, SecretFormatTLSPEM, SecretsZncdataFormat, v.Format)
+	}
+	if v.TlsPKCS12Password != "" && !slices.Contains(formats, SecretFormatTLSP12) && !slices.Contains(formats, SecretFormatTLSJKS) {
+		return fmt.Errorf("%s requires the %s or %s format, got %s %q",
+			PKCS12Password, SecretFormatTLSP12, SecretFormatTLSJKS, SecretsZncdataFormat, v.Format)
+	}
+	if v.AutoTls == AutoTlsModeCAOnly && (v.AutoTlsCertLifetime != 0 || v.AutoTlsCertJitterFactor != 0 || v.AutoTlsSpiffe || len(v.AutoTlsSANs) > 0) {
+		return fmt.Errorf("%s %s issues no certificate, %s, %s, %s and %s can not be used",
+			AutoTls, AutoTlsModeCAOnly, CertLifeTime, CertJitterFactor, AutoTlsSpiffe, AutoTlsSANs)
+	}
+	return nil
+}
+
+// parseClasses parses the comma separated list of secret classes, each class must be listed once.
+func parseClasses(value string) ([]string, error) {
+	var classes []string
+	for _, class := range strings.Split(value, ",") {
+		class = strings.TrimSpace(class)
+		if class == "" {
+			return nil, fmt.Errorf("invalid %s %q: empty secret class", SecretsZncdataClasses, value)
+		}
+		if slices.Contains(classes, class) {
+			return nil, fmt.Errorf("invalid %s %q: secret class %q is listed more than once", SecretsZncdataClasses, value, class)
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+// Formats returns the comma separated formats of the volume, the aliases are replaced and listed once.
+// It is empty when no format is set.
+func (v SecretVolumeSelector) Formats() []SecretFormat {
+	var formats []SecretFormat
+	for _, token := range strings.Split(string(v.Format), ",") {
+		format := SecretFormat(strings.TrimSpace(token))
+		if format == SecretFormatTLSPKCS12 {
+			format = SecretFormatTLSP12
+		}
+		if format != "" && !slices.Contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// parseKerberosServiceNames parses the comma separated service names, the duplicates are dropped.
+// A service name is the first component of the principals, so it must not contain "/" or "@".
+func parseKerberosServiceNames(value string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, "/@") {
+			return nil, fmt.Errorf("invalid %s %q: service name %q must be non empty without \"/\" or \"@\"",
+				SecretsZncdataKerberosServiceNames, value, name)
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// parseSANs parses the comma separated DNS names and IP addresses of AutoTlsSANs, the duplicates are dropped.
+// The DNS names are lower cased, and the IP addresses are in their canonical form, e.g. "::1".
+func parseSANs(value string) ([]string, error) {
+	var sans []string
+	for _, san := range strings.Split(value, ",") {
+		san = strings.TrimSpace(san)
+		if ip := net.ParseIP(san); ip != nil {
+			san = ip.String()
+		} else {
+			san = strings.ToLower(san)
+			if errs := validation.IsDNS1123Subdomain(san); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid %s %q: %q is neither an IP address nor a DNS name: %s",
+					AutoTlsSANs, value, san, strings.Join(errs, ", "))
+			}
+		}
+		if !slices.Contains(sans, san) {
+			sans = append(sans, san)
+		}
+	}
+	return sans, nil
+}
+
+// parseKeys parses the comma separated keys of the volume context key name, the duplicates are dropped.
+func parseKeys(name, value string) ([]string, error) {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("invalid %s %q: empty key", name, value)
+		}
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// secretsZncdataPrefix is the prefix of the volume context keys of the csi driver.
+const secretsZncdataPrefix = "secrets.zncdata.dev/"
+
+// ValidateDefaults checks the volume defaults of a secret class, the keys must be volume context keys of the
+// csi driver other than the classes, and the values must be valid on their own.
+func ValidateDefaults(defaults map[string]string) error {
+	for key := range defaults {
+		if !strings.HasPrefix(key, secretsZncdataPrefix) {
+			return fmt.Errorf("volume default %q is not a %s key", key, secretsZncdataPrefix)
+		}
+		if key == SecretsZncdataClass || key == SecretsZncdataClasses {
+			return fmt.Errorf("volume default %q can not be set by a secret class", key)
+		}
+	}
+	if _, err := NewVolumeSelectorFromMap(defaults); err != nil {
+		return fmt.Errorf("invalid volume defaults: %w", err)
+	}
+	return nil
+}
+
+// MergeDefaults returns the parameters with the defaults of the keys they do not set, the parameters win.
+// The defaults are applied in order, the first one setting a key wins.
+func MergeDefaults(parameters map[string]string, defaults ...map[string]string) map[string]string {
+	merged := make(map[string]string, len(parameters))
+	for key, value := range parameters {
+		merged[key] = value
+	}
+	for _, d := range defaults {
+		for key, value := range d {
+			if _, ok := merged[key]; !ok {
+				merged[key] = value
+			}
+		}
+	}
+	return merged
+}
+
+// SecretClasses returns the secret classes of the volume, either the classes or the single class.
+func (v SecretVolumeSelector) SecretClasses() []string {
+	if len(v.Classes) > 0 {
+		return v.Classes
+	}
+	if v.Class != "" {
+		return []string{v.Class}
+	}
+	return nil
+}
+
+// parseFileMode parses an octal permission string, e.g. "0400".
+// Only permission bits are allowed, and the mode must not be zero.
+func parseFileMode(key, value string) (fs.FileMode, error) {
+	m, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	mode := fs.FileMode(m)
+	if mode == 0 || mode&^fs.ModePerm != 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be between 0001 and 0777", key, value)
+	}
+	return mode, nil
+}
+
+// parseID parses a non-negative user or group id.
+func parseID(key, value string) (int64, error) {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	if id < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must not be negative", key, value)
+	}
+	return id, nil
+}
+
+// IsEphemeral returns whether the volume is an inline ephemeral volume.
+// For inline ephemeral volumes, the volume context is the volumeAttributes of the csi volume in the pod spec,
+// otherwise it is delivered from the PVC annotations by the controller.
+func (v SecretVolumeSelector) IsEphemeral() bool {
+	ephemeral, err := strconv.ParseBool(v.Ephemeral)
+	return err == nil && ephemeral
+}
+
+// MissingAttributes returns the required keys which are missing in the volume context.
+func (v SecretVolumeSelector) MissingAttributes() []string {
+	var missing []string
+	if len(v.SecretClasses()) == 0 {
+		missing = append(missing, SecretsZncdataClass)
+	}
+	if v.Pod == "" {
+		missing = append(missing, CSIStoragePodName)
+	}
+	if v.PodNamespace == "" {
+		missing = append(missing, CSIStoragePodNamespace)
+	}
+	return missing
+}
+
+// validateItemPath checks the item path is a relative path and does not escape the volume.
+func validateItemPath(value string) error {
+	if filepath.IsAbs(value) {
+		return fmt.Errorf("invalid %s %q: must be a relative path", ItemPath, value)
+	}
+	cleaned := filepath.Clean(value)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("invalid %s %q: must be a subdirectory of the volume", ItemPath, value)
+	}
+	return nil
+}
+
+// parseTLSPEMFiles parses the comma separated list of the tls-pem files, and checks they are supported.
+func parseTLSPEMFiles(value string) ([]string, error) {
+	var files []string
+	for _, file := range strings.Split(value, ",") {
+		file = strings.TrimSpace(file)
+		if !slices.Contains(TLSPEMFileNames, file) {
+			return nil, fmt.Errorf("invalid %s %q: %q is not one of %v", TLSPEMFiles, value, file, TLSPEMFileNames)
+		}
+		if !slices.Contains(files, file) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// parseItems parses the comma separated list of "<key>[:<path>]", the path defaults to the key.
+// The path is a file name in the volume, two keys must not be written to the same path.
+func parseItems(value string) ([]SecretItem, error) {
+	var items []SecretItem
+	for _, token := range strings.Split(value, ",") {
+		key, path, hasPath := strings.Cut(strings.TrimSpace(token), ":")
+		key, path = strings.TrimSpace(key), strings.TrimSpace(path)
+		if key == "" {
+			return nil, fmt.Errorf("invalid %s %q: empty key", Items, value)
+		}
+		if !hasPath {
+			path = key
+		}
+		if !isFileName(path) {
+			return nil, fmt.Errorf("invalid %s %q: path %q of key %q must be a file name in the volume", Items, value, path, key)
+		}
+		if slices.ContainsFunc(items, func(item SecretItem) bool { return item.Path == path }) {
+			return nil, fmt.Errorf("invalid %s %q: path %q is written more than once", Items, value, path)
+		}
+		items = append(items, SecretItem{Key: key, Path: path})
+	}
+	return items, nil
+}
+
+// isFileName returns whether the path is a file name in the volume, not in a directory nor reserved,
+// e.g. "..data" of the atomic writer.
+func isFileName(path string) bool {
+	return path != "" && path != "." && !strings.HasPrefix(path, "..") && !strings.ContainsRune(path, filepath.Separator)
+}
+
+func encodeItems(items []SecretItem) string {
+	tokens := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.Path == item.Key {
+			tokens = append(tokens, item.Key)
+		} else {
+			tokens = append(tokens, item.Key+":"+item.Path)
+		}
+	}
+	return strings.Join(tokens, ",")
+}
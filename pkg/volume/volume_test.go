@@ -4,6 +4,8 @@ import (
 	"reflect"
 	"testing"
 	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 func TestSecretVolumeSelectorToMap(t *testing.T) {
@@ -27,6 +29,8 @@ func TestSecretVolumeSelectorToMap(t *testing.T) {
 				ServiceAccountName: "my-service-account",
 				Ephemeral:          "true",
 				Provisioner:        "my-provisioner",
+				PVCName:            "my-pvc",
+				PVCNamespace:       "my-namespace",
 				Class:              "my-class",
 				Scope: SecretScope{
 					Pod:             ScopePod,
@@ -34,10 +38,33 @@ func TestSecretVolumeSelectorToMap(t *testing.T) {
 					Services:        []string{"my-service"},
 					ListenerVolumes: []string{"my-listener-volume"},
 				},
-				Format:              "tls-pem",
-				TlsPKCS12Password:   "my-password",
-				KerberosRealms:      []string{"realm1", "realm2"},
-				AutoTlsCertLifetime: 24 * time.Hour,
+				Format:                  "tls-pem",
+				TlsPKCS12Password:       "my-password",
+				KerberosRealms:          []string{"realm1", "realm2"},
+				KerberosServiceNames:    []string{"HTTP", "hdfs"},
+				AutoTlsCertLifetime:     24 * time.Hour,
+				AutoTlsCertJitterFactor: 0.2,
+				AutoTls:                 AutoTlsModeCAOnly,
+				AutoTlsSpiffe:           true,
+				AutoTlsSANs:             []string{"a.example.com", "10.0.0.5"},
+				TTL:                     time.Hour,
+				Items:                   []SecretItem{{Key: "tls.crt", Path: "cert.pem"}, {Key: "ca.crt", Path: "ca.crt"}},
+				EmitMetadata:            true,
+				GzipKeys:                []string{"config.json", "data.bin"},
+				Decode:                  DecodeBase64,
+				DecodeKeys:              []string{"keystore"},
+				FIFOKeys:                []string{"token"},
+				ConcatKeys:              []string{"alice.pub"},
+				ConcatFile:              "authorized_keys",
+				ConcatSeparator:         "\r\n",
+				NoCache:                 true,
+				Template:                "{{ .username }}",
+				TemplateFile:            "user.txt",
+				TemplateConfigMap:       "templates",
+				KeyCase:                 KeyCaseLower,
+				KeyPrefix:               "app-",
+				KeySuffix:               ".pem",
+				TrailingNewline:         TrailingNewlineStrip,
 			},
 			want: map[string]string{
 				CSIStoragePodName:                       "my-pod",
@@ -46,10 +73,37 @@ func TestSecretVolumeSelectorToMap(t *testing.T) {
 				CSIStorageServiceAccountName:            "my-service-account",
 				CSIStorageEphemeral:                     "true",
 				StorageKubernetesCSIProvisionerIdentity: "my-provisioner",
+				CSIStoragePVCName:                       "my-pvc",
+				CSIStoragePVCNamespace:                  "my-namespace",
 				SecretsZncdataClass:                     "my-class",
 				SecretsZncdataScope:                     "pod,node,service=my-service,listener-volume=my-listener-volume",
 				SecretsZncdataFormat:                    "tls-pem",
 				SecretsZncdataKerberosRealms:            "realm1,realm2",
+				SecretsZncdataKerberosServiceNames:      "HTTP,hdfs",
+				PKCS12Password:                          "my-password",
+				CertLifeTime:                            "24h0m0s",
+				CertJitterFactor:                        "0.2",
+				AutoTls:                                 "caOnly",
+				AutoTlsSpiffe:                           "true",
+				AutoTlsSANs:                             "a.example.com,10.0.0.5",
+				TTL:                                     "1h0m0s",
+				Items:                                   "tls.crt:cert.pem,ca.crt",
+				EmitMetadata:                            "true",
+				GzipKeys:                                "config.json,data.bin",
+				Decode:                                  "base64",
+				DecodeKeys:                              "keystore",
+				FIFOKeys:                                "token",
+				ConcatKeys:                              "alice.pub",
+				ConcatFile:                              "authorized_keys",
+				ConcatSeparator:                         `\r\n`,
+				NoCache:                                 "true",
+				Template:                                "{{ .username }}",
+				TemplateFile:                            "user.txt",
+				TemplateConfigMap:                       "templates",
+				KeyCase:                                 "lower",
+				KeyPrefix:                               "app-",
+				KeySuffix:                               ".pem",
+				TrailingNewline:                         "strip",
 			},
 		},
 		{
@@ -102,6 +156,13 @@ func TestNewVolumeSelectorFromMap(t *testing.T) {
 				SecretsZncdataScope:                     "pod,node,service=my-service,listener-volume=my-listener-volume",
 				SecretsZncdataFormat:                    "tls-pem",
 				SecretsZncdataKerberosRealms:            "realm1,realm2",
+				SecretsZncdataKerberosServiceNames:      "HTTP, hdfs,HTTP",
+				GzipKeys:                                "config.json, config.json,data.bin",
+				Decode:                                  "base64",
+				DecodeKeys:                              "keystore, password",
+				TTL:                                     "30m",
+				NoCache:                                 "true",
+				AutoTlsSpiffe:                           "true",
 			},
 			expected: &SecretVolumeSelector{
 				Pod:                "my-pod",
@@ -117,8 +178,193 @@ func TestNewVolumeSelectorFromMap(t *testing.T) {
 					Services:        []string{"my-service"},
 					ListenerVolumes: []string{"my-listener-volume"},
 				},
-				Format:         "tls-pem",
-				KerberosRealms: []string{"realm1", "realm2"},
+				Format:               "tls-pem",
+				KerberosRealms:       []string{"realm1", "realm2"},
+				KerberosServiceNames: []string{"HTTP", "hdfs"},
+				GzipKeys:             []string{"config.json", "data.bin"},
+				Decode:               DecodeBase64,
+				DecodeKeys:           []string{"keystore", "password"},
+				TTL:                  30 * time.Minute,
+				NoCache:              true,
+				AutoTlsSpiffe:        true,
+			},
+		},
+		{
+			name: "template",
+			parameters: map[string]string{
+				Template:          "postgres://{{ .username }}:{{ .password }}@db:5432/app",
+				TemplateFile:      "dsn",
+				TemplateConfigMap: "templates",
+			},
+			expected: &SecretVolumeSelector{
+				Template:          "postgres://{{ .username }}:{{ .password }}@db:5432/app",
+				TemplateFile:      "dsn",
+				TemplateConfigMap: "templates",
+			},
+		},
+		{
+			name: "fifo",
+			parameters: map[string]string{
+				SecretsZncdataFormat: "tls-pem,fifo",
+				FIFOKeys:             "tls.key, token",
+			},
+			expected: &SecretVolumeSelector{
+				Format:   "tls-pem,fifo",
+				FIFOKeys: []string{"tls.key", "token"},
+			},
+		},
+		{
+			name: "concat",
+			parameters: map[string]string{
+				SecretsZncdataFormat: "concat",
+				ConcatKeys:           "bob.pub, alice.pub",
+				ConcatFile:           "known_hosts",
+				ConcatSeparator:      `\n\n`,
+			},
+			expected: &SecretVolumeSelector{
+				Format:          "concat",
+				ConcatKeys:      []string{"bob.pub", "alice.pub"},
+				ConcatFile:      "known_hosts",
+				ConcatSeparator: "\n\n",
+			},
+		},
+		{
+			name: "key-normalization",
+			parameters: map[string]string{
+				KeyCase:   "upper",
+				KeyPrefix: "APP_",
+				KeySuffix: ".txt",
+			},
+			expected: &SecretVolumeSelector{
+				KeyCase:   KeyCaseUpper,
+				KeyPrefix: "APP_",
+				KeySuffix: ".txt",
+			},
+		},
+		{
+			name:       "class",
+			parameters: map[string]string{SecretsZncdataClass: "tls", SecretClassName: "legacy"},
+			expected:   &SecretVolumeSelector{Class: "tls"},
+		},
+		{
+			name:       "class-fallback",
+			parameters: map[string]string{SecretClassName: "legacy"},
+			expected:   &SecretVolumeSelector{Class: "legacy"},
+		},
+		{
+			name:       "classes-without-fallback",
+			parameters: map[string]string{SecretsZncdataClasses: "tls,shared", SecretClassName: "legacy"},
+			expected:   &SecretVolumeSelector{Classes: []string{"tls", "shared"}},
+		},
+		{
+			name:       "auto-tls-sans",
+			parameters: map[string]string{AutoTlsSANs: "A.example.com, 10.0.0.5,a.example.com,::0:1,b-1.example.com"},
+			expected:   &SecretVolumeSelector{AutoTlsSANs: []string{"a.example.com", "10.0.0.5", "::1", "b-1.example.com"}},
+		},
+		{
+			name:       "trailing-newline",
+			parameters: map[string]string{TrailingNewline: "ensure"},
+			expected:   &SecretVolumeSelector{TrailingNewline: TrailingNewlineEnsure},
+		},
+		{
+			name: "mode",
+			parameters: map[string]string{
+				Mode:    "0400",
+				DirMode: "0700",
+			},
+			expected: &SecretVolumeSelector{
+				Mode:    0400,
+				DirMode: 0700,
+			},
+		},
+		{
+			name: "size-limit",
+			parameters: map[string]string{
+				SizeLimit: "16Mi",
+			},
+			expected: &SecretVolumeSelector{
+				SizeLimit: func() *resource.Quantity {
+					q := resource.MustParse("16Mi")
+					return &q
+				}(),
+			},
+		},
+		{
+			name: "scope-node",
+			parameters: map[string]string{
+				SecretsZncdataScope: "node",
+			},
+			expected: &SecretVolumeSelector{
+				Scope: SecretScope{
+					Node: ScopeNode,
+				},
+			},
+		},
+		{
+			name: "cert-jitter-factor",
+			parameters: map[string]string{
+				CertJitterFactor: "0.2",
+			},
+			expected: &SecretVolumeSelector{
+				AutoTlsCertJitterFactor: 0.2,
+			},
+		},
+		{
+			name: "classes",
+			parameters: map[string]string{
+				SecretsZncdataClasses: "tls, shared",
+			},
+			expected: &SecretVolumeSelector{
+				Classes: []string{"tls", "shared"},
+			},
+		},
+		{
+			name: "auto-tls-ca-only",
+			parameters: map[string]string{
+				AutoTls: "caOnly",
+			},
+			expected: &SecretVolumeSelector{
+				AutoTls: AutoTlsModeCAOnly,
+			},
+		},
+		{
+			name: "tls-pem-files",
+			parameters: map[string]string{
+				TLSPEMFiles: "fullchain.pem, privkey.pem,fullchain.pem",
+			},
+			expected: &SecretVolumeSelector{
+				TLSPEMFiles: []string{"fullchain.pem", "privkey.pem"},
+			},
+		},
+		{
+			name: "items",
+			parameters: map[string]string{
+				Items: "tls.crt:cert.pem, tls.key:key.pem,ca.crt",
+			},
+			expected: &SecretVolumeSelector{
+				Items: []SecretItem{
+					{Key: "tls.crt", Path: "cert.pem"},
+					{Key: "tls.key", Path: "key.pem"},
+					{Key: "ca.crt", Path: "ca.crt"},
+				},
+			},
+		},
+		{
+			name: "emit-metadata",
+			parameters: map[string]string{
+				EmitMetadata: "true",
+			},
+			expected: &SecretVolumeSelector{
+				EmitMetadata: true,
+			},
+		},
+		{
+			name: "item-path",
+			parameters: map[string]string{
+				ItemPath: "certs/server",
+			},
+			expected: &SecretVolumeSelector{
+				ItemPath: "certs/server",
 			},
 		},
 	}
@@ -136,3 +382,488 @@ func TestNewVolumeSelectorFromMap(t *testing.T) {
 		})
 	}
 }
+
+func TestNewVolumeSelectorFromMapInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+	}{
+		{
+			name:       "kerberos-service-name-empty",
+			parameters: map[string]string{SecretsZncdataKerberosServiceNames: "HTTP,"},
+		},
+		{
+			name:       "kerberos-service-name-with-host",
+			parameters: map[string]string{SecretsZncdataKerberosServiceNames: "HTTP/host"},
+		},
+		{
+			name:       "gzip-key-empty",
+			parameters: map[string]string{GzipKeys: "config.json,,data.bin"},
+		},
+		{
+			name:       "decode-not-base64",
+			parameters: map[string]string{Decode: "hex", DecodeKeys: "password"},
+		},
+		{
+			name:       "decode-without-keys",
+			parameters: map[string]string{Decode: "base64"},
+		},
+		{
+			name:       "decode-keys-without-decode",
+			parameters: map[string]string{DecodeKeys: "password"},
+		},
+		{
+			name:       "concat-separator-invalid",
+			parameters: map[string]string{SecretsZncdataFormat: "concat", ConcatSeparator: `\x`},
+		},
+		{
+			name:       "concat-separator-empty",
+			parameters: map[string]string{SecretsZncdataFormat: "concat", ConcatSeparator: ""},
+		},
+		{
+			name:       "concat-file-in-directory",
+			parameters: map[string]string{SecretsZncdataFormat: "concat", ConcatFile: "ssh/authorized_keys"},
+		},
+		{
+			name:       "fifo-without-keys",
+			parameters: map[string]string{SecretsZncdataFormat: "fifo"},
+		},
+		{
+			name:       "fifo-keys-without-format",
+			parameters: map[string]string{FIFOKeys: "token"},
+		},
+		{
+			name:       "fifo-key-in-directory",
+			parameters: map[string]string{SecretsZncdataFormat: "fifo", FIFOKeys: "conf/token"},
+		},
+		{
+			name:       "mode-not-octal",
+			parameters: map[string]string{Mode: "0999"},
+		},
+		{
+			name:       "mode-out-of-range",
+			parameters: map[string]string{Mode: "01777"},
+		},
+		{
+			name:       "mode-zero",
+			parameters: map[string]string{Mode: "0"},
+		},
+		{
+			name:       "template-without-file",
+			parameters: map[string]string{Template: "{{ .username }}"},
+		},
+		{
+			name:       "template-file-without-template",
+			parameters: map[string]string{TemplateFile: "dsn"},
+		},
+		{
+			name:       "template-file-in-directory",
+			parameters: map[string]string{Template: "{{ .username }}", TemplateFile: "conf/dsn"},
+		},
+		{
+			name:       "key-case-invalid",
+			parameters: map[string]string{KeyCase: "camel"},
+		},
+		{
+			name:       "auto-tls-sans-invalid-name",
+			parameters: map[string]string{AutoTlsSANs: "a.example.com,bad_name"},
+		},
+		{
+			name:       "auto-tls-sans-empty",
+			parameters: map[string]string{AutoTlsSANs: "a.example.com,"},
+		},
+		{
+			name:       "auto-tls-sans-ca-only",
+			parameters: map[string]string{AutoTls: "caOnly", AutoTlsSANs: "a.example.com"},
+		},
+		{
+			name:       "trailing-newline-invalid",
+			parameters: map[string]string{TrailingNewline: "keep"},
+		},
+		{
+			name:       "key-prefix-directory",
+			parameters: map[string]string{KeyPrefix: "conf/"},
+		},
+		{
+			name:       "dir-mode-special-bits",
+			parameters: map[string]string{DirMode: "02700"},
+		},
+		{
+			name:       "size-limit-invalid",
+			parameters: map[string]string{SizeLimit: "abc"},
+		},
+		{
+			name:       "cert-jitter-factor-invalid",
+			parameters: map[string]string{CertJitterFactor: "abc"},
+		},
+		{
+			name:       "cert-jitter-factor-out-of-range",
+			parameters: map[string]string{CertJitterFactor: "1.5"},
+		},
+		{
+			name:       "ttl-invalid",
+			parameters: map[string]string{TTL: "abc"},
+		},
+		{
+			name:       "ttl-zero",
+			parameters: map[string]string{TTL: "0s"},
+		},
+		{
+			name:       "classes-duplicated",
+			parameters: map[string]string{SecretsZncdataClasses: "tls,shared,tls"},
+		},
+		{
+			name:       "classes-empty",
+			parameters: map[string]string{SecretsZncdataClasses: "tls,,shared"},
+		},
+		{
+			name:       "class-and-classes",
+			parameters: map[string]string{SecretsZncdataClass: "tls", SecretsZncdataClasses: "tls,shared"},
+		},
+		{
+			name:       "auto-tls-unknown",
+			parameters: map[string]string{AutoTls: "keyOnly"},
+		},
+		{
+			name:       "auto-tls-spiffe-invalid",
+			parameters: map[string]string{AutoTlsSpiffe: "yes"},
+		},
+		{
+			name:       "tls-pem-files-unknown",
+			parameters: map[string]string{TLSPEMFiles: "tls.crt,cert.pem"},
+		},
+		{
+			name:       "items-empty-key",
+			parameters: map[string]string{Items: "tls.crt,:key.pem"},
+		},
+		{
+			name:       "items-empty-path",
+			parameters: map[string]string{Items: "tls.crt:"},
+		},
+		{
+			name:       "items-path-in-directory",
+			parameters: map[string]string{Items: "tls.crt:certs/cert.pem"},
+		},
+		{
+			name:       "items-path-reserved",
+			parameters: map[string]string{Items: "tls.crt:..data"},
+		},
+		{
+			name:       "items-path-duplicated",
+			parameters: map[string]string{Items: "tls.crt:cert.pem,ca.crt:cert.pem"},
+		},
+		{
+			name:       "no-cache-invalid",
+			parameters: map[string]string{NoCache: "yes"},
+		},
+		{
+			name:       "emit-metadata-invalid",
+			parameters: map[string]string{EmitMetadata: "yes"},
+		},
+		{
+			name:       "item-path-absolute",
+			parameters: map[string]string{ItemPath: "/etc"},
+		},
+		{
+			name:       "item-path-traversal",
+			parameters: map[string]string{ItemPath: "certs/../../etc"},
+		},
+		{
+			name:       "item-path-root",
+			parameters: map[string]string{ItemPath: "certs/.."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewVolumeSelectorFromMap(tt.parameters); err == nil {
+				t.Errorf("expected error for parameters %v", tt.parameters)
+			}
+		})
+	}
+}
+
+func TestSecretVolumeSelectorValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector SecretVolumeSelector
+		wantErr  bool
+	}{
+		{name: "empty", selector: SecretVolumeSelector{}},
+		{name: "class and classes", selector: SecretVolumeSelector{Class: "tls", Classes: []string{"tls", "shared"}}, wantErr: true},
+		{name: "unknown format", selector: SecretVolumeSelector{Format: "tls-pem,pfx"}, wantErr: true},
+		{name: "pkcs12 alias", selector: SecretVolumeSelector{Format: "tls-pkcs12"}},
+		{name: "template without file", selector: SecretVolumeSelector{Template: "{{ .username }}"}, wantErr: true},
+		{name: "template file without template", selector: SecretVolumeSelector{TemplateFile: "user.txt"}, wantErr: true},
+		{
+			name:     "template and items",
+			selector: SecretVolumeSelector{Template: "{{ .username }}", TemplateFile: "user.txt", Items: []SecretItem{{Key: "user.txt", Path: "user"}}},
+			wantErr:  true,
+		},
+		{
+			name:     "template config map and items",
+			selector: SecretVolumeSelector{TemplateConfigMap: "templates", Items: []SecretItem{{Key: "username"}}},
+			wantErr:  true,
+		},
+		{name: "template config map", selector: SecretVolumeSelector{TemplateConfigMap: "templates"}},
+		{name: "items", selector: SecretVolumeSelector{Items: []SecretItem{{Key: "username", Path: "user"}}}},
+		{name: "decode without keys", selector: SecretVolumeSelector{Decode: DecodeBase64}, wantErr: true},
+		{name: "decode keys without decode", selector: SecretVolumeSelector{DecodeKeys: []string{"password"}}, wantErr: true},
+		{name: "fifo without keys", selector: SecretVolumeSelector{Format: "fifo"}, wantErr: true},
+		{name: "fifo keys without format", selector: SecretVolumeSelector{FIFOKeys: []string{"token"}}, wantErr: true},
+		{name: "concat", selector: SecretVolumeSelector{Format: "concat", ConcatKeys: []string{"alice.pub"}, ConcatFile: "authorized_keys"}},
+		{name: "concat keys without format", selector: SecretVolumeSelector{ConcatKeys: []string{"alice.pub"}}, wantErr: true},
+		{name: "concat separator without format", selector: SecretVolumeSelector{Format: "env", ConcatSeparator: ","}, wantErr: true},
+		{name: "pem files without format", selector: SecretVolumeSelector{TLSPEMFiles: []string{"fullchain.pem"}}},
+		{name: "pem files with fifo", selector: SecretVolumeSelector{Format: "tls-pem,fifo", FIFOKeys: []string{"tls.key"}, TLSPEMFiles: []string{"tls.key"}}},
+		{name: "pem files without pem format", selector: SecretVolumeSelector{Format: "tls-p12", TLSPEMFiles: []string{"fullchain.pem"}}, wantErr: true},
+		{name: "pkcs12 password with jks", selector: SecretVolumeSelector{Format: "tls-jks", TlsPKCS12Password: "changeit"}},
+		{name: "pkcs12 password without store format", selector: SecretVolumeSelector{Format: "tls-pem", TlsPKCS12Password: "changeit"}, wantErr: true},
+		{name: "ca only with lifetime", selector: SecretVolumeSelector{AutoTls: AutoTlsModeCAOnly, AutoTlsCertLifetime: time.Hour}, wantErr: true},
+		{name: "ca only with jitter", selector: SecretVolumeSelector{AutoTls: AutoTlsModeCAOnly, AutoTlsCertJitterFactor: 0.2}, wantErr: true},
+		{name: "ca only with spiffe", selector: SecretVolumeSelector{AutoTls: AutoTlsModeCAOnly, AutoTlsSpiffe: true}, wantErr: true},
+		{name: "ca only with ttl", selector: SecretVolumeSelector{AutoTls: AutoTlsModeCAOnly, TTL: time.Hour}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.selector.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: got %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeScope(t *testing.T) {
+	tests := []struct {
+		name     string
+		scope    string
+		expected SecretScope
+		wantErr  bool
+	}{
+		{
+			name:     "pod",
+			scope:    "pod",
+			expected: SecretScope{Pod: ScopePod},
+		},
+		{
+			name:  "pod-node-service",
+			scope: "pod,node,service=foo",
+			expected: SecretScope{
+				Pod:      ScopePod,
+				Node:     ScopeNode,
+				Services: []string{"foo"},
+			},
+		},
+		{
+			name:  "service-list",
+			scope: "service=foo,bar",
+			expected: SecretScope{
+				Services: []string{"foo", "bar"},
+			},
+		},
+		{
+			name:  "service-list-then-node",
+			scope: "service=foo,bar,node",
+			expected: SecretScope{
+				Node:     ScopeNode,
+				Services: []string{"foo", "bar"},
+			},
+		},
+		{
+			name:  "service-and-listener-volume-list",
+			scope: "service=foo, bar,listener-volume=lv1,lv2,pod",
+			expected: SecretScope{
+				Pod:             ScopePod,
+				Services:        []string{"foo", "bar"},
+				ListenerVolumes: []string{"lv1", "lv2"},
+			},
+		},
+		{
+			name:  "listener-list",
+			scope: "listener=lb,np,node",
+			expected: SecretScope{
+				Node:      ScopeNode,
+				Listeners: []string{"lb", "np"},
+			},
+		},
+		{
+			name:     "unknown",
+			scope:    "unknown,node",
+			expected: SecretScope{Node: ScopeNode},
+		},
+		{
+			name:    "service-without-name",
+			scope:   "service",
+			wantErr: true,
+		},
+		{
+			name:    "service-empty-name",
+			scope:   "pod,service=",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SecretVolumeSelector{}.decodeScope(tt.scope)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error for scope %q", tt.scope)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("unexpected scope: got %+v, want %+v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMissingAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		ephemeral  bool
+		missing    []string
+	}{
+		{
+			name: "persistent",
+			parameters: map[string]string{
+				CSIStoragePodName:      "my-pod",
+				CSIStoragePodNamespace: "my-namespace",
+				SecretsZncdataClass:    "my-class",
+			},
+		},
+		{
+			name: "persistent-missing-class",
+			parameters: map[string]string{
+				CSIStoragePodName:      "my-pod",
+				CSIStoragePodNamespace: "my-namespace",
+			},
+			missing: []string{SecretsZncdataClass},
+		},
+		{
+			name: "persistent-class-fallback",
+			parameters: map[string]string{
+				CSIStoragePodName:      "my-pod",
+				CSIStoragePodNamespace: "my-namespace",
+				SecretClassName:        "my-class",
+			},
+		},
+		{
+			name: "persistent-classes",
+			parameters: map[string]string{
+				CSIStoragePodName:      "my-pod",
+				CSIStoragePodNamespace: "my-namespace",
+				SecretsZncdataClasses:  "tls,shared",
+			},
+		},
+		{
+			name: "ephemeral",
+			parameters: map[string]string{
+				CSIStoragePodName:      "my-pod",
+				CSIStoragePodNamespace: "my-namespace",
+				CSIStorageEphemeral:    "true",
+				SecretsZncdataClass:    "my-class",
+			},
+			ephemeral: true,
+		},
+		{
+			name: "ephemeral-missing-all",
+			parameters: map[string]string{
+				CSIStorageEphemeral: "true",
+			},
+			ephemeral: true,
+			missing:   []string{SecretsZncdataClass, CSIStoragePodName, CSIStoragePodNamespace},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewVolumeSelectorFromMap(tt.parameters)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if v.IsEphemeral() != tt.ephemeral {
+				t.Errorf("unexpected ephemeral: got %v, want %v", v.IsEphemeral(), tt.ephemeral)
+			}
+			if !reflect.DeepEqual(v.MissingAttributes(), tt.missing) {
+				t.Errorf("unexpected missing attributes: got %v, want %v", v.MissingAttributes(), tt.missing)
+			}
+		})
+	}
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   SecretFormat
+		expected []SecretFormat
+	}{
+		{name: "empty"},
+		{name: "single", format: "tls-pem", expected: []SecretFormat{SecretFormatTLSPEM}},
+		{
+			name:     "list",
+			format:   "tls-pem, tls-pkcs12,tls-jks",
+			expected: []SecretFormat{SecretFormatTLSPEM, SecretFormatTLSP12, SecretFormatTLSJKS},
+		},
+		{
+			name:     "alias listed twice",
+			format:   "tls-p12,tls-pkcs12,,tls-pem",
+			expected: []SecretFormat{SecretFormatTLSP12, SecretFormatTLSPEM},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formats := SecretVolumeSelector{Format: tt.format}.Formats()
+			if !reflect.DeepEqual(formats, tt.expected) {
+				t.Errorf("unexpected formats: got %v, want %v", formats, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMergeDefaults(t *testing.T) {
+	parameters := map[string]string{SecretsZncdataClass: "tls", Mode: "0440"}
+	merged := MergeDefaults(parameters,
+		map[string]string{Mode: "0400", SecretsZncdataFormat: "env"},
+		map[string]string{SecretsZncdataFormat: "json", UID: "1000"},
+	)
+
+	want := map[string]string{SecretsZncdataClass: "tls", Mode: "0440", SecretsZncdataFormat: "env", UID: "1000"}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("unexpected merged parameters: got %v, want %v", merged, want)
+	}
+	if len(parameters) != 2 {
+		t.Errorf("the parameters are modified: %v", parameters)
+	}
+}
+
+func TestValidateDefaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults map[string]string
+		wantErr  bool
+	}{
+		{name: "valid", defaults: map[string]string{SecretsZncdataFormat: "tls-pem", Mode: "0400"}},
+		{name: "class", defaults: map[string]string{SecretsZncdataClass: "tls"}, wantErr: true},
+		{name: "classes", defaults: map[string]string{SecretsZncdataClasses: "tls,shared"}, wantErr: true},
+		{name: "pod info", defaults: map[string]string{CSIStoragePodName: "test-pod"}, wantErr: true},
+		{name: "invalid value", defaults: map[string]string{Mode: "0999"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateDefaults(tt.defaults)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: got %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}